@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/cloudflare/goflow/v3/transport"
 	"github.com/cloudflare/goflow/v3/utils"
@@ -18,19 +23,40 @@ var (
 	buildinfos = ""
 	AppVersion = "GoFlow NetFlow " + version + " " + buildinfos
 
-	Addr  = flag.String("addr", "", "NetFlow/IPFIX listening address")
-	Port  = flag.Int("port", 2055, "NetFlow/IPFIX listening port")
-	Reuse = flag.Bool("reuse", false, "Enable so_reuseport for NetFlow/IPFIX listening port")
-
-	Workers  = flag.Int("workers", 1, "Number of NetFlow workers")
-	LogLevel = flag.String("loglevel", "info", "Log level")
-	LogFmt   = flag.String("logfmt", "normal", "Log formatter")
-
-	EnableKafka  = flag.Bool("kafka", true, "Enable Kafka")
-	FixedLength  = flag.Bool("proto.fixedlen", false, "Enable fixed length protobuf")
-	MetricsAddr  = flag.String("metrics.addr", ":8080", "Metrics address")
-	MetricsPath  = flag.String("metrics.path", "/metrics", "Metrics path")
-	TemplatePath = flag.String("templates.path", "/templates", "NetFlow/IPFIX templates list")
+	Addr       = flag.String("addr", "", "NetFlow/IPFIX listening address")
+	Port       = flag.Int("port", 2055, "NetFlow/IPFIX listening port")
+	Reuse      = flag.Bool("reuse", false, "Enable so_reuseport for NetFlow/IPFIX listening port")
+	Sockets    = flag.Int("sockets", 1, "Number of so_reuseport sockets, each with a reader")
+	PinReaders = flag.Bool("pin.readers", false, "Lock each socket reader to an OS thread")
+	ReadBatch  = flag.Int("read.batch", 32, "Datagrams read per system call on Linux (1 reads them one by one)")
+	Pcap       = flag.String("pcap", "", "Replay the datagrams of a capture (pcap or pcapng) sent to the listening port instead of listening")
+
+	TCPPort                = flag.Int("tcp.port", 0, "IPFIX over TCP listening port (disabled when 0)")
+	TCPIdle                = flag.Duration("tcp.idle", 10*time.Minute, "Close the IPFIX over TCP connections receiving nothing for this long (0 keeps them)")
+	OptionsTTL             = flag.Duration("options.ttl", time.Hour, "Forget the sampling rates of samplers, selectors and interfaces, and the interface names, not received for this long (0 keeps them)")
+	Partial                = flag.Bool("partial", false, "Publish the flow sets of a NetFlow/IPFIX message which could be decoded when others cannot")
+	Mapping                = flag.String("mapping", "", "NetFlow/IPFIX fields mapping configuration file (YAML or JSON)")
+	Sampling               = flag.String("sampling", "", "Per-router sampling rates configuration file (YAML or JSON)")
+	SamplingRate           = flag.Uint("sampling.rate", 0, "Sampling rate of every NetFlow/IPFIX flow, replacing the ones sent by the routers (0 disables)")
+	TemplatesTimeout       = flag.Duration("templates.timeout", 0, "Ignore NetFlow v9 templates not received for this long (0 keeps them)")
+	BufferSize             = flag.Int("buffer.size", 0, "Data sets kept per template when received before it (0 disables)")
+	BufferAge              = flag.Duration("buffer.age", time.Minute, "Drop the data sets kept for a template not received for this long")
+	TemplatesStore         = flag.String("templates.store", "", "File where NetFlow/IPFIX templates and sampling rates are kept across restarts (disabled when empty)")
+	TemplatesStoreTTL      = flag.Duration("templates.store.ttl", time.Hour, "Forget stored templates and sampling rates not received for this long (0 keeps them)")
+	TemplatesStoreInterval = flag.Duration("templates.store.interval", 10*time.Second, "Interval between saves of the templates file")
+
+	Workers     = flag.Int("workers", 1, "Number of NetFlow workers")
+	QueueSize   = flag.Int("queue.size", 1000, "Datagrams waiting for a worker")
+	QueuePolicy = flag.String("queue.policy", "block", "When the queue is full: block, drop-newest or drop-oldest")
+	LogLevel    = flag.String("loglevel", "info", "Log level")
+	LogFmt      = flag.String("logfmt", "normal", "Log formatter")
+
+	EnableKafka   = flag.Bool("kafka", true, "Enable Kafka")
+	FixedLength   = flag.Bool("proto.fixedlen", false, "Enable fixed length protobuf")
+	MetricsAddr   = flag.String("metrics.addr", ":8080", "Metrics address")
+	MetricsPath   = flag.String("metrics.path", "/metrics", "Metrics path")
+	TemplatePath  = flag.String("templates.path", "/templates", "NetFlow/IPFIX templates list")
+	InterfacePath = flag.String("interfaces.path", "/interfaces", "NetFlow/IPFIX interface names list")
 
 	Version = flag.Bool("v", false, "Print version")
 )
@@ -42,6 +68,7 @@ func init() {
 func httpServer(state *utils.StateNetFlow) {
 	http.Handle(*MetricsPath, promhttp.Handler())
 	http.HandleFunc(*TemplatePath, state.ServeHTTPTemplates)
+	http.HandleFunc(*InterfacePath, state.ServeHTTPInterfaces)
 	log.Fatal(http.ListenAndServe(*MetricsAddr, nil))
 }
 
@@ -70,26 +97,116 @@ func main() {
 	log.Info("Starting GoFlow")
 
 	s := &utils.StateNetFlow{
-		Transport: defaultTransport,
-		Logger:    log.StandardLogger(),
+		Transport:   defaultTransport,
+		Logger:      log.StandardLogger(),
+		QueueSize:   *QueueSize,
+		QueuePolicy: *QueuePolicy,
+		Sockets:     *Sockets,
+		PinReaders:  *PinReaders,
+		ReadBatch:   *ReadBatch,
+
+		TemplateTimeout: *TemplatesTimeout,
+		OptionsTTL:      *OptionsTTL,
+		BufferSize:      *BufferSize,
+		BufferAge:       *BufferAge,
+		TCPIdleTimeout:  *TCPIdle,
+		PartialDecoding: *Partial,
+		SamplingRate:    uint32(*SamplingRate),
+	}
+
+	if *Mapping != "" {
+		config, err := utils.LoadNetFlowProducerConfig(*Mapping)
+		if err != nil {
+			log.Fatalf("Fatal error: could not load mapping (%v)", err)
+		}
+		s.Config = config
+	}
+	if *Sampling != "" {
+		config, err := utils.LoadSamplingRateConfig(*Sampling)
+		if err != nil {
+			log.Fatalf("Fatal error: could not load sampling rates (%v)", err)
+		}
+		s.Sampling = config
+	}
+	if *TemplatesStore != "" {
+		store := utils.NewTemplateStore(*TemplatesStore, *TemplatesStoreTTL)
+		err := store.Load()
+		if err != nil {
+			log.Errorf("Error loading templates: %v", err)
+		}
+		s.Store = store
 	}
 
 	go httpServer(s)
 
+	var kafkaState *transport.KafkaState
 	if *EnableKafka {
-		kafkaState, err := transport.StartKafkaProducerFromArgs(log.StandardLogger())
+		var err error
+		kafkaState, err = transport.StartKafkaProducerFromArgs(log.StandardLogger())
 		if err != nil {
 			log.Fatal(err)
 		}
 		kafkaState.FixedLengthProto = *FixedLength
 		s.Transport = kafkaState
 	}
+	closeTransport := func() {
+		if kafkaState == nil {
+			return
+		}
+		err := kafkaState.Close()
+		if err != nil {
+			log.Errorf("Error closing Kafka producer: %v", err)
+		}
+	}
+	if *Pcap != "" {
+		log.Infof("Replaying %v", *Pcap)
+
+		routes := map[int]utils.PcapRoute{*Port: s.PcapRoute()}
+		err := utils.PcapRoutine(*Pcap, routes, log.StandardLogger())
+		if err != nil {
+			log.Fatalf("Fatal error: could not replay capture (%v)", err)
+		}
+		closeTransport()
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if s.Store != nil {
+		go s.Store.SaveRoutine(ctx, *TemplatesStoreInterval, log.StandardLogger())
+	}
+
+	wg := &sync.WaitGroup{}
+	if *TCPPort != 0 {
+		wg.Add(1)
+		go func() {
+			log.WithFields(log.Fields{
+				"Type": "NetFlow"}).
+				Infof("Listening on TCP %v:%v", *Addr, *TCPPort)
+
+			err := s.FlowRoutineTCPContext(ctx, *Addr, *TCPPort)
+			if err != nil {
+				log.Fatalf("Fatal error: could not listen to TCP (%v)", err)
+			}
+			wg.Done()
+		}()
+	}
+
 	log.WithFields(log.Fields{
 		"Type": "NetFlow"}).
 		Infof("Listening on UDP %v:%v", *Addr, *Port)
 
-	err := s.FlowRoutine(*Workers, *Addr, *Port, *Reuse)
+	err := s.FlowRoutineContext(ctx, *Workers, *Addr, *Port, *Reuse)
 	if err != nil {
 		log.Fatalf("Fatal error: could not listen to UDP (%v)", err)
 	}
+	wg.Wait()
+	log.Info("Stopping GoFlow")
+	if s.Store != nil {
+		err := s.Store.Save()
+		if err != nil {
+			log.Errorf("Error saving templates: %v", err)
+		}
+	}
+	closeTransport()
 }
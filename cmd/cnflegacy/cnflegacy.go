@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 
 	"github.com/cloudflare/goflow/v3/transport"
 	"github.com/cloudflare/goflow/v3/utils"
@@ -18,13 +21,19 @@ var (
 	buildinfos = ""
 	AppVersion = "GoFlow NetFlowV5 " + version + " " + buildinfos
 
-	Addr  = flag.String("addr", "", "NetFlow v5 listening address")
-	Port  = flag.Int("port", 2055, "NetFlow v5 listening port")
-	Reuse = flag.Bool("reuse", false, "Enable so_reuseport for NetFlow v5 listening port")
+	Addr       = flag.String("addr", "", "NetFlow v5 listening address")
+	Port       = flag.Int("port", 2055, "NetFlow v5 listening port")
+	Reuse      = flag.Bool("reuse", false, "Enable so_reuseport for NetFlow v5 listening port")
+	Sockets    = flag.Int("sockets", 1, "Number of so_reuseport sockets, each with a reader")
+	PinReaders = flag.Bool("pin.readers", false, "Lock each socket reader to an OS thread")
+	ReadBatch  = flag.Int("read.batch", 32, "Datagrams read per system call on Linux (1 reads them one by one)")
+	Pcap       = flag.String("pcap", "", "Replay the datagrams of a capture (pcap or pcapng) sent to the listening port instead of listening")
 
-	Workers  = flag.Int("workers", 1, "Number of NetFlow v5 workers")
-	LogLevel = flag.String("loglevel", "info", "Log level")
-	LogFmt   = flag.String("logfmt", "normal", "Log formatter")
+	Workers     = flag.Int("workers", 1, "Number of NetFlow v5 workers")
+	QueueSize   = flag.Int("queue.size", 1000, "Datagrams waiting for a worker")
+	QueuePolicy = flag.String("queue.policy", "block", "When the queue is full: block, drop-newest or drop-oldest")
+	LogLevel    = flag.String("loglevel", "info", "Log level")
+	LogFmt      = flag.String("logfmt", "normal", "Log formatter")
 
 	EnableKafka = flag.Bool("kafka", true, "Enable Kafka")
 	FixedLength = flag.Bool("proto.fixedlen", false, "Enable fixed length protobuf")
@@ -68,26 +77,59 @@ func main() {
 	log.Info("Starting GoFlow")
 
 	s := &utils.StateNFLegacy{
-		Transport: defaultTransport,
-		Logger:    log.StandardLogger(),
+		Transport:   defaultTransport,
+		Logger:      log.StandardLogger(),
+		QueueSize:   *QueueSize,
+		QueuePolicy: *QueuePolicy,
+		Sockets:     *Sockets,
+		PinReaders:  *PinReaders,
+		ReadBatch:   *ReadBatch,
 	}
 
 	go httpServer()
 
+	var kafkaState *transport.KafkaState
 	if *EnableKafka {
-		kafkaState, err := transport.StartKafkaProducerFromArgs(log.StandardLogger())
+		var err error
+		kafkaState, err = transport.StartKafkaProducerFromArgs(log.StandardLogger())
 		if err != nil {
 			log.Fatal(err)
 		}
 		kafkaState.FixedLengthProto = *FixedLength
 		s.Transport = kafkaState
 	}
+	closeTransport := func() {
+		if kafkaState == nil {
+			return
+		}
+		err := kafkaState.Close()
+		if err != nil {
+			log.Errorf("Error closing Kafka producer: %v", err)
+		}
+	}
+	if *Pcap != "" {
+		log.Infof("Replaying %v", *Pcap)
+
+		routes := map[int]utils.PcapRoute{*Port: s.PcapRoute()}
+		err := utils.PcapRoutine(*Pcap, routes, log.StandardLogger())
+		if err != nil {
+			log.Fatalf("Fatal error: could not replay capture (%v)", err)
+		}
+		closeTransport()
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	log.WithFields(log.Fields{
 		"Type": "NetFlowLegacy"}).
 		Infof("Listening on UDP %v:%v", *Addr, *Port)
 
-	err := s.FlowRoutine(*Workers, *Addr, *Port, *Reuse)
+	err := s.FlowRoutineContext(ctx, *Workers, *Addr, *Port, *Reuse)
 	if err != nil {
 		log.Fatalf("Fatal error: could not listen to UDP (%v)", err)
 	}
+	log.Info("Stopping GoFlow")
+	closeTransport()
 }
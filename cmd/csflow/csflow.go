@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 
+	"github.com/cloudflare/goflow/v3/producer"
 	"github.com/cloudflare/goflow/v3/transport"
 	"github.com/cloudflare/goflow/v3/utils"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
@@ -18,13 +24,23 @@ var (
 	buildinfos = ""
 	AppVersion = "GoFlow sFlow " + version + " " + buildinfos
 
-	Addr  = flag.String("addr", "", "sFlow listening address")
-	Port  = flag.Int("port", 6343, "sFlow listening port")
-	Reuse = flag.Bool("reuse", false, "Enable so_reuseport for sFlow listening port")
+	Addr       = flag.String("addr", "", "sFlow listening address")
+	Port       = flag.Int("port", 6343, "sFlow listening port")
+	Reuse      = flag.Bool("reuse", false, "Enable so_reuseport for sFlow listening port")
+	Sockets    = flag.Int("sockets", 1, "Number of so_reuseport sockets, each with a reader")
+	PinReaders = flag.Bool("pin.readers", false, "Lock each socket reader to an OS thread")
+	ReadBatch  = flag.Int("read.batch", 32, "Datagrams read per system call on Linux (1 reads them one by one)")
+	Pcap       = flag.String("pcap", "", "Replay the datagrams of a capture (pcap or pcapng) sent to the listening port instead of listening")
 
-	Workers  = flag.Int("workers", 1, "Number of sFlow workers")
-	LogLevel = flag.String("loglevel", "info", "Log level")
-	LogFmt   = flag.String("logfmt", "normal", "Log formatter")
+	CountersMetrics = flag.Bool("counters.metrics", false, "Export the latest interface counters as metrics")
+	CountersTTL     = flag.Duration("counters.ttl", 5*time.Minute, "Stop exporting the counters of interfaces not received for this long")
+	EncapDepth      = flag.Int("encap.depth", 1, "Nested encapsulations (GRE, IP-in-IP, VXLAN, GENEVE) decoded in the sampled headers (-1 to disable)")
+
+	Workers     = flag.Int("workers", 1, "Number of sFlow workers")
+	QueueSize   = flag.Int("queue.size", 1000, "Datagrams waiting for a worker")
+	QueuePolicy = flag.String("queue.policy", "block", "When the queue is full: block, drop-newest or drop-oldest")
+	LogLevel    = flag.String("loglevel", "info", "Log level")
+	LogFmt      = flag.String("logfmt", "normal", "Log formatter")
 
 	EnableKafka = flag.Bool("kafka", true, "Enable Kafka")
 	FixedLength = flag.Bool("proto.fixedlen", false, "Enable fixed length protobuf")
@@ -68,26 +84,66 @@ func main() {
 	log.Info("Starting GoFlow")
 
 	s := &utils.StateSFlow{
-		Transport: defaultTransport,
-		Logger:    log.StandardLogger(),
+		Transport:   defaultTransport,
+		Logger:      log.StandardLogger(),
+		QueueSize:   *QueueSize,
+		QueuePolicy: *QueuePolicy,
+		Sockets:     *Sockets,
+		PinReaders:  *PinReaders,
+		ReadBatch:   *ReadBatch,
+		Config: &producer.SFlowProducerConfig{
+			MaxEncapDepth: *EncapDepth,
+		},
+	}
+	if *CountersMetrics {
+		s.Counters = utils.NewCountersExporter(*CountersTTL)
+		prometheus.MustRegister(s.Counters)
 	}
 
 	go httpServer()
 
+	var kafkaState *transport.KafkaState
 	if *EnableKafka {
-		kafkaState, err := transport.StartKafkaProducerFromArgs(log.StandardLogger())
+		var err error
+		kafkaState, err = transport.StartKafkaProducerFromArgs(log.StandardLogger())
 		if err != nil {
 			log.Fatal(err)
 		}
 		kafkaState.FixedLengthProto = *FixedLength
 		s.Transport = kafkaState
 	}
+	closeTransport := func() {
+		if kafkaState == nil {
+			return
+		}
+		err := kafkaState.Close()
+		if err != nil {
+			log.Errorf("Error closing Kafka producer: %v", err)
+		}
+	}
+	if *Pcap != "" {
+		log.Infof("Replaying %v", *Pcap)
+
+		routes := map[int]utils.PcapRoute{*Port: s.PcapRoute()}
+		err := utils.PcapRoutine(*Pcap, routes, log.StandardLogger())
+		if err != nil {
+			log.Fatalf("Fatal error: could not replay capture (%v)", err)
+		}
+		closeTransport()
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	log.WithFields(log.Fields{
 		"Type": "sFlow"}).
 		Infof("Listening on UDP %v:%v", *Addr, *Port)
 
-	err := s.FlowRoutine(*Workers, *Addr, *Port, *Reuse)
+	err := s.FlowRoutineContext(ctx, *Workers, *Addr, *Port, *Reuse)
 	if err != nil {
 		log.Fatalf("Fatal error: could not listen to UDP (%v)", err)
 	}
+	log.Info("Stopping GoFlow")
+	closeTransport()
 }
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/cloudflare/goflow/v3/decoders/rawcapture"
+	"github.com/cloudflare/goflow/v3/producer"
 	"github.com/cloudflare/goflow/v3/transport"
 	"github.com/cloudflare/goflow/v3/utils"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
@@ -19,31 +29,70 @@ var (
 	buildinfos = ""
 	AppVersion = "GoFlow " + version + " " + buildinfos
 
-	SFlowEnable = flag.Bool("sflow", true, "Enable sFlow")
-	SFlowAddr   = flag.String("sflow.addr", "", "sFlow listening address")
-	SFlowPort   = flag.Int("sflow.port", 6343, "sFlow listening port")
-	SFlowReuse  = flag.Bool("sflow.reuserport", false, "Enable so_reuseport for sFlow")
+	SFlowEnable  = flag.Bool("sflow", true, "Enable sFlow")
+	SFlowAddr    = flag.String("sflow.addr", "", "sFlow listening address")
+	SFlowPort    = flag.Int("sflow.port", 6343, "sFlow listening port")
+	SFlowReuse   = flag.Bool("sflow.reuserport", false, "Enable so_reuseport for sFlow")
+	SFlowSockets = flag.Int("sflow.sockets", 1, "Number of so_reuseport sockets for sFlow, each with a reader")
 
-	NFLEnable = flag.Bool("nfl", true, "Enable NetFlow v5")
-	NFLAddr   = flag.String("nfl.addr", "", "NetFlow v5 listening address")
-	NFLPort   = flag.Int("nfl.port", 2056, "NetFlow v5 listening port")
-	NFLReuse  = flag.Bool("nfl.reuserport", false, "Enable so_reuseport for NetFlow v5")
+	SFlowCountersMetrics = flag.Bool("sflow.counters.metrics", false, "Export the latest sFlow interface counters as metrics")
+	SFlowCountersTTL     = flag.Duration("sflow.counters.ttl", 5*time.Minute, "Stop exporting the counters of interfaces not received for this long")
+	SFlowEncapDepth      = flag.Int("sflow.encap.depth", 1, "Nested encapsulations (GRE, IP-in-IP, VXLAN, GENEVE) decoded in the sampled headers (-1 to disable)")
 
-	NFEnable = flag.Bool("nf", true, "Enable NetFlow/IPFIX")
-	NFAddr   = flag.String("nf.addr", "", "NetFlow/IPFIX listening address")
-	NFPort   = flag.Int("nf.port", 2055, "NetFlow/IPFIX listening port")
-	NFReuse  = flag.Bool("nf.reuserport", false, "Enable so_reuseport for NetFlow/IPFIX")
+	NFLEnable  = flag.Bool("nfl", true, "Enable NetFlow v5")
+	NFLAddr    = flag.String("nfl.addr", "", "NetFlow v5 listening address")
+	NFLPort    = flag.Int("nfl.port", 2056, "NetFlow v5 listening port")
+	NFLReuse   = flag.Bool("nfl.reuserport", false, "Enable so_reuseport for NetFlow v5")
+	NFLSockets = flag.Int("nfl.sockets", 1, "Number of so_reuseport sockets for NetFlow v5, each with a reader")
 
-	Workers  = flag.Int("workers", 1, "Number of workers per collector")
-	LogLevel = flag.String("loglevel", "info", "Log level")
-	LogFmt   = flag.String("logfmt", "normal", "Log formatter")
+	NFEnable  = flag.Bool("nf", true, "Enable NetFlow/IPFIX")
+	NFAddr    = flag.String("nf.addr", "", "NetFlow/IPFIX listening address")
+	NFPort    = flag.Int("nf.port", 2055, "NetFlow/IPFIX listening port")
+	NFReuse   = flag.Bool("nf.reuserport", false, "Enable so_reuseport for NetFlow/IPFIX")
+	NFSockets = flag.Int("nf.sockets", 1, "Number of so_reuseport sockets for NetFlow/IPFIX, each with a reader")
+
+	NFTCPPort              = flag.Int("nf.tcp.port", 0, "IPFIX over TCP listening port (disabled when 0)")
+	NFTCPIdle              = flag.Duration("nf.tcp.idle", 10*time.Minute, "Close the IPFIX over TCP connections receiving nothing for this long (0 keeps them)")
+	NFOptionsTTL           = flag.Duration("nf.options.ttl", time.Hour, "Forget the sampling rates of samplers, selectors and interfaces, and the interface names, not received for this long (0 keeps them)")
+	NFPartial              = flag.Bool("nf.partial", false, "Publish the flow sets of a NetFlow/IPFIX message which could be decoded when others cannot")
+	NFMapping              = flag.String("nf.mapping", "", "NetFlow/IPFIX fields mapping configuration file (YAML or JSON)")
+	NFSampling             = flag.String("nf.sampling", "", "Per-router sampling rates configuration file (YAML or JSON)")
+	NFSamplingRate         = flag.Uint("nf.sampling.rate", 0, "Sampling rate of every NetFlow/IPFIX flow, replacing the ones sent by the routers (0 disables)")
+	NFTemplatesTimeout     = flag.Duration("nf.templates.timeout", 0, "Ignore NetFlow v9 templates not received for this long (0 keeps them)")
+	NFBufferSize           = flag.Int("nf.buffer.size", 0, "Data sets kept per template when received before it (0 disables)")
+	NFBufferAge            = flag.Duration("nf.buffer.age", time.Minute, "Drop the data sets kept for a template not received for this long")
+	TemplatesStore         = flag.String("templates.store", "", "File where NetFlow/IPFIX templates and sampling rates are kept across restarts (disabled when empty)")
+	TemplatesStoreTTL      = flag.Duration("templates.store.ttl", time.Hour, "Forget stored templates and sampling rates not received for this long (0 keeps them)")
+	TemplatesStoreInterval = flag.Duration("templates.store.interval", 10*time.Second, "Interval between saves of the templates file")
+
+	PcapFile = flag.String("pcap.file", "", "Replay the datagrams of a capture (pcap or pcapng) to the enabled protocols ports instead of listening")
+
+	TeeDir      = flag.String("tee.dir", "", "Directory where received datagrams are written as-is (disabled when empty)")
+	TeePrefix   = flag.String("tee.prefix", "goflow", "Prefix of the raw capture files")
+	TeeMaxSize  = flag.Int64("tee.maxsize", 100*1024*1024, "Rotate raw capture files above this size in bytes (0 disables)")
+	TeeMaxAge   = flag.Duration("tee.maxage", time.Hour, "Rotate raw capture files older than this (0 disables)")
+	TeeMaxFiles = flag.Int("tee.maxfiles", 0, "Number of raw capture files to keep (0 keeps all)")
+	TeeQueue    = flag.Int("tee.queue", 10000, "Datagrams waiting to be written to the raw capture files before being dropped")
+	TeeReplay   = flag.String("tee.replay", "", "Replay raw capture files matching a pattern to the enabled protocols ports instead of listening")
+
+	IPFIXFile    = flag.String("ipfix.file", "", "Replay an IPFIX file (RFC 5655) instead of listening")
+	IPFIXFileSrc = flag.String("ipfix.file.src", "127.0.0.1", "Sampler address of the replayed IPFIX file")
+
+	Workers     = flag.Int("workers", 1, "Number of workers per collector")
+	QueueSize   = flag.Int("queue.size", 1000, "Datagrams waiting for a worker per collector")
+	QueuePolicy = flag.String("queue.policy", "block", "When the queue is full: block, drop-newest or drop-oldest")
+	PinReaders  = flag.Bool("pin.readers", false, "Lock each socket reader to an OS thread")
+	ReadBatch   = flag.Int("read.batch", 32, "Datagrams read per system call on Linux (1 reads them one by one)")
+	LogLevel    = flag.String("loglevel", "info", "Log level")
+	LogFmt      = flag.String("logfmt", "normal", "Log formatter")
 
 	EnableKafka = flag.Bool("kafka", true, "Enable Kafka")
 	FixedLength = flag.Bool("proto.fixedlen", false, "Enable fixed length protobuf")
 	MetricsAddr = flag.String("metrics.addr", ":8080", "Metrics address")
 	MetricsPath = flag.String("metrics.path", "/metrics", "Metrics path")
 
-	TemplatePath = flag.String("templates.path", "/templates", "NetFlow/IPFIX templates list")
+	TemplatePath  = flag.String("templates.path", "/templates", "NetFlow/IPFIX templates list")
+	InterfacePath = flag.String("interfaces.path", "/interfaces", "NetFlow/IPFIX interface names list")
 
 	Version = flag.Bool("v", false, "Print version")
 )
@@ -55,6 +104,7 @@ func init() {
 func httpServer(state *utils.StateNetFlow) {
 	http.Handle(*MetricsPath, promhttp.Handler())
 	http.HandleFunc(*TemplatePath, state.ServeHTTPTemplates)
+	http.HandleFunc(*InterfacePath, state.ServeHTTPInterfaces)
 	log.Fatal(http.ListenAndServe(*MetricsAddr, nil))
 }
 
@@ -83,22 +133,77 @@ func main() {
 	log.Info("Starting GoFlow")
 
 	sSFlow := &utils.StateSFlow{
-		Transport: defaultTransport,
-		Logger:    log.StandardLogger(),
+		Transport:   defaultTransport,
+		Logger:      log.StandardLogger(),
+		QueueSize:   *QueueSize,
+		QueuePolicy: *QueuePolicy,
+		Sockets:     *SFlowSockets,
+		PinReaders:  *PinReaders,
+		ReadBatch:   *ReadBatch,
+		Config: &producer.SFlowProducerConfig{
+			MaxEncapDepth: *SFlowEncapDepth,
+		},
+	}
+	if *SFlowCountersMetrics {
+		sSFlow.Counters = utils.NewCountersExporter(*SFlowCountersTTL)
+		prometheus.MustRegister(sSFlow.Counters)
 	}
 	sNF := &utils.StateNetFlow{
-		Transport: defaultTransport,
-		Logger:    log.StandardLogger(),
+		Transport:   defaultTransport,
+		Logger:      log.StandardLogger(),
+		QueueSize:   *QueueSize,
+		QueuePolicy: *QueuePolicy,
+		Sockets:     *NFSockets,
+		PinReaders:  *PinReaders,
+		ReadBatch:   *ReadBatch,
+
+		TemplateTimeout: *NFTemplatesTimeout,
+		OptionsTTL:      *NFOptionsTTL,
+		BufferSize:      *NFBufferSize,
+		BufferAge:       *NFBufferAge,
+		TCPIdleTimeout:  *NFTCPIdle,
+		PartialDecoding: *NFPartial,
+		SamplingRate:    uint32(*NFSamplingRate),
 	}
 	sNFL := &utils.StateNFLegacy{
-		Transport: defaultTransport,
-		Logger:    log.StandardLogger(),
+		Transport:   defaultTransport,
+		Logger:      log.StandardLogger(),
+		QueueSize:   *QueueSize,
+		QueuePolicy: *QueuePolicy,
+		Sockets:     *NFLSockets,
+		PinReaders:  *PinReaders,
+		ReadBatch:   *ReadBatch,
+	}
+
+	if *NFMapping != "" {
+		config, err := utils.LoadNetFlowProducerConfig(*NFMapping)
+		if err != nil {
+			log.Fatalf("Fatal error: could not load mapping (%v)", err)
+		}
+		sNF.Config = config
+	}
+	if *NFSampling != "" {
+		config, err := utils.LoadSamplingRateConfig(*NFSampling)
+		if err != nil {
+			log.Fatalf("Fatal error: could not load sampling rates (%v)", err)
+		}
+		sNF.Sampling = config
+	}
+	if *TemplatesStore != "" {
+		store := utils.NewTemplateStore(*TemplatesStore, *TemplatesStoreTTL)
+		err := store.Load()
+		if err != nil {
+			log.Errorf("Error loading templates: %v", err)
+		}
+		sNF.Store = store
 	}
 
 	go httpServer(sNF)
 
+	var kafkaState *transport.KafkaState
 	if *EnableKafka {
-		kafkaState, err := transport.StartKafkaProducerFromArgs(log.StandardLogger())
+		var err error
+		kafkaState, err = transport.StartKafkaProducerFromArgs(log.StandardLogger())
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -108,6 +213,78 @@ func main() {
 		sNFL.Transport = kafkaState
 		sNF.Transport = kafkaState
 	}
+	closeTransport := func() {
+		if kafkaState == nil {
+			return
+		}
+		err := kafkaState.Close()
+		if err != nil {
+			log.Errorf("Error closing Kafka producer: %v", err)
+		}
+	}
+
+	routes := make(map[int]utils.PcapRoute)
+	if *SFlowEnable {
+		routes[*SFlowPort] = sSFlow.PcapRoute()
+	}
+	if *NFEnable {
+		routes[*NFPort] = sNF.PcapRoute()
+	}
+	if *NFLEnable {
+		routes[*NFLPort] = sNFL.PcapRoute()
+	}
+	if *PcapFile != "" {
+		log.Infof("Replaying %v", *PcapFile)
+
+		err := utils.PcapRoutine(*PcapFile, routes, log.StandardLogger())
+		if err != nil {
+			log.Fatalf("Fatal error: could not replay capture (%v)", err)
+		}
+		closeTransport()
+		return
+	}
+	if *TeeReplay != "" {
+		paths, err := filepath.Glob(*TeeReplay)
+		if err != nil {
+			log.Fatalf("Fatal error: invalid raw capture pattern (%v)", err)
+		}
+		sort.Strings(paths)
+		log.Infof("Replaying %v raw capture files", len(paths))
+
+		err = utils.RawCaptureRoutine(paths, routes, log.StandardLogger())
+		if err != nil {
+			log.Fatalf("Fatal error: could not replay raw capture (%v)", err)
+		}
+		closeTransport()
+		return
+	}
+	if *IPFIXFile != "" {
+		log.WithFields(log.Fields{
+			"Type": "NetFlow"}).
+			Infof("Replaying %v", *IPFIXFile)
+
+		err := sNF.FlowRoutineFile(*IPFIXFile, net.ParseIP(*IPFIXFileSrc))
+		if err != nil {
+			log.Fatalf("Fatal error: could not replay file (%v)", err)
+		}
+		closeTransport()
+		return
+	}
+
+	if *TeeDir != "" {
+		writer := rawcapture.NewWriter(*TeeDir, *TeePrefix, *TeeMaxSize, *TeeMaxAge, *TeeMaxFiles)
+		tee := utils.NewAsyncTee(writer, *TeeQueue, log.StandardLogger())
+		defer tee.Close()
+		sSFlow.Tee = tee
+		sNF.Tee = tee
+		sNFL.Tee = tee
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if sNF.Store != nil {
+		go sNF.Store.SaveRoutine(ctx, *TemplatesStoreInterval, log.StandardLogger())
+	}
 
 	wg := &sync.WaitGroup{}
 	if *SFlowEnable {
@@ -117,7 +294,7 @@ func main() {
 				"Type": "sFlow"}).
 				Infof("Listening on UDP %v:%v", *SFlowAddr, *SFlowPort)
 
-			err := sSFlow.FlowRoutine(*Workers, *SFlowAddr, *SFlowPort, *SFlowReuse)
+			err := sSFlow.FlowRoutineContext(ctx, *Workers, *SFlowAddr, *SFlowPort, *SFlowReuse)
 			if err != nil {
 				log.Fatalf("Fatal error: could not listen to UDP (%v)", err)
 			}
@@ -131,13 +308,27 @@ func main() {
 				"Type": "NetFlow"}).
 				Infof("Listening on UDP %v:%v", *NFAddr, *NFPort)
 
-			err := sNF.FlowRoutine(*Workers, *NFAddr, *NFPort, *NFReuse)
+			err := sNF.FlowRoutineContext(ctx, *Workers, *NFAddr, *NFPort, *NFReuse)
 			if err != nil {
 				log.Fatalf("Fatal error: could not listen to UDP (%v)", err)
 			}
 			wg.Done()
 		}()
 	}
+	if *NFEnable && *NFTCPPort != 0 {
+		wg.Add(1)
+		go func() {
+			log.WithFields(log.Fields{
+				"Type": "NetFlow"}).
+				Infof("Listening on TCP %v:%v", *NFAddr, *NFTCPPort)
+
+			err := sNF.FlowRoutineTCPContext(ctx, *NFAddr, *NFTCPPort)
+			if err != nil {
+				log.Fatalf("Fatal error: could not listen to TCP (%v)", err)
+			}
+			wg.Done()
+		}()
+	}
 	if *NFLEnable {
 		wg.Add(1)
 		go func() {
@@ -145,7 +336,7 @@ func main() {
 				"Type": "NetFlowLegacy"}).
 				Infof("Listening on UDP %v:%v", *NFLAddr, *NFLPort)
 
-			err := sNFL.FlowRoutine(*Workers, *NFLAddr, *NFLPort, *NFLReuse)
+			err := sNFL.FlowRoutineContext(ctx, *Workers, *NFLAddr, *NFLPort, *NFLReuse)
 			if err != nil {
 				log.Fatalf("Fatal error: could not listen to UDP (%v)", err)
 			}
@@ -153,4 +344,12 @@ func main() {
 		}()
 	}
 	wg.Wait()
+	log.Info("Stopping GoFlow")
+	if sNF.Store != nil {
+		err := sNF.Store.Save()
+		if err != nil {
+			log.Errorf("Error saving templates: %v", err)
+		}
+	}
+	closeTransport()
 }
@@ -10,18 +10,32 @@ type MessageDecoded interface{}
 type DecoderFunc func(Message interface{}) error
 type DoneCallback func(string, int, time.Time, time.Time)
 type ErrorCallback func(string, int, time.Time, time.Time, error)
+type DropCallback func(string, Message)
+
+// Overflow policies of the queue of a processor.
+const (
+	QUEUE_POLICY_BLOCK       = "block"       // wait for a worker, the caller stops reading
+	QUEUE_POLICY_DROP_NEWEST = "drop-newest" // drop the message being sent
+	QUEUE_POLICY_DROP_OLDEST = "drop-oldest" // drop the oldest queued message to make room
+)
 
 // Worker structure
 type Worker struct {
 	Id            int
 	DecoderParams DecoderParams
-	WorkerPool    chan chan Message
-	Name          string
-	InMsg         chan Message
-	Quit          chan bool
+	// Deprecated: only the workers of CreateWorker add InMsg to the pool
+	// before each message, the ones of CreateQueueWorker read the queue of
+	// their processor (InMsg).
+	WorkerPool chan chan Message
+	Name       string
+	InMsg      chan Message
+	Quit       chan bool
+	done       chan bool
 }
 
 // Create a worker and add it to the pool.
+//
+// Deprecated: use CreateQueueWorker, the processors do not use a pool anymore.
 func CreateWorker(workerPool chan chan Message, decoderParams DecoderParams, id int, name string) Worker {
 	return Worker{
 		Id:            id,
@@ -30,44 +44,90 @@ func CreateWorker(workerPool chan chan Message, decoderParams DecoderParams, id
 		Name:          name,
 		InMsg:         make(chan Message),
 		Quit:          make(chan bool),
+		done:          make(chan bool),
+	}
+}
+
+// Create a worker reading the queue of a processor.
+func CreateQueueWorker(queue chan Message, decoderParams DecoderParams, id int, name string) Worker {
+	return Worker{
+		Id:            id,
+		DecoderParams: decoderParams,
+		Name:          name,
+		InMsg:         queue,
+		Quit:          make(chan bool),
+		done:          make(chan bool),
 	}
 }
 
-// Start the worker. Launches a goroutine to process NFv9 messages.
-// The worker will add its input channel of NFv9 messages to decode to the pool.
+// Start the worker. Launches a goroutine decoding the messages of the queue.
 func (w Worker) Start() {
+	if w.WorkerPool != nil {
+		w.startPool()
+		return
+	}
 	go func() {
 		//log.Debugf("Worker %v started", w.Id)
+		defer close(w.done)
 		for {
 			select {
 			case <-w.Quit:
-				break
-			case w.WorkerPool <- w.InMsg:
-				msg := <-w.InMsg
-				timeTrackStart := time.Now()
-				err := w.DecoderParams.DecoderFunc(msg)
-				timeTrackStop := time.Now()
-
-				if err != nil && w.DecoderParams.ErrorCallback != nil {
-					w.DecoderParams.ErrorCallback(w.Name, w.Id, timeTrackStart, timeTrackStop, err)
-				} else if err == nil && w.DecoderParams.DoneCallback != nil {
-					w.DecoderParams.DoneCallback(w.Name, w.Id, timeTrackStart, timeTrackStop)
+				// decode the messages left in the queue
+				for {
+					select {
+					case msg := <-w.InMsg:
+						w.decode(msg)
+					default:
+						//log.Debugf("Worker %v done", w.Id)
+						return
+					}
 				}
+			case msg := <-w.InMsg:
+				w.decode(msg)
 			}
 		}
-		//log.Debugf("Worker %v done", w.Id)
 	}()
 }
 
-// Stop the worker.
+// startPool launches a goroutine adding the input channel of the worker to
+// the pool before decoding each message.
+func (w Worker) startPool() {
+	go func() {
+		defer close(w.done)
+		for {
+			select {
+			case <-w.Quit:
+				return
+			case w.WorkerPool <- w.InMsg:
+				w.decode(<-w.InMsg)
+			}
+		}
+	}()
+}
+
+func (w Worker) decode(msg Message) {
+	timeTrackStart := time.Now()
+	err := w.DecoderParams.DecoderFunc(msg)
+	timeTrackStop := time.Now()
+
+	if err != nil && w.DecoderParams.ErrorCallback != nil {
+		w.DecoderParams.ErrorCallback(w.Name, w.Id, timeTrackStart, timeTrackStop, err)
+	} else if err == nil && w.DecoderParams.DoneCallback != nil {
+		w.DecoderParams.DoneCallback(w.Name, w.Id, timeTrackStart, timeTrackStop)
+	}
+}
+
+// Stop the worker. The queued messages and the one being decoded are
+// finished before the worker exits, Stop returns once it did.
 func (w Worker) Stop() {
 	//log.Debugf("Stopping worker %v", w.Id)
 	w.Quit <- true
+	<-w.done
 }
 
 // Processor structure
 type Processor struct {
-	workerpool    chan chan Message
+	queue         chan Message
 	workerlist    []Worker
 	DecoderParams DecoderParams
 	Name          string
@@ -78,18 +138,29 @@ type DecoderParams struct {
 	DecoderFunc   DecoderFunc
 	DoneCallback  DoneCallback
 	ErrorCallback ErrorCallback
+
+	// Messages waiting for a worker. When the queue is full, messages are
+	// handled following OverflowPolicy (QUEUE_POLICY_BLOCK by default) and
+	// DropCallback is called with the dropped ones.
+	QueueSize      int
+	OverflowPolicy string
+	DropCallback   DropCallback
 }
 
 // Create a message processor which is going to create all the workers and set-up the pool.
 func CreateProcessor(numWorkers int, decoderParams DecoderParams, name string) Processor {
+	queueSize := decoderParams.QueueSize
+	if queueSize < 0 {
+		queueSize = 0
+	}
 	processor := Processor{
-		workerpool:    make(chan chan Message),
+		queue:         make(chan Message, queueSize),
 		workerlist:    make([]Worker, numWorkers),
 		DecoderParams: decoderParams,
 		Name:          name,
 	}
 	for i := 0; i < numWorkers; i++ {
-		worker := CreateWorker(processor.workerpool, decoderParams, i, name)
+		worker := CreateQueueWorker(processor.queue, decoderParams, i, name)
 		processor.workerlist[i] = worker
 	}
 	return processor
@@ -102,14 +173,54 @@ func (p Processor) Start() {
 	}
 }
 
+// Stop the workers once they decoded the messages already sent to the pool.
+// ProcessMessage must not be called anymore.
 func (p Processor) Stop() {
 	for _, worker := range p.workerlist {
 		worker.Stop()
 	}
 }
 
+// QueueLength returns the number of messages waiting for a worker.
+func (p Processor) QueueLength() int {
+	return len(p.queue)
+}
+
+func (p Processor) drop(msg Message) {
+	if p.DecoderParams.DropCallback != nil {
+		p.DecoderParams.DropCallback(p.Name, msg)
+	}
+}
+
 // Send a message to be decoded to the pool.
 func (p Processor) ProcessMessage(msg Message) {
-	sendChannel := <-p.workerpool
-	sendChannel <- msg
+	switch p.DecoderParams.OverflowPolicy {
+	case QUEUE_POLICY_DROP_NEWEST:
+		select {
+		case p.queue <- msg:
+		default:
+			p.drop(msg)
+		}
+	case QUEUE_POLICY_DROP_OLDEST:
+		for {
+			select {
+			case p.queue <- msg:
+				return
+			default:
+			}
+			if cap(p.queue) == 0 {
+				// no queued message to replace
+				p.drop(msg)
+				return
+			}
+			select {
+			case oldMsg := <-p.queue:
+				p.drop(oldMsg)
+			default:
+				// a worker took a message in the meantime
+			}
+		}
+	default:
+		p.queue <- msg
+	}
 }
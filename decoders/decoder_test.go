@@ -0,0 +1,104 @@
+package decoder
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessorStop(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	var decoded int64
+	processor := CreateProcessor(4, DecoderParams{
+		DecoderFunc: func(msg interface{}) error {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&decoded, 1)
+			return nil
+		},
+	}, "test")
+	processor.Start()
+
+	for i := 0; i < 50; i++ {
+		processor.ProcessMessage(i)
+	}
+	processor.Stop()
+
+	assert.Equal(t, int64(50), atomic.LoadInt64(&decoded))
+	testhelpers.WaitGoroutines(t, goroutines)
+}
+
+func TestWorkerPool(t *testing.T) {
+	var decoded int64
+	pool := make(chan chan Message)
+	worker := CreateWorker(pool, DecoderParams{
+		DecoderFunc: func(msg interface{}) error {
+			atomic.AddInt64(&decoded, 1)
+			return nil
+		},
+	}, 0, "test")
+	worker.Start()
+	for i := 0; i < 3; i++ {
+		in := <-pool
+		in <- i
+	}
+	worker.Stop()
+	assert.Equal(t, int64(3), atomic.LoadInt64(&decoded))
+}
+
+// testOverflow queues messages 0 to 3 in a queue of 2 messages while the
+// only worker is decoding message 0 and returns the dropped and decoded ones.
+func testOverflow(t *testing.T, policy string) ([]interface{}, []interface{}) {
+	started := make(chan bool)
+	release := make(chan bool)
+	var lock sync.Mutex
+	var dropped, decoded []interface{}
+	processor := CreateProcessor(1, DecoderParams{
+		DecoderFunc: func(msg interface{}) error {
+			if msg == 0 {
+				started <- true
+				<-release
+			}
+			lock.Lock()
+			decoded = append(decoded, msg)
+			lock.Unlock()
+			return nil
+		},
+		QueueSize:      2,
+		OverflowPolicy: policy,
+		DropCallback: func(name string, msg Message) {
+			assert.Equal(t, "test", name)
+			lock.Lock()
+			dropped = append(dropped, msg)
+			lock.Unlock()
+		},
+	}, "test")
+	processor.Start()
+
+	processor.ProcessMessage(0)
+	<-started
+	for i := 1; i < 4; i++ {
+		processor.ProcessMessage(i)
+	}
+	assert.Equal(t, 2, processor.QueueLength())
+	close(release)
+	processor.Stop()
+	return dropped, decoded
+}
+
+func TestProcessorDropNewest(t *testing.T) {
+	dropped, decoded := testOverflow(t, QUEUE_POLICY_DROP_NEWEST)
+	assert.Equal(t, []interface{}{3}, dropped)
+	assert.Equal(t, []interface{}{0, 1, 2}, decoded)
+}
+
+func TestProcessorDropOldest(t *testing.T) {
+	dropped, decoded := testOverflow(t, QUEUE_POLICY_DROP_OLDEST)
+	assert.Equal(t, []interface{}{1}, dropped)
+	assert.Equal(t, []interface{}{0, 2, 3}, decoded)
+}
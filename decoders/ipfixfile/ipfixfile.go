@@ -0,0 +1,348 @@
+// Package ipfixfile reads and writes IPFIX files (RFC 5655): a sequence of
+// IPFIX messages in which templates precede the data sets using them.
+package ipfixfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+)
+
+type ErrorEncoding struct {
+	msg string
+}
+
+func NewErrorEncoding(msg string) *ErrorEncoding {
+	return &ErrorEncoding{
+		msg: msg,
+	}
+}
+
+func (e *ErrorEncoding) Error() string {
+	return fmt.Sprintf("Error encoding IPFIX: %v", e.msg)
+}
+
+type templateKey struct {
+	obsDomainId uint32
+	templateId  uint16
+}
+
+// Writer serializes IPFIX packets into a file.
+type Writer struct {
+	w io.Writer
+
+	// Templates already written in the file.
+	templates map[templateKey]interface{}
+
+	// Optional source of the templates of data sets whose template was not
+	// received in the same packet (eg: templates learnt by a collector).
+	Templates netflow.NetFlowTemplateSystem
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:         w,
+		templates: make(map[templateKey]interface{}),
+	}
+}
+
+// WritePacket writes packet as one IPFIX message. Templates of the data sets
+// which have not been written in the file yet are written first, in a
+// separate message, using the Templates system.
+func (w *Writer) WritePacket(packet netflow.IPFIXPacket) error {
+	missing := make([]interface{}, 0)
+	for _, flowSet := range packet.FlowSets {
+		switch flowSet := flowSet.(type) {
+		case netflow.TemplateFlowSet:
+			for _, record := range flowSet.Records {
+				if record.FieldCount == 0 && len(record.Fields) == 0 {
+					w.withdraw(packet.ObservationDomainId, record.TemplateId, false)
+					continue
+				}
+				w.templates[templateKey{packet.ObservationDomainId, record.TemplateId}] = record
+			}
+		case netflow.IPFIXOptionsTemplateFlowSet:
+			for _, record := range flowSet.Records {
+				if record.FieldCount == 0 && len(record.Scopes)+len(record.Options) == 0 {
+					w.withdraw(packet.ObservationDomainId, record.TemplateId, true)
+					continue
+				}
+				w.templates[templateKey{packet.ObservationDomainId, record.TemplateId}] = record
+			}
+		case netflow.DataFlowSet:
+			missing = w.appendMissingTemplate(missing, packet.ObservationDomainId, flowSet.Id)
+		case netflow.OptionsDataFlowSet:
+			missing = w.appendMissingTemplate(missing, packet.ObservationDomainId, flowSet.Id)
+		}
+	}
+
+	if len(missing) > 0 {
+		templatePacket := netflow.IPFIXPacket{
+			Version:             10,
+			ExportTime:          packet.ExportTime,
+			SequenceNumber:      packet.SequenceNumber,
+			ObservationDomainId: packet.ObservationDomainId,
+		}
+		for _, template := range missing {
+			switch template := template.(type) {
+			case netflow.TemplateRecord:
+				templatePacket.FlowSets = append(templatePacket.FlowSets, netflow.TemplateFlowSet{
+					FlowSetHeader: netflow.FlowSetHeader{Id: 2},
+					Records:       []netflow.TemplateRecord{template},
+				})
+			case netflow.IPFIXOptionsTemplateRecord:
+				templatePacket.FlowSets = append(templatePacket.FlowSets, netflow.IPFIXOptionsTemplateFlowSet{
+					FlowSetHeader: netflow.FlowSetHeader{Id: 3},
+					Records:       []netflow.IPFIXOptionsTemplateRecord{template},
+				})
+			}
+		}
+		err := w.writePacket(templatePacket)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.writePacket(packet)
+}
+
+func (w *Writer) appendMissingTemplate(missing []interface{}, obsDomainId uint32, templateId uint16) []interface{} {
+	key := templateKey{obsDomainId, templateId}
+	if _, ok := w.templates[key]; ok || w.Templates == nil {
+		return missing
+	}
+	template, err := w.Templates.GetTemplate(10, obsDomainId, templateId)
+	if err != nil {
+		return missing
+	}
+	switch template.(type) {
+	case netflow.TemplateRecord, netflow.IPFIXOptionsTemplateRecord:
+		w.templates[key] = template
+		missing = append(missing, template)
+	}
+	return missing
+}
+
+func (w *Writer) writePacket(packet netflow.IPFIXPacket) error {
+	b, err := w.encodePacket(packet)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(b)
+	return err
+}
+
+// WriteMessage writes a raw IPFIX message, such as received from an exporter.
+// Its templates are recorded in order to write the following packets.
+func (w *Writer) WriteMessage(msg []byte) error {
+	_, err := netflow.DecodeMessage(bytes.NewBuffer(msg), &recordingTemplateSystem{w})
+	if _, ok := err.(*netflow.ErrorTemplateNotFound); err != nil && !ok {
+		return err
+	}
+	_, err = w.w.Write(msg)
+	return err
+}
+
+// recordingTemplateSystem records the templates of raw messages into the
+// writer, and uses the writer ones to decode.
+type recordingTemplateSystem struct {
+	writer *Writer
+}
+
+func (s *recordingTemplateSystem) AddTemplate(version uint16, obsDomainId uint32, template interface{}) {
+	switch templatec := template.(type) {
+	case netflow.TemplateRecord:
+		s.writer.templates[templateKey{obsDomainId, templatec.TemplateId}] = template
+	case netflow.IPFIXOptionsTemplateRecord:
+		s.writer.templates[templateKey{obsDomainId, templatec.TemplateId}] = template
+	}
+}
+
+func (s *recordingTemplateSystem) RemoveTemplate(version uint16, obsDomainId uint32, templateId uint16) {
+	delete(s.writer.templates, templateKey{obsDomainId, templateId})
+}
+
+func (s *recordingTemplateSystem) RemoveTemplates(version uint16, obsDomainId uint32, options bool) {
+	templateId := uint16(netflow.IPFIX_TEMPLATE_SET_ID)
+	if options {
+		templateId = netflow.IPFIX_OPTIONS_TEMPLATE_SET_ID
+	}
+	s.writer.withdraw(obsDomainId, templateId, options)
+}
+
+// withdraw forgets the templates withdrawn by a record written to the file.
+func (w *Writer) withdraw(obsDomainId uint32, templateId uint16, options bool) {
+	if (!options && templateId != netflow.IPFIX_TEMPLATE_SET_ID) || (options && templateId != netflow.IPFIX_OPTIONS_TEMPLATE_SET_ID) {
+		delete(w.templates, templateKey{obsDomainId, templateId})
+		return
+	}
+	for key, template := range w.templates {
+		_, isTemplate := template.(netflow.TemplateRecord)
+		if key.obsDomainId == obsDomainId && isTemplate != options {
+			delete(w.templates, key)
+		}
+	}
+}
+
+func (s *recordingTemplateSystem) GetTemplate(version uint16, obsDomainId uint32, templateId uint16) (interface{}, error) {
+	template, ok := s.writer.templates[templateKey{obsDomainId, templateId}]
+	if !ok {
+		return nil, netflow.NewErrorTemplateNotFound(version, obsDomainId, templateId, "info")
+	}
+	return template, nil
+}
+
+func (w *Writer) encodePacket(packet netflow.IPFIXPacket) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, netflow.IPFIX_HEADER_SIZE))
+	for _, flowSet := range packet.FlowSets {
+		var id uint16
+		setBuf := &bytes.Buffer{}
+		var err error
+		switch flowSet := flowSet.(type) {
+		case netflow.TemplateFlowSet:
+			id = netflow.IPFIX_TEMPLATE_SET_ID
+			for _, record := range flowSet.Records {
+				binary.Write(setBuf, binary.BigEndian, []uint16{record.TemplateId, uint16(len(record.Fields))})
+				encodeFields(setBuf, record.Fields)
+			}
+		case netflow.IPFIXOptionsTemplateFlowSet:
+			id = netflow.IPFIX_OPTIONS_TEMPLATE_SET_ID
+			for _, record := range flowSet.Records {
+				count := uint16(len(record.Scopes) + len(record.Options))
+				if count == 0 {
+					// withdrawal record
+					binary.Write(setBuf, binary.BigEndian, []uint16{record.TemplateId, 0})
+					continue
+				}
+				binary.Write(setBuf, binary.BigEndian, []uint16{record.TemplateId, count, uint16(len(record.Scopes))})
+				encodeFields(setBuf, record.Scopes)
+				encodeFields(setBuf, record.Options)
+			}
+		case netflow.DataFlowSet:
+			id = flowSet.Id
+			template, ok := w.templates[templateKey{packet.ObservationDomainId, id}].(netflow.TemplateRecord)
+			if !ok {
+				return nil, netflow.NewErrorTemplateNotFound(10, packet.ObservationDomainId, id, "data")
+			}
+			for _, record := range flowSet.Records {
+				err = encodeValues(setBuf, template.Fields, record.Values)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case netflow.OptionsDataFlowSet:
+			id = flowSet.Id
+			template, ok := w.templates[templateKey{packet.ObservationDomainId, id}].(netflow.IPFIXOptionsTemplateRecord)
+			if !ok {
+				return nil, netflow.NewErrorTemplateNotFound(10, packet.ObservationDomainId, id, "options")
+			}
+			for _, record := range flowSet.Records {
+				err = encodeValues(setBuf, template.Scopes, record.ScopesValues)
+				if err != nil {
+					return nil, err
+				}
+				err = encodeValues(setBuf, template.Options, record.OptionsValues)
+				if err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, NewErrorEncoding(fmt.Sprintf("unknown set %T", flowSet))
+		}
+		if setBuf.Len()+4 > 65535 {
+			return nil, NewErrorEncoding("set too large")
+		}
+		binary.Write(buf, binary.BigEndian, netflow.FlowSetHeader{Id: id, Length: uint16(setBuf.Len() + 4)})
+		buf.Write(setBuf.Bytes())
+	}
+
+	b := buf.Bytes()
+	if len(b) > 65535 {
+		return nil, NewErrorEncoding("message too large")
+	}
+	binary.BigEndian.PutUint16(b[0:2], 10)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	binary.BigEndian.PutUint32(b[4:8], packet.ExportTime)
+	binary.BigEndian.PutUint32(b[8:12], packet.SequenceNumber)
+	binary.BigEndian.PutUint32(b[12:16], packet.ObservationDomainId)
+	return b, nil
+}
+
+func encodeFields(buf *bytes.Buffer, fields []netflow.Field) {
+	for _, field := range fields {
+		typeId := field.Type
+		if field.PenProvided {
+			typeId |= 0x8000
+		}
+		binary.Write(buf, binary.BigEndian, []uint16{typeId, field.Length})
+		if field.PenProvided {
+			binary.Write(buf, binary.BigEndian, field.Pen)
+		}
+	}
+}
+
+func encodeValues(buf *bytes.Buffer, fields []netflow.Field, values []netflow.DataField) error {
+	if len(fields) != len(values) {
+		return NewErrorEncoding(fmt.Sprintf("%v values for %v fields", len(values), len(fields)))
+	}
+	for i, field := range fields {
+		value, ok := values[i].Value.([]byte)
+		if !ok {
+			return NewErrorEncoding(fmt.Sprintf("value of field %v is not raw", field.Type))
+		}
+		if field.Length == netflow.FIELD_VARIABLE_LENGTH {
+			if len(value) < 255 {
+				buf.WriteByte(byte(len(value)))
+			} else if len(value) <= 65535 {
+				buf.WriteByte(255)
+				binary.Write(buf, binary.BigEndian, uint16(len(value)))
+			} else {
+				return NewErrorEncoding(fmt.Sprintf("value of field %v too long", field.Type))
+			}
+		} else if len(value) != int(field.Length) {
+			return NewErrorEncoding(fmt.Sprintf("value of field %v has %v bytes instead of %v", field.Type, len(value), field.Length))
+		}
+		buf.Write(value)
+	}
+	return nil
+}
+
+// Reader reads the IPFIX messages of a file.
+type Reader struct {
+	r         *bufio.Reader
+	templates *netflow.BasicTemplateSystem
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:         bufio.NewReader(r),
+		templates: netflow.CreateTemplateSystem(),
+	}
+}
+
+// ReadMessage returns the next raw IPFIX message, or io.EOF at the end of the file.
+func (r *Reader) ReadMessage() ([]byte, error) {
+	return netflow.ReadIPFIXMessage(r.r)
+}
+
+// ReadPacket returns the next IPFIX message decoded with the templates
+// previously read from the file.
+func (r *Reader) ReadPacket() (netflow.IPFIXPacket, error) {
+	msg, err := r.ReadMessage()
+	if err != nil {
+		return netflow.IPFIXPacket{}, err
+	}
+	msgDec, err := netflow.DecodeMessage(bytes.NewBuffer(msg), r.templates)
+	if err != nil {
+		return netflow.IPFIXPacket{}, err
+	}
+	packet, ok := msgDec.(netflow.IPFIXPacket)
+	if !ok {
+		return netflow.IPFIXPacket{}, netflow.NewErrorVersion(0)
+	}
+	return packet, nil
+}
@@ -0,0 +1,146 @@
+package ipfixfile
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTemplate() netflow.TemplateRecord {
+	return netflow.TemplateRecord{
+		TemplateId: 256,
+		FieldCount: 3,
+		Fields: []netflow.Field{
+			{Type: netflow.IPFIX_FIELD_sourceIPv4Address, Length: 4},
+			{Type: netflow.IPFIX_FIELD_applicationName, Length: netflow.FIELD_VARIABLE_LENGTH},
+			{PenProvided: true, Type: 1, Length: 2, Pen: 4242},
+		},
+	}
+}
+
+func getData() netflow.DataFlowSet {
+	return netflow.DataFlowSet{
+		FlowSetHeader: netflow.FlowSetHeader{Id: 256},
+		Records: []netflow.DataRecord{
+			{
+				Values: []netflow.DataField{
+					{Type: netflow.IPFIX_FIELD_sourceIPv4Address, Value: []byte{10, 0, 0, 1}},
+					{Type: netflow.IPFIX_FIELD_applicationName, Value: []byte("https")},
+					{PenProvided: true, Type: 1, Pen: 4242, Value: []byte{0, 42}},
+				},
+			},
+			{
+				Values: []netflow.DataField{
+					{Type: netflow.IPFIX_FIELD_sourceIPv4Address, Value: []byte{10, 0, 0, 2}},
+					{Type: netflow.IPFIX_FIELD_applicationName, Value: bytes.Repeat([]byte("a"), 300)},
+					{PenProvided: true, Type: 1, Pen: 4242, Value: []byte{0, 43}},
+				},
+			},
+		},
+	}
+}
+
+func TestWriteReadPacket(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+
+	err := w.WritePacket(netflow.IPFIXPacket{
+		Version:             10,
+		ExportTime:          1600000000,
+		SequenceNumber:      1,
+		ObservationDomainId: 1,
+		FlowSets: []interface{}{
+			netflow.TemplateFlowSet{
+				FlowSetHeader: netflow.FlowSetHeader{Id: 2},
+				Records:       []netflow.TemplateRecord{getTemplate()},
+			},
+			getData(),
+		},
+	})
+	assert.Nil(t, err)
+	err = w.WritePacket(netflow.IPFIXPacket{
+		Version:             10,
+		ExportTime:          1600000001,
+		SequenceNumber:      2,
+		ObservationDomainId: 1,
+		FlowSets:            []interface{}{getData()},
+	})
+	assert.Nil(t, err)
+
+	r := NewReader(buf)
+	for i := 0; i < 2; i++ {
+		packet, err := r.ReadPacket()
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(1600000000+i), packet.ExportTime)
+		data, ok := packet.FlowSets[len(packet.FlowSets)-1].(netflow.DataFlowSet)
+		assert.True(t, ok)
+		assert.Equal(t, getData().Records, data.Records)
+	}
+	_, err = r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWritePacketMissingTemplate(t *testing.T) {
+	packet := netflow.IPFIXPacket{
+		Version:             10,
+		ObservationDomainId: 1,
+		FlowSets:            []interface{}{getData()},
+	}
+
+	w := NewWriter(&bytes.Buffer{})
+	err := w.WritePacket(packet)
+	assert.IsType(t, &netflow.ErrorTemplateNotFound{}, err)
+
+	templates := netflow.CreateTemplateSystem()
+	templates.AddTemplate(10, 1, getTemplate())
+
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Templates = templates
+	err = w.WritePacket(packet)
+	assert.Nil(t, err)
+
+	r := NewReader(buf)
+	packetTemplate, err := r.ReadPacket()
+	assert.Nil(t, err)
+	assert.IsType(t, netflow.TemplateFlowSet{}, packetTemplate.FlowSets[0])
+	packetData, err := r.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, getData().Records, packetData.FlowSets[0].(netflow.DataFlowSet).Records)
+}
+
+func TestWriteMessage(t *testing.T) {
+	encoded := &bytes.Buffer{}
+	err := NewWriter(encoded).WritePacket(netflow.IPFIXPacket{
+		Version:             10,
+		ObservationDomainId: 1,
+		FlowSets: []interface{}{
+			netflow.TemplateFlowSet{
+				FlowSetHeader: netflow.FlowSetHeader{Id: 2},
+				Records:       []netflow.TemplateRecord{getTemplate()},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	err = w.WriteMessage(encoded.Bytes())
+	assert.Nil(t, err)
+	err = w.WritePacket(netflow.IPFIXPacket{
+		Version:             10,
+		ObservationDomainId: 1,
+		FlowSets:            []interface{}{getData()},
+	})
+	assert.Nil(t, err)
+
+	r := NewReader(buf)
+	_, err = r.ReadPacket()
+	assert.Nil(t, err)
+	packet, err := r.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, getData().Records, packet.FlowSets[0].(netflow.DataFlowSet).Records)
+}
@@ -940,13 +940,13 @@ func (flowSet IPFIXOptionsTemplateFlowSet) String(TypeToString func(uint16) stri
 		str += fmt.Sprintf("            Scopes (%v):\n", len(record.Scopes))
 
 		for k, field := range record.Scopes {
-			str += fmt.Sprintf("            - %v. %v (%v): %v\n", k, TypeToString(field.Type), field.Type, field.Length)
+			str += fmt.Sprintf("            - %v. %v: %v\n", k, field.TypeString(TypeToString), field.Length)
 		}
 
 		str += fmt.Sprintf("            Options (%v):\n", len(record.Options))
 
 		for k, field := range record.Options {
-			str += fmt.Sprintf("            - %v. %v (%v): %v\n", k, TypeToString(field.Type), field.Type, field.Length)
+			str += fmt.Sprintf("            - %v. %v: %v\n", k, field.TypeString(TypeToString), field.Length)
 		}
 
 	}
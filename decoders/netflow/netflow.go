@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/cloudflare/goflow/v3/decoders/utils"
 )
@@ -16,6 +19,65 @@ type NetFlowTemplateSystem interface {
 	AddTemplate(version uint16, obsDomainId uint32, template interface{})
 }
 
+// NetFlowTemplateRemover is implemented by the template systems handling
+// IPFIX template withdrawals (RFC 7011 8.1).
+type NetFlowTemplateRemover interface {
+	RemoveTemplate(version uint16, obsDomainId uint32, templateId uint16)
+	// RemoveTemplates removes all the templates (options templates when
+	// options is set) of an observation domain.
+	RemoveTemplates(version uint16, obsDomainId uint32, options bool)
+}
+
+// NetFlowDataSetBuffer is implemented by the template systems keeping the data
+// sets received before their template. packet is the NFv9Packet or IPFIXPacket
+// header and data is only valid during the call. BufferDataSet returns false
+// when the set is not kept, the message then fails with ErrorTemplateNotFound.
+type NetFlowDataSetBuffer interface {
+	BufferDataSet(packet interface{}, fsheader FlowSetHeader, data []byte) bool
+}
+
+// IPFIX set ids. A withdrawal record for the set id withdraws all the
+// templates of the set type.
+const (
+	IPFIX_TEMPLATE_SET_ID         = 2
+	IPFIX_OPTIONS_TEMPLATE_SET_ID = 3
+)
+
+// DecodeField reads a field specifier. In IPFIX, the enterprise bit of the
+// type announces a Private Enterprise Number following the length.
+func DecodeField(version uint16, payload *bytes.Buffer) (Field, error) {
+	field := Field{}
+	err := utils.BinaryDecoder(payload, &field.Type, &field.Length)
+	if err != nil {
+		return field, err
+	}
+	if version == 10 && field.Type&0x8000 != 0 {
+		field.PenProvided = true
+		field.Type &= 0x7fff
+		err = utils.BinaryDecoder(payload, &field.Pen)
+		if err != nil {
+			return field, err
+		}
+	}
+	return field, nil
+}
+
+func DecodeFields(version uint16, payload *bytes.Buffer, count int) ([]Field, error) {
+	// a field specifier is at least 4 bytes long
+	if count > payload.Len()/4 {
+		return nil, NewErrorDecodingNetFlow(fmt.Sprintf("Error decoding fields: %v fields in %v bytes.", count, payload.Len()))
+	}
+	fields := make([]Field, count)
+	for i := 0; i < count; i++ {
+		field, err := DecodeField(version, payload)
+		if err != nil {
+			return fields, err
+		}
+		fields[i] = field
+	}
+	return fields, nil
+}
+
 func DecodeNFv9OptionsTemplateSet(payload *bytes.Buffer) ([]NFv9OptionsTemplateRecord, error) {
 	records := make([]NFv9OptionsTemplateRecord, 0)
 	var err error
@@ -32,19 +94,15 @@ func DecodeNFv9OptionsTemplateSet(payload *bytes.Buffer) ([]NFv9OptionsTemplateR
 			return records, NewErrorDecodingNetFlow("Error decoding OptionsTemplateSet: negative length.")
 		}
 
-		fields := make([]Field, sizeScope)
-		for i := 0; i < sizeScope; i++ {
-			field := Field{}
-			err = utils.BinaryDecoder(payload, &field)
-			fields[i] = field
+		fields, err := DecodeFields(9, payload, sizeScope)
+		if err != nil {
+			return records, err
 		}
 		optsTemplateRecord.Scopes = fields
 
-		fields = make([]Field, sizeOptions)
-		for i := 0; i < sizeOptions; i++ {
-			field := Field{}
-			err = utils.BinaryDecoder(payload, &field)
-			fields[i] = field
+		fields, err = DecodeFields(9, payload, sizeOptions)
+		if err != nil {
+			return records, err
 		}
 		optsTemplateRecord.Options = fields
 
@@ -59,16 +117,27 @@ func DecodeIPFIXOptionsTemplateSet(payload *bytes.Buffer) ([]IPFIXOptionsTemplat
 	var err error
 	for payload.Len() >= 4 {
 		optsTemplateRecord := IPFIXOptionsTemplateRecord{}
-		err = utils.BinaryDecoder(payload, &optsTemplateRecord.TemplateId, &optsTemplateRecord.FieldCount, &optsTemplateRecord.ScopeFieldCount)
+		err = utils.BinaryDecoder(payload, &optsTemplateRecord.TemplateId, &optsTemplateRecord.FieldCount)
 		if err != nil {
 			break
 		}
+		if optsTemplateRecord.FieldCount == 0 {
+			if optsTemplateRecord.TemplateId == 0 {
+				// set padding
+				break
+			}
+			// withdrawal record, without scope field count
+			records = append(records, optsTemplateRecord)
+			continue
+		}
+		err = utils.BinaryDecoder(payload, &optsTemplateRecord.ScopeFieldCount)
+		if err != nil {
+			return records, err
+		}
 
-		fields := make([]Field, int(optsTemplateRecord.ScopeFieldCount))
-		for i := 0; i < int(optsTemplateRecord.ScopeFieldCount); i++ {
-			field := Field{}
-			err = utils.BinaryDecoder(payload, &field)
-			fields[i] = field
+		fields, err := DecodeFields(10, payload, int(optsTemplateRecord.ScopeFieldCount))
+		if err != nil {
+			return records, err
 		}
 		optsTemplateRecord.Scopes = fields
 
@@ -76,11 +145,9 @@ func DecodeIPFIXOptionsTemplateSet(payload *bytes.Buffer) ([]IPFIXOptionsTemplat
 		if optionsSize < 0 {
 			return records, NewErrorDecodingNetFlow("Error decoding OptionsTemplateSet: negative length.")
 		}
-		fields = make([]Field, optionsSize)
-		for i := 0; i < optionsSize; i++ {
-			field := Field{}
-			err = utils.BinaryDecoder(payload, &field)
-			fields[i] = field
+		fields, err = DecodeFields(10, payload, optionsSize)
+		if err != nil {
+			return records, err
 		}
 		optsTemplateRecord.Options = fields
 
@@ -90,7 +157,14 @@ func DecodeIPFIXOptionsTemplateSet(payload *bytes.Buffer) ([]IPFIXOptionsTemplat
 	return records, nil
 }
 
+// DecodeTemplateSet decodes a NetFlow v9 template set.
 func DecodeTemplateSet(payload *bytes.Buffer) ([]TemplateRecord, error) {
+	return DecodeTemplateSetVersion(9, payload)
+}
+
+// DecodeTemplateSetVersion decodes a template set of a NetFlow v9 or IPFIX
+// (enterprise fields and withdrawals) message.
+func DecodeTemplateSetVersion(version uint16, payload *bytes.Buffer) ([]TemplateRecord, error) {
 	records := make([]TemplateRecord, 0)
 	var err error
 	for payload.Len() >= 4 {
@@ -101,14 +175,21 @@ func DecodeTemplateSet(payload *bytes.Buffer) ([]TemplateRecord, error) {
 		}
 
 		if templateRecord.FieldCount == 0 {
-			return records, NewErrorDecodingNetFlow("Error decoding TemplateSet: zero count.")
+			if templateRecord.TemplateId == 0 {
+				// set padding
+				break
+			}
+			if version != 10 {
+				return records, NewErrorDecodingNetFlow("Error decoding TemplateSet: zero count.")
+			}
+			// withdrawal record
+			records = append(records, templateRecord)
+			continue
 		}
 
-		fields := make([]Field, int(templateRecord.FieldCount))
-		for i := 0; i < int(templateRecord.FieldCount); i++ {
-			field := Field{}
-			err = utils.BinaryDecoder(payload, &field)
-			fields[i] = field
+		fields, err := DecodeFields(version, payload, int(templateRecord.FieldCount))
+		if err != nil {
+			return records, err
 		}
 		templateRecord.Fields = fields
 		records = append(records, templateRecord)
@@ -117,30 +198,114 @@ func DecodeTemplateSet(payload *bytes.Buffer) ([]TemplateRecord, error) {
 	return records, nil
 }
 
+// FIELD_VARIABLE_LENGTH is the length announced by an IPFIX template for
+// Information Elements whose size is carried in each data record (RFC 7011 7).
+// NetFlow v9 has no variable-length fields.
+const FIELD_VARIABLE_LENGTH = 65535
+
+// MAX_DATA_FIELDS_PER_SET bounds the values decoded from a set. A set is at
+// most 65535 bytes long: only templates with zero-length fields exceed it.
+const MAX_DATA_FIELDS_PER_SET = 65535
+
+// GetTemplateSize returns the size of a NetFlow v9 record described by template.
 func GetTemplateSize(template []Field) int {
+	return GetTemplateSizeVersion(9, template)
+}
+
+// GetTemplateSizeVersion returns the minimum size of a record described by
+// template. IPFIX variable-length fields count for their one byte length prefix.
+func GetTemplateSizeVersion(version uint16, template []Field) int {
 	sum := 0
 	for _, templateField := range template {
-		sum += int(templateField.Length)
+		if version == 10 && templateField.Length == FIELD_VARIABLE_LENGTH {
+			sum += 1
+		} else {
+			sum += int(templateField.Length)
+		}
 	}
 	return sum
 }
 
+// DecodeFieldLength returns the size of the next value of templateField. For an
+// IPFIX variable-length field, the one byte or three bytes prefix is consumed.
+func DecodeFieldLength(version uint16, payload *bytes.Buffer, templateField Field) (int, error) {
+	if version != 10 || templateField.Length != FIELD_VARIABLE_LENGTH {
+		return int(templateField.Length), nil
+	}
+	var lengthShort uint8
+	err := utils.BinaryDecoder(payload, &lengthShort)
+	if err != nil {
+		return 0, err
+	}
+	if lengthShort < 255 {
+		return int(lengthShort), nil
+	}
+	var lengthLong uint16
+	err = utils.BinaryDecoder(payload, &lengthLong)
+	if err != nil {
+		return 0, err
+	}
+	return int(lengthLong), nil
+}
+
+// DecodeDataSetUsingFields decodes a NetFlow v9 record. The values of a
+// truncated record are left empty, DecodeDataSetUsingFieldsVersion returns the error.
 func DecodeDataSetUsingFields(payload *bytes.Buffer, listFields []Field) []DataField {
-	for payload.Len() >= GetTemplateSize(listFields) {
+	dataFields, _ := DecodeDataSetUsingFieldsVersion(9, payload, listFields)
+	return dataFields
+}
 
-		dataFields := make([]DataField, len(listFields))
+// DecodeDataSetUsingFieldsVersion decodes a record of a NetFlow v9 or IPFIX
+// (variable-length fields) message.
+func DecodeDataSetUsingFieldsVersion(version uint16, payload *bytes.Buffer, listFields []Field) ([]DataField, error) {
+	dataFields := make([]DataField, len(listFields))
+	err := decodeDataFields(version, payload, listFields, dataFields)
+	return dataFields, err
+}
 
-		for i, templateField := range listFields {
-			value := payload.Next(int(templateField.Length))
-			nfvalue := DataField{
-				Type:  templateField.Type,
-				Value: value,
-			}
-			dataFields[i] = nfvalue
+// decodeDataFields decodes a record into dataFields, which has one value per field.
+func decodeDataFields(version uint16, payload *bytes.Buffer, listFields []Field, dataFields []DataField) error {
+	for i, templateField := range listFields {
+		length, err := DecodeFieldLength(version, payload, templateField)
+		if err != nil {
+			return err
+		}
+		if payload.Len() < length {
+			return NewErrorDecodingNetFlow(fmt.Sprintf("Error decoding field %v: needs %v bytes, has %v.", templateField.Type, length, payload.Len()))
+		}
+		value := payload.Next(length)
+		dataFields[i] = DataField{
+			Type:        templateField.Type,
+			PenProvided: templateField.PenProvided,
+			Pen:         templateField.Pen,
+			Value:       value,
 		}
-		return dataFields
 	}
-	return []DataField{}
+	return nil
+}
+
+// dataFieldsAllocator hands out the values of the records of a set from a
+// single allocation sized for the records the set can hold.
+type dataFieldsAllocator struct {
+	values []DataField
+}
+
+func newDataFieldsAllocator(payloadLen int, recordSize int, fieldsCount int) *dataFieldsAllocator {
+	allocator := &dataFieldsAllocator{}
+	// templates with zero-length fields could make the estimate much larger than the set
+	if recordSize > 0 && fieldsCount <= recordSize {
+		allocator.values = make([]DataField, (payloadLen/recordSize)*fieldsCount)
+	}
+	return allocator
+}
+
+func (a *dataFieldsAllocator) get(count int) []DataField {
+	if len(a.values) < count {
+		return make([]DataField, count)
+	}
+	values := a.values[:count:count]
+	a.values = a.values[count:]
+	return values
 }
 
 type ErrorTemplateNotFound struct {
@@ -205,17 +370,61 @@ func (e *ErrorDecodingNetFlow) Error() string {
 	return fmt.Sprintf("Error decoding NetFlow: %v", e.msg)
 }
 
+// ErrorFlowSets lists the flow sets skipped by DecodeMessagePartial.
+type ErrorFlowSets struct {
+	ids    []uint16
+	errors []error
+}
+
+func (e *ErrorFlowSets) add(id uint16, err error) {
+	e.ids = append(e.ids, id)
+	e.errors = append(e.errors, err)
+}
+
+func (e *ErrorFlowSets) Error() string {
+	str := fmt.Sprintf("Skipped %v flow sets:", len(e.errors))
+	for i, err := range e.errors {
+		str += fmt.Sprintf(" flow set %v: %v;", e.ids[i], err)
+	}
+	return strings.TrimSuffix(str, ";")
+}
+
+// Unwrap returns the error of each skipped flow set.
+func (e *ErrorFlowSets) Unwrap() []error {
+	return e.errors
+}
+
 func DecodeOptionsDataSet(payload *bytes.Buffer, listFieldsScopes, listFieldsOption []Field) ([]OptionsDataRecord, error) {
-	records := make([]OptionsDataRecord, 0)
+	return DecodeOptionsDataSetVersion(9, payload, listFieldsScopes, listFieldsOption)
+}
 
-	listFieldsScopesSize := GetTemplateSize(listFieldsScopes)
-	listFieldsOptionSize := GetTemplateSize(listFieldsOption)
+// DecodeOptionsDataSetVersion decodes an options data set of a NetFlow v9 or
+// IPFIX (variable-length fields) message.
+func DecodeOptionsDataSetVersion(version uint16, payload *bytes.Buffer, listFieldsScopes, listFieldsOption []Field) ([]OptionsDataRecord, error) {
+	listFieldsScopesSize := GetTemplateSizeVersion(version, listFieldsScopes)
+	listFieldsOptionSize := GetTemplateSizeVersion(version, listFieldsOption)
+	if listFieldsScopesSize+listFieldsOptionSize == 0 {
+		return make([]OptionsDataRecord, 0), NewErrorDecodingNetFlow("Error decoding OptionsDataSet: empty template.")
+	}
 
-	for payload.Len() >= listFieldsScopesSize+listFieldsOptionSize {
-		payloadLim := bytes.NewBuffer(payload.Next(listFieldsScopesSize))
-		scopeValues := DecodeDataSetUsingFields(payloadLim, listFieldsScopes)
-		payloadLim = bytes.NewBuffer(payload.Next(listFieldsOptionSize))
-		optionValues := DecodeDataSetUsingFields(payloadLim, listFieldsOption)
+	recordSize := listFieldsScopesSize + listFieldsOptionSize
+	records := make([]OptionsDataRecord, 0, payload.Len()/recordSize)
+	allocator := newDataFieldsAllocator(payload.Len(), recordSize, len(listFieldsScopes)+len(listFieldsOption))
+	for payload.Len() >= recordSize {
+		if (len(records)+1)*(len(listFieldsScopes)+len(listFieldsOption)) > MAX_DATA_FIELDS_PER_SET {
+			return records, NewErrorDecodingNetFlow("Error decoding OptionsDataSet: too many fields.")
+		}
+		// a remainder shorter than a record is set padding, the loop stops before it
+		scopeValues := allocator.get(len(listFieldsScopes))
+		err := decodeDataFields(version, payload, listFieldsScopes, scopeValues)
+		if err != nil {
+			return records, NewErrorDecodingNetFlow(fmt.Sprintf("Error decoding OptionsDataSet record %v: %v", len(records), err))
+		}
+		optionValues := allocator.get(len(listFieldsOption))
+		err = decodeDataFields(version, payload, listFieldsOption, optionValues)
+		if err != nil {
+			return records, NewErrorDecodingNetFlow(fmt.Sprintf("Error decoding OptionsDataSet record %v: %v", len(records), err))
+		}
 
 		record := OptionsDataRecord{
 			ScopesValues:  scopeValues,
@@ -228,12 +437,29 @@ func DecodeOptionsDataSet(payload *bytes.Buffer, listFieldsScopes, listFieldsOpt
 }
 
 func DecodeDataSet(payload *bytes.Buffer, listFields []Field) ([]DataRecord, error) {
-	records := make([]DataRecord, 0)
+	return DecodeDataSetVersion(9, payload, listFields)
+}
 
-	listFieldsSize := GetTemplateSize(listFields)
+// DecodeDataSetVersion decodes a data set of a NetFlow v9 or IPFIX
+// (variable-length fields) message.
+func DecodeDataSetVersion(version uint16, payload *bytes.Buffer, listFields []Field) ([]DataRecord, error) {
+	listFieldsSize := GetTemplateSizeVersion(version, listFields)
+	if listFieldsSize == 0 {
+		return make([]DataRecord, 0), NewErrorDecodingNetFlow("Error decoding DataSet: empty template.")
+	}
+
+	records := make([]DataRecord, 0, payload.Len()/listFieldsSize)
+	allocator := newDataFieldsAllocator(payload.Len(), listFieldsSize, len(listFields))
 	for payload.Len() >= listFieldsSize {
-		payloadLim := bytes.NewBuffer(payload.Next(listFieldsSize))
-		values := DecodeDataSetUsingFields(payloadLim, listFields)
+		if (len(records)+1)*len(listFields) > MAX_DATA_FIELDS_PER_SET {
+			return records, NewErrorDecodingNetFlow("Error decoding DataSet: too many fields.")
+		}
+		// a remainder shorter than a record is set padding, the loop stops before it
+		values := allocator.get(len(listFields))
+		err := decodeDataFields(version, payload, listFields, values)
+		if err != nil {
+			return records, NewErrorDecodingNetFlow(fmt.Sprintf("Error decoding DataSet record %v: %v", len(records), err))
+		}
 
 		record := DataRecord{
 			Values: values,
@@ -244,13 +470,39 @@ func DecodeDataSet(payload *bytes.Buffer, listFields []Field) ([]DataRecord, err
 	return records, nil
 }
 
+// GetTemplates returns a copy of the templates which have not expired.
 func (ts *BasicTemplateSystem) GetTemplates() map[uint16]map[uint32]map[uint16]interface{} {
 	ts.templateslock.RLock()
-	tmp := ts.templates
-	ts.templateslock.RUnlock()
+	defer ts.templateslock.RUnlock()
+	now := time.Now()
+	tmp := make(FlowBaseTemplateSet)
+	for version, templatesVersion := range ts.templates {
+		tmp[version] = make(map[uint32]map[uint16]interface{})
+		for obsDomainId, templatesObsDom := range templatesVersion {
+			tmp[version][obsDomainId] = make(map[uint16]interface{})
+			for templateId, template := range templatesObsDom {
+				if ts.expired(version, obsDomainId, templateId, now) {
+					continue
+				}
+				tmp[version][obsDomainId][templateId] = template
+			}
+		}
+	}
 	return tmp
 }
 
+func getTemplateId(template interface{}) uint16 {
+	switch templateIdConv := template.(type) {
+	case IPFIXOptionsTemplateRecord:
+		return templateIdConv.TemplateId
+	case NFv9OptionsTemplateRecord:
+		return templateIdConv.TemplateId
+	case TemplateRecord:
+		return templateIdConv.TemplateId
+	}
+	return 0
+}
+
 func (ts *BasicTemplateSystem) AddTemplate(version uint16, obsDomainId uint32, template interface{}) {
 	ts.templateslock.Lock()
 	_, exists := ts.templates[version]
@@ -261,54 +513,197 @@ func (ts *BasicTemplateSystem) AddTemplate(version uint16, obsDomainId uint32, t
 	if !exists {
 		ts.templates[version][obsDomainId] = make(map[uint16]interface{})
 	}
-	var templateId uint16
-	switch templateIdConv := template.(type) {
-	case IPFIXOptionsTemplateRecord:
-		templateId = templateIdConv.TemplateId
-	case NFv9OptionsTemplateRecord:
-		templateId = templateIdConv.TemplateId
-	case TemplateRecord:
-		templateId = templateIdConv.TemplateId
-	}
+	templateId := getTemplateId(template)
 	ts.templates[version][obsDomainId][templateId] = template
+	ts.updated[templateKey{version, obsDomainId, templateId}] = time.Now()
 	ts.templateslock.Unlock()
 }
 
-func (ts *BasicTemplateSystem) GetTemplate(version uint16, obsDomainId uint32, templateId uint16) (interface{}, error) {
+// RemoveTemplate removes a template (eg: IPFIX withdrawal).
+func (ts *BasicTemplateSystem) RemoveTemplate(version uint16, obsDomainId uint32, templateId uint16) {
+	ts.templateslock.Lock()
+	if templatesObsDom, ok := ts.templates[version][obsDomainId]; ok {
+		delete(templatesObsDom, templateId)
+	}
+	delete(ts.updated, templateKey{version, obsDomainId, templateId})
+	ts.templateslock.Unlock()
+}
+
+// RemoveTemplates removes the templates, or the options templates, of an
+// observation domain.
+func (ts *BasicTemplateSystem) RemoveTemplates(version uint16, obsDomainId uint32, options bool) {
+	ts.templateslock.Lock()
+	for templateId, template := range ts.templates[version][obsDomainId] {
+		_, isTemplate := template.(TemplateRecord)
+		if isTemplate == options {
+			continue
+		}
+		delete(ts.templates[version][obsDomainId], templateId)
+		delete(ts.updated, templateKey{version, obsDomainId, templateId})
+	}
+	ts.templateslock.Unlock()
+}
+
+// TemplateUpdated returns when a template was last received.
+func (ts *BasicTemplateSystem) TemplateUpdated(version uint16, obsDomainId uint32, templateId uint16) (time.Time, bool) {
 	ts.templateslock.RLock()
-	templatesVersion, okver := ts.templates[version]
-	if okver {
-		templatesObsDom, okobs := templatesVersion[obsDomainId]
-		if okobs {
-			template, okid := templatesObsDom[templateId]
-			if okid {
-				ts.templateslock.RUnlock()
-				return template, nil
-			}
-			ts.templateslock.RUnlock()
-			return nil, NewErrorTemplateNotFound(version, obsDomainId, templateId, "info")
+	updated, ok := ts.updated[templateKey{version, obsDomainId, templateId}]
+	ts.templateslock.RUnlock()
+	return updated, ok
+}
+
+// RemoveExpiredTemplates deletes the NetFlow v9 templates which were not
+// received again for NFv9Timeout. Until then they are only ignored.
+func (ts *BasicTemplateSystem) RemoveExpiredTemplates() {
+	ts.templateslock.Lock()
+	now := time.Now()
+	for key := range ts.updated {
+		if !ts.expired(key.version, key.obsDomainId, key.templateId, now) {
+			continue
+		}
+		delete(ts.templates[key.version][key.obsDomainId], key.templateId)
+		if len(ts.templates[key.version][key.obsDomainId]) == 0 {
+			delete(ts.templates[key.version], key.obsDomainId)
 		}
-		ts.templateslock.RUnlock()
+		delete(ts.updated, key)
+	}
+	ts.templateslock.Unlock()
+}
+
+// expired must be called with the lock held.
+func (ts *BasicTemplateSystem) expired(version uint16, obsDomainId uint32, templateId uint16, now time.Time) bool {
+	if version != 9 || ts.NFv9Timeout <= 0 {
+		return false
+	}
+	updated, ok := ts.updated[templateKey{version, obsDomainId, templateId}]
+	return ok && now.Sub(updated) > ts.NFv9Timeout
+}
+
+func (ts *BasicTemplateSystem) GetTemplate(version uint16, obsDomainId uint32, templateId uint16) (interface{}, error) {
+	ts.templateslock.RLock()
+	defer ts.templateslock.RUnlock()
+	template, ok := ts.templates[version][obsDomainId][templateId]
+	if !ok || ts.expired(version, obsDomainId, templateId, time.Now()) {
 		return nil, NewErrorTemplateNotFound(version, obsDomainId, templateId, "info")
 	}
-	ts.templateslock.RUnlock()
-	return nil, NewErrorTemplateNotFound(version, obsDomainId, templateId, "info")
+	return template, nil
+}
+
+type templateKey struct {
+	version     uint16
+	obsDomainId uint32
+	templateId  uint16
 }
 
 type BasicTemplateSystem struct {
 	templates     FlowBaseTemplateSet
+	updated       map[templateKey]time.Time
 	templateslock *sync.RWMutex
+
+	// NetFlow v9 templates not received again for this long are ignored
+	// (0 keeps them). Set it before using the template system.
+	NFv9Timeout time.Duration
 }
 
 func CreateTemplateSystem() *BasicTemplateSystem {
 	ts := &BasicTemplateSystem{
 		templates:     make(FlowBaseTemplateSet),
+		updated:       make(map[templateKey]time.Time),
 		templateslock: &sync.RWMutex{},
 	}
 	return ts
 }
 
+// IPFIX_HEADER_SIZE is the size of the IPFIX message header.
+const IPFIX_HEADER_SIZE = 16
+
+// ReadIPFIXMessage reads one IPFIX message from a stream (TCP connection,
+// file...) using the length of its header. It returns io.EOF when the stream
+// ends between two messages.
+func ReadIPFIXMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	version := binary.BigEndian.Uint16(header[0:2])
+	if version != 10 {
+		return nil, NewErrorVersion(version)
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < IPFIX_HEADER_SIZE {
+		return nil, NewErrorDecodingNetFlow(fmt.Sprintf("Error framing IPFIX message: length %v.", length))
+	}
+	msg := make([]byte, length)
+	copy(msg, header)
+	_, err = io.ReadFull(r, msg[4:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return msg, err
+}
+
+// DecodeDataFlowSet decodes a data set of a NetFlow v9 or IPFIX message with
+// its template or options template.
+func DecodeDataFlowSet(version uint16, fsheader FlowSetHeader, payload *bytes.Buffer, template interface{}) (interface{}, error) {
+	switch templatec := template.(type) {
+	case TemplateRecord:
+		records, err := DecodeDataSetVersion(version, payload, templatec.Fields)
+		if err != nil {
+			return nil, err
+		}
+		return DataFlowSet{
+			FlowSetHeader: fsheader,
+			Records:       records,
+		}, nil
+	case IPFIXOptionsTemplateRecord:
+		records, err := DecodeOptionsDataSetVersion(10, payload, templatec.Scopes, templatec.Options)
+		if err != nil {
+			return nil, err
+		}
+		return OptionsDataFlowSet{
+			FlowSetHeader: fsheader,
+			Records:       records,
+		}, nil
+	case NFv9OptionsTemplateRecord:
+		records, err := DecodeOptionsDataSet(payload, templatec.Scopes, templatec.Options)
+		if err != nil {
+			return nil, err
+		}
+		return OptionsDataFlowSet{
+			FlowSetHeader: fsheader,
+			Records:       records,
+		}, nil
+	}
+	return nil, nil
+}
+
+// withdrawTemplate applies an IPFIX withdrawal record when the template
+// system supports it.
+func withdrawTemplate(templates NetFlowTemplateSystem, obsDomainId uint32, templateId uint16, options bool) {
+	remover, ok := templates.(NetFlowTemplateRemover)
+	if !ok {
+		return
+	}
+	if (!options && templateId == IPFIX_TEMPLATE_SET_ID) || (options && templateId == IPFIX_OPTIONS_TEMPLATE_SET_ID) {
+		remover.RemoveTemplates(10, obsDomainId, options)
+	} else {
+		remover.RemoveTemplate(10, obsDomainId, templateId)
+	}
+}
+
 func DecodeMessage(payload *bytes.Buffer, templates NetFlowTemplateSystem) (interface{}, error) {
+	return decodeMessage(payload, templates, false)
+}
+
+// DecodeMessagePartial decodes the flow sets of a message like DecodeMessage
+// but skips the flow sets which cannot be decoded. The message is returned with
+// the other flow sets and an *ErrorFlowSets listing the skipped ones.
+func DecodeMessagePartial(payload *bytes.Buffer, templates NetFlowTemplateSystem) (interface{}, error) {
+	return decodeMessage(payload, templates, true)
+}
+
+func decodeMessage(payload *bytes.Buffer, templates NetFlowTemplateSystem, partial bool) (interface{}, error) {
 	var size uint16
 	packetNFv9 := NFv9Packet{}
 	packetIPFIX := IPFIXPacket{}
@@ -316,16 +711,20 @@ func DecodeMessage(payload *bytes.Buffer, templates NetFlowTemplateSystem) (inte
 
 	var version uint16
 	var obsDomainId uint32
-	binary.Read(payload, binary.BigEndian, &version)
+	var setErrors ErrorFlowSets
+	err := utils.BinaryDecoder(payload, &version)
+	if err != nil {
+		return nil, NewErrorDecodingNetFlow("Error decoding packet: truncated header.")
+	}
 
 	if version == 9 {
-		utils.BinaryDecoder(payload, &packetNFv9.Count, &packetNFv9.SystemUptime, &packetNFv9.UnixSeconds, &packetNFv9.SequenceNumber, &packetNFv9.SourceId)
+		err = utils.BinaryDecoder(payload, &packetNFv9.Count, &packetNFv9.SystemUptime, &packetNFv9.UnixSeconds, &packetNFv9.SequenceNumber, &packetNFv9.SourceId)
 		size = packetNFv9.Count
 		packetNFv9.Version = version
 		returnItem = packetNFv9
 		obsDomainId = packetNFv9.SourceId
 	} else if version == 10 {
-		utils.BinaryDecoder(payload, &packetIPFIX.Length, &packetIPFIX.ExportTime, &packetIPFIX.SequenceNumber, &packetIPFIX.ObservationDomainId)
+		err = utils.BinaryDecoder(payload, &packetIPFIX.Length, &packetIPFIX.ExportTime, &packetIPFIX.SequenceNumber, &packetIPFIX.ObservationDomainId)
 		size = packetIPFIX.Length
 		packetIPFIX.Version = version
 		returnItem = packetIPFIX
@@ -333,154 +732,161 @@ func DecodeMessage(payload *bytes.Buffer, templates NetFlowTemplateSystem) (inte
 	} else {
 		return nil, NewErrorVersion(version)
 	}
+	if err != nil {
+		return nil, NewErrorDecodingNetFlow("Error decoding packet: truncated header.")
+	}
 
 	for i := 0; ((i < int(size) && version == 9) || version == 10) && payload.Len() > 0; i++ {
 		fsheader := FlowSetHeader{}
-		utils.BinaryDecoder(payload, &fsheader)
+		err := utils.BinaryDecoder(payload, &fsheader)
 
 		nextrelpos := int(fsheader.Length) - binary.Size(fsheader)
-		if nextrelpos < 0 {
-			return returnItem, NewErrorDecodingNetFlow("Error decoding packet: non-terminated stream.")
+		if err != nil || nextrelpos < 0 || nextrelpos > payload.Len() {
+			err := NewErrorDecodingNetFlow("Error decoding packet: non-terminated stream.")
+			if !partial {
+				return returnItem, err
+			}
+			// the next flow sets cannot be found
+			setErrors.add(fsheader.Id, err)
+			break
 		}
 
-		var flowSet interface{}
-
-		if fsheader.Id == 0 && version == 9 {
-			templateReader := bytes.NewBuffer(payload.Next(nextrelpos))
-			records, err := DecodeTemplateSet(templateReader)
-			if err != nil {
+		// returnItem holds the packet header without flow sets
+		flowSet, err := decodeFlowSet(version, obsDomainId, returnItem, fsheader, payload.Next(nextrelpos), templates)
+		if err != nil {
+			if !partial {
 				return returnItem, err
 			}
-			templatefs := TemplateFlowSet{
-				FlowSetHeader: fsheader,
-				Records:       records,
-			}
+			setErrors.add(fsheader.Id, err)
+			continue
+		}
+
+		if version == 9 && flowSet != nil {
+			packetNFv9.FlowSets = append(packetNFv9.FlowSets, flowSet)
+		} else if version == 10 && flowSet != nil {
+			packetIPFIX.FlowSets = append(packetIPFIX.FlowSets, flowSet)
+		}
+	}
 
-			flowSet = templatefs
+	err = nil
+	if len(setErrors.errors) > 0 {
+		err = &setErrors
+	}
+	if version == 9 {
+		return packetNFv9, err
+	} else if version == 10 {
+		return packetIPFIX, err
+	} else {
+		return returnItem, NewErrorVersion(version)
+	}
+}
 
-			if templates != nil {
-				for _, record := range records {
-					templates.AddTemplate(version, obsDomainId, record)
-				}
-			}
+// decodeFlowSet decodes the payload of a flow set and updates the templates.
+// header is the packet header without flow sets, a nil flow set is not added
+// to the packet.
+func decodeFlowSet(version uint16, obsDomainId uint32, header interface{}, fsheader FlowSetHeader, setPayload []byte, templates NetFlowTemplateSystem) (interface{}, error) {
+	var flowSet interface{}
 
-		} else if fsheader.Id == 1 && version == 9 {
-			templateReader := bytes.NewBuffer(payload.Next(nextrelpos))
-			records, err := DecodeNFv9OptionsTemplateSet(templateReader)
-			if err != nil {
-				return returnItem, err
-			}
-			optsTemplatefs := NFv9OptionsTemplateFlowSet{
-				FlowSetHeader: fsheader,
-				Records:       records,
-			}
-			flowSet = optsTemplatefs
+	if fsheader.Id == 0 && version == 9 {
+		templateReader := bytes.NewBuffer(setPayload)
+		records, err := DecodeTemplateSetVersion(version, templateReader)
+		if err != nil {
+			return nil, err
+		}
+		templatefs := TemplateFlowSet{
+			FlowSetHeader: fsheader,
+			Records:       records,
+		}
 
-			if templates != nil {
-				for _, record := range records {
-					templates.AddTemplate(version, obsDomainId, record)
-				}
-			}
+		flowSet = templatefs
 
-		} else if fsheader.Id == 2 && version == 10 {
-			templateReader := bytes.NewBuffer(payload.Next(nextrelpos))
-			records, err := DecodeTemplateSet(templateReader)
-			if err != nil {
-				return returnItem, err
+		if templates != nil {
+			for _, record := range records {
+				templates.AddTemplate(version, obsDomainId, record)
 			}
-			templatefs := TemplateFlowSet{
-				FlowSetHeader: fsheader,
-				Records:       records,
-			}
-			flowSet = templatefs
+		}
 
-			if templates != nil {
-				for _, record := range records {
-					templates.AddTemplate(version, obsDomainId, record)
-				}
-			}
+	} else if fsheader.Id == 1 && version == 9 {
+		templateReader := bytes.NewBuffer(setPayload)
+		records, err := DecodeNFv9OptionsTemplateSet(templateReader)
+		if err != nil {
+			return nil, err
+		}
+		optsTemplatefs := NFv9OptionsTemplateFlowSet{
+			FlowSetHeader: fsheader,
+			Records:       records,
+		}
+		flowSet = optsTemplatefs
 
-		} else if fsheader.Id == 3 && version == 10 {
-			templateReader := bytes.NewBuffer(payload.Next(nextrelpos))
-			records, err := DecodeIPFIXOptionsTemplateSet(templateReader)
-			if err != nil {
-				return returnItem, err
-			}
-			optsTemplatefs := IPFIXOptionsTemplateFlowSet{
-				FlowSetHeader: fsheader,
-				Records:       records,
+		if templates != nil {
+			for _, record := range records {
+				templates.AddTemplate(version, obsDomainId, record)
 			}
-			flowSet = optsTemplatefs
+		}
+
+	} else if fsheader.Id == 2 && version == 10 {
+		templateReader := bytes.NewBuffer(setPayload)
+		records, err := DecodeTemplateSetVersion(version, templateReader)
+		if err != nil {
+			return nil, err
+		}
+		templatefs := TemplateFlowSet{
+			FlowSetHeader: fsheader,
+			Records:       records,
+		}
+		flowSet = templatefs
 
-			if templates != nil {
-				for _, record := range records {
-					templates.AddTemplate(version, obsDomainId, record)
+		if templates != nil {
+			for _, record := range records {
+				if record.FieldCount == 0 {
+					withdrawTemplate(templates, obsDomainId, record.TemplateId, false)
+					continue
 				}
+				templates.AddTemplate(version, obsDomainId, record)
 			}
+		}
 
-		} else if fsheader.Id >= 256 {
-			dataReader := bytes.NewBuffer(payload.Next(nextrelpos))
-
-			if templates == nil {
-				continue
-			}
+	} else if fsheader.Id == 3 && version == 10 {
+		templateReader := bytes.NewBuffer(setPayload)
+		records, err := DecodeIPFIXOptionsTemplateSet(templateReader)
+		if err != nil {
+			return nil, err
+		}
+		optsTemplatefs := IPFIXOptionsTemplateFlowSet{
+			FlowSetHeader: fsheader,
+			Records:       records,
+		}
+		flowSet = optsTemplatefs
 
-			template, err := templates.GetTemplate(version, obsDomainId, fsheader.Id)
-
-			if err == nil {
-				switch templatec := template.(type) {
-				case TemplateRecord:
-					records, err := DecodeDataSet(dataReader, templatec.Fields)
-					if err != nil {
-						return returnItem, err
-					}
-					datafs := DataFlowSet{
-						FlowSetHeader: fsheader,
-						Records:       records,
-					}
-					flowSet = datafs
-				case IPFIXOptionsTemplateRecord:
-					records, err := DecodeOptionsDataSet(dataReader, templatec.Scopes, templatec.Options)
-					if err != nil {
-						return returnItem, err
-					}
-
-					datafs := OptionsDataFlowSet{
-						FlowSetHeader: fsheader,
-						Records:       records,
-					}
-					flowSet = datafs
-				case NFv9OptionsTemplateRecord:
-					records, err := DecodeOptionsDataSet(dataReader, templatec.Scopes, templatec.Options)
-					if err != nil {
-						return returnItem, err
-					}
-
-					datafs := OptionsDataFlowSet{
-						FlowSetHeader: fsheader,
-						Records:       records,
-					}
-					flowSet = datafs
+		if templates != nil {
+			for _, record := range records {
+				if record.FieldCount == 0 {
+					withdrawTemplate(templates, obsDomainId, record.TemplateId, true)
+					continue
 				}
-			} else {
-				return returnItem, err
+				templates.AddTemplate(version, obsDomainId, record)
 			}
-		} else {
-			return returnItem, NewErrorFlowId(fsheader.Id)
 		}
 
-		if version == 9 && flowSet != nil {
-			packetNFv9.FlowSets = append(packetNFv9.FlowSets, flowSet)
-		} else if version == 10 && flowSet != nil {
-			packetIPFIX.FlowSets = append(packetIPFIX.FlowSets, flowSet)
+	} else if fsheader.Id >= 256 {
+		dataReader := bytes.NewBuffer(setPayload)
+
+		if templates == nil {
+			return nil, nil
 		}
-	}
 
-	if version == 9 {
-		return packetNFv9, nil
-	} else if version == 10 {
-		return packetIPFIX, nil
+		template, err := templates.GetTemplate(version, obsDomainId, fsheader.Id)
+		if err != nil {
+			buffer, ok := templates.(NetFlowDataSetBuffer)
+			if ok && buffer.BufferDataSet(header, fsheader, dataReader.Bytes()) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		return DecodeDataFlowSet(version, fsheader, dataReader, template)
 	} else {
-		return returnItem, NewErrorVersion(version)
+		return nil, NewErrorFlowId(fsheader.Id)
 	}
+	return flowSet, nil
 }
@@ -0,0 +1,470 @@
+package netflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+// buildIPFIX wraps sets into an IPFIX message header for observation domain 1.
+func buildIPFIX(sets ...[]byte) []byte {
+	length := 16
+	for _, set := range sets {
+		length += len(set)
+	}
+	pkt := appendUint16(nil, 10)
+	pkt = appendUint16(pkt, uint16(length))
+	pkt = appendUint32(pkt, 1600000000)
+	pkt = appendUint32(pkt, 1)
+	pkt = appendUint32(pkt, 1)
+	for _, set := range sets {
+		pkt = append(pkt, set...)
+	}
+	return pkt
+}
+
+// buildSet prefixes a set header to a set body.
+func buildSet(id uint16, body []byte) []byte {
+	set := appendUint16(nil, id)
+	set = appendUint16(set, uint16(len(body)+4))
+	return append(set, body...)
+}
+
+func getMixedTemplateSet() []byte {
+	body := appendUint16(nil, 256)
+	body = appendUint16(body, 4)
+	body = appendUint16(body, IPFIX_FIELD_sourceIPv4Address)
+	body = appendUint16(body, 4)
+	body = appendUint16(body, IPFIX_FIELD_applicationName)
+	body = appendUint16(body, FIELD_VARIABLE_LENGTH)
+	body = appendUint16(body, IPFIX_FIELD_octetDeltaCount)
+	body = appendUint16(body, 8)
+	body = appendUint16(body, IPFIX_FIELD_interfaceName)
+	body = appendUint16(body, FIELD_VARIABLE_LENGTH)
+	return buildSet(2, body)
+}
+
+func TestDecodeVariableLengthFields(t *testing.T) {
+	longName := bytes.Repeat([]byte{'a'}, 300)
+
+	body := []byte{10, 0, 0, 1}
+	body = append(body, 4, 'h', 't', 't', 'p')
+	body = append(body, 0, 0, 0, 0, 0, 0, 0, 100)
+	body = append(body, 0)
+
+	body = append(body, 10, 0, 0, 2)
+	body = append(body, 255)
+	body = appendUint16(body, uint16(len(longName)))
+	body = append(body, longName...)
+	body = append(body, 0, 0, 0, 0, 0, 0, 0, 200)
+	body = append(body, 3, 'e', 't', '0')
+
+	// padding shorter than the smallest record
+	body = append(body, 0, 0, 0)
+
+	pkt := buildIPFIX(getMixedTemplateSet(), buildSet(256, body))
+
+	templates := CreateTemplateSystem()
+	dec, err := DecodeMessage(bytes.NewBuffer(pkt), templates)
+	assert.Nil(t, err)
+
+	ipfix, ok := dec.(IPFIXPacket)
+	assert.True(t, ok)
+	assert.Len(t, ipfix.FlowSets, 2)
+
+	dataFlowSet, ok := ipfix.FlowSets[1].(DataFlowSet)
+	assert.True(t, ok)
+	assert.Len(t, dataFlowSet.Records, 2)
+
+	first := dataFlowSet.Records[0].Values
+	assert.Equal(t, []byte{10, 0, 0, 1}, first[0].Value)
+	assert.Equal(t, []byte("http"), first[1].Value)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 100}, first[2].Value)
+	assert.Equal(t, []byte{}, first[3].Value)
+
+	second := dataFlowSet.Records[1].Values
+	assert.Equal(t, []byte{10, 0, 0, 2}, second[0].Value)
+	assert.Equal(t, longName, second[1].Value)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 200}, second[2].Value)
+	assert.Equal(t, []byte("et0"), second[3].Value)
+}
+
+func TestDecodeVariableLengthTruncated(t *testing.T) {
+	fields := []Field{
+		{Type: IPFIX_FIELD_sourceIPv4Address, Length: 4},
+		{Type: IPFIX_FIELD_applicationName, Length: FIELD_VARIABLE_LENGTH},
+	}
+	assert.Equal(t, 5, GetTemplateSizeVersion(10, fields))
+
+	payload := bytes.NewBuffer([]byte{10, 0, 0, 1, 10, 'a', 'b'})
+	_, err := DecodeDataSetUsingFieldsVersion(10, payload, fields)
+	assert.NotNil(t, err)
+
+	// a record longer than the rest of the set is not padding
+	records, err := DecodeDataSetVersion(10, bytes.NewBuffer([]byte{10, 0, 0, 1, 10, 'a', 'b'}), fields)
+	assert.NotNil(t, err)
+	assert.Len(t, records, 0)
+
+	// a remainder shorter than a record is
+	records, err = DecodeDataSetVersion(10, bytes.NewBuffer([]byte{10, 0, 0, 1, 2, 'a', 'b', 0, 0, 0}), fields)
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	records, err = DecodeDataSetVersion(10, bytes.NewBuffer([]byte{10, 0, 0, 1, 2, 'a', 'b', 10, 0, 0, 2, 255, 0}), fields)
+	assert.NotNil(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestDecodeLength65535NFv9(t *testing.T) {
+	// only IPFIX has variable-length fields
+	fields := []Field{
+		{Type: NFV9_FIELD_IPV4_SRC_ADDR, Length: 4},
+		{Type: NFV9_FIELD_IF_NAME, Length: FIELD_VARIABLE_LENGTH},
+	}
+	assert.Equal(t, 4+FIELD_VARIABLE_LENGTH, GetTemplateSize(fields))
+
+	data := []byte{10, 0, 0, 1, 2, 'a', 'b', 0}
+	records, err := DecodeDataSetVersion(9, bytes.NewBuffer(data), fields)
+	assert.Nil(t, err)
+	assert.Len(t, records, 0)
+	records, err = DecodeDataSetVersion(10, bytes.NewBuffer(data), fields)
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	payload := bytes.NewBuffer(data)
+	length, err := DecodeFieldLength(9, payload, fields[1])
+	assert.Nil(t, err)
+	assert.Equal(t, FIELD_VARIABLE_LENGTH, length)
+	assert.Equal(t, len(data), payload.Len())
+}
+
+func TestDecodeVariableLengthOptionsDataSet(t *testing.T) {
+	scopes := []Field{
+		{Type: IPFIX_FIELD_ingressInterface, Length: 4},
+	}
+	options := []Field{
+		{Type: IPFIX_FIELD_interfaceName, Length: FIELD_VARIABLE_LENGTH},
+		{Type: IPFIX_FIELD_interfaceDescription, Length: FIELD_VARIABLE_LENGTH},
+	}
+	payload := []byte{0, 0, 0, 1, 3, 'e', 't', '0', 2, 'u', 'p'}
+	payload = append(payload, 0, 0, 0, 2, 3, 'e', 't', '1', 0)
+
+	records, err := DecodeOptionsDataSetVersion(10, bytes.NewBuffer(payload), scopes, options)
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, []byte{0, 0, 0, 2}, records[1].ScopesValues[0].Value)
+	assert.Equal(t, []byte("et1"), records[1].OptionsValues[0].Value)
+	assert.Equal(t, []byte("up"), records[0].OptionsValues[1].Value)
+}
+
+func TestDecodeEnterpriseFields(t *testing.T) {
+	template := appendUint16(nil, 257)
+	template = appendUint16(template, 2)
+	template = appendUint16(template, IPFIX_FIELD_sourceIPv4Address)
+	template = appendUint16(template, 4)
+	template = appendUint16(template, 0x8000|12)
+	template = appendUint16(template, 2)
+	template = appendUint32(template, 29305)
+
+	optionsTemplate := appendUint16(nil, 258)
+	optionsTemplate = appendUint16(optionsTemplate, 2)
+	optionsTemplate = appendUint16(optionsTemplate, 1)
+	optionsTemplate = appendUint16(optionsTemplate, 0x8000|1)
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+	optionsTemplate = appendUint32(optionsTemplate, 2636)
+	optionsTemplate = appendUint16(optionsTemplate, IPFIX_FIELD_samplingInterval)
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+
+	data := []byte{10, 0, 0, 1, 0, 42}
+	optionsData := []byte{0, 0, 0, 7, 0, 0, 0, 100}
+
+	pkt := buildIPFIX(buildSet(2, template), buildSet(3, optionsTemplate), buildSet(257, data), buildSet(258, optionsData))
+
+	templates := CreateTemplateSystem()
+	dec, err := DecodeMessage(bytes.NewBuffer(pkt), templates)
+	assert.Nil(t, err)
+
+	ipfix := dec.(IPFIXPacket)
+	assert.Len(t, ipfix.FlowSets, 4)
+
+	templateFlowSet := ipfix.FlowSets[0].(TemplateFlowSet)
+	assert.Equal(t, Field{PenProvided: true, Type: 12, Length: 2, Pen: 29305}, templateFlowSet.Records[0].Fields[1])
+
+	dataFlowSet := ipfix.FlowSets[2].(DataFlowSet)
+	assert.Len(t, dataFlowSet.Records, 1)
+	assert.Equal(t, DataField{PenProvided: true, Type: 12, Pen: 29305, Value: []byte{0, 42}}, dataFlowSet.Records[0].Values[1])
+	assert.False(t, dataFlowSet.Records[0].Values[0].PenProvided)
+
+	optionsDataFlowSet := ipfix.FlowSets[3].(OptionsDataFlowSet)
+	assert.Len(t, optionsDataFlowSet.Records, 1)
+	assert.Equal(t, uint32(2636), optionsDataFlowSet.Records[0].ScopesValues[0].Pen)
+	assert.Equal(t, []byte{0, 0, 0, 100}, optionsDataFlowSet.Records[0].OptionsValues[0].Value)
+}
+
+func TestDecodeNFv9FieldTypeNotEnterprise(t *testing.T) {
+	payload := bytes.NewBuffer([]byte{0x80, 0x01, 0x00, 0x04})
+	field, err := DecodeField(9, payload)
+	assert.Nil(t, err)
+	assert.False(t, field.PenProvided)
+	assert.Equal(t, uint16(0x8001), field.Type)
+}
+
+func TestDecodeTemplateWithdrawal(t *testing.T) {
+	optionsTemplate := appendUint16(nil, 258)
+	optionsTemplate = appendUint16(optionsTemplate, 2)
+	optionsTemplate = appendUint16(optionsTemplate, 1)
+	optionsTemplate = appendUint16(optionsTemplate, IPFIX_FIELD_exporterIPv4Address)
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+	optionsTemplate = appendUint16(optionsTemplate, IPFIX_FIELD_samplingInterval)
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+
+	templates := CreateTemplateSystem()
+	_, err := DecodeMessage(bytes.NewBuffer(buildIPFIX(getMixedTemplateSet(), buildSet(3, optionsTemplate))), templates)
+	assert.Nil(t, err)
+	_, err = templates.GetTemplate(10, 1, 256)
+	assert.Nil(t, err)
+
+	// withdraws template 256, followed by padding
+	withdrawal := appendUint16(nil, 256)
+	withdrawal = appendUint16(withdrawal, 0)
+	withdrawal = append(withdrawal, 0, 0, 0, 0)
+	dec, err := DecodeMessage(bytes.NewBuffer(buildIPFIX(buildSet(2, withdrawal))), templates)
+	assert.Nil(t, err)
+	templateFlowSet := dec.(IPFIXPacket).FlowSets[0].(TemplateFlowSet)
+	assert.Len(t, templateFlowSet.Records, 1)
+
+	_, err = templates.GetTemplate(10, 1, 256)
+	assert.NotNil(t, err)
+	_, err = templates.GetTemplate(10, 1, 258)
+	assert.Nil(t, err)
+
+	// withdraws all the options templates
+	withdrawal = appendUint16(nil, IPFIX_OPTIONS_TEMPLATE_SET_ID)
+	withdrawal = appendUint16(withdrawal, 0)
+	_, err = DecodeMessage(bytes.NewBuffer(buildIPFIX(buildSet(3, withdrawal))), templates)
+	assert.Nil(t, err)
+	_, err = templates.GetTemplate(10, 1, 258)
+	assert.NotNil(t, err)
+}
+
+func TestTemplateTimeoutNFv9(t *testing.T) {
+	templates := CreateTemplateSystem()
+	templates.NFv9Timeout = 10 * time.Millisecond
+	templates.AddTemplate(9, 1, TemplateRecord{TemplateId: 256, FieldCount: 1, Fields: []Field{{Type: NFV9_FIELD_IPV4_SRC_ADDR, Length: 4}}})
+	templates.AddTemplate(10, 1, TemplateRecord{TemplateId: 256, FieldCount: 1, Fields: []Field{{Type: IPFIX_FIELD_sourceIPv4Address, Length: 4}}})
+
+	_, err := templates.GetTemplate(9, 1, 256)
+	assert.Nil(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = templates.GetTemplate(9, 1, 256)
+	assert.NotNil(t, err)
+	assert.Empty(t, templates.GetTemplates()[9][1])
+
+	templates.RemoveExpiredTemplates()
+	_, ok := templates.TemplateUpdated(9, 1, 256)
+	assert.False(t, ok)
+	assert.NotContains(t, templates.templates[9], uint32(1))
+
+	// IPFIX templates do not expire
+	_, err = templates.GetTemplate(10, 1, 256)
+	assert.Nil(t, err)
+}
+
+func TestDecodeMessagePartial(t *testing.T) {
+	template := appendUint16(nil, 256)
+	template = appendUint16(template, 1)
+	template = appendUint16(template, IPFIX_FIELD_sourceIPv4Address)
+	template = appendUint16(template, 4)
+
+	// unknown template, unknown set id, then a valid data set
+	pkt := buildIPFIX(buildSet(2, template), buildSet(257, []byte{1, 2, 3, 4}), buildSet(5, []byte{0}), buildSet(256, []byte{10, 0, 0, 1}))
+
+	_, err := DecodeMessage(bytes.NewBuffer(pkt), CreateTemplateSystem())
+	assert.IsType(t, &ErrorTemplateNotFound{}, err)
+
+	dec, err := DecodeMessagePartial(bytes.NewBuffer(pkt), CreateTemplateSystem())
+	if assert.IsType(t, &ErrorFlowSets{}, err) {
+		setErrors := err.(*ErrorFlowSets).Unwrap()
+		assert.Len(t, setErrors, 2)
+		assert.IsType(t, &ErrorTemplateNotFound{}, setErrors[0])
+		assert.IsType(t, &ErrorFlowId{}, setErrors[1])
+	}
+	ipfix := dec.(IPFIXPacket)
+	assert.Len(t, ipfix.FlowSets, 2)
+	dataFlowSet := ipfix.FlowSets[1].(DataFlowSet)
+	assert.Equal(t, []byte{10, 0, 0, 1}, dataFlowSet.Records[0].Values[0].Value)
+
+	dec, err = DecodeMessagePartial(bytes.NewBuffer(buildIPFIX(buildSet(2, template))), CreateTemplateSystem())
+	assert.Nil(t, err)
+	assert.Len(t, dec.(IPFIXPacket).FlowSets, 1)
+}
+
+// getBenchmarkMessage returns an IPFIX message with a template of 6 fields
+// and a data set of testhelpers.BENCHMARK_RECORDS records.
+func getBenchmarkMessage() []byte {
+	template := appendUint16(nil, 256)
+	template = appendUint16(template, 6)
+	for _, field := range [][2]uint16{
+		{IPFIX_FIELD_sourceIPv4Address, 4},
+		{IPFIX_FIELD_destinationIPv4Address, 4},
+		{IPFIX_FIELD_sourceTransportPort, 2},
+		{IPFIX_FIELD_destinationTransportPort, 2},
+		{IPFIX_FIELD_octetDeltaCount, 8},
+		{IPFIX_FIELD_packetDeltaCount, 8},
+	} {
+		template = appendUint16(template, field[0])
+		template = appendUint16(template, field[1])
+	}
+	var data []byte
+	for i := 0; i < testhelpers.BENCHMARK_RECORDS; i++ {
+		data = append(data, 10, 0, 0, byte(i), 10, 0, 1, byte(i))
+		data = appendUint16(data, 1024)
+		data = appendUint16(data, 443)
+		data = binary.BigEndian.AppendUint64(data, 1500)
+		data = binary.BigEndian.AppendUint64(data, 1)
+	}
+	return buildIPFIX(buildSet(2, template), buildSet(256, data))
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	msg := getBenchmarkMessage()
+	templates := CreateTemplateSystem()
+	testhelpers.ReportAllocsPerFlow(b, func() {
+		_, err := DecodeMessage(bytes.NewBuffer(msg), templates)
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+// BenchmarkDecodeDataSetPerRecord decodes the records with one allocation
+// of values each, for comparison with BenchmarkDecodeDataSet.
+func BenchmarkDecodeDataSetPerRecord(b *testing.B) {
+	msg := getBenchmarkMessage()
+	fields := getBenchmarkFields(b, msg)
+	data := msg[len(msg)-testhelpers.BENCHMARK_RECORDS*28:]
+	testhelpers.ReportAllocsPerFlow(b, func() {
+		payload := bytes.NewBuffer(data)
+		records := make([]DataRecord, 0)
+		for payload.Len() > 0 {
+			values, err := DecodeDataSetUsingFieldsVersion(10, payload, fields)
+			if err != nil {
+				b.Fatal(err)
+			}
+			records = append(records, DataRecord{Values: values})
+		}
+	})
+}
+
+func BenchmarkDecodeDataSet(b *testing.B) {
+	msg := getBenchmarkMessage()
+	fields := getBenchmarkFields(b, msg)
+	data := msg[len(msg)-testhelpers.BENCHMARK_RECORDS*28:]
+	testhelpers.ReportAllocsPerFlow(b, func() {
+		_, err := DecodeDataSetVersion(10, bytes.NewBuffer(data), fields)
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func getBenchmarkFields(b *testing.B, msg []byte) []Field {
+	templates := CreateTemplateSystem()
+	_, err := DecodeMessage(bytes.NewBuffer(msg), templates)
+	if err != nil {
+		b.Fatal(err)
+	}
+	template, err := templates.GetTemplate(10, 1, 256)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return template.(TemplateRecord).Fields
+}
+
+// buildNFv9 wraps sets into a NetFlow v9 packet header for source id 1.
+func buildNFv9(sets ...[]byte) []byte {
+	pkt := appendUint16(nil, 9)
+	pkt = appendUint16(pkt, uint16(len(sets)))
+	pkt = appendUint32(pkt, 1000)
+	pkt = appendUint32(pkt, 1600000000)
+	pkt = appendUint32(pkt, 1)
+	pkt = appendUint32(pkt, 1)
+	for _, set := range sets {
+		pkt = append(pkt, set...)
+	}
+	return pkt
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	template := appendUint16(nil, 256)
+	template = appendUint16(template, 2)
+	template = appendUint16(template, NFV9_FIELD_IPV4_SRC_ADDR)
+	template = appendUint16(template, 4)
+	template = appendUint16(template, NFV9_FIELD_IN_BYTES)
+	template = appendUint16(template, 4)
+	optionsTemplate := appendUint16(nil, 257)
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+	optionsTemplate = appendUint16(optionsTemplate, 1) // system scope
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+	optionsTemplate = appendUint16(optionsTemplate, NFV9_FIELD_SAMPLING_INTERVAL)
+	optionsTemplate = appendUint16(optionsTemplate, 4)
+	f.Add(buildNFv9(buildSet(0, template), buildSet(1, optionsTemplate),
+		buildSet(256, []byte{10, 0, 0, 1, 0, 0, 5, 220}), buildSet(257, []byte{10, 0, 0, 254, 0, 0, 0, 100})))
+
+	f.Add(getBenchmarkMessage())
+	f.Add(buildIPFIX(getMixedTemplateSet(), buildSet(256, []byte{10, 0, 0, 1, 3, 'a', 'p', 'p', 0, 0, 0, 0, 0, 0, 0, 1, 255, 0, 3, 'e', 't', 'h'})))
+	ipfixOptionsTemplate := appendUint16(nil, 258)
+	ipfixOptionsTemplate = appendUint16(ipfixOptionsTemplate, 2)
+	ipfixOptionsTemplate = appendUint16(ipfixOptionsTemplate, 1)
+	ipfixOptionsTemplate = appendUint16(ipfixOptionsTemplate, IPFIX_FIELD_exporterIPv4Address)
+	ipfixOptionsTemplate = appendUint16(ipfixOptionsTemplate, 4)
+	ipfixOptionsTemplate = appendUint16(ipfixOptionsTemplate, IPFIX_FIELD_samplingInterval)
+	ipfixOptionsTemplate = appendUint16(ipfixOptionsTemplate, 4)
+	f.Add(buildIPFIX(buildSet(3, ipfixOptionsTemplate), buildSet(258, []byte{10, 0, 0, 254, 0, 0, 0, 100}),
+		buildSet(2, []byte{1, 0, 0, 0})))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// the templates of a message are used by its data sets
+		templates := CreateTemplateSystem()
+		DecodeMessage(bytes.NewBuffer(data), templates)
+		DecodeMessagePartial(bytes.NewBuffer(data), templates)
+	})
+}
+
+func TestDecodeDataSetTooManyFields(t *testing.T) {
+	// one byte records of a thousand values
+	template := appendUint16(nil, 256)
+	template = appendUint16(template, 1001)
+	template = appendUint16(template, IPFIX_FIELD_protocolIdentifier)
+	template = appendUint16(template, 1)
+	for i := 0; i < 1000; i++ {
+		template = appendUint16(template, IPFIX_FIELD_octetDeltaCount)
+		template = appendUint16(template, 0)
+	}
+	_, err := DecodeMessage(bytes.NewBuffer(buildIPFIX(buildSet(2, template), buildSet(256, make([]byte, 10000)))), CreateTemplateSystem())
+	assert.IsType(t, &ErrorDecodingNetFlow{}, err)
+}
+
+func TestDecodeMessageTruncated(t *testing.T) {
+	pkt := buildIPFIX(getMixedTemplateSet())
+	_, err := DecodeMessage(bytes.NewBuffer(pkt[:10]), CreateTemplateSystem())
+	assert.IsType(t, &ErrorDecodingNetFlow{}, err)
+
+	// the set is longer than the message
+	_, err = DecodeMessage(bytes.NewBuffer(pkt[:len(pkt)-2]), CreateTemplateSystem())
+	assert.IsType(t, &ErrorDecodingNetFlow{}, err)
+}
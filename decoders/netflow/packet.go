@@ -77,17 +77,30 @@ type OptionsDataRecord struct {
 // Field does not contain the record value itself it is just a description of
 // what record value will look like.
 type Field struct {
+	// Set when the enterprise bit is on: Type is then relative to Pen.
+	PenProvided bool
+
 	// A numeric value that represents the type of field.
 	Type uint16
 
-	// The length (in bytes) of the field.
+	// The length (in bytes) of the field. IPFIX uses 65535 for fields whose
+	// length is given in each data record.
 	Length uint16
+
+	// The IANA Private Enterprise Number of the organization defining Type.
+	Pen uint32
 }
 
 type DataField struct {
+	// Set when the field is an enterprise-specific Information Element.
+	PenProvided bool
+
 	// A numeric value that represents the type of field.
 	Type uint16
 
+	// The Private Enterprise Number of the Information Element.
+	Pen uint32
+
 	// The value (in bytes) of the field.
 	Value interface{}
 	//Value []byte
@@ -103,13 +116,13 @@ func (flowSet OptionsDataFlowSet) String(TypeToString func(uint16) string, Scope
 		str += fmt.Sprintf("            Scopes (%v):\n", len(record.ScopesValues))
 
 		for k, value := range record.ScopesValues {
-			str += fmt.Sprintf("            - %v. %v (%v): %v\n", k, ScopeToString(value.Type), value.Type, value.Value)
+			str += fmt.Sprintf("            - %v. %v: %v\n", k, value.TypeString(ScopeToString), value.Value)
 		}
 
 		str += fmt.Sprintf("            Options (%v):\n", len(record.OptionsValues))
 
 		for k, value := range record.OptionsValues {
-			str += fmt.Sprintf("            - %v. %v (%v): %v\n", k, TypeToString(value.Type), value.Type, value.Value)
+			str += fmt.Sprintf("            - %v. %v: %v\n", k, value.TypeString(TypeToString), value.Value)
 		}
 	}
 
@@ -126,7 +139,7 @@ func (flowSet DataFlowSet) String(TypeToString func(uint16) string) string {
 		str += fmt.Sprintf("            Values (%v):\n", len(record.Values))
 
 		for k, value := range record.Values {
-			str += fmt.Sprintf("            - %v. %v (%v): %v\n", k, TypeToString(value.Type), value.Type, value.Value)
+			str += fmt.Sprintf("            - %v. %v: %v\n", k, value.TypeString(TypeToString), value.Value)
 		}
 	}
 
@@ -145,9 +158,22 @@ func (flowSet TemplateFlowSet) String(TypeToString func(uint16) string) string {
 		str += fmt.Sprintf("            Fields (%v):\n", len(record.Fields))
 
 		for k, field := range record.Fields {
-			str += fmt.Sprintf("            - %v. %v (%v): %v\n", k, TypeToString(field.Type), field.Type, field.Length)
+			str += fmt.Sprintf("            - %v. %v: %v\n", k, field.TypeString(TypeToString), field.Length)
 		}
 	}
 
 	return str
 }
+
+// TypeString describes the field type, showing the enterprise number of
+// vendor-specific fields instead of a standard name.
+func (field Field) TypeString(TypeToString func(uint16) string) string {
+	if field.PenProvided {
+		return fmt.Sprintf("enterprise %v (%v)", field.Pen, field.Type)
+	}
+	return fmt.Sprintf("%v (%v)", TypeToString(field.Type), field.Type)
+}
+
+func (field DataField) TypeString(TypeToString func(uint16) string) string {
+	return Field{PenProvided: field.PenProvided, Type: field.Type, Pen: field.Pen}.TypeString(TypeToString)
+}
@@ -27,12 +27,15 @@ func (e *ErrorVersion) Error() string {
 
 func DecodeMessage(payload *bytes.Buffer) (interface{}, error) {
 	var version uint16
-	utils.BinaryDecoder(payload, &version)
+	err := utils.BinaryDecoder(payload, &version)
+	if err != nil {
+		return nil, fmt.Errorf("truncated header: %v", err)
+	}
 	packet := PacketNetFlowV5{}
 	if version == 5 {
 		packet.Version = version
 
-		utils.BinaryDecoder(payload,
+		err = utils.BinaryDecoder(payload,
 			&(packet.Count),
 			&(packet.SysUptime),
 			&(packet.UnixSecs),
@@ -42,16 +45,23 @@ func DecodeMessage(payload *bytes.Buffer) (interface{}, error) {
 			&(packet.EngineId),
 			&(packet.SamplingInterval),
 		)
+		if err != nil {
+			return nil, fmt.Errorf("truncated header: %v", err)
+		}
 
 		if packet.Count > MAX_FLOWS_PER_PACKET {
 			return nil, fmt.Errorf("invalid amount of flows: %d", packet.Count)
 		}
 
-		packet.Records = make([]RecordsNetFlowV5, int(packet.Count))
+		packet.Records = make([]RecordsNetFlowV5, 0, int(packet.Count))
+		// the records missing from a truncated packet are not returned
 		for i := 0; i < int(packet.Count) && payload.Len() >= FLOW_SIZE; i++ {
 			record := RecordsNetFlowV5{}
-			utils.BinaryDecoder(payload, &record)
-			packet.Records[i] = record
+			err = utils.BinaryDecoder(payload, &record)
+			if err != nil {
+				break
+			}
+			packet.Records = append(packet.Records, record)
 		}
 
 		return packet, nil
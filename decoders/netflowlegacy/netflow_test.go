@@ -8,7 +8,30 @@ import (
 )
 
 func TestDecodeNetFlowV5(t *testing.T) {
-	data := []byte{
+	data := getNetFlowV5Packet()
+	buf := bytes.NewBuffer(data)
+
+	dec, err := DecodeMessage(buf)
+	assert.Nil(t, err)
+	assert.NotNil(t, dec)
+	decNfv5 := dec.(PacketNetFlowV5)
+	assert.Equal(t, uint16(5), decNfv5.Version)
+	assert.Equal(t, uint16(9), decNfv5.Records[0].Input)
+}
+
+func TestDecodeNetFlowV5Truncated(t *testing.T) {
+	data := getNetFlowV5Packet()
+	_, err := DecodeMessage(bytes.NewBuffer(data[:20]))
+	assert.NotNil(t, err)
+
+	// the second record is cut
+	dec, err := DecodeMessage(bytes.NewBuffer(data[:24+48+20]))
+	assert.Nil(t, err)
+	assert.Len(t, dec.(PacketNetFlowV5).Records, 1)
+}
+
+func getNetFlowV5Packet() []byte {
+	return []byte{
 		0x00, 0x05, 0x00, 0x06, 0x00, 0x82, 0xc3, 0x48, 0x5b, 0xcd, 0xba, 0x1b, 0x05, 0x97, 0x6d, 0xc7,
 		0x00, 0x00, 0x64, 0x3d, 0x08, 0x08, 0x00, 0x00, 0x0a, 0x80, 0x02, 0x79, 0x0a, 0x80, 0x02, 0x01,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x02, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x02, 0x4e,
@@ -30,12 +53,11 @@ func TestDecodeNetFlowV5(t *testing.T) {
 		0x00, 0x82, 0x9b, 0x90, 0x00, 0x82, 0x9b, 0x9d, 0x1f, 0x90, 0xb9, 0x1a, 0x00, 0x1b, 0x06, 0x00,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 	}
-	buf := bytes.NewBuffer(data)
+}
 
-	dec, err := DecodeMessage(buf)
-	assert.Nil(t, err)
-	assert.NotNil(t, dec)
-	decNfv5 := dec.(PacketNetFlowV5)
-	assert.Equal(t, uint16(5), decNfv5.Version)
-	assert.Equal(t, uint16(9), decNfv5.Records[0].Input)
+func FuzzDecodeMessage(f *testing.F) {
+	f.Add(getNetFlowV5Packet())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecodeMessage(bytes.NewBuffer(data))
+	})
 }
@@ -0,0 +1,284 @@
+// Package pcap reads packet captures in the pcap and pcapng formats and
+// extracts their UDP datagrams, without depending on libpcap.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	PCAP_MAGIC_MICROSECONDS = 0xa1b2c3d4
+	PCAP_MAGIC_NANOSECONDS  = 0xa1b23c4d
+
+	PCAPNG_BLOCK_SECTION_HEADER        = 0x0a0d0d0a
+	PCAPNG_BLOCK_INTERFACE_DESCRIPTION = 0x00000001
+	PCAPNG_BLOCK_PACKET                = 0x00000002
+	PCAPNG_BLOCK_ENHANCED_PACKET       = 0x00000006
+	PCAPNG_BYTE_ORDER_MAGIC            = 0x1a2b3c4d
+
+	PCAPNG_OPTION_END        = 0
+	PCAPNG_OPTION_IF_TSRESOL = 9
+
+	// Blocks larger than this are considered corrupted.
+	MAX_BLOCK_SIZE = 16 * 1024 * 1024
+)
+
+type ErrorFormat struct {
+	msg string
+}
+
+func NewErrorFormat(msg string) *ErrorFormat {
+	return &ErrorFormat{
+		msg: msg,
+	}
+}
+
+func (e *ErrorFormat) Error() string {
+	return fmt.Sprintf("Capture format error: %v", e.msg)
+}
+
+// Packet is a captured frame.
+type Packet struct {
+	Timestamp time.Time
+	LinkType  uint32
+	Data      []byte
+}
+
+type pcapngInterface struct {
+	linkType uint32
+	// Number of timestamp units per second.
+	tsUnits uint64
+}
+
+// Reader reads the packets of a pcap or pcapng capture.
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+
+	// pcap
+	linkType   uint32
+	nanosecond bool
+
+	// pcapng, interfaces of the current section
+	interfaces []pcapngInterface
+}
+
+// NewReader detects the format of the capture and reads its header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		r: bufio.NewReader(r),
+	}
+	magic, err := reader.r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(magic) == PCAPNG_BLOCK_SECTION_HEADER:
+		reader.ng = true
+		// The section header is read with the first block.
+		return reader, nil
+	case binary.LittleEndian.Uint32(magic) == PCAP_MAGIC_MICROSECONDS:
+		reader.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == PCAP_MAGIC_MICROSECONDS:
+		reader.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(magic) == PCAP_MAGIC_NANOSECONDS:
+		reader.order = binary.LittleEndian
+		reader.nanosecond = true
+	case binary.BigEndian.Uint32(magic) == PCAP_MAGIC_NANOSECONDS:
+		reader.order = binary.BigEndian
+		reader.nanosecond = true
+	default:
+		return nil, NewErrorFormat(fmt.Sprintf("unknown magic %x", magic))
+	}
+
+	header := make([]byte, 24)
+	_, err = io.ReadFull(reader.r, header)
+	if err != nil {
+		return nil, err
+	}
+	reader.linkType = reader.order.Uint32(header[20:24]) & 0x0fffffff
+	return reader, nil
+}
+
+// ReadPacket returns the next packet of the capture, or io.EOF at its end.
+func (r *Reader) ReadPacket() (Packet, error) {
+	if r.ng {
+		return r.readPacketNg()
+	}
+
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r.r, header)
+	if err != nil {
+		return Packet{}, err
+	}
+	sec := r.order.Uint32(header[0:4])
+	frac := r.order.Uint32(header[4:8])
+	length := r.order.Uint32(header[8:12])
+	if length > MAX_BLOCK_SIZE {
+		return Packet{}, NewErrorFormat(fmt.Sprintf("packet too large (%v bytes)", length))
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r.r, data)
+	if err != nil {
+		return Packet{}, unexpectedEOF(err)
+	}
+
+	if !r.nanosecond {
+		frac *= 1000
+	}
+	return Packet{
+		Timestamp: time.Unix(int64(sec), int64(frac)),
+		LinkType:  r.linkType,
+		Data:      data,
+	}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (r *Reader) readPacketNg() (Packet, error) {
+	for {
+		blockType, body, err := r.readBlock()
+		if err != nil {
+			return Packet{}, err
+		}
+
+		switch blockType {
+		case PCAPNG_BLOCK_SECTION_HEADER:
+			r.interfaces = r.interfaces[:0]
+		case PCAPNG_BLOCK_INTERFACE_DESCRIPTION:
+			if len(body) < 8 {
+				return Packet{}, NewErrorFormat("interface description block too short")
+			}
+			iface := pcapngInterface{
+				linkType: uint32(r.order.Uint16(body[0:2])),
+				tsUnits:  1000000,
+			}
+			iface.tsUnits, err = r.readTsResol(body[8:], iface.tsUnits)
+			if err != nil {
+				return Packet{}, err
+			}
+			r.interfaces = append(r.interfaces, iface)
+		case PCAPNG_BLOCK_ENHANCED_PACKET, PCAPNG_BLOCK_PACKET:
+			if len(body) < 20 {
+				return Packet{}, NewErrorFormat("packet block too short")
+			}
+			var ifaceId uint32
+			if blockType == PCAPNG_BLOCK_ENHANCED_PACKET {
+				ifaceId = r.order.Uint32(body[0:4])
+			} else {
+				ifaceId = uint32(r.order.Uint16(body[0:2]))
+			}
+			if int(ifaceId) >= len(r.interfaces) {
+				return Packet{}, NewErrorFormat(fmt.Sprintf("unknown interface %v", ifaceId))
+			}
+			iface := r.interfaces[ifaceId]
+			ts := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+			length := r.order.Uint32(body[12:16])
+			if int(length) > len(body)-20 {
+				return Packet{}, NewErrorFormat("packet data exceeds block")
+			}
+
+			sec := ts / iface.tsUnits
+			var nsec uint64
+			if iface.tsUnits <= 1000000000 {
+				nsec = (ts % iface.tsUnits) * 1000000000 / iface.tsUnits
+			} else {
+				nsec = uint64(float64(ts%iface.tsUnits) / float64(iface.tsUnits) * 1e9)
+			}
+			return Packet{
+				Timestamp: time.Unix(int64(sec), int64(nsec)),
+				LinkType:  iface.linkType,
+				Data:      body[20 : 20+length],
+			}, nil
+		}
+		// Other blocks (statistics, name resolution...) are skipped.
+	}
+}
+
+// readBlock returns the type and the body of the next pcapng block.
+func (r *Reader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r.r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	blockType := binary.BigEndian.Uint32(header[0:4])
+	if blockType == PCAPNG_BLOCK_SECTION_HEADER {
+		// Byte order of the section is given by its header.
+		magic, err := r.r.Peek(4)
+		if err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == PCAPNG_BYTE_ORDER_MAGIC:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == PCAPNG_BYTE_ORDER_MAGIC:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, NewErrorFormat(fmt.Sprintf("unknown byte order magic %x", magic))
+		}
+	} else if r.order == nil {
+		return 0, nil, NewErrorFormat("missing section header")
+	}
+	blockType = r.order.Uint32(header[0:4])
+
+	length := r.order.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > MAX_BLOCK_SIZE {
+		return 0, nil, NewErrorFormat(fmt.Sprintf("invalid block length %v", length))
+	}
+	body := make([]byte, length-8)
+	_, err = io.ReadFull(r.r, body)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if r.order.Uint32(body[len(body)-4:]) != length {
+		return 0, nil, NewErrorFormat("block lengths mismatch")
+	}
+	return blockType, body[:len(body)-4], nil
+}
+
+// readTsResol returns the timestamp units per second of an interface from its options.
+func (r *Reader) readTsResol(options []byte, tsUnits uint64) (uint64, error) {
+	for len(options) >= 4 {
+		code := r.order.Uint16(options[0:2])
+		length := int(r.order.Uint16(options[2:4]))
+		if code == PCAPNG_OPTION_END {
+			break
+		}
+		padded := (length + 3) &^ 3
+		if len(options) < 4+padded {
+			return 0, NewErrorFormat("option exceeds block")
+		}
+		if code == PCAPNG_OPTION_IF_TSRESOL && length >= 1 {
+			resol := options[4]
+			exponent := uint64(resol & 0x7f)
+			var base uint64 = 10
+			maxExponent := uint64(18)
+			if resol&0x80 != 0 {
+				base = 2
+				maxExponent = 62
+			}
+			if exponent > maxExponent {
+				return 0, NewErrorFormat(fmt.Sprintf("timestamp resolution %x not supported", resol))
+			}
+			tsUnits = 1
+			for i := uint64(0); i < exponent; i++ {
+				tsUnits *= base
+			}
+		}
+		options = options[4+padded:]
+	}
+	return tsUnits, nil
+}
@@ -0,0 +1,150 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getEthernetFrame returns a frame with a VLAN tag carrying a UDP datagram
+// from 10.0.0.1:1234 to 10.0.0.2:2055.
+func getEthernetFrame(payload []byte) []byte {
+	frame := []byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x81, 0x00,
+		0x00, 0x0a, 0x08, 0x00,
+	}
+	ip := []byte{
+		0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02,
+		0x04, 0xd2, 0x08, 0x07, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.BigEndian.PutUint16(ip[2:4], uint16(28+len(payload)))
+	binary.BigEndian.PutUint16(ip[24:26], uint16(8+len(payload)))
+	frame = append(frame, ip...)
+	frame = append(frame, payload...)
+	// ethernet padding
+	return append(frame, 0, 0, 0, 0)
+}
+
+func getPcap(order binary.ByteOrder, frames ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, order, []uint32{PCAP_MAGIC_MICROSECONDS})
+	binary.Write(buf, order, []uint16{2, 4})
+	binary.Write(buf, order, []uint32{0, 0, 65535, LINKTYPE_ETHERNET})
+	for i, frame := range frames {
+		binary.Write(buf, order, []uint32{1600000000 + uint32(i), 500000, uint32(len(frame)), uint32(len(frame))})
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+func appendBlock(buf *bytes.Buffer, blockType uint32, body []byte) {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	length := uint32(len(body) + 12)
+	binary.Write(buf, binary.LittleEndian, []uint32{blockType, length})
+	buf.Write(body)
+	binary.Write(buf, binary.LittleEndian, length)
+}
+
+func getPcapng(frame []byte) []byte {
+	buf := &bytes.Buffer{}
+	appendBlock(buf, PCAPNG_BLOCK_SECTION_HEADER, []byte{
+		0x4d, 0x3c, 0x2b, 0x1a, 0x01, 0x00, 0x00, 0x00,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	})
+	// interface with nanosecond timestamps
+	appendBlock(buf, PCAPNG_BLOCK_INTERFACE_DESCRIPTION, []byte{
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x09, 0x00, 0x01, 0x00, 0x09, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	})
+	// interface statistics, skipped
+	appendBlock(buf, 5, make([]byte, 12))
+
+	ts := uint64(1600000000123456789)
+	epb := &bytes.Buffer{}
+	binary.Write(epb, binary.LittleEndian, []uint32{0, uint32(ts >> 32), uint32(ts), uint32(len(frame)), uint32(len(frame))})
+	epb.Write(frame)
+	appendBlock(buf, PCAPNG_BLOCK_ENHANCED_PACKET, epb.Bytes())
+	return buf.Bytes()
+}
+
+func TestReadPcap(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		frames := [][]byte{getEthernetFrame([]byte{1, 2, 3}), getEthernetFrame([]byte{4})}
+		r, err := NewReader(bytes.NewReader(getPcap(order, frames...)))
+		assert.Nil(t, err)
+
+		for i, frame := range frames {
+			packet, err := r.ReadPacket()
+			assert.Nil(t, err)
+			assert.Equal(t, time.Unix(1600000000+int64(i), 500000000), packet.Timestamp)
+			assert.Equal(t, uint32(LINKTYPE_ETHERNET), packet.LinkType)
+			assert.Equal(t, frame, packet.Data)
+		}
+		_, err = r.ReadPacket()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestReadPcapTruncated(t *testing.T) {
+	b := getPcap(binary.LittleEndian, getEthernetFrame([]byte{1, 2, 3}))
+	r, err := NewReader(bytes.NewReader(b[:len(b)-5]))
+	assert.Nil(t, err)
+	_, err = r.ReadPacket()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = NewReader(bytes.NewReader([]byte{1, 2, 3, 4}))
+	assert.IsType(t, &ErrorFormat{}, err)
+}
+
+func TestReadPcapng(t *testing.T) {
+	frame := getEthernetFrame([]byte{1, 2, 3})
+	r, err := NewReader(bytes.NewReader(getPcapng(frame)))
+	assert.Nil(t, err)
+
+	packet, err := r.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Unix(1600000000, 123456789), packet.Timestamp)
+	assert.Equal(t, frame, packet.Data)
+
+	datagram, err := DecodeUDP(packet.LinkType, packet.Data)
+	assert.Nil(t, err)
+	assert.Equal(t, net.IP{10, 0, 0, 1}, datagram.Src)
+	assert.Equal(t, net.IP{10, 0, 0, 2}, datagram.Dst)
+	assert.Equal(t, 1234, datagram.SrcPort)
+	assert.Equal(t, 2055, datagram.DstPort)
+	assert.Equal(t, []byte{1, 2, 3}, datagram.Payload)
+
+	_, err = r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecodeUDPIPv6(t *testing.T) {
+	data := []byte{
+		0x60, 0x00, 0x00, 0x00, 0x00, 0x12, 0x00, 0x40,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02,
+		// destination options
+		0x11, 0x00, 0x01, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x04, 0xd2, 0x18, 0xc7, 0x00, 0x0a, 0x00, 0x00,
+		0xaa, 0xbb,
+	}
+	datagram, err := DecodeUDP(LINKTYPE_RAW, data)
+	assert.Nil(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8::1"), datagram.Src)
+	assert.Equal(t, 6343, datagram.DstPort)
+	assert.Equal(t, []byte{0xaa, 0xbb}, datagram.Payload)
+
+	// fragments are not reassembled
+	data[6] = 44
+	_, err = DecodeUDP(LINKTYPE_RAW, data)
+	assert.IsType(t, &ErrorNotUDP{}, err)
+}
@@ -0,0 +1,181 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Link types (http://www.tcpdump.org/linktypes.html).
+const (
+	LINKTYPE_NULL       = 0
+	LINKTYPE_ETHERNET   = 1
+	LINKTYPE_RAW        = 101
+	LINKTYPE_LOOP       = 108
+	LINKTYPE_LINUX_SLL  = 113
+	LINKTYPE_IPV4       = 228
+	LINKTYPE_IPV6       = 229
+	LINKTYPE_LINUX_SLL2 = 276
+)
+
+const (
+	ETHERTYPE_IPV4   = 0x0800
+	ETHERTYPE_IPV6   = 0x86dd
+	ETHERTYPE_DOT1Q  = 0x8100
+	ETHERTYPE_DOT1AD = 0x88a8
+
+	IP_PROTOCOL_UDP = 17
+)
+
+// Datagram is a UDP datagram extracted from a captured frame.
+type Datagram struct {
+	Src     net.IP
+	Dst     net.IP
+	SrcPort int
+	DstPort int
+	Payload []byte
+}
+
+type ErrorNotUDP struct {
+	msg string
+}
+
+func NewErrorNotUDP(msg string) *ErrorNotUDP {
+	return &ErrorNotUDP{
+		msg: msg,
+	}
+}
+
+func (e *ErrorNotUDP) Error() string {
+	return fmt.Sprintf("Not a UDP datagram: %v", e.msg)
+}
+
+// DecodeUDP extracts the UDP datagram of a frame. ErrorNotUDP is returned
+// for other protocols and for IP fragments, which are not reassembled.
+func DecodeUDP(linkType uint32, data []byte) (*Datagram, error) {
+	var etherType uint16
+	switch linkType {
+	case LINKTYPE_ETHERNET:
+		if len(data) < 14 {
+			return nil, NewErrorNotUDP("ethernet header too short")
+		}
+		etherType = binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for etherType == ETHERTYPE_DOT1Q || etherType == ETHERTYPE_DOT1AD {
+			if len(data) < 4 {
+				return nil, NewErrorNotUDP("vlan header too short")
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+	case LINKTYPE_NULL, LINKTYPE_LOOP:
+		if len(data) < 4 {
+			return nil, NewErrorNotUDP("loopback header too short")
+		}
+		// The address family is in the byte order of the capturing host.
+		family := binary.LittleEndian.Uint32(data[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data[0:4])
+		}
+		data = data[4:]
+		switch family {
+		case 2:
+			etherType = ETHERTYPE_IPV4
+		case 10, 24, 28, 30:
+			etherType = ETHERTYPE_IPV6
+		}
+	case LINKTYPE_RAW, LINKTYPE_IPV4, LINKTYPE_IPV6:
+		if len(data) < 1 {
+			return nil, NewErrorNotUDP("empty packet")
+		}
+		switch data[0] >> 4 {
+		case 4:
+			etherType = ETHERTYPE_IPV4
+		case 6:
+			etherType = ETHERTYPE_IPV6
+		}
+	case LINKTYPE_LINUX_SLL:
+		if len(data) < 16 {
+			return nil, NewErrorNotUDP("linux cooked header too short")
+		}
+		etherType = binary.BigEndian.Uint16(data[14:16])
+		data = data[16:]
+	case LINKTYPE_LINUX_SLL2:
+		if len(data) < 20 {
+			return nil, NewErrorNotUDP("linux cooked v2 header too short")
+		}
+		etherType = binary.BigEndian.Uint16(data[0:2])
+		data = data[20:]
+	default:
+		return nil, NewErrorNotUDP(fmt.Sprintf("unsupported link type %v", linkType))
+	}
+
+	datagram := &Datagram{}
+	var protocol byte
+	switch etherType {
+	case ETHERTYPE_IPV4:
+		if len(data) < 20 {
+			return nil, NewErrorNotUDP("ipv4 header too short")
+		}
+		ihl := int(data[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(data[2:4]))
+		if ihl < 20 || len(data) < ihl || totalLength < ihl {
+			return nil, NewErrorNotUDP("invalid ipv4 header")
+		}
+		if binary.BigEndian.Uint16(data[6:8])&0x3fff != 0 {
+			return nil, NewErrorNotUDP("ipv4 fragment")
+		}
+		protocol = data[9]
+		datagram.Src = net.IP(append([]byte{}, data[12:16]...))
+		datagram.Dst = net.IP(append([]byte{}, data[16:20]...))
+		// Frames may be padded after the IP packet.
+		if totalLength < len(data) {
+			data = data[:totalLength]
+		}
+		data = data[ihl:]
+	case ETHERTYPE_IPV6:
+		if len(data) < 40 {
+			return nil, NewErrorNotUDP("ipv6 header too short")
+		}
+		protocol = data[6]
+		payloadLength := int(binary.BigEndian.Uint16(data[4:6]))
+		datagram.Src = net.IP(append([]byte{}, data[8:24]...))
+		datagram.Dst = net.IP(append([]byte{}, data[24:40]...))
+		data = data[40:]
+		if payloadLength < len(data) {
+			data = data[:payloadLength]
+		}
+		// Hop-by-hop, routing and destination options extension headers.
+		for protocol == 0 || protocol == 43 || protocol == 60 {
+			if len(data) < 8 {
+				return nil, NewErrorNotUDP("ipv6 extension header too short")
+			}
+			extLength := (int(data[1]) + 1) * 8
+			if len(data) < extLength {
+				return nil, NewErrorNotUDP("ipv6 extension header too short")
+			}
+			protocol = data[0]
+			data = data[extLength:]
+		}
+		if protocol == 44 {
+			return nil, NewErrorNotUDP("ipv6 fragment")
+		}
+	default:
+		return nil, NewErrorNotUDP(fmt.Sprintf("unsupported ethertype 0x%04x", etherType))
+	}
+
+	if protocol != IP_PROTOCOL_UDP {
+		return nil, NewErrorNotUDP(fmt.Sprintf("ip protocol %v", protocol))
+	}
+	if len(data) < 8 {
+		return nil, NewErrorNotUDP("udp header too short")
+	}
+	datagram.SrcPort = int(binary.BigEndian.Uint16(data[0:2]))
+	datagram.DstPort = int(binary.BigEndian.Uint16(data[2:4]))
+	udpLength := int(binary.BigEndian.Uint16(data[4:6]))
+	if udpLength < 8 || udpLength > len(data) {
+		return nil, NewErrorNotUDP("truncated udp datagram")
+	}
+	datagram.Payload = data[8:udpLength]
+	return datagram, nil
+}
@@ -0,0 +1,215 @@
+// Package rawcapture stores received datagrams as-is so that decoding can be
+// reproduced later from the exact bytes.
+//
+// A capture file starts with a header (magic "GFRC", version) followed by records:
+//
+//	uint32   payload length
+//	int64    receive time (nanoseconds since epoch)
+//	[16]byte source address (IPv4-mapped for IPv4)
+//	uint16   source port
+//	uint16   local port
+//	payload
+//
+// Each capture file has an index file (magic "GFRI", version) with the
+// offset and the receive time of every record:
+//
+//	uint64 offset
+//	int64  receive time (nanoseconds since epoch)
+//
+// All integers are big endian.
+package rawcapture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	CAPTURE_MAGIC = "GFRC"
+	INDEX_MAGIC   = "GFRI"
+	VERSION       = 1
+
+	HEADER_SIZE        = 8
+	RECORD_HEADER_SIZE = 32
+	INDEX_ENTRY_SIZE   = 16
+
+	CAPTURE_EXTENSION = ".raw"
+	INDEX_EXTENSION   = ".idx"
+
+	// Records larger than this are considered corrupted.
+	MAX_PAYLOAD_SIZE = 65535
+)
+
+type ErrorFormat struct {
+	msg string
+}
+
+func NewErrorFormat(msg string) *ErrorFormat {
+	return &ErrorFormat{
+		msg: msg,
+	}
+}
+
+func (e *ErrorFormat) Error() string {
+	return fmt.Sprintf("Raw capture format error: %v", e.msg)
+}
+
+// Datagram is a datagram as received by a collector.
+type Datagram struct {
+	Time      time.Time
+	Src       net.IP
+	SrcPort   int
+	LocalPort int
+	Payload   []byte
+}
+
+// IndexEntry locates a record in a capture file.
+type IndexEntry struct {
+	Offset int64
+	Time   time.Time
+}
+
+func encodeHeader(magic string) []byte {
+	header := make([]byte, HEADER_SIZE)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[4:6], VERSION)
+	return header
+}
+
+func readHeader(r io.Reader, magic string) error {
+	header := make([]byte, HEADER_SIZE)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return err
+	}
+	if string(header[0:4]) != magic {
+		return NewErrorFormat(fmt.Sprintf("bad magic %x", header[0:4]))
+	}
+	version := binary.BigEndian.Uint16(header[4:6])
+	if version != VERSION {
+		return NewErrorFormat(fmt.Sprintf("unknown version %v", version))
+	}
+	return nil
+}
+
+func encodeRecord(datagram Datagram) ([]byte, error) {
+	if len(datagram.Payload) > MAX_PAYLOAD_SIZE {
+		return nil, NewErrorFormat(fmt.Sprintf("payload too large (%v bytes)", len(datagram.Payload)))
+	}
+	record := make([]byte, RECORD_HEADER_SIZE+len(datagram.Payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(datagram.Payload)))
+	binary.BigEndian.PutUint64(record[4:12], uint64(datagram.Time.UnixNano()))
+	copy(record[12:28], datagram.Src.To16())
+	binary.BigEndian.PutUint16(record[28:30], uint16(datagram.SrcPort))
+	binary.BigEndian.PutUint16(record[30:32], uint16(datagram.LocalPort))
+	copy(record[RECORD_HEADER_SIZE:], datagram.Payload)
+	return record, nil
+}
+
+func encodeIndexEntry(entry IndexEntry) []byte {
+	b := make([]byte, INDEX_ENTRY_SIZE)
+	binary.BigEndian.PutUint64(b[0:8], uint64(entry.Offset))
+	binary.BigEndian.PutUint64(b[8:16], uint64(entry.Time.UnixNano()))
+	return b
+}
+
+// Reader reads the datagrams of a capture file.
+type Reader struct {
+	r  io.Reader
+	br *bufio.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		r:  r,
+		br: bufio.NewReader(r),
+	}
+	err := readHeader(reader.br, CAPTURE_MAGIC)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// ReadDatagram returns the next datagram, or io.EOF at the end of the file.
+func (r *Reader) ReadDatagram() (Datagram, error) {
+	header := make([]byte, RECORD_HEADER_SIZE)
+	_, err := io.ReadFull(r.br, header)
+	if err != nil {
+		return Datagram{}, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > MAX_PAYLOAD_SIZE {
+		return Datagram{}, NewErrorFormat(fmt.Sprintf("payload too large (%v bytes)", length))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r.br, payload)
+	if err == io.EOF {
+		return Datagram{}, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return Datagram{}, err
+	}
+
+	src := net.IP(append([]byte{}, header[12:28]...))
+	if src.To4() != nil {
+		src = src.To4()
+	}
+	return Datagram{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[4:12]))),
+		Src:       src,
+		SrcPort:   int(binary.BigEndian.Uint16(header[28:30])),
+		LocalPort: int(binary.BigEndian.Uint16(header[30:32])),
+		Payload:   payload,
+	}, nil
+}
+
+// SeekRecord moves to the record at offset (from the index) when the underlying
+// reader is an io.Seeker.
+func (r *Reader) SeekRecord(offset int64) error {
+	seeker, ok := r.r.(io.Seeker)
+	if !ok {
+		return NewErrorFormat("capture is not seekable")
+	}
+	_, err := seeker.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.br.Reset(r.r)
+	return nil
+}
+
+// ReadIndex reads the entries of an index file. A truncated last entry
+// (eg: interrupted write) is ignored.
+func ReadIndex(r io.Reader) ([]IndexEntry, error) {
+	br := bufio.NewReader(r)
+	err := readHeader(br, INDEX_MAGIC)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]IndexEntry, 0)
+	b := make([]byte, INDEX_ENTRY_SIZE)
+	for {
+		_, err := io.ReadFull(br, b)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		entries = append(entries, IndexEntry{
+			Offset: int64(binary.BigEndian.Uint64(b[0:8])),
+			Time:   time.Unix(0, int64(binary.BigEndian.Uint64(b[8:16]))),
+		})
+	}
+}
+
+// FindIndexEntry returns the position of the first entry received at or after t.
+func FindIndexEntry(entries []IndexEntry, t time.Time) int {
+	return sort.Search(len(entries), func(i int) bool {
+		return !entries[i].Time.Before(t)
+	})
+}
@@ -0,0 +1,113 @@
+package rawcapture
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readFile(t *testing.T, path string) []Datagram {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	r, err := NewReader(f)
+	assert.Nil(t, err)
+
+	datagrams := make([]Datagram, 0)
+	for {
+		datagram, err := r.ReadDatagram()
+		if err == io.EOF {
+			return datagrams
+		}
+		assert.Nil(t, err)
+		datagrams = append(datagrams, datagram)
+	}
+}
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	// two records of 32+10 bytes per file
+	w := NewWriter(dir, "test", HEADER_SIZE+2*42, 0, 2)
+
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 5; i++ {
+		err := w.WriteDatagram(Datagram{
+			Time:      start.Add(time.Duration(i) * time.Millisecond),
+			Src:       net.IPv4(10, 0, 0, byte(i)),
+			SrcPort:   1234,
+			LocalPort: 2055,
+			Payload:   make([]byte, 10),
+		})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+
+	// the first file was removed
+	files, err := ListFiles(dir, "test")
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+
+	datagrams := readFile(t, files[0])
+	assert.Len(t, datagrams, 2)
+	assert.Equal(t, net.IP{10, 0, 0, 2}, datagrams[0].Src)
+	assert.Equal(t, 1234, datagrams[0].SrcPort)
+	assert.Equal(t, 2055, datagrams[0].LocalPort)
+	assert.True(t, start.Add(2*time.Millisecond).Equal(datagrams[0].Time))
+	assert.Len(t, readFile(t, files[1]), 1)
+}
+
+func TestWriterMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, "test", 0, time.Minute, 0)
+	start := time.Unix(1600000000, 0)
+	for _, offset := range []time.Duration{0, 30 * time.Second, 61 * time.Second} {
+		err := w.WriteDatagram(Datagram{Time: start.Add(offset), Src: net.IPv6loopback, Payload: []byte{1}})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+
+	files, err := ListFiles(dir, "test")
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	datagrams := readFile(t, files[0])
+	assert.Len(t, datagrams, 2)
+	assert.Equal(t, net.IPv6loopback, datagrams[0].Src)
+}
+
+func TestIndexSeek(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, "test", 0, 0, 0)
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 3; i++ {
+		err := w.WriteDatagram(Datagram{Time: start.Add(time.Duration(i) * time.Second), Src: net.IPv4(10, 0, 0, 1), Payload: []byte{byte(i)}})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+
+	files, err := ListFiles(dir, "test")
+	assert.Nil(t, err)
+	fIndex, err := os.Open(strings.TrimSuffix(files[0], CAPTURE_EXTENSION) + INDEX_EXTENSION)
+	assert.Nil(t, err)
+	defer fIndex.Close()
+	entries, err := ReadIndex(fIndex)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+
+	i := FindIndexEntry(entries, start.Add(1500*time.Millisecond))
+	assert.Equal(t, 2, i)
+
+	f, err := os.Open(files[0])
+	assert.Nil(t, err)
+	defer f.Close()
+	r, err := NewReader(f)
+	assert.Nil(t, err)
+	assert.Nil(t, r.SeekRecord(entries[i].Offset))
+	datagram, err := r.ReadDatagram()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, datagram.Payload)
+}
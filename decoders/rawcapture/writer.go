@@ -0,0 +1,184 @@
+package rawcapture
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writer appends datagrams to capture files rotated by size and age.
+// Files are named <Prefix>-<creation time><extension> in Dir so that they
+// sort in capture order. It can be used by several goroutines.
+type Writer struct {
+	Dir    string
+	Prefix string
+
+	MaxSize  int64         // rotate when the capture file exceeds this size (0 disables)
+	MaxAge   time.Duration // rotate when the capture file is older (0 disables)
+	MaxFiles int           // remove the oldest capture files above this count (0 disables)
+
+	lock        sync.Mutex
+	data        *os.File
+	index       *os.File
+	dataBuffer  *bufio.Writer
+	indexBuffer *bufio.Writer
+	size        int64
+	created     time.Time
+}
+
+func NewWriter(dir string, prefix string, maxSize int64, maxAge time.Duration, maxFiles int) *Writer {
+	return &Writer{
+		Dir:      dir,
+		Prefix:   prefix,
+		MaxSize:  maxSize,
+		MaxAge:   maxAge,
+		MaxFiles: maxFiles,
+	}
+}
+
+// WriteDatagram appends a datagram to the current capture file. The files
+// are buffered: the records are written once Flush or Close is called or
+// the buffer is full, and a crash leaves at most one truncated record.
+func (w *Writer) WriteDatagram(datagram Datagram) error {
+	record, err := encodeRecord(datagram)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.data != nil && ((w.MaxSize > 0 && w.size+int64(len(record)) > w.MaxSize && w.size > HEADER_SIZE) ||
+		(w.MaxAge > 0 && datagram.Time.Sub(w.created) >= w.MaxAge)) {
+		err = w.close()
+		if err != nil {
+			return err
+		}
+	}
+	if w.data == nil {
+		err = w.open(datagram.Time)
+		if err != nil {
+			return err
+		}
+	}
+
+	offset := w.size
+	_, err = w.dataBuffer.Write(record)
+	if err != nil {
+		return err
+	}
+	w.size += int64(len(record))
+	_, err = w.indexBuffer.Write(encodeIndexEntry(IndexEntry{Offset: offset, Time: datagram.Time}))
+	return err
+}
+
+// Flush writes the buffered records to the current capture file.
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.flush()
+}
+
+// flush writes the records before their index entries so that the index
+// never points past the end of the capture file.
+func (w *Writer) flush() error {
+	if w.data == nil {
+		return nil
+	}
+	err := w.dataBuffer.Flush()
+	if err != nil {
+		return err
+	}
+	return w.indexBuffer.Flush()
+}
+
+func (w *Writer) open(now time.Time) error {
+	err := os.MkdirAll(w.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(w.Dir, w.Prefix+"-"+now.UTC().Format("20060102T150405.000000000Z"))
+	data, err := os.OpenFile(base+CAPTURE_EXTENSION, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	index, err := os.OpenFile(base+INDEX_EXTENSION, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		data.Close()
+		return err
+	}
+	_, err = data.Write(encodeHeader(CAPTURE_MAGIC))
+	if err == nil {
+		_, err = index.Write(encodeHeader(INDEX_MAGIC))
+	}
+	if err != nil {
+		data.Close()
+		index.Close()
+		return err
+	}
+
+	w.data = data
+	w.index = index
+	w.dataBuffer = bufio.NewWriter(data)
+	w.indexBuffer = bufio.NewWriter(index)
+	w.size = HEADER_SIZE
+	w.created = now
+	return w.removeOldFiles()
+}
+
+func (w *Writer) removeOldFiles() error {
+	if w.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := ListFiles(w.Dir, w.Prefix)
+	if err != nil {
+		return err
+	}
+	for len(files) > w.MaxFiles {
+		os.Remove(files[0])
+		os.Remove(strings.TrimSuffix(files[0], CAPTURE_EXTENSION) + INDEX_EXTENSION)
+		files = files[1:]
+	}
+	return nil
+}
+
+func (w *Writer) close() error {
+	if w.data == nil {
+		return nil
+	}
+	errFlush := w.flush()
+	err := w.data.Close()
+	errIndex := w.index.Close()
+	w.data = nil
+	w.index = nil
+	w.dataBuffer = nil
+	w.indexBuffer = nil
+	if errFlush != nil {
+		return errFlush
+	}
+	if err != nil {
+		return err
+	}
+	return errIndex
+}
+
+// Close closes the current capture file. A following write opens a new one.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.close()
+}
+
+// ListFiles returns the capture files of prefix in dir, oldest first.
+func ListFiles(dir string, prefix string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, prefix+"-*"+CAPTURE_EXTENSION))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -64,6 +64,77 @@ type ExtendedGateway struct {
 	LocalPref         uint32
 }
 
+type ExtendedUser struct {
+	SrcCharset uint32 // IANA character set MIBenum
+	SrcUser    string
+	DstCharset uint32
+	DstUser    string
+}
+
+type ExtendedURL struct {
+	Direction uint32 // 1: source address is the server, 2: destination address is the server
+	URL       string
+	Host      string
+}
+
+type ExtendedMPLS struct {
+	NextHopIPVersion uint32
+	NextHop          []byte
+	InLabelStack     []uint32 // label stack entries (RFC 3032)
+	OutLabelStack    []uint32
+}
+
+type ExtendedNAT struct {
+	SrcIPVersion uint32
+	SrcIP        []byte
+	DstIPVersion uint32
+	DstIP        []byte
+}
+
+type ExtendedMPLSTunnel struct {
+	TunnelLSPName string
+	TunnelId      uint32
+	TunnelCos     uint32
+}
+
+type ExtendedMPLSVC struct {
+	VcInstanceName string
+	VllVcId        uint32
+	VcLabelCos     uint32
+}
+
+type ExtendedMPLSFTN struct {
+	MplsFTNDescr string
+	MplsFTNMask  uint32
+}
+
+type ExtendedVNI struct {
+	VNI uint32
+}
+
+type Extended80211Rx struct {
+	SSID           string
+	BSSID          []byte // MAC address
+	Version        uint32 // 1: 802.11a, 2: 802.11b, 3: 802.11g, 4: 802.11n
+	Channel        uint32
+	Speed          uint64
+	RSNI           uint32
+	RCPI           uint32
+	PacketDuration uint32 // microseconds
+}
+
+type Extended80211Tx struct {
+	SSID            string
+	BSSID           []byte // MAC address
+	Version         uint32
+	Transmissions   uint32
+	PacketDuration  uint32 // microseconds
+	RetransDuration uint32 // microseconds
+	Channel         uint32
+	Speed           uint64
+	Power           uint32 // mW
+}
+
 type IfCounters struct {
 	IfIndex            uint32
 	IfType             uint32
@@ -101,3 +172,119 @@ type EthernetCounters struct {
 	Dot3StatsInternalMacReceiveErrors  uint32
 	Dot3StatsSymbolErrors              uint32
 }
+
+type VlanCounters struct {
+	VlanId        uint32
+	Octets        uint64
+	UcastPkts     uint32
+	MulticastPkts uint32
+	BroadcastPkts uint32
+	Discards      uint32
+}
+
+type LagPortStats struct {
+	ActorSystemID        []byte // MAC address
+	PartnerOperSystemID  []byte // MAC address
+	AttachedAggID        uint32
+	ActorAdminState      uint8
+	ActorOperState       uint8
+	PartnerAdminState    uint8
+	PartnerOperState     uint8
+	LACPDUsRx            uint32
+	MarkerPDUsRx         uint32
+	MarkerResponsePDUsRx uint32
+	UnknownRx            uint32
+	IllegalRx            uint32
+	LACPDUsTx            uint32
+	MarkerPDUsTx         uint32
+	MarkerResponsePDUsTx uint32
+}
+
+type SFPLane struct {
+	Index         uint32
+	TxBiasCurrent uint32 // microamps
+	TxPower       uint32 // microwatts
+	TxPowerMin    uint32
+	TxPowerMax    uint32
+	TxWavelength  uint32 // nanometers
+	RxPower       uint32 // microwatts
+	RxPowerMin    uint32
+	RxPowerMax    uint32
+	RxWavelength  uint32
+}
+
+type SFPCounters struct {
+	ModuleId            uint32
+	ModuleTotalLanes    uint32
+	ModuleSupplyVoltage uint32 // millivolts
+	ModuleTemperature   int32  // thousandths of degree Celsius
+	Lanes               []SFPLane
+}
+
+type ProcessorCounters struct {
+	Cpu5s       uint32 // hundredths of percent
+	Cpu1m       uint32
+	Cpu5m       uint32
+	TotalMemory uint64
+	FreeMemory  uint64
+}
+
+type HostCPUCounters struct {
+	LoadOne      float32
+	LoadFive     float32
+	LoadFifteen  float32
+	ProcRun      uint32
+	ProcTotal    uint32
+	CpuNum       uint32
+	CpuSpeed     uint32 // MHz
+	Uptime       uint32 // seconds
+	CpuUser      uint32 // milliseconds
+	CpuNice      uint32
+	CpuSystem    uint32
+	CpuIdle      uint32
+	CpuWio       uint32
+	CpuIntr      uint32
+	CpuSintr     uint32
+	Interrupts   uint32
+	Contexts     uint32
+	CpuSteal     uint32 // only sent by recent agents
+	CpuGuest     uint32
+	CpuGuestNice uint32
+}
+
+type HostMemoryCounters struct {
+	MemTotal   uint64
+	MemFree    uint64
+	MemShared  uint64
+	MemBuffers uint64
+	MemCached  uint64
+	SwapTotal  uint64
+	SwapFree   uint64
+	PageIn     uint32
+	PageOut    uint32
+	SwapIn     uint32
+	SwapOut    uint32
+}
+
+type HostDiskCounters struct {
+	DiskTotal    uint64
+	DiskFree     uint64
+	PartMaxUsed  uint32 // hundredths of percent
+	Reads        uint32
+	BytesRead    uint64
+	ReadTime     uint32 // milliseconds
+	Writes       uint32
+	BytesWritten uint64
+	WriteTime    uint32 // milliseconds
+}
+
+type HostNetCounters struct {
+	BytesIn  uint64
+	PktsIn   uint32
+	ErrsIn   uint32
+	DropsIn  uint32
+	BytesOut uint64
+	PktsOut  uint32
+	ErrsOut  uint32
+	DropsOut uint32
+}
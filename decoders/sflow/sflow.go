@@ -2,19 +2,42 @@ package sflow
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/cloudflare/goflow/v3/decoders/utils"
 )
 
 const (
-	FORMAT_EXT_SWITCH  = 1001
-	FORMAT_EXT_ROUTER  = 1002
-	FORMAT_EXT_GATEWAY = 1003
-	FORMAT_RAW_PKT     = 1
-	FORMAT_ETH         = 2
-	FORMAT_IPV4        = 3
-	FORMAT_IPV6        = 4
+	FORMAT_EXT_SWITCH      = 1001
+	FORMAT_EXT_ROUTER      = 1002
+	FORMAT_EXT_GATEWAY     = 1003
+	FORMAT_EXT_USER        = 1004
+	FORMAT_EXT_URL         = 1005
+	FORMAT_EXT_MPLS        = 1006
+	FORMAT_EXT_NAT         = 1007
+	FORMAT_EXT_MPLS_TUNNEL = 1008
+	FORMAT_EXT_MPLS_VC     = 1009
+	FORMAT_EXT_MPLS_FTN    = 1010
+	FORMAT_EXT_80211_RX    = 1014
+	FORMAT_EXT_80211_TX    = 1015
+	FORMAT_EXT_VNI_EGRESS  = 1029 // extended_vni_egress (1023 and 1024 are the IPv4 tunnels, skipped)
+	FORMAT_EXT_VNI_INGRESS = 1030
+	FORMAT_RAW_PKT         = 1
+	FORMAT_ETH             = 2
+	FORMAT_IPV4            = 3
+	FORMAT_IPV6            = 4
+
+	COUNTERS_GENERIC_IF  = 1
+	COUNTERS_ETHERNET    = 2
+	COUNTERS_VLAN        = 5
+	COUNTERS_LAG         = 7
+	COUNTERS_SFP         = 10
+	COUNTERS_PROCESSOR   = 1001
+	COUNTERS_HOST_CPU    = 2003
+	COUNTERS_HOST_MEMORY = 2004
+	COUNTERS_HOST_DISK   = 2005
+	COUNTERS_HOST_NET    = 2006
 
 	// The following max constants control what goflow considers reasonable amounts of data objects reported in a single packet.
 	// This is to prevent an attacker from making us allocate arbitrary amounts of memory and let goflow be killed by the OOM killer.
@@ -22,6 +45,7 @@ const (
 	MAX_UDP_PKT_SIZE          = 65535
 	USUAL_SAMPLED_HEADER_SIZE = 128
 	FLOW_RECORD_HEADER_SIZE   = 8
+	SAMPLE_HEADER_SIZE        = 8
 	ASN_SIZE                  = 4
 	COMMUNITY_SIZE            = 4
 	MAX_SAMPLES_PER_PACKET    = MAX_UDP_PKT_SIZE / USUAL_SAMPLED_HEADER_SIZE
@@ -90,25 +114,98 @@ func DecodeCounterRecord(header *RecordHeader, payload *bytes.Buffer) (CounterRe
 	counterRecord := CounterRecord{
 		Header: *header,
 	}
+	var err error
 	switch (*header).DataFormat {
-	case 1:
+	case COUNTERS_GENERIC_IF:
 		ifCounters := IfCounters{}
-		utils.BinaryDecoder(payload, &ifCounters)
+		err = utils.BinaryDecoder(payload, &ifCounters)
 		counterRecord.Data = ifCounters
-	case 2:
+	case COUNTERS_ETHERNET:
 		ethernetCounters := EthernetCounters{}
-		utils.BinaryDecoder(payload, &ethernetCounters)
+		err = utils.BinaryDecoder(payload, &ethernetCounters)
 		counterRecord.Data = ethernetCounters
+	case COUNTERS_VLAN:
+		vlanCounters := VlanCounters{}
+		err = utils.BinaryDecoder(payload, &vlanCounters)
+		counterRecord.Data = vlanCounters
+	case COUNTERS_LAG:
+		counterRecord.Data, err = decodeLagPortStats(payload)
+	case COUNTERS_SFP:
+		counterRecord.Data, err = decodeSFPCounters(payload)
+	case COUNTERS_PROCESSOR:
+		processorCounters := ProcessorCounters{}
+		err = utils.BinaryDecoder(payload, &processorCounters)
+		counterRecord.Data = processorCounters
+	case COUNTERS_HOST_CPU:
+		hostCPUCounters := HostCPUCounters{}
+		err = utils.BinaryDecoder(payload, &hostCPUCounters.LoadOne, &hostCPUCounters.LoadFive, &hostCPUCounters.LoadFifteen,
+			&hostCPUCounters.ProcRun, &hostCPUCounters.ProcTotal, &hostCPUCounters.CpuNum, &hostCPUCounters.CpuSpeed, &hostCPUCounters.Uptime,
+			&hostCPUCounters.CpuUser, &hostCPUCounters.CpuNice, &hostCPUCounters.CpuSystem, &hostCPUCounters.CpuIdle, &hostCPUCounters.CpuWio,
+			&hostCPUCounters.CpuIntr, &hostCPUCounters.CpuSintr, &hostCPUCounters.Interrupts, &hostCPUCounters.Contexts)
+		if err == nil && payload.Len() >= 12 {
+			err = utils.BinaryDecoder(payload, &hostCPUCounters.CpuSteal, &hostCPUCounters.CpuGuest, &hostCPUCounters.CpuGuestNice)
+		}
+		counterRecord.Data = hostCPUCounters
+	case COUNTERS_HOST_MEMORY:
+		hostMemoryCounters := HostMemoryCounters{}
+		err = utils.BinaryDecoder(payload, &hostMemoryCounters)
+		counterRecord.Data = hostMemoryCounters
+	case COUNTERS_HOST_DISK:
+		hostDiskCounters := HostDiskCounters{}
+		err = utils.BinaryDecoder(payload, &hostDiskCounters)
+		counterRecord.Data = hostDiskCounters
+	case COUNTERS_HOST_NET:
+		hostNetCounters := HostNetCounters{}
+		err = utils.BinaryDecoder(payload, &hostNetCounters)
+		counterRecord.Data = hostNetCounters
 	default:
-		return counterRecord, NewErrorDataFormat((*header).DataFormat)
+		// the payload only holds the record: unknown formats are skipped
+		// and Data is left empty
+	}
+	if err != nil {
+		return counterRecord, NewErrorDecodingSFlow(fmt.Sprintf("counter record %v: %v", (*header).DataFormat, err))
 	}
 
 	return counterRecord, nil
 }
 
+func decodeLagPortStats(payload *bytes.Buffer) (LagPortStats, error) {
+	lagPortStats := LagPortStats{
+		// MAC addresses are padded to 8 bytes
+		ActorSystemID:       make([]byte, 8),
+		PartnerOperSystemID: make([]byte, 8),
+	}
+	err := utils.BinaryDecoder(payload, lagPortStats.ActorSystemID, lagPortStats.PartnerOperSystemID, &lagPortStats.AttachedAggID,
+		&lagPortStats.ActorAdminState, &lagPortStats.ActorOperState, &lagPortStats.PartnerAdminState, &lagPortStats.PartnerOperState,
+		&lagPortStats.LACPDUsRx, &lagPortStats.MarkerPDUsRx, &lagPortStats.MarkerResponsePDUsRx, &lagPortStats.UnknownRx,
+		&lagPortStats.IllegalRx, &lagPortStats.LACPDUsTx, &lagPortStats.MarkerPDUsTx, &lagPortStats.MarkerResponsePDUsTx)
+	lagPortStats.ActorSystemID = lagPortStats.ActorSystemID[:6]
+	lagPortStats.PartnerOperSystemID = lagPortStats.PartnerOperSystemID[:6]
+	return lagPortStats, err
+}
+
+func decodeSFPCounters(payload *bytes.Buffer) (SFPCounters, error) {
+	sfpCounters := SFPCounters{}
+	var lanesCount uint32
+	err := utils.BinaryDecoder(payload, &sfpCounters.ModuleId, &sfpCounters.ModuleTotalLanes, &sfpCounters.ModuleSupplyVoltage,
+		&sfpCounters.ModuleTemperature, &lanesCount)
+	if err != nil {
+		return sfpCounters, err
+	}
+	if int(lanesCount) > payload.Len()/binary.Size(SFPLane{}) {
+		return sfpCounters, fmt.Errorf("invalid number of lanes: %d", lanesCount)
+	}
+	sfpCounters.Lanes = make([]SFPLane, lanesCount)
+	err = utils.BinaryDecoder(payload, sfpCounters.Lanes)
+	return sfpCounters, err
+}
+
 func DecodeIP(payload *bytes.Buffer) (uint32, []byte, error) {
 	var ipVersion uint32
-	utils.BinaryDecoder(payload, &ipVersion)
+	err := utils.BinaryDecoder(payload, &ipVersion)
+	if err != nil {
+		return ipVersion, nil, err
+	}
 	var ip []byte
 	if ipVersion == 1 {
 		ip = make([]byte, 4)
@@ -117,12 +214,11 @@ func DecodeIP(payload *bytes.Buffer) (uint32, []byte, error) {
 	} else {
 		return ipVersion, ip, NewErrorIPVersion(ipVersion)
 	}
-	if payload.Len() >= len(ip) {
-		utils.BinaryDecoder(payload, &ip)
-	} else {
+	if payload.Len() < len(ip) {
 		return ipVersion, ip, NewErrorDecodingSFlow(fmt.Sprintf("Not enough data: %v, needs %v.", payload.Len(), len(ip)))
 	}
-	return ipVersion, ip, nil
+	err = utils.BinaryDecoder(payload, ip)
+	return ipVersion, ip, err
 }
 
 func DecodeFlowRecord(header *RecordHeader, payload *bytes.Buffer) (FlowRecord, error) {
@@ -253,12 +349,188 @@ func DecodeFlowRecord(header *RecordHeader, payload *bytes.Buffer) (FlowRecord,
 		extendedGateway.Communities = communities
 
 		flowRecord.Data = extendedGateway
+	case FORMAT_EXT_USER:
+		extendedUser := ExtendedUser{}
+		err := utils.BinaryDecoder(payload, &(extendedUser.SrcCharset))
+		if err == nil {
+			extendedUser.SrcUser, err = decodeString(payload)
+		}
+		if err == nil {
+			err = utils.BinaryDecoder(payload, &(extendedUser.DstCharset))
+		}
+		if err == nil {
+			extendedUser.DstUser, err = decodeString(payload)
+		}
+		if err != nil {
+			return flowRecord, err
+		}
+		flowRecord.Data = extendedUser
+	case FORMAT_EXT_URL:
+		extendedURL := ExtendedURL{}
+		err := utils.BinaryDecoder(payload, &(extendedURL.Direction))
+		if err == nil {
+			extendedURL.URL, err = decodeString(payload)
+		}
+		// the host was added in a later revision of the structure
+		if err == nil && payload.Len() > 0 {
+			extendedURL.Host, err = decodeString(payload)
+		}
+		if err != nil {
+			return flowRecord, err
+		}
+		flowRecord.Data = extendedURL
+	case FORMAT_EXT_MPLS:
+		extendedMPLS := ExtendedMPLS{}
+		ipVersion, ip, err := DecodeIP(payload)
+		if err != nil {
+			return flowRecord, err
+		}
+		extendedMPLS.NextHopIPVersion = ipVersion
+		extendedMPLS.NextHop = ip
+		extendedMPLS.InLabelStack, err = decodeLabelStack(payload)
+		if err != nil {
+			return flowRecord, err
+		}
+		extendedMPLS.OutLabelStack, err = decodeLabelStack(payload)
+		if err != nil {
+			return flowRecord, err
+		}
+		flowRecord.Data = extendedMPLS
+	case FORMAT_EXT_NAT:
+		extendedNAT := ExtendedNAT{}
+		ipVersion, ip, err := DecodeIP(payload)
+		if err != nil {
+			return flowRecord, err
+		}
+		extendedNAT.SrcIPVersion = ipVersion
+		extendedNAT.SrcIP = ip
+		ipVersion, ip, err = DecodeIP(payload)
+		if err != nil {
+			return flowRecord, err
+		}
+		extendedNAT.DstIPVersion = ipVersion
+		extendedNAT.DstIP = ip
+		flowRecord.Data = extendedNAT
+	case FORMAT_EXT_MPLS_TUNNEL:
+		extendedMPLSTunnel := ExtendedMPLSTunnel{}
+		var err error
+		extendedMPLSTunnel.TunnelLSPName, err = decodeString(payload)
+		if err == nil {
+			err = utils.BinaryDecoder(payload, &(extendedMPLSTunnel.TunnelId), &(extendedMPLSTunnel.TunnelCos))
+		}
+		if err != nil {
+			return flowRecord, err
+		}
+		flowRecord.Data = extendedMPLSTunnel
+	case FORMAT_EXT_MPLS_VC:
+		extendedMPLSVC := ExtendedMPLSVC{}
+		var err error
+		extendedMPLSVC.VcInstanceName, err = decodeString(payload)
+		if err == nil {
+			err = utils.BinaryDecoder(payload, &(extendedMPLSVC.VllVcId), &(extendedMPLSVC.VcLabelCos))
+		}
+		if err != nil {
+			return flowRecord, err
+		}
+		flowRecord.Data = extendedMPLSVC
+	case FORMAT_EXT_MPLS_FTN:
+		extendedMPLSFTN := ExtendedMPLSFTN{}
+		var err error
+		extendedMPLSFTN.MplsFTNDescr, err = decodeString(payload)
+		if err == nil {
+			err = utils.BinaryDecoder(payload, &(extendedMPLSFTN.MplsFTNMask))
+		}
+		if err != nil {
+			return flowRecord, err
+		}
+		flowRecord.Data = extendedMPLSFTN
+	case FORMAT_EXT_80211_RX:
+		extended80211Rx := Extended80211Rx{
+			// MAC addresses are padded to 8 bytes
+			BSSID: make([]byte, 8),
+		}
+		var err error
+		extended80211Rx.SSID, err = decodeString(payload)
+		if err == nil {
+			err = utils.BinaryDecoder(payload, extended80211Rx.BSSID, &(extended80211Rx.Version), &(extended80211Rx.Channel),
+				&(extended80211Rx.Speed), &(extended80211Rx.RSNI), &(extended80211Rx.RCPI), &(extended80211Rx.PacketDuration))
+		}
+		if err != nil {
+			return flowRecord, err
+		}
+		extended80211Rx.BSSID = extended80211Rx.BSSID[:6]
+		flowRecord.Data = extended80211Rx
+	case FORMAT_EXT_80211_TX:
+		extended80211Tx := Extended80211Tx{
+			BSSID: make([]byte, 8),
+		}
+		var err error
+		extended80211Tx.SSID, err = decodeString(payload)
+		if err == nil {
+			err = utils.BinaryDecoder(payload, extended80211Tx.BSSID, &(extended80211Tx.Version), &(extended80211Tx.Transmissions),
+				&(extended80211Tx.PacketDuration), &(extended80211Tx.RetransDuration), &(extended80211Tx.Channel),
+				&(extended80211Tx.Speed), &(extended80211Tx.Power))
+		}
+		if err != nil {
+			return flowRecord, err
+		}
+		extended80211Tx.BSSID = extended80211Tx.BSSID[:6]
+		flowRecord.Data = extended80211Tx
+	case FORMAT_EXT_VNI_EGRESS, FORMAT_EXT_VNI_INGRESS:
+		extendedVNI := ExtendedVNI{}
+		err := utils.BinaryDecoder(payload, &extendedVNI)
+		if err != nil {
+			return flowRecord, err
+		}
+		flowRecord.Data = extendedVNI
 	default:
-		return flowRecord, fmt.Errorf("unknown data format %v", (*header).DataFormat)
+		// the payload only holds the record: unknown formats are skipped
+		// and Data is left empty
 	}
 	return flowRecord, nil
 }
 
+// decodeString decodes a string<> of XDR: its length followed by its
+// characters padded to 4 bytes.
+func decodeString(payload *bytes.Buffer) (string, error) {
+	var length uint32
+	err := utils.BinaryDecoder(payload, &length)
+	if err != nil {
+		return "", err
+	}
+	if int(length) > payload.Len() {
+		return "", fmt.Errorf("invalid string length: %d", length)
+	}
+	str := string(payload.Next(int(length)))
+	payload.Next((4 - int(length)%4) % 4)
+	return str, nil
+}
+
+func decodeLabelStack(payload *bytes.Buffer) ([]uint32, error) {
+	var count uint32
+	err := utils.BinaryDecoder(payload, &count)
+	if err != nil {
+		return nil, err
+	}
+	if int(count) > payload.Len()/4 {
+		return nil, fmt.Errorf("invalid label stack length: %d", count)
+	}
+	labels := make([]uint32, count)
+	if count > 0 {
+		err = utils.BinaryDecoder(payload, labels)
+	}
+	return labels, err
+}
+
+// maxItems limits a count read from a datagram to the number of items of at
+// least size bytes that the rest of the payload can hold.
+func maxItems(count uint32, payload *bytes.Buffer, size int) int {
+	if limit := payload.Len() / size; int(count) > limit {
+		return limit
+	}
+	return int(count)
+}
+
 func DecodeSample(header *SampleHeader, payload *bytes.Buffer) (interface{}, error) {
 	format := (*header).Format
 	var sample interface{}
@@ -302,7 +574,7 @@ func DecodeSample(header *SampleHeader, payload *bytes.Buffer) (interface{}, err
 		if recordsCount > MAX_FLOW_RECORDS {
 			return flowSample, fmt.Errorf("invalid number of flows records: %d", recordsCount)
 		}
-		flowSample.Records = make([]FlowRecord, recordsCount)
+		flowSample.Records = make([]FlowRecord, maxItems(recordsCount, payload, FLOW_RECORD_HEADER_SIZE))
 		sample = flowSample
 	} else if format == FORMAT_ETH || format == FORMAT_IPV6 {
 		err = utils.BinaryDecoder(payload, &recordsCount)
@@ -317,7 +589,7 @@ func DecodeSample(header *SampleHeader, payload *bytes.Buffer) (interface{}, err
 		if recordsCount > MAX_SAMPLES_PER_PACKET {
 			return flowSample, fmt.Errorf("invalid number of samples: %d", recordsCount)
 		}
-		counterSample.Records = make([]CounterRecord, recordsCount)
+		counterSample.Records = make([]CounterRecord, maxItems(recordsCount, payload, FLOW_RECORD_HEADER_SIZE))
 		sample = counterSample
 	} else if format == FORMAT_IPV4 {
 		expandedFlowSample = ExpandedFlowSample{
@@ -330,7 +602,10 @@ func DecodeSample(header *SampleHeader, payload *bytes.Buffer) (interface{}, err
 			return sample, err
 		}
 		recordsCount = expandedFlowSample.FlowRecordsCount
-		expandedFlowSample.Records = make([]FlowRecord, recordsCount)
+		if recordsCount > MAX_FLOW_RECORDS {
+			return expandedFlowSample, fmt.Errorf("invalid number of flows records: %d", recordsCount)
+		}
+		expandedFlowSample.Records = make([]FlowRecord, maxItems(recordsCount, payload, FLOW_RECORD_HEADER_SIZE))
 		sample = expandedFlowSample
 	}
 	for i := 0; i < int(recordsCount) && payload.Len() >= 8; i++ {
@@ -380,23 +655,25 @@ func DecodeMessage(payload *bytes.Buffer) (interface{}, error) {
 		var ip []byte
 		if packetV5.IPVersion == 1 {
 			ip = make([]byte, 4)
-			utils.BinaryDecoder(payload, ip)
 		} else if packetV5.IPVersion == 2 {
 			ip = make([]byte, 16)
-			err = utils.BinaryDecoder(payload, ip)
-			if err != nil {
-				return packetV5, err
-			}
 		} else {
 			return nil, NewErrorIPVersion(packetV5.IPVersion)
 		}
+		err = utils.BinaryDecoder(payload, ip)
+		if err != nil {
+			return packetV5, err
+		}
 
 		packetV5.AgentIP = ip
 		err = utils.BinaryDecoder(payload, &(packetV5.SubAgentId), &(packetV5.SequenceNumber), &(packetV5.Uptime), &(packetV5.SamplesCount))
 		if err != nil {
 			return packetV5, err
 		}
-		packetV5.Samples = make([]interface{}, int(packetV5.SamplesCount))
+		if packetV5.SamplesCount > MAX_SAMPLES_PER_PACKET {
+			return packetV5, fmt.Errorf("invalid number of samples: %d", packetV5.SamplesCount)
+		}
+		packetV5.Samples = make([]interface{}, maxItems(packetV5.SamplesCount, payload, SAMPLE_HEADER_SIZE))
 		for i := 0; i < int(packetV5.SamplesCount) && payload.Len() >= 8; i++ {
 			header := SampleHeader{}
 			err = utils.BinaryDecoder(payload, &(header.Format), &(header.Length))
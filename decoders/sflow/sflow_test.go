@@ -2,13 +2,206 @@ package sflow
 
 import (
 	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestSFlowDecode(t *testing.T) {
-	data := []byte{
+	data := getSFlowDecode()
+	buf := bytes.NewBuffer(data)
+	_, err := DecodeMessage(buf)
+	assert.Nil(t, err)
+}
+
+func TestExpandedSFlowDecode(t *testing.T) {
+	data := getExpandedSFlowDecode()
+
+	buf := bytes.NewBuffer(data)
+	_, err := DecodeMessage(buf)
+	assert.Nil(t, err)
+}
+
+// buildCounterSample returns an sFlow datagram with a counter sample of the
+// records, each being the data format followed by the record data.
+func buildCounterSample(records ...[]byte) []byte {
+	sample := binary.BigEndian.AppendUint32(nil, 1) // sequence number
+	sample = binary.BigEndian.AppendUint32(sample, 3)
+	sample = binary.BigEndian.AppendUint32(sample, uint32(len(records)))
+	for _, record := range records {
+		sample = append(sample, record[:4]...)
+		sample = binary.BigEndian.AppendUint32(sample, uint32(len(record)-4))
+		sample = append(sample, record[4:]...)
+	}
+
+	pkt := binary.BigEndian.AppendUint32(nil, 5)
+	pkt = binary.BigEndian.AppendUint32(pkt, 1)
+	pkt = append(pkt, 192, 0, 2, 1)
+	pkt = append(pkt, make([]byte, 12)...) // sub agent, sequence number, uptime
+	pkt = binary.BigEndian.AppendUint32(pkt, 1)
+	pkt = binary.BigEndian.AppendUint32(pkt, FORMAT_ETH)
+	pkt = binary.BigEndian.AppendUint32(pkt, uint32(len(sample)))
+	return append(pkt, sample...)
+}
+
+func appendUint32s(b []byte, values ...uint32) []byte {
+	for _, value := range values {
+		b = binary.BigEndian.AppendUint32(b, value)
+	}
+	return b
+}
+
+func TestDecodeCounterRecords(t *testing.T) {
+	vlan := appendUint32s(nil, COUNTERS_VLAN, 100, 0, 1500, 10, 1, 2, 3)
+	unknown := appendUint32s(nil, 9999, 1, 2, 3)
+	processor := appendUint32s(nil, COUNTERS_PROCESSOR, 1000, 2000, 3000, 0, 4096, 0, 1024)
+	// without the steal and guest times of recent agents
+	hostCPU := appendUint32s(nil, COUNTERS_HOST_CPU, math.Float32bits(0.5), math.Float32bits(1), math.Float32bits(1.5), 2, 200, 8)
+	hostCPU = append(hostCPU, make([]byte, 11*4)...)
+	sfp := appendUint32s(nil, COUNTERS_SFP, 1, 4, 3300, 0xffffd8f0, 1, 1, 500, 6000)
+	sfp = append(sfp, make([]byte, 7*4)...)
+	lag := appendUint32s(nil, COUNTERS_LAG)
+	lag = append(lag, 0, 1, 2, 3, 4, 5, 0, 0, 6, 7, 8, 9, 10, 11, 0, 0)
+	lag = appendUint32s(lag, 42, 0x3d3d3f3f, 1, 2, 3, 4, 5, 6, 7, 8)
+
+	dec, err := DecodeMessage(bytes.NewBuffer(buildCounterSample(vlan, unknown, processor, hostCPU, sfp, lag)))
+	assert.Nil(t, err)
+	counterSample := dec.(Packet).Samples[0].(CounterSample)
+	assert.Len(t, counterSample.Records, 6)
+
+	assert.Equal(t, VlanCounters{VlanId: 100, Octets: 1500, UcastPkts: 10, MulticastPkts: 1, BroadcastPkts: 2, Discards: 3}, counterSample.Records[0].Data)
+	assert.Nil(t, counterSample.Records[1].Data)
+	assert.Equal(t, uint32(9999), counterSample.Records[1].Header.DataFormat)
+	assert.Equal(t, ProcessorCounters{Cpu5s: 1000, Cpu1m: 2000, Cpu5m: 3000, TotalMemory: 4096, FreeMemory: 1024}, counterSample.Records[2].Data)
+
+	hostCPUCounters := counterSample.Records[3].Data.(HostCPUCounters)
+	assert.Equal(t, float32(1.5), hostCPUCounters.LoadFifteen)
+	assert.Equal(t, uint32(8), hostCPUCounters.CpuNum)
+	assert.Zero(t, hostCPUCounters.CpuSteal)
+
+	sfpCounters := counterSample.Records[4].Data.(SFPCounters)
+	assert.Equal(t, int32(-10000), sfpCounters.ModuleTemperature)
+	if assert.Len(t, sfpCounters.Lanes, 1) {
+		assert.Equal(t, uint32(6000), sfpCounters.Lanes[0].TxPower)
+	}
+
+	lagPortStats := counterSample.Records[5].Data.(LagPortStats)
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5}, lagPortStats.ActorSystemID)
+	assert.Equal(t, []byte{6, 7, 8, 9, 10, 11}, lagPortStats.PartnerOperSystemID)
+	assert.Equal(t, uint32(42), lagPortStats.AttachedAggID)
+	assert.Equal(t, uint8(0x3f), lagPortStats.PartnerOperState)
+	assert.Equal(t, uint32(8), lagPortStats.MarkerResponsePDUsTx)
+}
+
+func TestDecodeCounterRecordTruncated(t *testing.T) {
+	vlan := appendUint32s(nil, COUNTERS_VLAN, 100)
+	_, err := DecodeCounterRecord(&RecordHeader{DataFormat: COUNTERS_VLAN, Length: 4}, bytes.NewBuffer(vlan[4:]))
+	assert.IsType(t, &ErrorDecodingSFlow{}, err)
+
+	// more lanes than the record holds
+	sfp := appendUint32s(nil, 1, 4, 3300, 0, 1000)
+	_, err = DecodeCounterRecord(&RecordHeader{DataFormat: COUNTERS_SFP}, bytes.NewBuffer(sfp))
+	assert.IsType(t, &ErrorDecodingSFlow{}, err)
+}
+
+// appendString appends a string<> of XDR, padded to 4 bytes.
+func appendString(b []byte, str string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(str)))
+	b = append(b, str...)
+	return append(b, make([]byte, (4-len(str)%4)%4)...)
+}
+
+func TestDecodeFlowRecords(t *testing.T) {
+	decode := func(format uint32, data []byte) (interface{}, error) {
+		record, err := DecodeFlowRecord(&RecordHeader{DataFormat: format, Length: uint32(len(data))}, bytes.NewBuffer(data))
+		return record.Data, err
+	}
+
+	user := appendString(appendUint32s(nil, 106), "alice")
+	user = appendString(appendUint32s(user, 106), "bob")
+	data, err := decode(FORMAT_EXT_USER, user)
+	assert.Nil(t, err)
+	assert.Equal(t, ExtendedUser{SrcCharset: 106, SrcUser: "alice", DstCharset: 106, DstUser: "bob"}, data)
+
+	// without the host of recent agents
+	data, err = decode(FORMAT_EXT_URL, appendString(appendUint32s(nil, 2), "/index.html"))
+	assert.Nil(t, err)
+	assert.Equal(t, ExtendedURL{Direction: 2, URL: "/index.html"}, data)
+	data, err = decode(FORMAT_EXT_URL, appendString(appendString(appendUint32s(nil, 2), "/"), "example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", data.(ExtendedURL).Host)
+
+	mpls := appendUint32s(nil, 1, 0xc0000201, 2, 100<<12|64, 200<<12|0x100|63, 0)
+	data, err = decode(FORMAT_EXT_MPLS, mpls)
+	assert.Nil(t, err)
+	assert.Equal(t, ExtendedMPLS{
+		NextHopIPVersion: 1,
+		NextHop:          []byte{192, 0, 2, 1},
+		InLabelStack:     []uint32{100<<12 | 64, 200<<12 | 0x100 | 63},
+		OutLabelStack:    []uint32{},
+	}, data)
+
+	nat := appendUint32s(nil, 1, 0xc6336401, 2)
+	nat = append(nat, net.ParseIP("2001:db8::1")...)
+	data, err = decode(FORMAT_EXT_NAT, nat)
+	assert.Nil(t, err)
+	assert.Equal(t, ExtendedNAT{SrcIPVersion: 1, SrcIP: []byte{198, 51, 100, 1}, DstIPVersion: 2, DstIP: []byte(net.ParseIP("2001:db8::1"))}, data)
+
+	data, err = decode(FORMAT_EXT_MPLS_TUNNEL, appendUint32s(appendString(nil, "lsp1"), 7, 3))
+	assert.Nil(t, err)
+	assert.Equal(t, ExtendedMPLSTunnel{TunnelLSPName: "lsp1", TunnelId: 7, TunnelCos: 3}, data)
+
+	// extended_vni_ingress
+	data, err = decode(1030, appendUint32s(nil, 4096))
+	assert.Nil(t, err)
+	assert.Equal(t, ExtendedVNI{VNI: 4096}, data)
+	// extended_ipv4_tunnel_egress carries a sampled_ipv4
+	data, err = decode(1023, appendUint32s(nil, 32, 17, 0xc0000201, 0xc0000202, 1024, 53, 0, 0))
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	wifi := appendString(nil, "goflow")
+	wifi = append(wifi, 0, 1, 2, 3, 4, 5, 0, 0)
+	wifi = appendUint32s(wifi, 4, 36, 0, 300000000, 20, 120, 50)
+	data, err = decode(FORMAT_EXT_80211_RX, wifi)
+	assert.Nil(t, err)
+	assert.Equal(t, Extended80211Rx{SSID: "goflow", BSSID: []byte{0, 1, 2, 3, 4, 5}, Version: 4, Channel: 36, Speed: 300000000, RSNI: 20, RCPI: 120, PacketDuration: 50}, data)
+
+	data, err = decode(9999, appendUint32s(nil, 1, 2))
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+}
+
+func TestDecodeFlowRecordsInvalidLengths(t *testing.T) {
+	_, err := DecodeFlowRecord(&RecordHeader{DataFormat: FORMAT_EXT_USER}, bytes.NewBuffer(appendUint32s(nil, 106, 1000)))
+	assert.NotNil(t, err)
+
+	_, err = DecodeFlowRecord(&RecordHeader{DataFormat: FORMAT_EXT_MPLS}, bytes.NewBuffer(appendUint32s(nil, 1, 0xc0000201, 1000, 1)))
+	assert.NotNil(t, err)
+}
+
+func TestDecodeMessageInvalidCounts(t *testing.T) {
+	data := getSFlowDecode()
+	// samples count
+	binary.BigEndian.PutUint32(data[24:28], 0xaeaeae67)
+	_, err := DecodeMessage(bytes.NewBuffer(data))
+	assert.NotNil(t, err)
+
+	// more flow records announced than the sample holds
+	data = getSFlowDecode()
+	binary.BigEndian.PutUint32(data[64:68], 1000)
+	dec, err := DecodeMessage(bytes.NewBuffer(data))
+	assert.Nil(t, err)
+	flowSample := dec.(Packet).Samples[0].(FlowSample)
+	assert.Equal(t, uint32(1000), flowSample.FlowRecordsCount)
+	assert.Less(t, len(flowSample.Records), 1000)
+}
+
+func getSFlowDecode() []byte {
+	return []byte{
 		0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x01, 0xac, 0x10, 0x00, 0x11, 0x00, 0x00, 0x00, 0x01,
 		0x00, 0x00, 0x01, 0xaa, 0x67, 0xee, 0xaa, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
 		0x00, 0x00, 0x00, 0x88, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x04, 0x13, 0x00, 0x00, 0x08, 0x00,
@@ -21,17 +214,6 @@ func TestSFlowDecode(t *testing.T) {
 		0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76,
 		0x77, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x00, 0x00,
 	}
-	buf := bytes.NewBuffer(data)
-	_, err := DecodeMessage(buf)
-	assert.Nil(t, err)
-}
-
-func TestExpandedSFlowDecode(t *testing.T) {
-	data := getExpandedSFlowDecode()
-
-	buf := bytes.NewBuffer(data)
-	_, err := DecodeMessage(buf)
-	assert.Nil(t, err)
 }
 
 func getExpandedSFlowDecode() []byte {
@@ -132,3 +314,16 @@ func getExpandedSFlowDecode() []byte {
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 	}
 }
+
+func FuzzDecodeMessage(f *testing.F) {
+	f.Add(getSFlowDecode())
+	f.Add(getExpandedSFlowDecode())
+	vlan := appendUint32s(nil, COUNTERS_VLAN, 100, 0, 1500, 10, 1, 2, 3)
+	sfp := appendUint32s(nil, COUNTERS_SFP, 1, 4, 3300, 0xffffd8f0, 1, 1, 500, 6000)
+	sfp = append(sfp, make([]byte, 7*4)...)
+	f.Add(buildCounterSample(vlan, sfp))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecodeMessage(bytes.NewBuffer(data))
+	})
+}
@@ -0,0 +1,36 @@
+// Package testhelpers holds the helpers shared by the tests of several
+// packages.
+package testhelpers
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// WaitGoroutines fails if the number of goroutines does not go back to n.
+func WaitGoroutines(t *testing.T, n int) {
+	for i := 0; i < 100 && runtime.NumGoroutine() > n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), n, "goroutines leaked")
+}
+
+// Flows decoded or produced per iteration by the allocation benchmarks.
+const BENCHMARK_RECORDS = 30
+
+// ReportAllocsPerFlow runs f b.N times and reports the allocations per
+// flow, f handling BENCHMARK_RECORDS flows.
+func ReportAllocsPerFlow(b *testing.B, f func()) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N*BENCHMARK_RECORDS), "allocs/flow")
+}
@@ -137,6 +137,12 @@ type FlowMessage struct {
 	// PPP information
 	HasPPP               bool     `protobuf:"varint,63,opt,name=HasPPP,proto3" json:"HasPPP,omitempty"`
 	PPPAddressControl    uint32   `protobuf:"varint,64,opt,name=PPPAddressControl,proto3" json:"PPPAddressControl,omitempty"`
+	SrcAddrNat           []byte   `protobuf:"bytes,65,opt,name=SrcAddrNat,proto3" json:"SrcAddrNat,omitempty"`
+	DstAddrNat           []byte   `protobuf:"bytes,66,opt,name=DstAddrNat,proto3" json:"DstAddrNat,omitempty"`
+	VniIngress           uint32   `protobuf:"varint,67,opt,name=VniIngress,proto3" json:"VniIngress,omitempty"`
+	VniEgress            uint32   `protobuf:"varint,68,opt,name=VniEgress,proto3" json:"VniEgress,omitempty"`
+	InIfName             string   `protobuf:"bytes,69,opt,name=InIfName,proto3" json:"InIfName,omitempty"`
+	OutIfName            string   `protobuf:"bytes,70,opt,name=OutIfName,proto3" json:"OutIfName,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -608,72 +614,489 @@ func (m *FlowMessage) GetPPPAddressControl() uint32 {
 	return 0
 }
 
+func (m *FlowMessage) GetSrcAddrNat() []byte {
+	if m != nil {
+		return m.SrcAddrNat
+	}
+	return nil
+}
+
+func (m *FlowMessage) GetDstAddrNat() []byte {
+	if m != nil {
+		return m.DstAddrNat
+	}
+	return nil
+}
+
+func (m *FlowMessage) GetVniIngress() uint32 {
+	if m != nil {
+		return m.VniIngress
+	}
+	return 0
+}
+
+func (m *FlowMessage) GetVniEgress() uint32 {
+	if m != nil {
+		return m.VniEgress
+	}
+	return 0
+}
+
+func (m *FlowMessage) GetInIfName() string {
+	if m != nil {
+		return m.InIfName
+	}
+	return ""
+}
+
+func (m *FlowMessage) GetOutIfName() string {
+	if m != nil {
+		return m.OutIfName
+	}
+	return ""
+}
+
+// Counters of an interface sent by an sFlow agent (counter samples)
+type CountersMessage struct {
+	TimeReceived                       uint64   `protobuf:"varint,1,opt,name=TimeReceived,proto3" json:"TimeReceived,omitempty"`
+	SequenceNum                        uint32   `protobuf:"varint,2,opt,name=SequenceNum,proto3" json:"SequenceNum,omitempty"`
+	SampleSequenceNum                  uint32   `protobuf:"varint,3,opt,name=SampleSequenceNum,proto3" json:"SampleSequenceNum,omitempty"`
+	SamplerAddress                     []byte   `protobuf:"bytes,4,opt,name=SamplerAddress,proto3" json:"SamplerAddress,omitempty"`
+	SubAgentId                         uint32   `protobuf:"varint,5,opt,name=SubAgentId,proto3" json:"SubAgentId,omitempty"`
+	IfIndex                            uint32   `protobuf:"varint,6,opt,name=IfIndex,proto3" json:"IfIndex,omitempty"`
+	IfType                             uint32   `protobuf:"varint,7,opt,name=IfType,proto3" json:"IfType,omitempty"`
+	IfSpeed                            uint64   `protobuf:"varint,8,opt,name=IfSpeed,proto3" json:"IfSpeed,omitempty"`
+	IfDirection                        uint32   `protobuf:"varint,9,opt,name=IfDirection,proto3" json:"IfDirection,omitempty"`
+	IfStatus                           uint32   `protobuf:"varint,10,opt,name=IfStatus,proto3" json:"IfStatus,omitempty"`
+	IfInOctets                         uint64   `protobuf:"varint,11,opt,name=IfInOctets,proto3" json:"IfInOctets,omitempty"`
+	IfInUcastPkts                      uint32   `protobuf:"varint,12,opt,name=IfInUcastPkts,proto3" json:"IfInUcastPkts,omitempty"`
+	IfInMulticastPkts                  uint32   `protobuf:"varint,13,opt,name=IfInMulticastPkts,proto3" json:"IfInMulticastPkts,omitempty"`
+	IfInBroadcastPkts                  uint32   `protobuf:"varint,14,opt,name=IfInBroadcastPkts,proto3" json:"IfInBroadcastPkts,omitempty"`
+	IfInDiscards                       uint32   `protobuf:"varint,15,opt,name=IfInDiscards,proto3" json:"IfInDiscards,omitempty"`
+	IfInErrors                         uint32   `protobuf:"varint,16,opt,name=IfInErrors,proto3" json:"IfInErrors,omitempty"`
+	IfInUnknownProtos                  uint32   `protobuf:"varint,17,opt,name=IfInUnknownProtos,proto3" json:"IfInUnknownProtos,omitempty"`
+	IfOutOctets                        uint64   `protobuf:"varint,18,opt,name=IfOutOctets,proto3" json:"IfOutOctets,omitempty"`
+	IfOutUcastPkts                     uint32   `protobuf:"varint,19,opt,name=IfOutUcastPkts,proto3" json:"IfOutUcastPkts,omitempty"`
+	IfOutMulticastPkts                 uint32   `protobuf:"varint,20,opt,name=IfOutMulticastPkts,proto3" json:"IfOutMulticastPkts,omitempty"`
+	IfOutBroadcastPkts                 uint32   `protobuf:"varint,21,opt,name=IfOutBroadcastPkts,proto3" json:"IfOutBroadcastPkts,omitempty"`
+	IfOutDiscards                      uint32   `protobuf:"varint,22,opt,name=IfOutDiscards,proto3" json:"IfOutDiscards,omitempty"`
+	IfOutErrors                        uint32   `protobuf:"varint,23,opt,name=IfOutErrors,proto3" json:"IfOutErrors,omitempty"`
+	IfPromiscuousMode                  uint32   `protobuf:"varint,24,opt,name=IfPromiscuousMode,proto3" json:"IfPromiscuousMode,omitempty"`
+	HasEthernetCounters                bool     `protobuf:"varint,25,opt,name=HasEthernetCounters,proto3" json:"HasEthernetCounters,omitempty"`
+	Dot3StatsAlignmentErrors           uint32   `protobuf:"varint,26,opt,name=Dot3StatsAlignmentErrors,proto3" json:"Dot3StatsAlignmentErrors,omitempty"`
+	Dot3StatsFCSErrors                 uint32   `protobuf:"varint,27,opt,name=Dot3StatsFCSErrors,proto3" json:"Dot3StatsFCSErrors,omitempty"`
+	Dot3StatsSingleCollisionFrames     uint32   `protobuf:"varint,28,opt,name=Dot3StatsSingleCollisionFrames,proto3" json:"Dot3StatsSingleCollisionFrames,omitempty"`
+	Dot3StatsMultipleCollisionFrames   uint32   `protobuf:"varint,29,opt,name=Dot3StatsMultipleCollisionFrames,proto3" json:"Dot3StatsMultipleCollisionFrames,omitempty"`
+	Dot3StatsSQETestErrors             uint32   `protobuf:"varint,30,opt,name=Dot3StatsSQETestErrors,proto3" json:"Dot3StatsSQETestErrors,omitempty"`
+	Dot3StatsDeferredTransmissions     uint32   `protobuf:"varint,31,opt,name=Dot3StatsDeferredTransmissions,proto3" json:"Dot3StatsDeferredTransmissions,omitempty"`
+	Dot3StatsLateCollisions            uint32   `protobuf:"varint,32,opt,name=Dot3StatsLateCollisions,proto3" json:"Dot3StatsLateCollisions,omitempty"`
+	Dot3StatsExcessiveCollisions       uint32   `protobuf:"varint,33,opt,name=Dot3StatsExcessiveCollisions,proto3" json:"Dot3StatsExcessiveCollisions,omitempty"`
+	Dot3StatsInternalMacTransmitErrors uint32   `protobuf:"varint,34,opt,name=Dot3StatsInternalMacTransmitErrors,proto3" json:"Dot3StatsInternalMacTransmitErrors,omitempty"`
+	Dot3StatsCarrierSenseErrors        uint32   `protobuf:"varint,35,opt,name=Dot3StatsCarrierSenseErrors,proto3" json:"Dot3StatsCarrierSenseErrors,omitempty"`
+	Dot3StatsFrameTooLongs             uint32   `protobuf:"varint,36,opt,name=Dot3StatsFrameTooLongs,proto3" json:"Dot3StatsFrameTooLongs,omitempty"`
+	Dot3StatsInternalMacReceiveErrors  uint32   `protobuf:"varint,37,opt,name=Dot3StatsInternalMacReceiveErrors,proto3" json:"Dot3StatsInternalMacReceiveErrors,omitempty"`
+	Dot3StatsSymbolErrors              uint32   `protobuf:"varint,38,opt,name=Dot3StatsSymbolErrors,proto3" json:"Dot3StatsSymbolErrors,omitempty"`
+	XXX_NoUnkeyedLiteral               struct{} `json:"-"`
+	XXX_unrecognized                   []byte   `json:"-"`
+	XXX_sizecache                      int32    `json:"-"`
+}
+
+func (m *CountersMessage) Reset()         { *m = CountersMessage{} }
+func (m *CountersMessage) String() string { return proto.CompactTextString(m) }
+func (*CountersMessage) ProtoMessage()    {}
+func (*CountersMessage) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0beab9b6746e934c, []int{1}
+}
+
+func (m *CountersMessage) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CountersMessage.Unmarshal(m, b)
+}
+func (m *CountersMessage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CountersMessage.Marshal(b, m, deterministic)
+}
+func (m *CountersMessage) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CountersMessage.Merge(m, src)
+}
+func (m *CountersMessage) XXX_Size() int {
+	return xxx_messageInfo_CountersMessage.Size(m)
+}
+func (m *CountersMessage) XXX_DiscardUnknown() {
+	xxx_messageInfo_CountersMessage.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CountersMessage proto.InternalMessageInfo
+
+func (m *CountersMessage) GetTimeReceived() uint64 {
+	if m != nil {
+		return m.TimeReceived
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetSequenceNum() uint32 {
+	if m != nil {
+		return m.SequenceNum
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetSampleSequenceNum() uint32 {
+	if m != nil {
+		return m.SampleSequenceNum
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetSamplerAddress() []byte {
+	if m != nil {
+		return m.SamplerAddress
+	}
+	return nil
+}
+
+func (m *CountersMessage) GetSubAgentId() uint32 {
+	if m != nil {
+		return m.SubAgentId
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfIndex() uint32 {
+	if m != nil {
+		return m.IfIndex
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfType() uint32 {
+	if m != nil {
+		return m.IfType
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfSpeed() uint64 {
+	if m != nil {
+		return m.IfSpeed
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfDirection() uint32 {
+	if m != nil {
+		return m.IfDirection
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfStatus() uint32 {
+	if m != nil {
+		return m.IfStatus
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfInOctets() uint64 {
+	if m != nil {
+		return m.IfInOctets
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfInUcastPkts() uint32 {
+	if m != nil {
+		return m.IfInUcastPkts
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfInMulticastPkts() uint32 {
+	if m != nil {
+		return m.IfInMulticastPkts
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfInBroadcastPkts() uint32 {
+	if m != nil {
+		return m.IfInBroadcastPkts
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfInDiscards() uint32 {
+	if m != nil {
+		return m.IfInDiscards
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfInErrors() uint32 {
+	if m != nil {
+		return m.IfInErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfInUnknownProtos() uint32 {
+	if m != nil {
+		return m.IfInUnknownProtos
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfOutOctets() uint64 {
+	if m != nil {
+		return m.IfOutOctets
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfOutUcastPkts() uint32 {
+	if m != nil {
+		return m.IfOutUcastPkts
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfOutMulticastPkts() uint32 {
+	if m != nil {
+		return m.IfOutMulticastPkts
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfOutBroadcastPkts() uint32 {
+	if m != nil {
+		return m.IfOutBroadcastPkts
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfOutDiscards() uint32 {
+	if m != nil {
+		return m.IfOutDiscards
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfOutErrors() uint32 {
+	if m != nil {
+		return m.IfOutErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetIfPromiscuousMode() uint32 {
+	if m != nil {
+		return m.IfPromiscuousMode
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetHasEthernetCounters() bool {
+	if m != nil {
+		return m.HasEthernetCounters
+	}
+	return false
+}
+
+func (m *CountersMessage) GetDot3StatsAlignmentErrors() uint32 {
+	if m != nil {
+		return m.Dot3StatsAlignmentErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsFCSErrors() uint32 {
+	if m != nil {
+		return m.Dot3StatsFCSErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsSingleCollisionFrames() uint32 {
+	if m != nil {
+		return m.Dot3StatsSingleCollisionFrames
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsMultipleCollisionFrames() uint32 {
+	if m != nil {
+		return m.Dot3StatsMultipleCollisionFrames
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsSQETestErrors() uint32 {
+	if m != nil {
+		return m.Dot3StatsSQETestErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsDeferredTransmissions() uint32 {
+	if m != nil {
+		return m.Dot3StatsDeferredTransmissions
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsLateCollisions() uint32 {
+	if m != nil {
+		return m.Dot3StatsLateCollisions
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsExcessiveCollisions() uint32 {
+	if m != nil {
+		return m.Dot3StatsExcessiveCollisions
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsInternalMacTransmitErrors() uint32 {
+	if m != nil {
+		return m.Dot3StatsInternalMacTransmitErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsCarrierSenseErrors() uint32 {
+	if m != nil {
+		return m.Dot3StatsCarrierSenseErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsFrameTooLongs() uint32 {
+	if m != nil {
+		return m.Dot3StatsFrameTooLongs
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsInternalMacReceiveErrors() uint32 {
+	if m != nil {
+		return m.Dot3StatsInternalMacReceiveErrors
+	}
+	return 0
+}
+
+func (m *CountersMessage) GetDot3StatsSymbolErrors() uint32 {
+	if m != nil {
+		return m.Dot3StatsSymbolErrors
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("flowprotob.FlowMessage_FlowType", FlowMessage_FlowType_name, FlowMessage_FlowType_value)
 	proto.RegisterType((*FlowMessage)(nil), "flowprotob.FlowMessage")
+	proto.RegisterType((*CountersMessage)(nil), "flowprotob.CountersMessage")
 }
 
 func init() { proto.RegisterFile("pb/flow.proto", fileDescriptor_0beab9b6746e934c) }
 
 var fileDescriptor_0beab9b6746e934c = []byte{
-	// 943 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x96, 0x6f, 0x73, 0xda, 0xc6,
-	0x13, 0xc7, 0x7f, 0x24, 0xc4, 0x7f, 0x0e, 0x63, 0x93, 0x4b, 0x7e, 0xee, 0xd6, 0x4d, 0x53, 0xea,
-	0xa6, 0x29, 0x4d, 0x52, 0x92, 0x40, 0x9c, 0x36, 0xfd, 0x1f, 0xdb, 0x30, 0xd6, 0x14, 0x63, 0x0d,
-	0xa2, 0x4e, 0x9f, 0x75, 0x0e, 0xe9, 0x60, 0x98, 0x0a, 0x89, 0x4a, 0x47, 0xdc, 0xbc, 0x85, 0xbe,
-	0xea, 0xce, 0xee, 0x9e, 0x90, 0xe4, 0xe4, 0x11, 0xfa, 0x7e, 0xbe, 0xbb, 0xcb, 0xdd, 0xed, 0x9e,
-	0x40, 0xd4, 0x97, 0x93, 0xa7, 0xd3, 0x30, 0xbe, 0x6a, 0x2f, 0x93, 0xd8, 0xc4, 0x52, 0xe0, 0x33,
-	0x3d, 0x4e, 0x0e, 0xff, 0x6d, 0x88, 0x5a, 0x3f, 0x8c, 0xaf, 0xce, 0x75, 0x9a, 0xaa, 0x99, 0x96,
-	0x2f, 0x44, 0x75, 0xfc, 0x6e, 0xa9, 0xa1, 0xd2, 0xac, 0xb4, 0x76, 0x3b, 0xcd, 0x76, 0x1e, 0xda,
-	0x2e, 0x84, 0xd1, 0x33, 0xc6, 0x8d, 0x28, 0x5a, 0x1e, 0x8a, 0x9d, 0xf1, 0x7c, 0xa1, 0x47, 0xda,
-	0xd7, 0xf3, 0xb7, 0x3a, 0x80, 0x1b, 0xcd, 0x4a, 0xab, 0x3a, 0x2a, 0x31, 0xd9, 0x14, 0x35, 0x4f,
-	0xff, 0xbd, 0xd2, 0x91, 0xaf, 0x87, 0xab, 0x05, 0x54, 0x9b, 0x95, 0x56, 0x7d, 0x54, 0x44, 0x58,
-	0xc5, 0x53, 0x8b, 0x65, 0x38, 0x8f, 0x66, 0x23, 0x65, 0x34, 0xdc, 0xe4, 0x2a, 0x45, 0x26, 0x1f,
-	0x88, 0x3a, 0x7e, 0xf7, 0xe9, 0x3c, 0xd1, 0xbe, 0x99, 0xc7, 0x11, 0x3c, 0xa2, 0x3a, 0x65, 0x28,
-	0x1f, 0x8a, 0x5d, 0xca, 0xd2, 0xc9, 0xeb, 0x20, 0x48, 0x74, 0x9a, 0x42, 0xad, 0x59, 0x69, 0xed,
-	0x8c, 0xae, 0x51, 0xac, 0x86, 0x6b, 0xc4, 0x64, 0xcf, 0xa8, 0xc4, 0xc0, 0x43, 0xfa, 0xca, 0x32,
-	0xc4, 0x95, 0x67, 0xa0, 0x17, 0x05, 0x70, 0x8b, 0x62, 0x8a, 0x48, 0xde, 0x15, 0xb7, 0x8e, 0xdf,
-	0x19, 0x9d, 0xc2, 0x36, 0x79, 0x2c, 0x24, 0x88, 0x4d, 0x57, 0xf9, 0x7f, 0x69, 0x93, 0x82, 0x20,
-	0x9e, 0x49, 0x74, 0xbc, 0xc4, 0xc7, 0x55, 0xc0, 0x06, 0x2d, 0x2c, 0x93, 0xe8, 0x9c, 0xa6, 0x86,
-	0x9c, 0x4d, 0x76, 0xac, 0xc4, 0xef, 0xe8, 0x19, 0x6c, 0xcd, 0x7d, 0xda, 0x31, 0x0b, 0xa4, 0x2e,
-	0xb6, 0x07, 0xee, 0x32, 0x25, 0x61, 0xeb, 0xbb, 0x71, 0x62, 0xe0, 0xff, 0xc4, 0x33, 0x69, 0xeb,
-	0x93, 0xb3, 0xcf, 0x8e, 0x95, 0x52, 0x8a, 0xaa, 0x13, 0x39, 0x53, 0x90, 0x84, 0xe9, 0x19, 0xab,
-	0x5f, 0xac, 0x8c, 0x33, 0x85, 0x3b, 0x5c, 0x9d, 0x84, 0xdc, 0x17, 0x1b, 0x5e, 0xe2, 0x9f, 0x2b,
-	0x1f, 0x3e, 0xa1, 0x6d, 0x59, 0x85, 0xfc, 0x34, 0x35, 0xc8, 0xef, 0x31, 0x67, 0x65, 0x57, 0x73,
-	0x19, 0xaa, 0x08, 0x3e, 0x5f, 0xaf, 0x06, 0xa5, 0x5d, 0x0d, 0x39, 0x87, 0xeb, 0xd5, 0x90, 0xb3,
-	0x2f, 0x36, 0xf0, 0xd3, 0x09, 0xe0, 0x53, 0x32, 0xac, 0xc2, 0x19, 0x71, 0xa2, 0x19, 0x36, 0xef,
-	0x32, 0x99, 0x3a, 0xa7, 0xf0, 0x15, 0xb9, 0x25, 0x86, 0xfd, 0xea, 0x15, 0x42, 0x5a, 0x3c, 0x69,
-	0x05, 0x84, 0xfb, 0x72, 0xdc, 0x71, 0x9c, 0xc2, 0x47, 0xbc, 0x2f, 0x12, 0xf2, 0x91, 0x68, 0xf4,
-	0xe3, 0xe4, 0x4a, 0x25, 0xc1, 0x3c, 0x9a, 0x79, 0x46, 0x99, 0x55, 0x0a, 0x40, 0x01, 0xef, 0x71,
-	0x5b, 0x61, 0x3c, 0x80, 0x8f, 0xd7, 0x15, 0xc6, 0x03, 0x79, 0x20, 0xb6, 0xc6, 0x27, 0x6e, 0x3f,
-	0x54, 0xb3, 0x14, 0x0e, 0xc8, 0x58, 0x6b, 0xf4, 0x1c, 0x7f, 0xb1, 0xa4, 0xdb, 0xf5, 0x19, 0x7b,
-	0x99, 0xce, 0xbc, 0x93, 0x38, 0xd0, 0xd0, 0xcc, 0x3d, 0xd4, 0x38, 0xa3, 0x8e, 0xfb, 0xf6, 0x25,
-	0x8e, 0xda, 0x40, 0x4d, 0x74, 0x08, 0x5f, 0xf2, 0xc4, 0x97, 0xa0, 0xbc, 0x2f, 0x44, 0x3f, 0x51,
-	0xb3, 0x85, 0x8e, 0x8c, 0x13, 0xc0, 0x17, 0x14, 0x52, 0x20, 0x78, 0x23, 0x32, 0x75, 0x31, 0x9d,
-	0xa6, 0xda, 0xc0, 0x03, 0x8a, 0xb9, 0x46, 0x65, 0x4b, 0xec, 0x1d, 0xcf, 0xcb, 0x37, 0xec, 0x6b,
-	0x0a, 0xbc, 0x8e, 0xf1, 0x04, 0x70, 0x68, 0x3d, 0xd8, 0xe5, 0x13, 0x20, 0x81, 0x14, 0x07, 0xd6,
-	0x83, 0x3d, 0xa6, 0x24, 0xb0, 0xcf, 0x43, 0xfd, 0x8f, 0x39, 0x8b, 0x97, 0xb0, 0xc3, 0x53, 0x6d,
-	0xa5, 0xbc, 0x27, 0xb6, 0xed, 0xe3, 0x6b, 0x0f, 0xea, 0x94, 0x93, 0x03, 0x3b, 0x69, 0x43, 0x6d,
-	0xa0, 0xc1, 0x53, 0xc0, 0xca, 0x4e, 0x1a, 0xf2, 0xdb, 0xcc, 0x59, 0xe1, 0x39, 0x9e, 0xa9, 0xb4,
-	0x17, 0xf9, 0x6a, 0x09, 0x8f, 0x9b, 0x95, 0xd6, 0xd6, 0x68, 0xad, 0xe9, 0xed, 0xc2, 0x97, 0x8c,
-	0xfd, 0x27, 0xb4, 0x90, 0x12, 0xc3, 0x18, 0x7b, 0xdd, 0x38, 0xe6, 0x1b, 0x8e, 0x29, 0x32, 0x3c,
-	0x69, 0xba, 0x64, 0x1c, 0xd1, 0xe6, 0x93, 0xce, 0x09, 0xfa, 0x74, 0x35, 0xd9, 0x7f, 0xca, 0x7e,
-	0x4e, 0xd0, 0xa7, 0x71, 0x63, 0xff, 0x19, 0xfb, 0x39, 0xb1, 0xfe, 0x78, 0xc0, 0xfe, 0xf3, 0xb5,
-	0x6f, 0x89, 0x6c, 0x0b, 0x59, 0x6a, 0x3d, 0xc7, 0x75, 0x28, 0xee, 0x03, 0x0e, 0x76, 0x34, 0x9f,
-	0x03, 0x0e, 0xee, 0x72, 0x47, 0xaf, 0x61, 0xf9, 0x4c, 0xdc, 0x29, 0x4f, 0x03, 0x47, 0xbf, 0xa0,
-	0xe8, 0x0f, 0x59, 0xd8, 0xd7, 0x33, 0x95, 0x9e, 0xbb, 0x03, 0x0f, 0x8e, 0xe8, 0xb8, 0x33, 0x89,
-	0x7d, 0xc5, 0xcf, 0x93, 0x78, 0x15, 0x19, 0x78, 0xc9, 0x7d, 0x5d, 0x03, 0xec, 0x13, 0x8a, 0xe7,
-	0x78, 0x81, 0xbe, 0xe5, 0x79, 0xcf, 0x34, 0xee, 0x9f, 0x9e, 0x79, 0xd8, 0xbf, 0xe3, 0xfd, 0xe7,
-	0x24, 0xcb, 0xed, 0x60, 0xee, 0xab, 0x3c, 0xb7, 0x53, 0xc8, 0xed, 0x70, 0xee, 0xf7, 0x79, 0x6e,
-	0xa7, 0x94, 0xdb, 0xc5, 0xdc, 0x1f, 0xf2, 0xdc, 0x6e, 0x21, 0xb7, 0xcb, 0xb9, 0x3f, 0xe6, 0xb9,
-	0x4c, 0xf0, 0xad, 0x82, 0x6a, 0xa0, 0x52, 0x83, 0xe9, 0x3f, 0xf1, 0x5b, 0xa5, 0x80, 0xf0, 0xa6,
-	0x66, 0x92, 0x8b, 0xfc, 0xcc, 0x37, 0xb5, 0x04, 0x71, 0x76, 0xcf, 0x54, 0xea, 0xba, 0x2e, 0xfc,
-	0x42, 0x47, 0x66, 0x95, 0x7c, 0x22, 0x6e, 0xbb, 0xae, 0x6b, 0x7f, 0x99, 0x4e, 0xe2, 0xc8, 0x24,
-	0x71, 0x08, 0xbf, 0x52, 0x85, 0xf7, 0x8d, 0x43, 0x4f, 0x6c, 0x65, 0xbf, 0xc1, 0x72, 0x4f, 0xd4,
-	0xfa, 0x83, 0x8b, 0x37, 0xbf, 0x0f, 0x7f, 0x1b, 0x5e, 0xbc, 0x19, 0x36, 0xfe, 0x27, 0x6b, 0x62,
-	0xd3, 0x43, 0xf2, 0xe7, 0x51, 0xa3, 0x22, 0x77, 0x85, 0x18, 0xf6, 0xc6, 0x24, 0x2f, 0x8f, 0x1a,
-	0x37, 0x4a, 0xfa, 0x55, 0xe3, 0xa6, 0xdc, 0xc6, 0x37, 0x59, 0xdf, 0xf9, 0xa3, 0x51, 0x3d, 0x7e,
-	0x2c, 0x0e, 0xfc, 0x78, 0xd1, 0xf6, 0xc3, 0x78, 0x15, 0x4c, 0x43, 0x95, 0xe8, 0x76, 0xa4, 0x0d,
-	0xfd, 0x05, 0x50, 0xb3, 0xd9, 0x71, 0xbd, 0xf0, 0x07, 0xc0, 0x9d, 0x4c, 0x36, 0xe8, 0x6f, 0x41,
-	0xf7, 0xbf, 0x00, 0x00, 0x00, 0xff, 0xff, 0xaa, 0x12, 0x2f, 0x89, 0x5d, 0x08, 0x00, 0x00,
+	// 1548 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x57, 0xe9, 0x76, 0x13, 0xcb,
+	0x11, 0x8e, 0xc0, 0x78, 0x69, 0xef, 0x0d, 0x98, 0x0e, 0x10, 0x22, 0x1c, 0x42, 0x14, 0x20, 0x06,
+	0x6c, 0x20, 0x40, 0x36, 0x6c, 0x2d, 0xc7, 0x93, 0xc8, 0xf2, 0x44, 0x23, 0x4c, 0xfe, 0xe5, 0x8c,
+	0x47, 0x3d, 0xba, 0x73, 0x18, 0xf5, 0xe8, 0x4e, 0xb7, 0x30, 0x3c, 0xc2, 0x7d, 0xad, 0xfb, 0x64,
+	0xf7, 0x54, 0x55, 0xcf, 0x66, 0xd9, 0xf8, 0xfe, 0xd2, 0x7c, 0xdf, 0x57, 0xd5, 0x4b, 0x55, 0x75,
+	0x77, 0x89, 0xad, 0x4e, 0x4e, 0x9f, 0x87, 0x71, 0x72, 0xb6, 0x33, 0x49, 0x13, 0x93, 0x70, 0x06,
+	0xdf, 0xf8, 0x79, 0xba, 0xfd, 0xf3, 0x26, 0x5b, 0xee, 0xc4, 0xc9, 0xd9, 0x91, 0xd4, 0xda, 0x1f,
+	0x49, 0xfe, 0x8a, 0xcd, 0x0d, 0xbe, 0x4d, 0xa4, 0xa8, 0xd5, 0x6b, 0x8d, 0xb5, 0xdd, 0xfa, 0x4e,
+	0x61, 0xba, 0x53, 0x32, 0xc3, 0x6f, 0xb0, 0xeb, 0xa3, 0x35, 0xdf, 0x66, 0x2b, 0x83, 0x68, 0x2c,
+	0xfb, 0x32, 0x90, 0xd1, 0x17, 0x39, 0x14, 0xd7, 0xea, 0xb5, 0xc6, 0x5c, 0xbf, 0xc2, 0xf1, 0x3a,
+	0x5b, 0xf6, 0xe4, 0x8f, 0x53, 0xa9, 0x02, 0xd9, 0x9b, 0x8e, 0xc5, 0x5c, 0xbd, 0xd6, 0x58, 0xed,
+	0x97, 0x29, 0x18, 0xc5, 0xf3, 0xc7, 0x93, 0x38, 0x52, 0xa3, 0xbe, 0x6f, 0xa4, 0xb8, 0x4e, 0xa3,
+	0x94, 0x39, 0xfe, 0x88, 0xad, 0xc2, 0xdc, 0xad, 0x28, 0x95, 0x81, 0x89, 0x12, 0x25, 0x9e, 0xe0,
+	0x38, 0x55, 0x92, 0x3f, 0x66, 0x6b, 0xe8, 0x25, 0xd3, 0xfd, 0xe1, 0x30, 0x95, 0x5a, 0x8b, 0xe5,
+	0x7a, 0xad, 0xb1, 0xd2, 0x3f, 0xc7, 0xc2, 0x68, 0xb0, 0x46, 0x70, 0xf6, 0x8c, 0x9f, 0x1a, 0xf1,
+	0x18, 0xa7, 0xac, 0x92, 0xb0, 0xf2, 0x8c, 0x68, 0xab, 0xa1, 0xb8, 0x81, 0x36, 0x65, 0x8a, 0xdf,
+	0x62, 0x37, 0x0e, 0xbe, 0x19, 0xa9, 0xc5, 0x12, 0x6a, 0x04, 0xb8, 0x60, 0x0b, 0xae, 0x1f, 0x7c,
+	0x96, 0x46, 0x0b, 0x86, 0x7c, 0x06, 0x41, 0xf1, 0xd2, 0x00, 0x56, 0x21, 0xe6, 0x71, 0x61, 0x19,
+	0x04, 0xa5, 0xa5, 0x0d, 0x2a, 0x0b, 0xa4, 0x58, 0x08, 0x73, 0xb4, 0x0d, 0xa4, 0xe6, 0x01, 0xee,
+	0x98, 0x00, 0xb0, 0x2e, 0xa4, 0x47, 0xdc, 0x22, 0x16, 0x81, 0x1d, 0xdf, 0x4d, 0x52, 0x23, 0x6e,
+	0x23, 0x9f, 0x41, 0x3b, 0x3e, 0x2a, 0x5b, 0xa4, 0x58, 0xc8, 0x39, 0x9b, 0x73, 0x94, 0x13, 0x0a,
+	0x8e, 0x34, 0x7e, 0xc3, 0xe8, 0xc7, 0x53, 0xe3, 0x84, 0xe2, 0x26, 0x8d, 0x8e, 0x80, 0x6f, 0xb1,
+	0x79, 0x2f, 0x0d, 0x8e, 0xfc, 0x40, 0xdc, 0xc3, 0x6d, 0x59, 0x04, 0x7c, 0x4b, 0x1b, 0xe0, 0xef,
+	0x13, 0x4f, 0xc8, 0xae, 0xe6, 0x24, 0xf6, 0x95, 0x78, 0x98, 0xaf, 0x06, 0xa0, 0x5d, 0x0d, 0x2a,
+	0xdb, 0xf9, 0x6a, 0x50, 0xd9, 0x62, 0xf3, 0xf0, 0xeb, 0x0c, 0xc5, 0xef, 0x50, 0xb0, 0x08, 0x6a,
+	0xc4, 0x51, 0x23, 0x48, 0xde, 0x49, 0x1a, 0x3a, 0x2d, 0xf1, 0x27, 0x54, 0x2b, 0x1c, 0xe4, 0xab,
+	0x5d, 0x32, 0x69, 0x50, 0xa5, 0x95, 0x28, 0xd8, 0x97, 0xe3, 0x0e, 0x12, 0x2d, 0xee, 0xd0, 0xbe,
+	0x10, 0xf0, 0x27, 0x6c, 0xa3, 0x93, 0xa4, 0x67, 0x7e, 0x3a, 0x8c, 0xd4, 0xc8, 0x33, 0xbe, 0x99,
+	0x6a, 0x21, 0xd0, 0x60, 0x86, 0xb7, 0x23, 0x0c, 0xba, 0xe2, 0xb7, 0xf9, 0x08, 0x83, 0x2e, 0xbf,
+	0xcb, 0x16, 0x07, 0x4d, 0xb7, 0x13, 0xfb, 0x23, 0x2d, 0xee, 0xa2, 0x90, 0x63, 0xd0, 0x9c, 0x60,
+	0x3c, 0xc1, 0xd3, 0xf5, 0x7b, 0xd2, 0x32, 0x9c, 0x69, 0xcd, 0x64, 0x28, 0x45, 0xbd, 0xd0, 0x00,
+	0x43, 0x8d, 0x3a, 0xee, 0x97, 0x37, 0x50, 0x6a, 0x5d, 0xff, 0x54, 0xc6, 0xe2, 0x8f, 0x54, 0xf1,
+	0x15, 0x92, 0x3f, 0x60, 0xac, 0x93, 0xfa, 0xa3, 0xb1, 0x54, 0xc6, 0x19, 0x8a, 0x3f, 0xa0, 0x49,
+	0x89, 0x81, 0x13, 0x91, 0xa1, 0xe3, 0x30, 0xd4, 0xd2, 0x88, 0x47, 0x68, 0x73, 0x8e, 0xe5, 0x0d,
+	0xb6, 0x7e, 0x10, 0x55, 0x4f, 0xd8, 0x9f, 0xd1, 0xf0, 0x3c, 0x0d, 0x11, 0x80, 0xa2, 0xf5, 0xc4,
+	0x1a, 0x45, 0x00, 0x01, 0xb0, 0x50, 0xb0, 0x9e, 0x58, 0x27, 0x16, 0x01, 0xe4, 0xb9, 0x27, 0xbf,
+	0x9a, 0xc3, 0x64, 0x22, 0x56, 0xa8, 0xaa, 0x2d, 0xe4, 0xf7, 0xd9, 0x92, 0xfd, 0xdc, 0xf7, 0xc4,
+	0x2a, 0xfa, 0x14, 0x84, 0xad, 0xb4, 0x9e, 0x34, 0x62, 0x83, 0xaa, 0x80, 0x90, 0xad, 0x34, 0xe0,
+	0x37, 0x89, 0x27, 0x04, 0x71, 0x3c, 0xf4, 0x75, 0x5b, 0x05, 0xfe, 0x44, 0x3c, 0xad, 0xd7, 0x1a,
+	0x8b, 0xfd, 0x1c, 0xe3, 0xed, 0x42, 0x87, 0x8c, 0xf4, 0x67, 0xb8, 0x90, 0x0a, 0x07, 0x36, 0xf6,
+	0xb8, 0x91, 0xcd, 0x5f, 0xc8, 0xa6, 0xcc, 0x41, 0xa4, 0xf1, 0x90, 0x91, 0xc5, 0x0e, 0x45, 0xba,
+	0x60, 0x40, 0xc7, 0xa3, 0x49, 0xfa, 0x73, 0xd2, 0x0b, 0x06, 0x74, 0x2c, 0x37, 0xd2, 0x5f, 0x90,
+	0x5e, 0x30, 0x56, 0x1f, 0x74, 0x49, 0x7f, 0x99, 0xeb, 0x96, 0xe1, 0x3b, 0x8c, 0x57, 0x52, 0x4f,
+	0x76, 0xbb, 0x68, 0x77, 0x81, 0x02, 0x19, 0x2d, 0xea, 0x80, 0x8c, 0xf7, 0x28, 0xa3, 0xe7, 0x68,
+	0xfe, 0x82, 0xdd, 0xac, 0x56, 0x03, 0x59, 0xbf, 0x42, 0xeb, 0x8b, 0x24, 0xc8, 0xeb, 0xa1, 0xaf,
+	0x8f, 0xdc, 0xae, 0x27, 0x5e, 0x63, 0xb8, 0x33, 0x08, 0x79, 0x85, 0xdf, 0x66, 0x32, 0x55, 0x46,
+	0xbc, 0xa1, 0xbc, 0xe6, 0x04, 0xe4, 0x09, 0xc0, 0x4b, 0x38, 0x40, 0x7f, 0xa5, 0x7a, 0xcf, 0x30,
+	0xec, 0x1f, 0xbf, 0xa9, 0xd8, 0xdf, 0xd2, 0xfe, 0x0b, 0x26, 0xf3, 0xdd, 0x05, 0xdf, 0x77, 0x85,
+	0xef, 0x6e, 0xc9, 0x77, 0x97, 0x7c, 0xdf, 0x17, 0xbe, 0xbb, 0x15, 0xdf, 0x3d, 0xf0, 0xfd, 0x5b,
+	0xe1, 0xbb, 0x57, 0xf2, 0xdd, 0x23, 0xdf, 0xbf, 0x17, 0xbe, 0xc4, 0xc0, 0xad, 0x02, 0xa8, 0xeb,
+	0x6b, 0x03, 0xee, 0xff, 0xa0, 0x5b, 0xa5, 0x44, 0xc1, 0x49, 0xcd, 0x20, 0x0d, 0xf2, 0x4f, 0x3a,
+	0xa9, 0x15, 0x12, 0x6a, 0xf7, 0xd0, 0xd7, 0xae, 0xeb, 0x8a, 0x7f, 0x61, 0xc8, 0x2c, 0xe2, 0xcf,
+	0xd8, 0xa6, 0xeb, 0xba, 0xf6, 0x65, 0x6a, 0x26, 0xca, 0xa4, 0x49, 0x2c, 0x3e, 0xe0, 0x08, 0xb3,
+	0x02, 0xac, 0xd6, 0x56, 0x6e, 0xcf, 0x37, 0x62, 0x1f, 0xeb, 0xb4, 0xc4, 0x80, 0x6e, 0xab, 0x16,
+	0xf4, 0x03, 0xd2, 0x0b, 0x06, 0xf4, 0x13, 0x15, 0xd9, 0x6b, 0x53, 0x34, 0x69, 0xb7, 0x05, 0x03,
+	0xf9, 0x3b, 0x51, 0x11, 0xdd, 0x99, 0xa2, 0x45, 0xf9, 0xcb, 0x09, 0xbc, 0xaf, 0x94, 0x13, 0xf6,
+	0xfc, 0xb1, 0x14, 0xed, 0x7a, 0xad, 0xb1, 0xd4, 0xcf, 0x31, 0x78, 0xe2, 0x33, 0x81, 0x62, 0x07,
+	0xc5, 0x82, 0xd8, 0xf6, 0xd8, 0x62, 0xd6, 0x3b, 0xf0, 0x75, 0xb6, 0xdc, 0xe9, 0x1e, 0x7f, 0xfa,
+	0xd8, 0xfb, 0x4f, 0xef, 0xf8, 0x53, 0x6f, 0xe3, 0x37, 0x7c, 0x99, 0x2d, 0x78, 0xc0, 0xfc, 0xff,
+	0xf5, 0x46, 0x8d, 0xaf, 0x31, 0xd6, 0x6b, 0x0f, 0x10, 0x9e, 0xbc, 0xde, 0xb8, 0x56, 0xc1, 0xef,
+	0x36, 0xae, 0xf3, 0x25, 0xb8, 0x81, 0x3b, 0xce, 0xff, 0x36, 0xe6, 0xb6, 0x7f, 0x5a, 0x63, 0xeb,
+	0x58, 0x58, 0x32, 0xd5, 0x59, 0x23, 0x73, 0xbe, 0x25, 0xa9, 0x5d, 0xdd, 0x92, 0x5c, 0x9b, 0x6d,
+	0x49, 0x9e, 0xb1, 0x4d, 0x6a, 0x19, 0xca, 0x76, 0xd7, 0x29, 0x29, 0x33, 0xc2, 0x05, 0x6d, 0xc7,
+	0xdc, 0x85, 0x6d, 0x07, 0x24, 0x6f, 0x7a, 0xba, 0x3f, 0xa2, 0xcb, 0xfa, 0x06, 0x05, 0xbf, 0x60,
+	0xe0, 0x58, 0x39, 0xa1, 0xa3, 0x86, 0xf2, 0x2b, 0xb6, 0x07, 0xab, 0xfd, 0x0c, 0x42, 0xf1, 0x38,
+	0x21, 0x3e, 0x21, 0x0b, 0x74, 0xf1, 0x11, 0x22, 0x0f, 0x6f, 0x22, 0xe5, 0x50, 0x2c, 0x52, 0xab,
+	0x61, 0x21, 0xec, 0xd1, 0x09, 0x8b, 0xcb, 0x7c, 0x89, 0xf6, 0x58, 0xa2, 0x30, 0x99, 0xa1, 0x7d,
+	0xee, 0x98, 0x7d, 0x7c, 0x2c, 0xc6, 0xcb, 0x28, 0x74, 0xd4, 0x71, 0x60, 0xa4, 0xa1, 0x26, 0x6a,
+	0xae, 0x5f, 0x62, 0xf0, 0x71, 0x0a, 0x1d, 0xf5, 0x31, 0xf0, 0xb5, 0x71, 0x3f, 0x1b, 0x2d, 0x56,
+	0xec, 0xe3, 0x54, 0x26, 0x21, 0x8a, 0x40, 0x1c, 0x4d, 0x63, 0x13, 0xe5, 0x96, 0x74, 0xd9, 0xcf,
+	0x0a, 0x99, 0xf5, 0x41, 0x9a, 0xf8, 0xc3, 0xdc, 0x7a, 0xad, 0xb0, 0xae, 0x08, 0xd8, 0x10, 0x84,
+	0x8e, 0x6a, 0x45, 0x3a, 0xf0, 0xd3, 0xa1, 0xb6, 0xef, 0x4e, 0x85, 0xcb, 0x76, 0xd1, 0x4e, 0xd3,
+	0x24, 0xd5, 0xf6, 0x29, 0x29, 0x31, 0xd9, 0x8c, 0x1f, 0xd5, 0x67, 0x95, 0x9c, 0x29, 0xbc, 0xcb,
+	0xb5, 0x7d, 0x59, 0x66, 0x05, 0x8a, 0xe8, 0xf1, 0xd4, 0xd8, 0xa0, 0x70, 0x6a, 0x07, 0x4b, 0x14,
+	0xd4, 0x01, 0xc2, 0x22, 0x2c, 0xd4, 0x3f, 0x9d, 0x63, 0xf1, 0x2a, 0x07, 0xa6, 0x1a, 0x98, 0x5b,
+	0xf6, 0x2a, 0x9f, 0x51, 0x72, 0xfb, 0x6a, 0x68, 0x6e, 0x97, 0xec, 0xab, 0xb1, 0xc1, 0xec, 0x1c,
+	0x4f, 0x4d, 0x1e, 0x9c, 0xad, 0x2c, 0x3b, 0x25, 0x32, 0xdf, 0x8f, 0x0d, 0xcf, 0x9d, 0xac, 0x42,
+	0x72, 0x8a, 0xe2, 0xe3, 0xa6, 0xc9, 0x38, 0xd2, 0xc1, 0x34, 0x99, 0xea, 0x23, 0xe8, 0x53, 0x44,
+	0x16, 0x9f, 0x73, 0x02, 0x3c, 0x23, 0xf0, 0xe8, 0x9a, 0x1f, 0x64, 0xaa, 0xa4, 0xc9, 0xce, 0x25,
+	0x36, 0x4a, 0x8b, 0xfd, 0x8b, 0x24, 0xfe, 0x9e, 0x89, 0x56, 0x62, 0xf6, 0xa0, 0xe6, 0xf4, 0x7e,
+	0x1c, 0x8d, 0x14, 0xbc, 0x33, 0x76, 0x39, 0xd4, 0x46, 0x5d, 0xaa, 0x43, 0x4c, 0x72, 0xad, 0xd3,
+	0xf4, 0xac, 0xd7, 0x3d, 0x8a, 0xc9, 0xac, 0xc2, 0x3b, 0xec, 0x41, 0xce, 0x7a, 0x91, 0x1a, 0xc5,
+	0xb2, 0x99, 0xc4, 0x71, 0xa4, 0xa3, 0x44, 0x75, 0x52, 0x7f, 0x2c, 0x35, 0x36, 0xaf, 0xab, 0xfd,
+	0x2b, 0xac, 0xf8, 0xbf, 0x59, 0x3d, 0xb7, 0xc0, 0x2c, 0x4d, 0x66, 0x47, 0xa2, 0xd6, 0xf5, 0x4a,
+	0x3b, 0xfe, 0x86, 0x6d, 0x15, 0xb3, 0xfd, 0xb7, 0x3d, 0x90, 0x3a, 0xdb, 0x3d, 0xf5, 0xfa, 0x97,
+	0xa8, 0x95, 0xbd, 0xb4, 0x64, 0x28, 0xd3, 0x54, 0x0e, 0x07, 0xa9, 0xaf, 0xf4, 0x38, 0xd2, 0x30,
+	0xbc, 0xb6, 0x8d, 0xe6, 0x15, 0x56, 0xfc, 0x2d, 0xbb, 0x93, 0x5b, 0x74, 0x7d, 0x53, 0xac, 0x4f,
+	0xdb, 0x6e, 0xf4, 0x32, 0x99, 0x1f, 0xb0, 0xfb, 0xb9, 0xd4, 0xfe, 0x1a, 0x48, 0xad, 0xa3, 0x2f,
+	0x65, 0x77, 0xea, 0xf7, 0xbf, 0x6b, 0xc3, 0x7b, 0x6c, 0x3b, 0xd7, 0x1d, 0x28, 0x08, 0xe5, 0xc7,
+	0x47, 0x7e, 0x60, 0x97, 0x98, 0x45, 0x82, 0xfe, 0x1f, 0xfc, 0x0a, 0x4b, 0xfe, 0x81, 0xdd, 0xcb,
+	0xad, 0x9a, 0x7e, 0x9a, 0x46, 0x32, 0xf5, 0xa4, 0xd2, 0xd2, 0x0e, 0x44, 0xbd, 0xf1, 0xf7, 0x4c,
+	0x2a, 0xf9, 0xc0, 0x14, 0x0d, 0x92, 0xa4, 0x9b, 0xa8, 0x91, 0xb6, 0x4d, 0xf3, 0x25, 0x2a, 0xef,
+	0xb2, 0x87, 0x17, 0xad, 0xcf, 0xbe, 0x37, 0x76, 0x7e, 0x6a, 0xdf, 0xaf, 0x36, 0xe4, 0xaf, 0xd8,
+	0xed, 0x22, 0xef, 0xdf, 0xc6, 0xa7, 0x49, 0x6c, 0x47, 0x78, 0x8c, 0x23, 0x5c, 0x2c, 0x1e, 0x3c,
+	0x65, 0x77, 0x83, 0x64, 0xbc, 0x13, 0xc4, 0xc9, 0x74, 0x18, 0xc6, 0x7e, 0x2a, 0x77, 0x94, 0x34,
+	0xf8, 0x37, 0xde, 0x1f, 0x8d, 0x0e, 0x56, 0x4b, 0x7f, 0xe2, 0xdd, 0xd3, 0xd3, 0x79, 0xfc, 0x6b,
+	0xbf, 0xf7, 0xcb, 0x00, 0x97, 0xa5, 0x39, 0x07, 0x21, 0x10, 0x00, 0x00,
 }
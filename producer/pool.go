@@ -0,0 +1,31 @@
+package producer
+
+import (
+	"sync"
+
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+)
+
+var flowMessagePool = sync.Pool{
+	New: func() interface{} {
+		return &flowmessage.FlowMessage{}
+	},
+}
+
+// NewFlowMessage returns an empty flow message, recycled from the messages
+// given to ReleaseFlowMessages when possible.
+func NewFlowMessage() *flowmessage.FlowMessage {
+	return flowMessagePool.Get().(*flowmessage.FlowMessage)
+}
+
+// ReleaseFlowMessages resets the messages so that they are reused by the
+// following conversions. They must not be used afterwards.
+func ReleaseFlowMessages(msgs []*flowmessage.FlowMessage) {
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		msg.Reset()
+		flowMessagePool.Put(msg)
+	}
+}
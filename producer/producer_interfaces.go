@@ -0,0 +1,142 @@
+package producer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+)
+
+// InterfaceName is the name and description of an interface sent by a router
+// in option data (interfaceName and interfaceDescription, IF_NAME and IF_DESC
+// in NetFlow v9).
+type InterfaceName struct {
+	Name        string
+	Description string
+}
+
+// InterfaceTable keeps the interface names of a router by ifIndex.
+type InterfaceTable struct {
+	interfaces map[uint32]InterfaceName
+	updated    map[uint32]time.Time
+	lock       *sync.RWMutex
+}
+
+func CreateInterfaceTable() *InterfaceTable {
+	return &InterfaceTable{
+		interfaces: make(map[uint32]InterfaceName),
+		updated:    make(map[uint32]time.Time),
+		lock:       &sync.RWMutex{},
+	}
+}
+
+// AddInterface records the name of an interface. Empty values keep the
+// previous ones since some routers send names and descriptions separately.
+func (t *InterfaceTable) AddInterface(index uint32, ifName InterfaceName) {
+	t.lock.Lock()
+	previous := t.interfaces[index]
+	if ifName.Name == "" {
+		ifName.Name = previous.Name
+	}
+	if ifName.Description == "" {
+		ifName.Description = previous.Description
+	}
+	t.interfaces[index] = ifName
+	t.updated[index] = time.Now()
+	t.lock.Unlock()
+}
+
+// RemoveExpiredInterfaces deletes the interfaces not received for ttl.
+func (t *InterfaceTable) RemoveExpiredInterfaces(ttl time.Duration) {
+	t.lock.Lock()
+	now := time.Now()
+	for index, updated := range t.updated {
+		if now.Sub(updated) >= ttl {
+			delete(t.interfaces, index)
+			delete(t.updated, index)
+		}
+	}
+	t.lock.Unlock()
+}
+
+func (t *InterfaceTable) GetInterface(index uint32) (InterfaceName, bool) {
+	t.lock.RLock()
+	ifName, ok := t.interfaces[index]
+	t.lock.RUnlock()
+	return ifName, ok
+}
+
+// GetInterfaces returns a copy of the table.
+func (t *InterfaceTable) GetInterfaces() map[uint32]InterfaceName {
+	t.lock.RLock()
+	interfaces := make(map[uint32]InterfaceName, len(t.interfaces))
+	for index, ifName := range t.interfaces {
+		interfaces[index] = ifName
+	}
+	t.lock.RUnlock()
+	return interfaces
+}
+
+// SetInterfaceNames sets the InIfName and OutIfName of the flows from the table.
+func (t *InterfaceTable) SetInterfaceNames(flowMessageSet []*flowmessage.FlowMessage) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if len(t.interfaces) == 0 {
+		return
+	}
+	for _, fmsg := range flowMessageSet {
+		fmsg.InIfName = t.interfaces[fmsg.InIf].Name
+		fmsg.OutIfName = t.interfaces[fmsg.OutIf].Name
+	}
+}
+
+func netFlowLookForString(dataFields []netflow.DataField, typeId uint16) string {
+	for _, field := range dataFields {
+		if field.PenProvided || field.Type != typeId {
+			continue
+		}
+		value, _ := field.Value.([]byte)
+		// fixed length values are padded with zeros
+		return strings.TrimRight(string(value), "\x00")
+	}
+	return ""
+}
+
+// SearchNetFlowOptionInterfaces returns the interfaces named by option data
+// records, by ifIndex.
+func SearchNetFlowOptionInterfaces(version uint16, dataFlowSet []netflow.OptionsDataFlowSet) map[uint32]InterfaceName {
+	interfaces := make(map[uint32]InterfaceName)
+	for _, dataFlowSetItem := range dataFlowSet {
+		for _, record := range dataFlowSetItem.Records {
+			ifName := InterfaceName{
+				Name:        netFlowLookForString(record.OptionsValues, netflow.IPFIX_FIELD_interfaceName),
+				Description: netFlowLookForString(record.OptionsValues, netflow.IPFIX_FIELD_interfaceDescription),
+			}
+			if ifName.Name == "" && ifName.Description == "" {
+				continue
+			}
+			var index uint32
+			found := version == 9 && netFlowLookForUNumber(record.ScopesValues, nfv9ScopeInterface, &index)
+			if !found {
+				found = netFlowLookForUNumber(record.ScopesValues, netflow.IPFIX_FIELD_ingressInterface, &index) ||
+					netFlowLookForUNumber(record.OptionsValues, netflow.IPFIX_FIELD_ingressInterface, &index)
+			}
+			if found {
+				interfaces[index] = ifName
+			}
+		}
+	}
+	return interfaces
+}
+
+// ProcessNetFlowInterfaces adds the interfaces named in the option data sets
+// of a NetFlow v9 or IPFIX packet to table then sets the interface names of
+// the flows converted from the packet.
+func ProcessNetFlowInterfaces(version uint16, optionDataFlowSet []netflow.OptionsDataFlowSet, table *InterfaceTable, flowMessageSet []*flowmessage.FlowMessage) {
+	for index, ifName := range SearchNetFlowOptionInterfaces(version, optionDataFlowSet) {
+		table.AddInterface(index, ifName)
+	}
+	table.SetInterfaceNames(flowMessageSet)
+}
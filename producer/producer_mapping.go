@@ -0,0 +1,292 @@
+package producer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+	proto "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+)
+
+// Decodings of a mapped NetFlow/IPFIX value.
+const (
+	MAPPING_DECODING_UNSIGNED        = "unsigned"
+	MAPPING_DECODING_IP              = "ip"
+	MAPPING_DECODING_MAC             = "mac"
+	MAPPING_DECODING_BYTES           = "bytes"
+	MAPPING_DECODING_TS_SECONDS      = "ts_seconds"
+	MAPPING_DECODING_TS_MILLISECONDS = "ts_milliseconds"
+	MAPPING_DECODING_TS_MICROSECONDS = "ts_microseconds"
+	MAPPING_DECODING_TS_NANOSECONDS  = "ts_nanoseconds"
+	MAPPING_DECODING_TS_UPTIME       = "ts_uptime" // NetFlow v9 milliseconds since router boot
+)
+
+// NetFlowMapField copies the value of a NetFlow/IPFIX field into the
+// FlowMessage field numbered Destination in pb/flow.proto. Numbers not
+// defined in the FlowMessage (eg: custom fields >= 1000) are serialized as-is.
+type NetFlowMapField struct {
+	Pen         uint32 `yaml:"pen" json:"pen"`
+	Type        uint16 `yaml:"field" json:"field"`
+	Destination int32  `yaml:"destination" json:"destination"`
+	Decoding    string `yaml:"decoding" json:"decoding"`
+}
+
+type NetFlowProtocolMapping struct {
+	Mapping []NetFlowMapField `yaml:"mapping" json:"mapping"`
+}
+
+// NetFlowMapping is the content of a mapping file:
+//
+//	ipfix:
+//	  mapping:
+//	    - field: 225
+//	      destination: 1000
+//	      decoding: ip
+//	netflowv9:
+//	  mapping:
+//	    - field: 95
+//	      destination: 1001
+type NetFlowMapping struct {
+	IPFIX     NetFlowProtocolMapping `yaml:"ipfix" json:"ipfix"`
+	NetFlowV9 NetFlowProtocolMapping `yaml:"netflowv9" json:"netflowv9"`
+}
+
+// LoadNetFlowMapping parses a YAML or JSON mapping.
+func LoadNetFlowMapping(r io.Reader) (*NetFlowMapping, error) {
+	mapping := &NetFlowMapping{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	err := dec.Decode(mapping)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+type mappingKey struct {
+	pen    uint32
+	typeId uint16
+}
+
+type NetFlowProducerConfig struct {
+	ipfix     map[mappingKey][]NetFlowMapField
+	netflowv9 map[mappingKey][]NetFlowMapField
+
+	// called with the values which could not be mapped (eg: a number longer than 8 bytes)
+	MappingErrorCallback func(field NetFlowMapField, err error)
+}
+
+func isTimestampDecoding(decoding string) bool {
+	switch decoding {
+	case MAPPING_DECODING_TS_SECONDS, MAPPING_DECODING_TS_MILLISECONDS, MAPPING_DECODING_TS_MICROSECONDS,
+		MAPPING_DECODING_TS_NANOSECONDS, MAPPING_DECODING_TS_UPTIME:
+		return true
+	}
+	return false
+}
+
+// checkMappingDestination verifies that a FlowMessage field can hold the
+// values of a decoding. Custom destinations accept any decoding.
+func checkMappingDestination(field NetFlowMapField) error {
+	fd := proto.MessageReflect(&flowmessage.FlowMessage{}).Descriptor().Fields().ByNumber(protoreflect.FieldNumber(field.Destination))
+	if fd == nil {
+		return nil
+	}
+	if fd.Cardinality() == protoreflect.Repeated {
+		return fmt.Errorf("destination %v: repeated fields are not supported", fd.Name())
+	}
+	switch fd.Kind() {
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if field.Decoding == MAPPING_DECODING_IP {
+			return fmt.Errorf("destination %v: %v decoding needs a bytes field", fd.Name(), field.Decoding)
+		}
+	case protoreflect.BoolKind:
+		if field.Decoding != MAPPING_DECODING_UNSIGNED && field.Decoding != MAPPING_DECODING_BYTES {
+			return fmt.Errorf("destination %v: %v decoding into a bool field", fd.Name(), field.Decoding)
+		}
+	case protoreflect.BytesKind:
+		if isTimestampDecoding(field.Decoding) {
+			return fmt.Errorf("destination %v: %v decoding needs a number field", fd.Name(), field.Decoding)
+		}
+	default:
+		return fmt.Errorf("destination %v: unsupported kind %v", fd.Name(), fd.Kind())
+	}
+	return nil
+}
+
+func compileNetFlowMapping(fields []NetFlowMapField, version uint16) (map[mappingKey][]NetFlowMapField, error) {
+	compiled := make(map[mappingKey][]NetFlowMapField)
+	for _, field := range fields {
+		if field.Decoding == "" {
+			field.Decoding = MAPPING_DECODING_UNSIGNED
+		}
+		switch field.Decoding {
+		case MAPPING_DECODING_UNSIGNED, MAPPING_DECODING_IP, MAPPING_DECODING_MAC, MAPPING_DECODING_BYTES,
+			MAPPING_DECODING_TS_SECONDS, MAPPING_DECODING_TS_MILLISECONDS, MAPPING_DECODING_TS_MICROSECONDS,
+			MAPPING_DECODING_TS_NANOSECONDS, MAPPING_DECODING_TS_UPTIME:
+		default:
+			return nil, fmt.Errorf("field %v: unknown decoding %v", field.Type, field.Decoding)
+		}
+		if field.Destination <= 0 || field.Destination > int32(protowire.MaxValidNumber) {
+			return nil, fmt.Errorf("field %v: invalid destination %v", field.Type, field.Destination)
+		}
+		if version == 9 && field.Pen != 0 {
+			return nil, fmt.Errorf("field %v: NetFlow v9 has no enterprise fields", field.Type)
+		}
+		if err := checkMappingDestination(field); err != nil {
+			return nil, fmt.Errorf("field %v: %v", field.Type, err)
+		}
+		key := mappingKey{field.Pen, field.Type}
+		compiled[key] = append(compiled[key], field)
+	}
+	return compiled, nil
+}
+
+// CreateNetFlowProducerConfig validates a mapping and indexes it for
+// ProcessMessageNetFlowConfig.
+func CreateNetFlowProducerConfig(mapping *NetFlowMapping) (*NetFlowProducerConfig, error) {
+	config := &NetFlowProducerConfig{}
+	if mapping == nil {
+		return config, nil
+	}
+	var err error
+	config.ipfix, err = compileNetFlowMapping(mapping.IPFIX.Mapping, 10)
+	if err != nil {
+		return nil, fmt.Errorf("ipfix mapping: %v", err)
+	}
+	config.netflowv9, err = compileNetFlowMapping(mapping.NetFlowV9.Mapping, 9)
+	if err != nil {
+		return nil, fmt.Errorf("netflowv9 mapping: %v", err)
+	}
+	return config, nil
+}
+
+func (c *NetFlowProducerConfig) getMapping(version uint16, df netflow.DataField) []NetFlowMapField {
+	if c == nil {
+		return nil
+	}
+	var pen uint32
+	if df.PenProvided {
+		pen = df.Pen
+	}
+	if version == 9 {
+		return c.netflowv9[mappingKey{0, df.Type}]
+	} else if version == 10 {
+		return c.ipfix[mappingKey{pen, df.Type}]
+	}
+	return nil
+}
+
+func decodeTimestamp(decoding string, v []byte, baseTime uint32, uptime uint32) (uint64, error) {
+	var t uint64
+	err := DecodeUNumber(v, &t)
+	if err != nil {
+		return 0, err
+	}
+	switch decoding {
+	case MAPPING_DECODING_TS_MILLISECONDS:
+		t /= 1000
+	case MAPPING_DECODING_TS_MICROSECONDS:
+		t /= 1000000
+	case MAPPING_DECODING_TS_NANOSECONDS:
+		t /= 1000000000
+	case MAPPING_DECODING_TS_UPTIME:
+		timeDiff := (uptime - uint32(t)) / 1000
+		t = uint64(baseTime - timeDiff)
+	}
+	return t, nil
+}
+
+// MapNetFlowField writes the value v of a field into flowMessage following
+// the mapping entry field.
+func MapNetFlowField(flowMessage *flowmessage.FlowMessage, field NetFlowMapField, v []byte, baseTime uint32, uptime uint32) error {
+	var number uint64
+	isNumber := true
+	switch field.Decoding {
+	case MAPPING_DECODING_IP:
+		if len(v) != net.IPv4len && len(v) != net.IPv6len {
+			return fmt.Errorf("destination %v: %v bytes for an IP address", field.Destination, len(v))
+		}
+		isNumber = false
+	case MAPPING_DECODING_BYTES:
+		isNumber = false
+	case MAPPING_DECODING_MAC:
+		if len(v) != 6 {
+			return fmt.Errorf("destination %v: %v bytes for a MAC address", field.Destination, len(v))
+		}
+		DecodeUNumber(v, &number)
+	case MAPPING_DECODING_UNSIGNED, "":
+		if err := DecodeUNumber(v, &number); err != nil {
+			return err
+		}
+	default:
+		var err error
+		number, err = decodeTimestamp(field.Decoding, v, baseTime, uptime)
+		if err != nil {
+			return err
+		}
+	}
+
+	msg := proto.MessageReflect(flowMessage)
+	fd := msg.Descriptor().Fields().ByNumber(protoreflect.FieldNumber(field.Destination))
+	if fd == nil {
+		num := protowire.Number(field.Destination)
+		var raw []byte
+		if isNumber {
+			raw = protowire.AppendTag(raw, num, protowire.VarintType)
+			raw = protowire.AppendVarint(raw, number)
+		} else {
+			raw = protowire.AppendTag(raw, num, protowire.BytesType)
+			raw = protowire.AppendBytes(raw, v)
+		}
+		msg.SetUnknown(append(msg.GetUnknown(), raw...))
+		return nil
+	}
+
+	switch fd.Kind() {
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if !isNumber {
+			number = decodeBytesNumber(v)
+		}
+		if number > math.MaxUint32 {
+			return fmt.Errorf("destination %v: %v overflows 32 bits", field.Destination, number)
+		}
+		msg.Set(fd, protoreflect.ValueOfUint32(uint32(number)))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if !isNumber {
+			number = decodeBytesNumber(v)
+		}
+		msg.Set(fd, protoreflect.ValueOfUint64(number))
+	case protoreflect.BoolKind:
+		msg.Set(fd, protoreflect.ValueOfBool(number != 0 || (!isNumber && len(v) > 0)))
+	case protoreflect.BytesKind:
+		if isNumber && field.Decoding != MAPPING_DECODING_MAC {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, number)
+			msg.Set(fd, protoreflect.ValueOfBytes(b))
+		} else {
+			msg.Set(fd, protoreflect.ValueOfBytes(v))
+		}
+	default:
+		return fmt.Errorf("destination %v: unsupported kind %v", field.Destination, fd.Kind())
+	}
+	return nil
+}
+
+func decodeBytesNumber(v []byte) uint64 {
+	var o uint64
+	if len(v) > 8 {
+		v = v[len(v)-8:]
+	}
+	for _, b := range v {
+		o = o<<8 | uint64(b)
+	}
+	return o
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudflare/goflow/v3/decoders/netflow"
@@ -18,19 +19,101 @@ type SamplingRateSystem interface {
 	AddSamplingRate(version uint16, obsDomainId uint32, samplingRate uint32)
 }
 
+// Scopes of the sampling rates sent in option data, by the field carrying
+// their identifier in the data records.
+const (
+	SAMPLING_SCOPE_OBS_DOMAIN = 0
+	SAMPLING_SCOPE_SAMPLER    = netflow.IPFIX_FIELD_samplerId // FLOW_SAMPLER_ID in NetFlow v9
+	SAMPLING_SCOPE_SELECTOR   = netflow.IPFIX_FIELD_selectorId
+	SAMPLING_SCOPE_INTERFACE  = netflow.IPFIX_FIELD_ingressInterface
+
+	nfv9ScopeInterface = 2 // NetFlow v9 option scope field type
+)
+
+// SamplingRateScope identifies a sampler, a selector or an input interface
+// of an observation domain.
+type SamplingRateScope struct {
+	Type uint16 // SAMPLING_SCOPE_*
+	Id   uint64
+}
+
+// ScopedSamplingRate is a sampling rate sent in an option data record.
+type ScopedSamplingRate struct {
+	Scope        SamplingRateScope
+	SamplingRate uint32
+}
+
+// ScopedSamplingRateSystem is implemented by the sampling rate systems
+// keeping the rates of each sampler, selector or interface.
+type ScopedSamplingRateSystem interface {
+	SamplingRateSystem
+	GetScopedSamplingRate(version uint16, obsDomainId uint32, scope SamplingRateScope) (uint32, bool)
+	AddScopedSamplingRate(version uint16, obsDomainId uint32, scope SamplingRateScope, samplingRate uint32)
+	// RemoveExpiredScopedSamplingRates forgets the rates not received again for ttl.
+	RemoveExpiredScopedSamplingRates(ttl time.Duration)
+}
+
+type scopedSamplingRateKey struct {
+	version     uint16
+	obsDomainId uint32
+	scope       SamplingRateScope
+}
+
+type scopedSamplingRate struct {
+	samplingRate uint32
+	updated      time.Time
+}
+
 type basicSamplingRateSystem struct {
 	sampling     map[uint16]map[uint32]uint32
+	scoped       map[scopedSamplingRateKey]scopedSamplingRate
 	samplinglock *sync.RWMutex
+
+	// length of scoped, the flows of routers without scoped rates skip the lock
+	scopedCount int64
 }
 
 func CreateSamplingSystem() SamplingRateSystem {
 	ts := &basicSamplingRateSystem{
 		sampling:     make(map[uint16]map[uint32]uint32),
+		scoped:       make(map[scopedSamplingRateKey]scopedSamplingRate),
 		samplinglock: &sync.RWMutex{},
 	}
 	return ts
 }
 
+func (s *basicSamplingRateSystem) AddScopedSamplingRate(version uint16, obsDomainId uint32, scope SamplingRateScope, samplingRate uint32) {
+	s.samplinglock.Lock()
+	s.scoped[scopedSamplingRateKey{version, obsDomainId, scope}] = scopedSamplingRate{samplingRate, time.Now()}
+	atomic.StoreInt64(&s.scopedCount, int64(len(s.scoped)))
+	s.samplinglock.Unlock()
+}
+
+func (s *basicSamplingRateSystem) GetScopedSamplingRate(version uint16, obsDomainId uint32, scope SamplingRateScope) (uint32, bool) {
+	if atomic.LoadInt64(&s.scopedCount) == 0 {
+		return 0, false
+	}
+	s.samplinglock.RLock()
+	rate, ok := s.scoped[scopedSamplingRateKey{version, obsDomainId, scope}]
+	s.samplinglock.RUnlock()
+	return rate.samplingRate, ok
+}
+
+func (s *basicSamplingRateSystem) RemoveExpiredScopedSamplingRates(ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	s.samplinglock.Lock()
+	for key, rate := range s.scoped {
+		if now.Sub(rate.updated) > ttl {
+			delete(s.scoped, key)
+		}
+	}
+	atomic.StoreInt64(&s.scopedCount, int64(len(s.scoped)))
+	s.samplinglock.Unlock()
+}
+
 func (s *basicSamplingRateSystem) AddSamplingRate(version uint16, obsDomainId uint32, samplingRate uint32) {
 	s.samplinglock.Lock()
 	_, exists := s.sampling[version]
@@ -68,9 +151,40 @@ func (s *SingleSamplingRateSystem) GetSamplingRate(version uint16, obsDomainId u
 	return s.Sampling, nil
 }
 
+// NetFlowEnterpriseFieldFunc populates a FlowMessage from the raw value of an
+// enterprise-specific Information Element.
+type NetFlowEnterpriseFieldFunc func(flowMessage *flowmessage.FlowMessage, value []byte)
+
+type enterpriseFieldKey struct {
+	pen    uint32
+	typeId uint16
+}
+
+var (
+	enterpriseFields     = make(map[enterpriseFieldKey]NetFlowEnterpriseFieldFunc)
+	enterpriseFieldsLock = &sync.RWMutex{}
+)
+
+// RegisterNetFlowEnterpriseField maps the Information Element typeId of the
+// enterprise pen to a function filling the FlowMessage.
+// Enterprise elements without a registered function are ignored.
+func RegisterNetFlowEnterpriseField(pen uint32, typeId uint16, populate NetFlowEnterpriseFieldFunc) {
+	enterpriseFieldsLock.Lock()
+	enterpriseFields[enterpriseFieldKey{pen, typeId}] = populate
+	enterpriseFieldsLock.Unlock()
+}
+
+func GetNetFlowEnterpriseField(pen uint32, typeId uint16) (NetFlowEnterpriseFieldFunc, bool) {
+	enterpriseFieldsLock.RLock()
+	populate, ok := enterpriseFields[enterpriseFieldKey{pen, typeId}]
+	enterpriseFieldsLock.RUnlock()
+	return populate, ok
+}
+
+// NetFlowLookFor returns the value of the first standard field of type typeId.
 func NetFlowLookFor(dataFields []netflow.DataField, typeId uint16) (bool, interface{}) {
 	for _, dataField := range dataFields {
-		if dataField.Type == typeId {
+		if dataField.Type == typeId && !dataField.PenProvided {
 			return true, dataField.Value
 		}
 	}
@@ -138,7 +252,13 @@ func DecodeUNumber(b []byte, out interface{}) error {
 }
 
 func ConvertNetFlowDataSet(version uint16, baseTime uint32, uptime uint32, record []netflow.DataField) *flowmessage.FlowMessage {
-	flowMessage := &flowmessage.FlowMessage{}
+	return ConvertNetFlowDataSetConfig(version, baseTime, uptime, record, nil)
+}
+
+// ConvertNetFlowDataSetConfig converts a data record and then applies the
+// fields mapping of config, which takes precedence over the built-in fields.
+func ConvertNetFlowDataSetConfig(version uint16, baseTime uint32, uptime uint32, record []netflow.DataField, config *NetFlowProducerConfig) *flowmessage.FlowMessage {
+	flowMessage := NewFlowMessage()
 	var time uint64
 
 	if version == 9 {
@@ -155,6 +275,13 @@ func ConvertNetFlowDataSet(version uint16, baseTime uint32, uptime uint32, recor
 			continue
 		}
 
+		if df.PenProvided {
+			if populate, ok := GetNetFlowEnterpriseField(df.Pen, df.Type); ok {
+				populate(flowMessage, v)
+			}
+			continue
+		}
+
 		switch df.Type {
 
 		// Statistics
@@ -334,13 +461,34 @@ func ConvertNetFlowDataSet(version uint16, baseTime uint32, uptime uint32, recor
 
 	}
 
+	if config != nil {
+		for _, df := range record {
+			v, ok := df.Value.([]byte)
+			if !ok {
+				continue
+			}
+			for _, mapped := range config.getMapping(version, df) {
+				err := MapNetFlowField(flowMessage, mapped, v, baseTime, uptime)
+				if err != nil && config.MappingErrorCallback != nil {
+					config.MappingErrorCallback(mapped, err)
+				}
+			}
+		}
+	}
+
 	return flowMessage
 }
 
 func SearchNetFlowDataSetsRecords(version uint16, baseTime uint32, uptime uint32, dataRecords []netflow.DataRecord) []*flowmessage.FlowMessage {
+	return SearchNetFlowDataSetsRecordsConfig(version, baseTime, uptime, dataRecords, nil)
+}
+
+// SearchNetFlowDataSetsRecordsConfig converts data records applying the
+// mapping of config.
+func SearchNetFlowDataSetsRecordsConfig(version uint16, baseTime uint32, uptime uint32, dataRecords []netflow.DataRecord, config *NetFlowProducerConfig) []*flowmessage.FlowMessage {
 	flowMessageSet := make([]*flowmessage.FlowMessage, 0)
 	for _, record := range dataRecords {
-		fmsg := ConvertNetFlowDataSet(version, baseTime, uptime, record.Values)
+		fmsg := ConvertNetFlowDataSetConfig(version, baseTime, uptime, record.Values, config)
 		if fmsg != nil {
 			flowMessageSet = append(flowMessageSet, fmsg)
 		}
@@ -349,9 +497,15 @@ func SearchNetFlowDataSetsRecords(version uint16, baseTime uint32, uptime uint32
 }
 
 func SearchNetFlowDataSets(version uint16, baseTime uint32, uptime uint32, dataFlowSet []netflow.DataFlowSet) []*flowmessage.FlowMessage {
+	return SearchNetFlowDataSetsConfig(version, baseTime, uptime, dataFlowSet, nil)
+}
+
+// SearchNetFlowDataSetsConfig converts the records of data sets applying the
+// mapping of config.
+func SearchNetFlowDataSetsConfig(version uint16, baseTime uint32, uptime uint32, dataFlowSet []netflow.DataFlowSet, config *NetFlowProducerConfig) []*flowmessage.FlowMessage {
 	flowMessageSet := make([]*flowmessage.FlowMessage, 0)
 	for _, dataFlowSetItem := range dataFlowSet {
-		fmsg := SearchNetFlowDataSetsRecords(version, baseTime, uptime, dataFlowSetItem.Records)
+		fmsg := SearchNetFlowDataSetsRecordsConfig(version, baseTime, uptime, dataFlowSetItem.Records, config)
 		if fmsg != nil {
 			flowMessageSet = append(flowMessageSet, fmsg...)
 		}
@@ -359,11 +513,22 @@ func SearchNetFlowDataSets(version uint16, baseTime uint32, uptime uint32, dataF
 	return flowMessageSet
 }
 
+// SearchNetFlowOptionDataSets returns the first sampling rate of the option
+// data records applying to the whole observation domain. The records scoped
+// to a sampler, a selector or an interface are skipped.
 func SearchNetFlowOptionDataSets(dataFlowSet []netflow.OptionsDataFlowSet) (uint32, bool) {
 	var samplingRate uint32
 	var found bool
 	for _, dataFlowSetItem := range dataFlowSet {
 		for _, record := range dataFlowSetItem.Records {
+			// the version is not known: the NetFlow v9 (2) and IPFIX (10)
+			// interface scopes are both checked, neither is a scope of the other
+			if _, scoped := samplingRateScope(9, record); scoped {
+				continue
+			}
+			if _, scoped := samplingRateScope(10, record); scoped {
+				continue
+			}
 			b := NetFlowPopulate(record.OptionsValues, 305, &samplingRate)
 			if b {
 				return samplingRate, b
@@ -381,6 +546,107 @@ func SearchNetFlowOptionDataSets(dataFlowSet []netflow.OptionsDataFlowSet) (uint
 	return samplingRate, found
 }
 
+// samplingRateScope returns the sampler, selector or interface an option
+// data record applies to.
+func samplingRateScope(version uint16, record netflow.OptionsDataRecord) (SamplingRateScope, bool) {
+	var id uint64
+	for _, scopeType := range []uint16{SAMPLING_SCOPE_SAMPLER, SAMPLING_SCOPE_SELECTOR} {
+		for _, fields := range [][]netflow.DataField{record.ScopesValues, record.OptionsValues} {
+			if netFlowLookForUNumber(fields, scopeType, &id) {
+				return SamplingRateScope{scopeType, id}, true
+			}
+		}
+	}
+	interfaceType := uint16(SAMPLING_SCOPE_INTERFACE)
+	if version == 9 {
+		interfaceType = nfv9ScopeInterface
+	}
+	if netFlowLookForUNumber(record.ScopesValues, interfaceType, &id) {
+		return SamplingRateScope{SAMPLING_SCOPE_INTERFACE, id}, true
+	}
+	return SamplingRateScope{}, false
+}
+
+func netFlowLookForUNumber(dataFields []netflow.DataField, typeId uint16, out interface{}) bool {
+	for _, field := range dataFields {
+		if field.PenProvided || field.Type != typeId {
+			continue
+		}
+		value, ok := field.Value.([]byte)
+		return ok && DecodeUNumber(value, out) == nil
+	}
+	return false
+}
+
+// SearchNetFlowOptionScopedSamplingRates returns the sampling rates of the
+// option data records scoped to a sampler, a selector or an interface.
+func SearchNetFlowOptionScopedSamplingRates(version uint16, dataFlowSet []netflow.OptionsDataFlowSet) []ScopedSamplingRate {
+	var samplingRates []ScopedSamplingRate
+	for _, dataFlowSetItem := range dataFlowSet {
+		for _, record := range dataFlowSetItem.Records {
+			scope, ok := samplingRateScope(version, record)
+			if !ok {
+				continue
+			}
+			var samplingRate uint32
+			for _, rateType := range []uint16{netflow.IPFIX_FIELD_samplingPacketInterval, netflow.NFV9_FIELD_FLOW_SAMPLER_RANDOM_INTERVAL, netflow.IPFIX_FIELD_samplingInterval} {
+				if netFlowLookForUNumber(record.OptionsValues, rateType, &samplingRate) {
+					samplingRates = append(samplingRates, ScopedSamplingRate{scope, samplingRate})
+					break
+				}
+			}
+		}
+	}
+	return samplingRates
+}
+
+// setSamplingRates sets the sampling rate of the flows converted from the
+// records of dataFlowSet: the rate of the sampler or selector of the record,
+// else of its input interface, else of the observation domain.
+func setSamplingRates(version uint16, obsDomainId uint32, samplingRate uint32, samplingRateSys SamplingRateSystem, optionDataFlowSet []netflow.OptionsDataFlowSet, dataFlowSet []netflow.DataFlowSet, flowMessageSet []*flowmessage.FlowMessage) {
+	scoped, _ := samplingRateSys.(ScopedSamplingRateSystem)
+	if scoped != nil {
+		for _, rate := range SearchNetFlowOptionScopedSamplingRates(version, optionDataFlowSet) {
+			scoped.AddScopedSamplingRate(version, obsDomainId, rate.Scope, rate.SamplingRate)
+		}
+	}
+	resolver, _ := samplingRateSys.(SamplingRateResolver)
+
+	// SearchNetFlowDataSetsConfig converts every record in order
+	var i int
+	for _, dataFlowSetItem := range dataFlowSet {
+		for _, record := range dataFlowSetItem.Records {
+			if i >= len(flowMessageSet) {
+				return
+			}
+			fmsg := flowMessageSet[i]
+			i++
+			recordSamplingRate := samplingRate
+			if scoped != nil {
+				if scopedRate, ok := getRecordSamplingRate(version, obsDomainId, scoped, record, fmsg.InIf); ok {
+					recordSamplingRate = scopedRate
+				}
+			}
+			fmsg.SamplingRate = uint64(recordSamplingRate)
+			if resolver != nil {
+				fmsg.SamplingRate = uint64(resolver.ResolveSamplingRate(version, obsDomainId, fmsg.InIf, recordSamplingRate))
+			}
+		}
+	}
+}
+
+func getRecordSamplingRate(version uint16, obsDomainId uint32, scoped ScopedSamplingRateSystem, record netflow.DataRecord, inIf uint32) (uint32, bool) {
+	var id uint64
+	for _, scopeType := range []uint16{SAMPLING_SCOPE_SAMPLER, SAMPLING_SCOPE_SELECTOR} {
+		if netFlowLookForUNumber(record.Values, scopeType, &id) {
+			if samplingRate, ok := scoped.GetScopedSamplingRate(version, obsDomainId, SamplingRateScope{scopeType, id}); ok {
+				return samplingRate, true
+			}
+		}
+	}
+	return scoped.GetScopedSamplingRate(version, obsDomainId, SamplingRateScope{SAMPLING_SCOPE_INTERFACE, uint64(inIf)})
+}
+
 func SplitNetFlowSets(packetNFv9 netflow.NFv9Packet) ([]netflow.DataFlowSet, []netflow.TemplateFlowSet, []netflow.NFv9OptionsTemplateFlowSet, []netflow.OptionsDataFlowSet) {
 	dataFlowSet := make([]netflow.DataFlowSet, 0)
 	templatesFlowSet := make([]netflow.TemplateFlowSet, 0)
@@ -424,6 +690,10 @@ func SplitIPFIXSets(packetIPFIX netflow.IPFIXPacket) ([]netflow.DataFlowSet, []n
 // Convert a NetFlow datastructure to a FlowMessage protobuf
 // Does not put sampling rate
 func ProcessMessageNetFlow(msgDec interface{}, samplingRateSys SamplingRateSystem) ([]*flowmessage.FlowMessage, error) {
+	return ProcessMessageNetFlowConfig(msgDec, samplingRateSys, nil)
+}
+
+func ProcessMessageNetFlowConfig(msgDec interface{}, samplingRateSys SamplingRateSystem, config *NetFlowProducerConfig) ([]*flowmessage.FlowMessage, error) {
 	seqnum := uint32(0)
 	var baseTime uint32
 	var uptime uint32
@@ -439,7 +709,7 @@ func ProcessMessageNetFlow(msgDec interface{}, samplingRateSys SamplingRateSyste
 		uptime = msgDecConv.SystemUptime
 		obsDomainId := msgDecConv.SourceId
 
-		flowMessageSet = SearchNetFlowDataSets(9, baseTime, uptime, dataFlowSet)
+		flowMessageSet = SearchNetFlowDataSetsConfig(9, baseTime, uptime, dataFlowSet, config)
 		samplingRate, found := SearchNetFlowOptionDataSets(optionDataFlowSet)
 		if samplingRateSys != nil {
 			if found {
@@ -450,8 +720,8 @@ func ProcessMessageNetFlow(msgDec interface{}, samplingRateSys SamplingRateSyste
 		}
 		for _, fmsg := range flowMessageSet {
 			fmsg.SequenceNum = seqnum
-			fmsg.SamplingRate = uint64(samplingRate)
 		}
+		setSamplingRates(9, obsDomainId, samplingRate, samplingRateSys, optionDataFlowSet, dataFlowSet, flowMessageSet)
 	case netflow.IPFIXPacket:
 		dataFlowSet, _, _, optionDataFlowSet := SplitIPFIXSets(msgDecConv)
 
@@ -459,7 +729,7 @@ func ProcessMessageNetFlow(msgDec interface{}, samplingRateSys SamplingRateSyste
 		baseTime = msgDecConv.ExportTime
 		obsDomainId := msgDecConv.ObservationDomainId
 
-		flowMessageSet = SearchNetFlowDataSets(10, baseTime, uptime, dataFlowSet)
+		flowMessageSet = SearchNetFlowDataSetsConfig(10, baseTime, uptime, dataFlowSet, config)
 
 		samplingRate, found := SearchNetFlowOptionDataSets(optionDataFlowSet)
 		if samplingRateSys != nil {
@@ -471,8 +741,8 @@ func ProcessMessageNetFlow(msgDec interface{}, samplingRateSys SamplingRateSyste
 		}
 		for _, fmsg := range flowMessageSet {
 			fmsg.SequenceNum = seqnum
-			fmsg.SamplingRate = uint64(samplingRate)
 		}
+		setSamplingRates(10, obsDomainId, samplingRate, samplingRateSys, optionDataFlowSet, dataFlowSet, flowMessageSet)
 	default:
 		return flowMessageSet, errors.New("bad NetFlow/IPFIX version")
 	}
@@ -10,7 +10,7 @@ import (
 )
 
 func ConvertNetFlowLegacyRecord(baseTime uint32, uptime uint32, record netflowlegacy.RecordsNetFlowV5) *flowmessage.FlowMessage {
-	flowMessage := &flowmessage.FlowMessage{}
+	flowMessage := NewFlowMessage()
 
 	flowMessage.Type = flowmessage.FlowMessage_NETFLOW_V5
 
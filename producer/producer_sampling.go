@@ -0,0 +1,156 @@
+package producer
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sources of the sampling rate of a flow.
+const (
+	SAMPLING_SOURCE_LEARNED  = "learned"  // sent by the router in option data
+	SAMPLING_SOURCE_OVERRIDE = "override" // forced by a rule
+	SAMPLING_SOURCE_DEFAULT  = "default"  // from a rule, the router did not send it
+	SAMPLING_SOURCE_NONE     = "none"
+)
+
+// SamplingRateRule sets the sampling rate of the routers in Network. The rule
+// can be restricted to an observation domain (or NetFlow v9 source id) and to
+// the input interface of the flows. In "default" mode (the default) the rate
+// is used when the router did not send one, in "override" mode it replaces it.
+type SamplingRateRule struct {
+	Network     string  `yaml:"network" json:"network"`
+	ObsDomainId *uint32 `yaml:"obs_domain_id" json:"obs_domain_id"`
+	Interface   *uint32 `yaml:"interface" json:"interface"`
+	Rate        uint32  `yaml:"rate" json:"rate"`
+	Mode        string  `yaml:"mode" json:"mode"`
+}
+
+// SamplingRateMapping is the content of a sampling rates file. The first
+// matching rule of each mode applies, specific rules go first:
+//
+//	sampling:
+//	  - network: 192.0.2.1/32
+//	    interface: 12
+//	    rate: 100
+//	    mode: override
+//	  - network: 192.0.2.0/24
+//	    rate: 1000
+type SamplingRateMapping struct {
+	Sampling []SamplingRateRule `yaml:"sampling" json:"sampling"`
+}
+
+// LoadSamplingRateMapping parses a YAML or JSON sampling rates file.
+func LoadSamplingRateMapping(r io.Reader) (*SamplingRateMapping, error) {
+	mapping := &SamplingRateMapping{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	err := dec.Decode(mapping)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+type samplingRateRule struct {
+	network     *net.IPNet
+	obsDomainId *uint32
+	inIf        *uint32
+	rate        uint32
+	override    bool
+}
+
+func (r *samplingRateRule) match(obsDomainId uint32, inIf uint32) bool {
+	return (r.obsDomainId == nil || *r.obsDomainId == obsDomainId) &&
+		(r.inIf == nil || *r.inIf == inIf)
+}
+
+type SamplingRateConfig struct {
+	rules []*samplingRateRule
+}
+
+// CreateSamplingRateConfig validates the rules of a sampling rates file.
+func CreateSamplingRateConfig(mapping *SamplingRateMapping) (*SamplingRateConfig, error) {
+	config := &SamplingRateConfig{}
+	if mapping == nil {
+		return config, nil
+	}
+	for i, rule := range mapping.Sampling {
+		_, network, err := net.ParseCIDR(rule.Network)
+		if err != nil {
+			return nil, fmt.Errorf("rule %v: %v", i, err)
+		}
+		if rule.Rate == 0 {
+			return nil, fmt.Errorf("rule %v: missing rate", i)
+		}
+		var override bool
+		switch rule.Mode {
+		case "", SAMPLING_SOURCE_DEFAULT:
+		case SAMPLING_SOURCE_OVERRIDE:
+			override = true
+		default:
+			return nil, fmt.Errorf("rule %v: unknown mode %v", i, rule.Mode)
+		}
+		config.rules = append(config.rules, &samplingRateRule{
+			network:     network,
+			obsDomainId: rule.ObsDomainId,
+			inIf:        rule.Interface,
+			rate:        rule.Rate,
+			override:    override,
+		})
+	}
+	return config, nil
+}
+
+// RouterSamplingRates are the rules of a SamplingRateConfig matching a router.
+type RouterSamplingRates struct {
+	rules []*samplingRateRule
+}
+
+// Router returns the rules of a router, nil when none matches.
+func (c *SamplingRateConfig) Router(router net.IP) *RouterSamplingRates {
+	if c == nil || router == nil {
+		return nil
+	}
+	var rules []*samplingRateRule
+	for _, rule := range c.rules {
+		if rule.network.Contains(router) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &RouterSamplingRates{rules: rules}
+}
+
+// Resolve returns the sampling rate of a flow and its source (SAMPLING_SOURCE_*)
+// given the rate learned from the router, 0 if unknown.
+func (r *RouterSamplingRates) Resolve(obsDomainId uint32, inIf uint32, learned uint32) (uint32, string) {
+	if r != nil {
+		for _, rule := range r.rules {
+			if rule.override && rule.match(obsDomainId, inIf) {
+				return rule.rate, SAMPLING_SOURCE_OVERRIDE
+			}
+		}
+	}
+	if learned != 0 {
+		return learned, SAMPLING_SOURCE_LEARNED
+	}
+	if r != nil {
+		for _, rule := range r.rules {
+			if !rule.override && rule.match(obsDomainId, inIf) {
+				return rule.rate, SAMPLING_SOURCE_DEFAULT
+			}
+		}
+	}
+	return 0, SAMPLING_SOURCE_NONE
+}
+
+// SamplingRateResolver is implemented by the sampling rate systems choosing
+// the rate of each flow from the rate learned for its observation domain.
+type SamplingRateResolver interface {
+	ResolveSamplingRate(version uint16, obsDomainId uint32, inIf uint32, samplingRate uint32) uint32
+}
@@ -1,7 +1,6 @@
 package producer
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
@@ -27,7 +26,8 @@ func GetSFlowFlowSamples(packet *sflow.Packet) []interface{} {
 }
 
 type SFlowProducerConfig struct {
-	DecodeGRE bool
+	DecodeGRE     bool // the inner IP header of encapsulated packets is the main one
+	MaxEncapDepth int  // nested encapsulations decoded (0 for the default, negative for none)
 }
 
 func ParseSampledHeader(flowMessage *flowmessage.FlowMessage, sampledHeader *sflow.SampledHeader) error {
@@ -36,296 +36,56 @@ func ParseSampledHeader(flowMessage *flowmessage.FlowMessage, sampledHeader *sfl
 
 func ParseSampledHeaderConfig(flowMessage *flowmessage.FlowMessage, sampledHeader *sflow.SampledHeader, config *SFlowProducerConfig) error {
 	var decodeGRE bool
+	maxDepth := defaultMaxEncapDepth
 	if config != nil {
 		decodeGRE = config.DecodeGRE
+		if config.MaxEncapDepth != 0 {
+			maxDepth = config.MaxEncapDepth
+		}
 	}
 
 	data := (*sampledHeader).HeaderData
-	switch (*sampledHeader).Protocol {
-	case 1: // Ethernet
-		if len(data) < ethernetHeaderSize {
-			return fmt.Errorf("data shorter than ethernet header (%d<%d bytes)", len(data), ethernetHeaderSize)
-		}
-		var hasPPP bool
-		var pppAddressControl uint16
-		var hasMPLS bool
-		var countMpls uint32
-		var firstLabelMpls uint32
-		var firstTtlMpls uint8
-		var secondLabelMpls uint32
-		var secondTtlMpls uint8
-		var thirdLabelMpls uint32
-		var thirdTtlMpls uint8
-		var lastLabelMpls uint32
-		var lastTtlMpls uint8
-
-		var hasEncap bool
-		var nextHeader byte
-		var nextHeaderEncap byte
-		var tcpflags byte
-		srcIP := net.IP{}
-		dstIP := net.IP{}
-		srcIPEncap := net.IP{}
-		dstIPEncap := net.IP{}
-		offset := ethernetHeaderSize
-
-		var srcMac uint64
-		var dstMac uint64
-
-		var tos byte
-		var ttl byte
-		var identification uint16
-		var fragOffset uint16
-		var flowLabel uint32
-
-		var tosEncap byte
-		var ttlEncap byte
-		var identificationEncap uint16
-		var fragOffsetEncap uint16
-		var flowLabelEncap uint32
-
-		var srcPort uint16
-		var dstPort uint16
-
-		etherType := data[12:14]
-		etherTypeEncap := []byte{0, 0}
-
-		dstMac = binary.BigEndian.Uint64(append([]byte{0, 0}, data[0:6]...))
-		srcMac = binary.BigEndian.Uint64(append([]byte{0, 0}, data[6:12]...))
-		(*flowMessage).SrcMac = srcMac
-		(*flowMessage).DstMac = dstMac
-
-		encap := true
-		iterations := 0
-		for encap && iterations <= 1 {
-			encap = false
-
-			if etherType[0] == 0x81 && etherType[1] == 0x0 { // VLAN 802.1Q
-				(*flowMessage).VlanId = uint32(binary.BigEndian.Uint16(data[14:16]))
-				offset += 4
-				etherType = data[16:18]
-			}
-
-			if etherType[0] == 0x88 && etherType[1] == 0x47 { // MPLS
-				iterateMpls := true
-				hasMPLS = true
-				for iterateMpls {
-					if len(data) < offset+5 {
-						iterateMpls = false
-						break
-					}
-					label := binary.BigEndian.Uint32(append([]byte{0}, data[offset:offset+3]...)) >> 4
-					//exp := data[offset+2] > 1
-					bottom := data[offset+2] & 1
-					mplsTtl := data[offset+3]
-					offset += 4
-
-					if bottom == 1 || label <= 15 || offset > len(data) {
-						if data[offset]&0xf0>>4 == 4 {
-							etherType = []byte{0x8, 0x0}
-						} else if data[offset]&0xf0>>4 == 6 {
-							etherType = []byte{0x86, 0xdd}
-						}
-						iterateMpls = false
-					}
-
-					if countMpls == 0 {
-						firstLabelMpls = label
-						firstTtlMpls = mplsTtl
-					} else if countMpls == 1 {
-						secondLabelMpls = label
-						secondTtlMpls = mplsTtl
-					} else if countMpls == 2 {
-						thirdLabelMpls = label
-						thirdTtlMpls = mplsTtl
-					} else {
-						lastLabelMpls = label
-						lastTtlMpls = mplsTtl
-					}
-					countMpls++
-				}
-			}
-
-			if etherType[0] == 0x8 && etherType[1] == 0x0 { // IPv4
-				if len(data) >= offset+20 {
-					nextHeader = data[offset+9]
-					srcIP = data[offset+12 : offset+16]
-					dstIP = data[offset+16 : offset+20]
-					tos = data[offset+1]
-					ttl = data[offset+8]
-
-					identification = binary.BigEndian.Uint16(data[offset+4 : offset+6])
-					fragOffset = binary.BigEndian.Uint16(data[offset+6 : offset+8])
-
-					offset += 20
-				}
-			} else if etherType[0] == 0x86 && etherType[1] == 0xdd { // IPv6
-				if len(data) >= offset+40 {
-					nextHeader = data[offset+6]
-					srcIP = data[offset+8 : offset+24]
-					dstIP = data[offset+24 : offset+40]
-
-					tostmp := uint32(binary.BigEndian.Uint16(data[offset : offset+2]))
-					tos = uint8(tostmp & 0x0ff0 >> 4)
-					ttl = data[offset+7]
-
-					flowLabel = binary.BigEndian.Uint32(data[offset : offset+4])
-
-					offset += 40
-
-				}
-			} else if etherType[0] == 0x8 && etherType[1] == 0x6 { // ARP
-			} /*else {
-				return errors.New(fmt.Sprintf("Unknown EtherType: %v\n", etherType))
-			} */
-
-			if len(data) >= offset+4 && (nextHeader == 17 || nextHeader == 6) {
-				srcPort = binary.BigEndian.Uint16(data[offset+0 : offset+2])
-				dstPort = binary.BigEndian.Uint16(data[offset+2 : offset+4])
-			}
-
-			if len(data) > offset+13 && nextHeader == 6 {
-				tcpflags = data[offset+13]
-			}
-
-			// ICMP and ICMPv6
-			if len(data) >= offset+2 && (nextHeader == 1 || nextHeader == 58) {
-				(*flowMessage).IcmpType = uint32(data[offset+0])
-				(*flowMessage).IcmpCode = uint32(data[offset+1])
-			}
-
-			// GRE
-			if len(data) >= offset+4 && nextHeader == 47 {
-				etherTypeEncap = data[offset+2 : offset+4]
-				offset += 4
-				if (etherTypeEncap[0] == 0x8 && etherTypeEncap[1] == 0x0) ||
-					(etherTypeEncap[0] == 0x86 && etherTypeEncap[1] == 0xdd) {
-					encap = true
-					hasEncap = true
-				}
-				if etherTypeEncap[0] == 0x88 && etherTypeEncap[1] == 0x0b && len(data) >= offset+12 {
-					offset += 8
-					encap = true
-					hasPPP = true
-					pppAddressControl = binary.BigEndian.Uint16(data[offset : offset+2])
-					pppEtherType := data[offset+2 : offset+4]
-					if pppEtherType[0] == 0x0 && pppEtherType[1] == 0x21 {
-						etherTypeEncap = []byte{0x8, 0x00}
-						hasEncap = true
-					} else if pppEtherType[0] == 0x0 && pppEtherType[1] == 0x57 {
-						etherTypeEncap = []byte{0x86, 0xdd}
-						hasEncap = true
-					}
-					offset += 4
-
-				}
-
-				if hasEncap {
-					srcIPEncap = srcIP
-					dstIPEncap = dstIP
-
-					nextHeaderEncap = nextHeader
-					tosEncap = tos
-					ttlEncap = ttl
-					identificationEncap = identification
-					fragOffsetEncap = fragOffset
-					flowLabelEncap = flowLabel
-
-					etherTypeEncapTmp := etherTypeEncap
-					etherTypeEncap = etherType
-					etherType = etherTypeEncapTmp
-				}
-
-			}
-			iterations++
-		}
-
-		if !decodeGRE && hasEncap {
-			//fmt.Printf("DEOCDE %v -> %v || %v -> %v\n", net.IP(srcIPEncap), net.IP(dstIPEncap), net.IP(srcIP), net.IP(dstIP))
-			tmpSrc := srcIPEncap
-			tmpDst := dstIPEncap
-			srcIPEncap = srcIP
-			dstIPEncap = dstIP
-			srcIP = tmpSrc
-			dstIP = tmpDst
-
-			tmpNextHeader := nextHeaderEncap
-			nextHeaderEncap = nextHeader
-			nextHeader = tmpNextHeader
-
-			tosTmp := tosEncap
-			tosEncap = tos
-			tos = tosTmp
-
-			ttlTmp := ttlEncap
-			ttlEncap = ttl
-			ttl = ttlTmp
-
-			identificationTmp := identificationEncap
-			identificationEncap = identification
-			identification = identificationTmp
-
-			fragOffsetTmp := fragOffsetEncap
-			fragOffsetEncap = fragOffset
-			fragOffset = fragOffsetTmp
-
-			flowLabelTmp := flowLabelEncap
-			flowLabelEncap = flowLabel
-			flowLabel = flowLabelTmp
-		}
-
-		(*flowMessage).HasPPP = hasPPP
-		(*flowMessage).PPPAddressControl = uint32(pppAddressControl)
-
-		(*flowMessage).HasMPLS = hasMPLS
-		(*flowMessage).MPLSCount = countMpls
-		(*flowMessage).MPLS1Label = firstLabelMpls
-		(*flowMessage).MPLS1TTL = uint32(firstTtlMpls)
-		(*flowMessage).MPLS2Label = secondLabelMpls
-		(*flowMessage).MPLS2TTL = uint32(secondTtlMpls)
-		(*flowMessage).MPLS3Label = thirdLabelMpls
-		(*flowMessage).MPLS3TTL = uint32(thirdTtlMpls)
-		(*flowMessage).MPLSLastLabel = lastLabelMpls
-		(*flowMessage).MPLSLastTTL = uint32(lastTtlMpls)
-
-		(*flowMessage).HasEncap = hasEncap
-		(*flowMessage).ProtoEncap = uint32(nextHeaderEncap)
-		(*flowMessage).SrcAddrEncap = srcIPEncap
-		(*flowMessage).DstAddrEncap = dstIPEncap
-		(*flowMessage).EtypeEncap = uint32(binary.BigEndian.Uint16(etherTypeEncap[0:2]))
-
-		(*flowMessage).IPTosEncap = uint32(tosEncap)
-		(*flowMessage).IPTTLEncap = uint32(ttlEncap)
-		(*flowMessage).FragmentIdEncap = uint32(identificationEncap)
-		(*flowMessage).FragmentOffsetEncap = uint32(fragOffsetEncap)
-		(*flowMessage).IPv6FlowLabelEncap = flowLabelEncap & 0xFFFFF
-
-		(*flowMessage).Etype = uint32(binary.BigEndian.Uint16(etherType[0:2]))
-		(*flowMessage).IPv6FlowLabel = flowLabel & 0xFFFFF
-
-		(*flowMessage).SrcPort = uint32(srcPort)
-		(*flowMessage).DstPort = uint32(dstPort)
-
-		(*flowMessage).SrcAddr = srcIP
-		(*flowMessage).DstAddr = dstIP
-		(*flowMessage).Proto = uint32(nextHeader)
-		(*flowMessage).IPTos = uint32(tos)
-		(*flowMessage).IPTTL = uint32(ttl)
-		(*flowMessage).TCPFlags = uint32(tcpflags)
-
-		(*flowMessage).FragmentId = uint32(identification)
-		(*flowMessage).FragmentOffset = uint32(fragOffset)
+	decoder, ok := headerProtocolDecoders[(*sampledHeader).Protocol]
+	if !ok {
+		return nil
+	}
+	if (*sampledHeader).Protocol == 1 && len(data) < ethernetHeaderSize {
+		return fmt.Errorf("data shorter than ethernet header (%d<%d bytes)", len(data), ethernetHeaderSize)
+	}
+	parser := sampledHeaderParser{
+		data:     data,
+		maxDepth: maxDepth,
 	}
+	parser.parse(decoder)
+	parser.fill(flowMessage, decodeGRE)
 	return nil
 }
 
+// setMPLSLabels fills the MPLS fields of a flow message from a stack of
+// label entries (RFC 3032) starting with the top of the stack.
+func setMPLSLabels(flowMessage *flowmessage.FlowMessage, labels []uint32) {
+	label := func(entry uint32) (uint32, uint32) {
+		return entry >> 12, entry & 0xff
+	}
+	flowMessage.HasMPLS = true
+	flowMessage.MPLSCount = uint32(len(labels))
+	flowMessage.MPLS1Label, flowMessage.MPLS1TTL = label(labels[0])
+	if len(labels) > 1 {
+		flowMessage.MPLS2Label, flowMessage.MPLS2TTL = label(labels[1])
+	}
+	if len(labels) > 2 {
+		flowMessage.MPLS3Label, flowMessage.MPLS3TTL = label(labels[2])
+	}
+	flowMessage.MPLSLastLabel, flowMessage.MPLSLastTTL = label(labels[len(labels)-1])
+}
+
 func SearchSFlowSamplesConfig(samples []interface{}, config *SFlowProducerConfig, agent net.IP) []*flowmessage.FlowMessage {
 	flowMessageSet := make([]*flowmessage.FlowMessage, 0)
 
 	for _, flowSample := range samples {
 		var records []sflow.FlowRecord
 
-		flowMessage := &flowmessage.FlowMessage{}
+		flowMessage := NewFlowMessage()
 		flowMessage.Type = flowmessage.FlowMessage_SFLOW_5
 
 		switch flowSample := flowSample.(type) {
@@ -344,6 +104,7 @@ func SearchSFlowSamplesConfig(samples []interface{}, config *SFlowProducerConfig
 		var ipNh net.IP
 		var ipSrc net.IP
 		var ipDst net.IP
+		var mplsLabels []uint32
 
 		flowMessage.Packets = 1
 		for _, record := range records {
@@ -395,8 +156,23 @@ func SearchSFlowSamplesConfig(samples []interface{}, config *SFlowProducerConfig
 			case sflow.ExtendedSwitch:
 				flowMessage.SrcVlan = recordData.SrcVlan
 				flowMessage.DstVlan = recordData.DstVlan
+			case sflow.ExtendedNAT:
+				flowMessage.SrcAddrNat = recordData.SrcIP
+				flowMessage.DstAddrNat = recordData.DstIP
+			case sflow.ExtendedMPLS:
+				mplsLabels = recordData.InLabelStack
+			case sflow.ExtendedVNI:
+				if record.Header.DataFormat == sflow.FORMAT_EXT_VNI_INGRESS {
+					flowMessage.VniIngress = recordData.VNI
+				} else {
+					flowMessage.VniEgress = recordData.VNI
+				}
 			}
 		}
+		// the labels of the sampled header are more accurate
+		if !flowMessage.HasMPLS && len(mplsLabels) > 0 {
+			setMPLSLabels(flowMessage, mplsLabels)
+		}
 		flowMessageSet = append(flowMessageSet, flowMessage)
 	}
 	return flowMessageSet
@@ -425,3 +201,95 @@ func ProcessMessageSFlowConfig(msgDec interface{}, config *SFlowProducerConfig)
 		return []*flowmessage.FlowMessage{}, errors.New("bad sFlow version")
 	}
 }
+
+func GetSFlowCounterSamples(packet *sflow.Packet) []sflow.CounterSample {
+	counterSamples := make([]sflow.CounterSample, 0)
+	for _, sample := range packet.Samples {
+		switch sample := sample.(type) {
+		case sflow.CounterSample:
+			counterSamples = append(counterSamples, sample)
+		}
+	}
+	return counterSamples
+}
+
+// SearchSFlowCounterSamples converts the interface counters of the counter
+// samples into one message per sample. The samples without interface counters
+// are skipped.
+func SearchSFlowCounterSamples(samples []sflow.CounterSample) []*flowmessage.CountersMessage {
+	countersMessageSet := make([]*flowmessage.CountersMessage, 0)
+
+	for _, counterSample := range samples {
+		countersMessage := &flowmessage.CountersMessage{
+			SampleSequenceNum: counterSample.Header.SampleSequenceNumber,
+			// the data source of interface counters is the ifIndex
+			IfIndex: counterSample.Header.SourceIdValue,
+		}
+
+		var found bool
+		for _, record := range counterSample.Records {
+			switch recordData := record.Data.(type) {
+			case sflow.IfCounters:
+				found = true
+				countersMessage.IfIndex = recordData.IfIndex
+				countersMessage.IfType = recordData.IfType
+				countersMessage.IfSpeed = recordData.IfSpeed
+				countersMessage.IfDirection = recordData.IfDirection
+				countersMessage.IfStatus = recordData.IfStatus
+				countersMessage.IfInOctets = recordData.IfInOctets
+				countersMessage.IfInUcastPkts = recordData.IfInUcastPkts
+				countersMessage.IfInMulticastPkts = recordData.IfInMulticastPkts
+				countersMessage.IfInBroadcastPkts = recordData.IfInBroadcastPkts
+				countersMessage.IfInDiscards = recordData.IfInDiscards
+				countersMessage.IfInErrors = recordData.IfInErrors
+				countersMessage.IfInUnknownProtos = recordData.IfInUnknownProtos
+				countersMessage.IfOutOctets = recordData.IfOutOctets
+				countersMessage.IfOutUcastPkts = recordData.IfOutUcastPkts
+				countersMessage.IfOutMulticastPkts = recordData.IfOutMulticastPkts
+				countersMessage.IfOutBroadcastPkts = recordData.IfOutBroadcastPkts
+				countersMessage.IfOutDiscards = recordData.IfOutDiscards
+				countersMessage.IfOutErrors = recordData.IfOutErrors
+				countersMessage.IfPromiscuousMode = recordData.IfPromiscuousMode
+			case sflow.EthernetCounters:
+				found = true
+				countersMessage.HasEthernetCounters = true
+				countersMessage.Dot3StatsAlignmentErrors = recordData.Dot3StatsAlignmentErrors
+				countersMessage.Dot3StatsFCSErrors = recordData.Dot3StatsFCSErrors
+				countersMessage.Dot3StatsSingleCollisionFrames = recordData.Dot3StatsSingleCollisionFrames
+				countersMessage.Dot3StatsMultipleCollisionFrames = recordData.Dot3StatsMultipleCollisionFrames
+				countersMessage.Dot3StatsSQETestErrors = recordData.Dot3StatsSQETestErrors
+				countersMessage.Dot3StatsDeferredTransmissions = recordData.Dot3StatsDeferredTransmissions
+				countersMessage.Dot3StatsLateCollisions = recordData.Dot3StatsLateCollisions
+				countersMessage.Dot3StatsExcessiveCollisions = recordData.Dot3StatsExcessiveCollisions
+				countersMessage.Dot3StatsInternalMacTransmitErrors = recordData.Dot3StatsInternalMacTransmitErrors
+				countersMessage.Dot3StatsCarrierSenseErrors = recordData.Dot3StatsCarrierSenseErrors
+				countersMessage.Dot3StatsFrameTooLongs = recordData.Dot3StatsFrameTooLongs
+				countersMessage.Dot3StatsInternalMacReceiveErrors = recordData.Dot3StatsInternalMacReceiveErrors
+				countersMessage.Dot3StatsSymbolErrors = recordData.Dot3StatsSymbolErrors
+			}
+		}
+		if found {
+			countersMessageSet = append(countersMessageSet, countersMessage)
+		}
+	}
+	return countersMessageSet
+}
+
+// ProcessMessageSFlowCounters converts the counter samples of an sFlow packet
+// into interface counters keyed by agent and ifIndex.
+func ProcessMessageSFlowCounters(msgDec interface{}) ([]*flowmessage.CountersMessage, error) {
+	switch packet := msgDec.(type) {
+	case sflow.Packet:
+		counterSamples := GetSFlowCounterSamples(&packet)
+		countersMessageSet := SearchSFlowCounterSamples(counterSamples)
+		for _, cmsg := range countersMessageSet {
+			cmsg.SamplerAddress = packet.AgentIP
+			cmsg.SubAgentId = packet.SubAgentId
+			cmsg.SequenceNum = packet.SequenceNumber
+		}
+
+		return countersMessageSet, nil
+	default:
+		return []*flowmessage.CountersMessage{}, errors.New("bad sFlow version")
+	}
+}
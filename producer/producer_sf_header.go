@@ -0,0 +1,434 @@
+package producer
+
+import (
+	"encoding/binary"
+	"net"
+
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+)
+
+// defaultMaxEncapDepth is the number of nested encapsulations decoded when
+// the configuration does not set one.
+const defaultMaxEncapDepth = 1
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+)
+
+// sampledIPHeader is an IP header found in a sampled header. There is one
+// for each level of IP encapsulation.
+type sampledIPHeader struct {
+	etherType  uint16
+	src        net.IP
+	dst        net.IP
+	proto      uint8 // upper-layer protocol, after the IPv6 extension headers
+	tos        uint8
+	ttl        uint8
+	fragId     uint32
+	fragOffset uint16
+	flowLabel  uint32
+}
+
+// sampledHeaderParser walks the layers of a sampled header. Each layer is
+// decoded by a layerDecoder found in the tables below from the protocol
+// announced by the previous layer.
+type sampledHeaderParser struct {
+	data     []byte
+	offset   int
+	depth    int // encapsulations entered
+	maxDepth int
+
+	hasMac bool
+	srcMac uint64
+	dstMac uint64
+
+	hasVlan bool
+	vlanId  uint32
+
+	hasPPP            bool
+	pppAddressControl uint16
+
+	mplsLabels []uint32 // label stack entries of the outermost stack
+	etherType  uint16   // last EtherType found
+	ipHeaders  []sampledIPHeader
+
+	// transport of the innermost IP header decoded
+	srcPort  uint16
+	dstPort  uint16
+	tcpFlags uint8
+	icmpType uint8
+	icmpCode uint8
+}
+
+// layerDecoder decodes the layer at the offset of the parser and returns
+// the decoder of the next layer, nil when there is nothing more to decode.
+type layerDecoder func(p *sampledHeaderParser) layerDecoder
+
+var (
+	// sFlow header_protocol of the sampled headers
+	headerProtocolDecoders map[uint32]layerDecoder
+	etherTypeDecoders      map[uint16]layerDecoder
+	ipProtoDecoders        map[uint8]layerDecoder
+	udpPortDecoders        map[uint16]layerDecoder
+)
+
+func init() {
+	headerProtocolDecoders = map[uint32]layerDecoder{
+		1:  decodeEthernet,
+		11: decodeIPv4,
+		12: decodeIPv6,
+	}
+	etherTypeDecoders = map[uint16]layerDecoder{
+		etherTypeIPv4: decodeIPv4,
+		etherTypeIPv6: decodeIPv6,
+		0x8100:        decodeVLAN, // 802.1Q
+		0x88a8:        decodeVLAN, // 802.1ad (QinQ)
+		0x9100:        decodeVLAN, // legacy QinQ
+		0x8847:        decodeMPLS,
+		0x880b:        decodePPP,
+		0x6558:        decodeEthernet, // transparent Ethernet bridging (NVGRE, GENEVE)
+	}
+	ipProtoDecoders = map[uint8]layerDecoder{
+		1:  decodeICMP,
+		4:  encapsulation(decodeIPv4), // IP-in-IP
+		6:  decodeTCP,
+		17: decodeUDP,
+		41: encapsulation(decodeIPv6),
+		47: encapsulation(decodeGRE),
+		58: decodeICMP,
+	}
+	udpPortDecoders = map[uint16]layerDecoder{
+		4789: encapsulation(decodeVXLAN),
+		6081: encapsulation(decodeGENEVE),
+	}
+}
+
+// encapsulation stops decoding once the maximum depth of encapsulations is
+// reached.
+func encapsulation(decoder layerDecoder) layerDecoder {
+	return func(p *sampledHeaderParser) layerDecoder {
+		if p.depth >= p.maxDepth {
+			return nil
+		}
+		p.depth++
+		return decoder(p)
+	}
+}
+
+func (p *sampledHeaderParser) remaining() int {
+	return len(p.data) - p.offset
+}
+
+func (p *sampledHeaderParser) nextEtherType(etherType uint16) layerDecoder {
+	p.etherType = etherType
+	return etherTypeDecoders[etherType]
+}
+
+func (p *sampledHeaderParser) parse(decoder layerDecoder) {
+	for decoder != nil && p.offset < len(p.data) {
+		decoder = decoder(p)
+	}
+}
+
+func decodeEthernet(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < ethernetHeaderSize {
+		return nil
+	}
+	data := p.data[p.offset:]
+	// the addresses of an encapsulated frame are not kept
+	if !p.hasMac {
+		p.hasMac = true
+		p.dstMac = binary.BigEndian.Uint64(append([]byte{0, 0}, data[0:6]...))
+		p.srcMac = binary.BigEndian.Uint64(append([]byte{0, 0}, data[6:12]...))
+	}
+	p.offset += ethernetHeaderSize
+	return p.nextEtherType(binary.BigEndian.Uint16(data[12:14]))
+}
+
+func decodeVLAN(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 4 {
+		return nil
+	}
+	data := p.data[p.offset:]
+	// the outer tag of a QinQ stack is the service VLAN
+	if !p.hasVlan {
+		p.hasVlan = true
+		p.vlanId = uint32(binary.BigEndian.Uint16(data[0:2]) & 0x0fff)
+	}
+	p.offset += 4
+	return p.nextEtherType(binary.BigEndian.Uint16(data[2:4]))
+}
+
+func decodeMPLS(p *sampledHeaderParser) layerDecoder {
+	outermost := p.mplsLabels == nil
+	for p.remaining() >= 4 {
+		entry := binary.BigEndian.Uint32(p.data[p.offset:])
+		p.offset += 4
+		if outermost {
+			p.mplsLabels = append(p.mplsLabels, entry)
+		}
+		if entry&0x100 == 0 {
+			continue
+		}
+		// the payload is not announced after the bottom of the stack
+		if p.remaining() > 0 {
+			switch p.data[p.offset] >> 4 {
+			case 4:
+				return p.nextEtherType(etherTypeIPv4)
+			case 6:
+				return p.nextEtherType(etherTypeIPv6)
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+func decodePPP(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 4 {
+		return nil
+	}
+	p.hasPPP = true
+	data := p.data[p.offset:]
+	// the address and control fields may be compressed
+	if data[0] == 0xff && data[1] == 0x03 {
+		p.pppAddressControl = binary.BigEndian.Uint16(data[0:2])
+		p.offset += 2
+		data = data[2:]
+	}
+	p.offset += 2
+	switch binary.BigEndian.Uint16(data[0:2]) {
+	case 0x0021:
+		return p.nextEtherType(etherTypeIPv4)
+	case 0x0057:
+		return p.nextEtherType(etherTypeIPv6)
+	case 0x0281:
+		return p.nextEtherType(0x8847)
+	}
+	return nil
+}
+
+func decodeIPv4(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 20 {
+		return nil
+	}
+	data := p.data[p.offset:]
+	ipHeader := sampledIPHeader{
+		etherType:  etherTypeIPv4,
+		src:        data[12:16],
+		dst:        data[16:20],
+		proto:      data[9],
+		tos:        data[1],
+		ttl:        data[8],
+		fragId:     uint32(binary.BigEndian.Uint16(data[4:6])),
+		fragOffset: binary.BigEndian.Uint16(data[6:8]),
+	}
+	p.etherType = etherTypeIPv4
+	p.ipHeaders = append(p.ipHeaders, ipHeader)
+
+	headerLength := int(data[0]&0x0f) * 4
+	if headerLength < 20 || headerLength > len(data) {
+		return nil
+	}
+	p.offset += headerLength
+	// only the first fragment holds the next header
+	if ipHeader.fragOffset&0x1fff != 0 {
+		return nil
+	}
+	return ipProtoDecoders[ipHeader.proto]
+}
+
+func decodeIPv6(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 40 {
+		return nil
+	}
+	data := p.data[p.offset:]
+	ipHeader := sampledIPHeader{
+		etherType: etherTypeIPv6,
+		src:       data[8:24],
+		dst:       data[24:40],
+		tos:       uint8(binary.BigEndian.Uint16(data[0:2]) & 0x0ff0 >> 4),
+		ttl:       data[7],
+		flowLabel: binary.BigEndian.Uint32(data[0:4]) & 0xfffff,
+	}
+	p.offset += 40
+	nextHeader := data[6]
+	complete := decodeIPv6ExtensionHeaders(p, &ipHeader, &nextHeader)
+	ipHeader.proto = nextHeader
+	p.etherType = etherTypeIPv6
+	p.ipHeaders = append(p.ipHeaders, ipHeader)
+	if !complete || ipHeader.fragOffset&0xfff8 != 0 {
+		return nil
+	}
+	return ipProtoDecoders[nextHeader]
+}
+
+// decodeIPv6ExtensionHeaders skips the extension headers following an IPv6
+// header and returns false when they are truncated.
+func decodeIPv6ExtensionHeaders(p *sampledHeaderParser, ipHeader *sampledIPHeader, nextHeader *uint8) bool {
+	for {
+		var length int
+		switch *nextHeader {
+		case 0, 43, 60: // hop-by-hop options, routing, destination options
+			if p.remaining() < 8 {
+				return false
+			}
+			length = (int(p.data[p.offset+1]) + 1) * 8
+		case 44: // fragment
+			if p.remaining() < 8 {
+				return false
+			}
+			ipHeader.fragOffset = binary.BigEndian.Uint16(p.data[p.offset+2 : p.offset+4])
+			ipHeader.fragId = binary.BigEndian.Uint32(p.data[p.offset+4 : p.offset+8])
+			length = 8
+		case 51: // authentication header
+			if p.remaining() < 8 {
+				return false
+			}
+			length = (int(p.data[p.offset+1]) + 2) * 4
+		default:
+			return true
+		}
+		if p.remaining() < length {
+			return false
+		}
+		*nextHeader = p.data[p.offset]
+		p.offset += length
+	}
+}
+
+func decodeGRE(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 4 {
+		return nil
+	}
+	data := p.data[p.offset:]
+	flags := binary.BigEndian.Uint16(data[0:2])
+	length := 4
+	if flags&0x8000 != 0 { // checksum
+		length += 4
+	}
+	if flags&0x2000 != 0 { // key
+		length += 4
+	}
+	if flags&0x1000 != 0 { // sequence number
+		length += 4
+	}
+	if flags&0x7 == 1 && flags&0x80 != 0 { // acknowledgment number of enhanced GRE (PPTP)
+		length += 4
+	}
+	p.offset += length
+	return p.nextEtherType(binary.BigEndian.Uint16(data[2:4]))
+}
+
+func decodeUDP(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 4 {
+		return nil
+	}
+	data := p.data[p.offset:]
+	p.srcPort = binary.BigEndian.Uint16(data[0:2])
+	p.dstPort = binary.BigEndian.Uint16(data[2:4])
+	if p.remaining() < 8 {
+		return nil
+	}
+	p.offset += 8
+	return udpPortDecoders[p.dstPort]
+}
+
+func decodeTCP(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 4 {
+		return nil
+	}
+	data := p.data[p.offset:]
+	p.srcPort = binary.BigEndian.Uint16(data[0:2])
+	p.dstPort = binary.BigEndian.Uint16(data[2:4])
+	if len(data) > 13 {
+		p.tcpFlags = data[13]
+	}
+	return nil
+}
+
+func decodeICMP(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 2 {
+		return nil
+	}
+	p.icmpType = p.data[p.offset]
+	p.icmpCode = p.data[p.offset+1]
+	return nil
+}
+
+func decodeVXLAN(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 8 || p.data[p.offset]&0x08 == 0 {
+		return nil
+	}
+	p.offset += 8
+	return decodeEthernet
+}
+
+func decodeGENEVE(p *sampledHeaderParser) layerDecoder {
+	if p.remaining() < 8 {
+		return nil
+	}
+	data := p.data[p.offset:]
+	if data[0]>>6 != 0 { // version
+		return nil
+	}
+	p.offset += 8 + int(data[0]&0x3f)*4
+	return p.nextEtherType(binary.BigEndian.Uint16(data[2:4]))
+}
+
+// fill copies the decoded layers into a flow message. The outermost and the
+// innermost IP headers are kept when the packet is encapsulated: the inner
+// one is the main header with decodeInner, the outer one otherwise.
+// The ports, TCP flags and ICMP type are those of the innermost header.
+func (p *sampledHeaderParser) fill(flowMessage *flowmessage.FlowMessage, decodeInner bool) {
+	if p.hasMac {
+		flowMessage.SrcMac = p.srcMac
+		flowMessage.DstMac = p.dstMac
+	}
+	if p.hasVlan {
+		flowMessage.VlanId = p.vlanId
+	}
+	flowMessage.HasPPP = p.hasPPP
+	flowMessage.PPPAddressControl = uint32(p.pppAddressControl)
+	if len(p.mplsLabels) > 0 {
+		setMPLSLabels(flowMessage, p.mplsLabels)
+	}
+
+	flowMessage.Etype = uint32(p.etherType)
+	if len(p.ipHeaders) == 0 {
+		return
+	}
+	main := p.ipHeaders[0]
+	if len(p.ipHeaders) > 1 {
+		encap := p.ipHeaders[len(p.ipHeaders)-1]
+		if decodeInner {
+			main, encap = encap, main
+		}
+		flowMessage.HasEncap = true
+		flowMessage.EtypeEncap = uint32(encap.etherType)
+		flowMessage.SrcAddrEncap = encap.src
+		flowMessage.DstAddrEncap = encap.dst
+		flowMessage.ProtoEncap = uint32(encap.proto)
+		flowMessage.IPTosEncap = uint32(encap.tos)
+		flowMessage.IPTTLEncap = uint32(encap.ttl)
+		flowMessage.FragmentIdEncap = encap.fragId
+		flowMessage.FragmentOffsetEncap = uint32(encap.fragOffset)
+		flowMessage.IPv6FlowLabelEncap = encap.flowLabel
+	}
+	flowMessage.Etype = uint32(main.etherType)
+	flowMessage.SrcAddr = main.src
+	flowMessage.DstAddr = main.dst
+	flowMessage.Proto = uint32(main.proto)
+	flowMessage.IPTos = uint32(main.tos)
+	flowMessage.IPTTL = uint32(main.ttl)
+	flowMessage.FragmentId = main.fragId
+	flowMessage.FragmentOffset = uint32(main.fragOffset)
+	flowMessage.IPv6FlowLabel = main.flowLabel
+
+	flowMessage.SrcPort = uint32(p.srcPort)
+	flowMessage.DstPort = uint32(p.dstPort)
+	flowMessage.TCPFlags = uint32(p.tcpFlags)
+	flowMessage.IcmpType = uint32(p.icmpType)
+	flowMessage.IcmpCode = uint32(p.icmpCode)
+}
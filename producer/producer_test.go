@@ -1,10 +1,17 @@
 package producer
 
 import (
+	"encoding/binary"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cloudflare/goflow/v3/decoders/netflow"
 	"github.com/cloudflare/goflow/v3/decoders/sflow"
+	"github.com/cloudflare/goflow/v3/internal/testhelpers"
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+	proto "github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,6 +46,175 @@ func TestProcessMessageNetFlow(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestProcessMessageNetFlowScopedSamplingRates(t *testing.T) {
+	options := netflow.OptionsDataFlowSet{
+		Records: []netflow.OptionsDataRecord{
+			{
+				ScopesValues: []netflow.DataField{{Type: 1, Value: []byte{0, 0, 0, 0}}},
+				OptionsValues: []netflow.DataField{
+					{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_ID, Value: []byte{1}},
+					{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_RANDOM_INTERVAL, Value: []byte{0, 0, 0, 100}},
+				},
+			},
+			{
+				ScopesValues: []netflow.DataField{{Type: 1, Value: []byte{0, 0, 0, 0}}},
+				OptionsValues: []netflow.DataField{
+					{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_ID, Value: []byte{2}},
+					{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_RANDOM_INTERVAL, Value: []byte{0, 0, 0x03, 0xe8}},
+				},
+			},
+			{
+				ScopesValues:  []netflow.DataField{{Type: 2, Value: []byte{0, 0, 0, 7}}},
+				OptionsValues: []netflow.DataField{{Type: netflow.NFV9_FIELD_SAMPLING_INTERVAL, Value: []byte{0, 0, 0, 10}}},
+			},
+			{
+				ScopesValues:  []netflow.DataField{{Type: 1, Value: []byte{0, 0, 0, 0}}},
+				OptionsValues: []netflow.DataField{{Type: netflow.NFV9_FIELD_SAMPLING_INTERVAL, Value: []byte{0, 0, 0, 50}}},
+			},
+		},
+	}
+	data := netflow.DataFlowSet{
+		Records: []netflow.DataRecord{
+			{Values: []netflow.DataField{{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_ID, Value: []byte{2}}}},
+			{Values: []netflow.DataField{{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_ID, Value: []byte{1}}}},
+			{Values: []netflow.DataField{{Type: netflow.NFV9_FIELD_INPUT_SNMP, Value: []byte{0, 7}}}},
+			{Values: []netflow.DataField{{Type: netflow.NFV9_FIELD_INPUT_SNMP, Value: []byte{0, 8}}}},
+		},
+	}
+	samplingRates := CreateSamplingSystem()
+
+	msgs, err := ProcessMessageNetFlow(netflow.NFv9Packet{SourceId: 1, FlowSets: []interface{}{options, data}}, samplingRates)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, uint64(1000), msgs[0].SamplingRate)
+	assert.Equal(t, uint64(100), msgs[1].SamplingRate)
+	assert.Equal(t, uint64(10), msgs[2].SamplingRate)
+	// the rates of samplers and interfaces do not apply to the observation domain
+	assert.Equal(t, uint64(50), msgs[3].SamplingRate)
+
+	// the rates are kept for the next packets of the observation domain
+	msgs, err = ProcessMessageNetFlow(netflow.NFv9Packet{SourceId: 1, FlowSets: []interface{}{data}}, samplingRates)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), msgs[0].SamplingRate)
+	msgs, err = ProcessMessageNetFlow(netflow.NFv9Packet{SourceId: 2, FlowSets: []interface{}{data}}, samplingRates)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), msgs[0].SamplingRate)
+
+	time.Sleep(10 * time.Millisecond)
+	samplingRates.(ScopedSamplingRateSystem).RemoveExpiredScopedSamplingRates(5 * time.Millisecond)
+	msgs, err = ProcessMessageNetFlow(netflow.NFv9Packet{SourceId: 1, FlowSets: []interface{}{data}}, samplingRates)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(50), msgs[0].SamplingRate)
+}
+
+func TestSearchNetFlowOptionScopedSamplingRates(t *testing.T) {
+	options := []netflow.OptionsDataFlowSet{
+		{
+			Records: []netflow.OptionsDataRecord{
+				{
+					ScopesValues:  []netflow.DataField{{Type: netflow.IPFIX_FIELD_selectorId, Value: []byte{0, 0, 0, 0, 0, 0, 0, 5}}},
+					OptionsValues: []netflow.DataField{{Type: netflow.IPFIX_FIELD_samplingPacketInterval, Value: []byte{0, 0, 0x0f, 0xa0}}},
+				},
+				{
+					ScopesValues:  []netflow.DataField{{Type: netflow.IPFIX_FIELD_ingressInterface, Value: []byte{0, 0, 0, 3}}},
+					OptionsValues: []netflow.DataField{{Type: netflow.IPFIX_FIELD_samplingInterval, Value: []byte{0, 0, 0, 64}}},
+				},
+				{
+					// not scoped
+					ScopesValues:  []netflow.DataField{{Type: netflow.IPFIX_FIELD_exportingProcessId, Value: []byte{0, 0, 0, 1}}},
+					OptionsValues: []netflow.DataField{{Type: netflow.IPFIX_FIELD_samplingInterval, Value: []byte{0, 0, 0, 32}}},
+				},
+			},
+		},
+	}
+	assert.Equal(t, []ScopedSamplingRate{
+		{SamplingRateScope{SAMPLING_SCOPE_SELECTOR, 5}, 4000},
+		{SamplingRateScope{SAMPLING_SCOPE_INTERFACE, 3}, 64},
+	}, SearchNetFlowOptionScopedSamplingRates(10, options))
+}
+
+func TestProcessNetFlowInterfaces(t *testing.T) {
+	options := netflow.OptionsDataFlowSet{
+		Records: []netflow.OptionsDataRecord{
+			{
+				ScopesValues: []netflow.DataField{{Type: 2, Value: []byte{0, 0, 0, 3}}},
+				OptionsValues: []netflow.DataField{
+					{Type: netflow.NFV9_FIELD_IF_NAME, Value: []byte("et-0/0/3\x00\x00\x00\x00")},
+				},
+			},
+			{
+				ScopesValues: []netflow.DataField{{Type: 1, Value: []byte{0, 0, 0, 0}}},
+				OptionsValues: []netflow.DataField{
+					{Type: netflow.NFV9_FIELD_INPUT_SNMP, Value: []byte{0, 4}},
+					{Type: netflow.NFV9_FIELD_IF_NAME, Value: []byte("et-0/0/4")},
+					{Type: netflow.NFV9_FIELD_IF_DESC, Value: []byte("transit")},
+				},
+			},
+		},
+	}
+	data := netflow.DataFlowSet{
+		Records: []netflow.DataRecord{
+			{Values: []netflow.DataField{
+				{Type: netflow.NFV9_FIELD_INPUT_SNMP, Value: []byte{0, 3}},
+				{Type: netflow.NFV9_FIELD_OUTPUT_SNMP, Value: []byte{0, 4}},
+			}},
+			{Values: []netflow.DataField{
+				{Type: netflow.NFV9_FIELD_INPUT_SNMP, Value: []byte{0, 5}},
+			}},
+		},
+	}
+	pkt := netflow.NFv9Packet{FlowSets: []interface{}{options, data}}
+	table := CreateInterfaceTable()
+	msgs, err := ProcessMessageNetFlow(pkt, nil)
+	assert.Nil(t, err)
+	ProcessNetFlowInterfaces(9, []netflow.OptionsDataFlowSet{options}, table, msgs)
+	assert.Equal(t, "et-0/0/3", msgs[0].InIfName)
+	assert.Equal(t, "et-0/0/4", msgs[0].OutIfName)
+	assert.Equal(t, "", msgs[1].InIfName)
+
+	// a description sent alone keeps the name
+	table.AddInterface(3, InterfaceName{Description: "peering"})
+	assert.Equal(t, map[uint32]InterfaceName{
+		3: {Name: "et-0/0/3", Description: "peering"},
+		4: {Name: "et-0/0/4", Description: "transit"},
+	}, table.GetInterfaces())
+
+	table.updated[4] = time.Now().Add(-2 * time.Hour)
+	table.RemoveExpiredInterfaces(time.Hour)
+	assert.Equal(t, map[uint32]InterfaceName{
+		3: {Name: "et-0/0/3", Description: "peering"},
+	}, table.GetInterfaces())
+}
+
+func TestConvertNetFlowEnterpriseField(t *testing.T) {
+	RegisterNetFlowEnterpriseField(29305, 12, func(flowMessage *flowmessage.FlowMessage, value []byte) {
+		DecodeUNumber(value, &(flowMessage.DstVlan))
+	})
+
+	record := []netflow.DataField{
+		{
+			Type:  netflow.IPFIX_FIELD_destinationTransportPort,
+			Value: []byte{0, 80},
+		},
+		{
+			PenProvided: true,
+			Type:        12,
+			Pen:         29305,
+			Value:       []byte{0, 42},
+		},
+		{
+			PenProvided: true,
+			Type:        netflow.IPFIX_FIELD_sourceTransportPort,
+			Pen:         9,
+			Value:       []byte{0, 22},
+		},
+	}
+	fmsg := ConvertNetFlowDataSet(10, 0, 0, record)
+	assert.Equal(t, uint32(80), fmsg.DstPort)
+	assert.Equal(t, uint32(42), fmsg.DstVlan)
+	assert.Equal(t, uint32(0), fmsg.SrcPort)
+}
+
 func TestProcessMessageSFlow(t *testing.T) {
 	sh := sflow.SampledHeader{
 		FrameLength: 10,
@@ -76,3 +252,556 @@ func TestProcessMessageSFlow(t *testing.T) {
 	_, err := ProcessMessageSFlow(pkt)
 	assert.Nil(t, err)
 }
+
+func TestProcessMessageSFlowExtendedRecords(t *testing.T) {
+	pkt := sflow.Packet{
+		Version: 5,
+		Samples: []interface{}{
+			sflow.FlowSample{
+				SamplingRate: 1,
+				Records: []sflow.FlowRecord{
+					{
+						Data: sflow.ExtendedNAT{SrcIPVersion: 1, SrcIP: []byte{198, 51, 100, 1}, DstIPVersion: 1, DstIP: []byte{203, 0, 113, 1}},
+					},
+					{
+						Data: sflow.ExtendedMPLS{InLabelStack: []uint32{100<<12 | 64, 200<<12 | 0x100 | 63}},
+					},
+					{
+						Header: sflow.RecordHeader{DataFormat: sflow.FORMAT_EXT_VNI_INGRESS},
+						Data:   sflow.ExtendedVNI{VNI: 4096},
+					},
+					{
+						Header: sflow.RecordHeader{DataFormat: sflow.FORMAT_EXT_VNI_EGRESS},
+						Data:   sflow.ExtendedVNI{VNI: 8192},
+					},
+				},
+			},
+		},
+	}
+	msgs, err := ProcessMessageSFlow(pkt)
+	assert.Nil(t, err)
+	if assert.Len(t, msgs, 1) {
+		msg := msgs[0]
+		assert.Equal(t, []byte{198, 51, 100, 1}, msg.SrcAddrNat)
+		assert.Equal(t, []byte{203, 0, 113, 1}, msg.DstAddrNat)
+		assert.True(t, msg.HasMPLS)
+		assert.Equal(t, uint32(2), msg.MPLSCount)
+		assert.Equal(t, uint32(100), msg.MPLS1Label)
+		assert.Equal(t, uint32(64), msg.MPLS1TTL)
+		assert.Equal(t, uint32(200), msg.MPLSLastLabel)
+		assert.Equal(t, uint32(63), msg.MPLSLastTTL)
+		assert.Equal(t, uint32(4096), msg.VniIngress)
+		assert.Equal(t, uint32(8192), msg.VniEgress)
+	}
+}
+
+func TestProcessMessageSFlowCounters(t *testing.T) {
+	pkt := sflow.Packet{
+		Version:        5,
+		AgentIP:        []byte{192, 0, 2, 1},
+		SequenceNumber: 12,
+		Samples: []interface{}{
+			sflow.CounterSample{
+				Header: sflow.SampleHeader{SourceIdValue: 3},
+				Records: []sflow.CounterRecord{
+					{
+						Data: sflow.IfCounters{IfIndex: 3, IfSpeed: 10000000000, IfInOctets: 1500, IfOutOctets: 3000},
+					},
+					{
+						Data: sflow.EthernetCounters{Dot3StatsFCSErrors: 2},
+					},
+				},
+			},
+			// no interface counters
+			sflow.CounterSample{
+				Header: sflow.SampleHeader{SourceIdValue: 4},
+			},
+			sflow.FlowSample{
+				SamplingRate: 1,
+			},
+		},
+	}
+	countersMessages, err := ProcessMessageSFlowCounters(pkt)
+	assert.Nil(t, err)
+	if assert.Len(t, countersMessages, 1) {
+		cmsg := countersMessages[0]
+		assert.Equal(t, []byte{192, 0, 2, 1}, cmsg.SamplerAddress)
+		assert.Equal(t, uint32(12), cmsg.SequenceNum)
+		assert.Equal(t, uint32(3), cmsg.IfIndex)
+		assert.Equal(t, uint64(10000000000), cmsg.IfSpeed)
+		assert.Equal(t, uint64(1500), cmsg.IfInOctets)
+		assert.Equal(t, uint64(3000), cmsg.IfOutOctets)
+		assert.True(t, cmsg.HasEthernetCounters)
+		assert.Equal(t, uint32(2), cmsg.Dot3StatsFCSErrors)
+	}
+}
+
+func TestNetFlowMapping(t *testing.T) {
+	mappingYAML := `
+ipfix:
+  mapping:
+    - field: 225
+      destination: 1000
+      decoding: ip
+    - field: 12
+      pen: 29305
+      destination: 34
+    - field: 152
+      destination: 38
+      decoding: ts_milliseconds
+`
+	mapping, err := LoadNetFlowMapping(strings.NewReader(mappingYAML))
+	assert.Nil(t, err)
+	config, err := CreateNetFlowProducerConfig(mapping)
+	assert.Nil(t, err)
+
+	record := []netflow.DataField{
+		{
+			Type:  netflow.IPFIX_FIELD_postNATSourceIPv4Address,
+			Value: []byte{192, 0, 2, 1},
+		},
+		{
+			PenProvided: true,
+			Type:        12,
+			Pen:         29305,
+			Value:       []byte{0, 42},
+		},
+		{
+			Type:  netflow.IPFIX_FIELD_flowStartMilliseconds,
+			Value: []byte{0, 0, 1, 0x74, 0x87, 0x6e, 0x80, 0x00},
+		},
+	}
+	fmsg := ConvertNetFlowDataSetConfig(10, 0, 0, record, config)
+	assert.Equal(t, uint32(42), fmsg.DstVlan)
+	assert.Equal(t, uint64(1600000000), fmsg.TimeFlowStart)
+
+	b, err := proto.Marshal(fmsg)
+	assert.Nil(t, err)
+	decoded := &flowmessage.FlowMessage{}
+	assert.Nil(t, proto.Unmarshal(b, decoded))
+	// field 1000, length-delimited
+	assert.Equal(t, []byte{0xc2, 0x3e, 0x04, 192, 0, 2, 1}, decoded.XXX_unrecognized)
+}
+
+func TestNetFlowMappingJSON(t *testing.T) {
+	mapping, err := LoadNetFlowMapping(strings.NewReader(`{"netflowv9": {"mapping": [{"field": 22, "destination": 1001, "decoding": "ts_uptime"}]}}`))
+	assert.Nil(t, err)
+	config, err := CreateNetFlowProducerConfig(mapping)
+	assert.Nil(t, err)
+
+	record := []netflow.DataField{
+		{
+			Type:  netflow.NFV9_FIELD_FIRST_SWITCHED,
+			Value: []byte{0, 0, 0x03, 0xe8},
+		},
+	}
+	fmsg := ConvertNetFlowDataSetConfig(9, 1600000000, 11000, record, config)
+	b, err := proto.Marshal(fmsg)
+	assert.Nil(t, err)
+	// field 1001, varint 1599999990
+	assert.Contains(t, string(b), string([]byte{0xc8, 0x3e, 0xf6, 0x9f, 0xf8, 0xfa, 0x05}))
+}
+
+func TestNetFlowMappingInvalid(t *testing.T) {
+	_, err := CreateNetFlowProducerConfig(&NetFlowMapping{
+		IPFIX: NetFlowProtocolMapping{
+			Mapping: []NetFlowMapField{{Type: 1, Destination: 1000, Decoding: "float"}},
+		},
+	})
+	assert.NotNil(t, err)
+
+	_, err = CreateNetFlowProducerConfig(&NetFlowMapping{
+		NetFlowV9: NetFlowProtocolMapping{
+			Mapping: []NetFlowMapField{{Type: 1, Pen: 9, Destination: 1000}},
+		},
+	})
+	assert.NotNil(t, err)
+
+	// destinations which cannot hold the values
+	for _, field := range []NetFlowMapField{
+		{Type: 8, Destination: 9, Decoding: MAPPING_DECODING_IP},
+		{Type: 150, Destination: 6, Decoding: MAPPING_DECODING_TS_SECONDS},
+		{Type: 1, Destination: 1},
+	} {
+		_, err = CreateNetFlowProducerConfig(&NetFlowMapping{
+			IPFIX: NetFlowProtocolMapping{Mapping: []NetFlowMapField{field}},
+		})
+		assert.NotNil(t, err)
+	}
+}
+
+func TestNetFlowMappingErrorCallback(t *testing.T) {
+	config, err := CreateNetFlowProducerConfig(&NetFlowMapping{
+		IPFIX: NetFlowProtocolMapping{
+			Mapping: []NetFlowMapField{
+				{Type: 1000, Destination: 18},
+				{Type: 1001, Destination: 6, Decoding: MAPPING_DECODING_IP},
+			},
+		},
+	})
+	assert.Nil(t, err)
+	var errs []int32
+	config.MappingErrorCallback = func(field NetFlowMapField, err error) {
+		errs = append(errs, field.Destination)
+	}
+
+	record := []netflow.DataField{
+		{Type: 1000, Value: []byte{1, 0, 0, 0, 0}},
+		{Type: 1001, Value: []byte{10, 0, 0}},
+	}
+	fmsg := ConvertNetFlowDataSetConfig(10, 0, 0, record, config)
+	assert.Equal(t, uint32(0), fmsg.InIf)
+	assert.Nil(t, fmsg.SrcAddr)
+	assert.Equal(t, []int32{18, 6}, errs)
+}
+
+func TestSamplingRateConfig(t *testing.T) {
+	samplingYAML := `
+sampling:
+  - network: 192.0.2.1/32
+    interface: 12
+    rate: 100
+    mode: override
+  - network: 192.0.2.0/24
+    obs_domain_id: 256
+    rate: 512
+  - network: 192.0.2.0/24
+    rate: 1000
+`
+	mapping, err := LoadSamplingRateMapping(strings.NewReader(samplingYAML))
+	assert.Nil(t, err)
+	config, err := CreateSamplingRateConfig(mapping)
+	assert.Nil(t, err)
+
+	assert.Nil(t, config.Router(net.ParseIP("198.51.100.1")))
+	rates := config.Router(net.ParseIP("192.0.2.1"))
+	assert.NotNil(t, rates)
+
+	testCases := []struct {
+		obsDomainId uint32
+		inIf        uint32
+		learned     uint32
+		rate        uint32
+		source      string
+	}{
+		{0, 12, 10, 100, SAMPLING_SOURCE_OVERRIDE},
+		{0, 13, 10, 10, SAMPLING_SOURCE_LEARNED},
+		{256, 13, 0, 512, SAMPLING_SOURCE_DEFAULT},
+		{0, 13, 0, 1000, SAMPLING_SOURCE_DEFAULT},
+	}
+	for _, tc := range testCases {
+		rate, source := rates.Resolve(tc.obsDomainId, tc.inIf, tc.learned)
+		assert.Equal(t, tc.rate, rate)
+		assert.Equal(t, tc.source, source)
+	}
+
+	var none *RouterSamplingRates
+	rate, source := none.Resolve(0, 0, 0)
+	assert.Equal(t, uint32(0), rate)
+	assert.Equal(t, SAMPLING_SOURCE_NONE, source)
+}
+
+func TestSamplingRateConfigInvalid(t *testing.T) {
+	for _, rule := range []SamplingRateRule{
+		{Network: "192.0.2.1", Rate: 100},
+		{Network: "192.0.2.0/24"},
+		{Network: "192.0.2.0/24", Rate: 100, Mode: "fixed"},
+	} {
+		_, err := CreateSamplingRateConfig(&SamplingRateMapping{Sampling: []SamplingRateRule{rule}})
+		assert.NotNil(t, err)
+	}
+}
+
+func getBenchmarkPacket() netflow.IPFIXPacket {
+	records := make([]netflow.DataRecord, testhelpers.BENCHMARK_RECORDS)
+	for i := range records {
+		records[i].Values = []netflow.DataField{
+			{Type: netflow.IPFIX_FIELD_sourceIPv4Address, Value: []byte{10, 0, 0, byte(i)}},
+			{Type: netflow.IPFIX_FIELD_destinationIPv4Address, Value: []byte{10, 0, 1, byte(i)}},
+			{Type: netflow.IPFIX_FIELD_sourceTransportPort, Value: []byte{4, 0}},
+			{Type: netflow.IPFIX_FIELD_destinationTransportPort, Value: []byte{1, 187}},
+			{Type: netflow.IPFIX_FIELD_octetDeltaCount, Value: []byte{0, 0, 0, 0, 0, 0, 5, 220}},
+			{Type: netflow.IPFIX_FIELD_packetDeltaCount, Value: []byte{0, 0, 0, 0, 0, 0, 0, 1}},
+		}
+	}
+	return netflow.IPFIXPacket{
+		Version:             10,
+		ExportTime:          1600000000,
+		ObservationDomainId: 1,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{Records: records},
+		},
+	}
+}
+
+// BenchmarkProcessMessageNetFlow does not release the messages, for
+// comparison with BenchmarkProcessMessageNetFlowRelease.
+func BenchmarkProcessMessageNetFlow(b *testing.B) {
+	pkt := getBenchmarkPacket()
+	samplingRates := CreateSamplingSystem()
+	testhelpers.ReportAllocsPerFlow(b, func() {
+		_, err := ProcessMessageNetFlow(pkt, samplingRates)
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkProcessMessageNetFlowRelease(b *testing.B) {
+	pkt := getBenchmarkPacket()
+	samplingRates := CreateSamplingSystem()
+	testhelpers.ReportAllocsPerFlow(b, func() {
+		msgs, err := ProcessMessageNetFlow(pkt, samplingRates)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ReleaseFlowMessages(msgs)
+	})
+}
+
+func TestReleaseFlowMessages(t *testing.T) {
+	msg := NewFlowMessage()
+	msg.Bytes = 1500
+	msg.SrcAddr = []byte{10, 0, 0, 1}
+	ReleaseFlowMessages([]*flowmessage.FlowMessage{msg, nil})
+	assert.Equal(t, uint64(0), msg.Bytes)
+	assert.Nil(t, msg.SrcAddr)
+	assert.Equal(t, uint64(0), NewFlowMessage().Bytes)
+}
+
+// sampledHeaderCaptures are sampled headers captured on routers.
+var sampledHeaderCaptures = [][]byte{
+	// IPv6 TCP
+	{
+		0xff, 0xab, 0xcd, 0xef, 0xab, 0xcd, 0xff, 0xab, 0xcd, 0xef, 0xab, 0xbc, 0x86, 0xdd, 0x60, 0x2e,
+		0xc4, 0xec, 0x01, 0xcc, 0x06, 0x40, 0xfd, 0x01, 0x00, 0x00, 0xff, 0x01, 0x82, 0x10, 0xcd, 0xff,
+		0xff, 0x1c, 0x00, 0x00, 0x01, 0x50, 0xfd, 0x01, 0x00, 0x00, 0xff, 0x01, 0x00, 0x01, 0x02, 0xff,
+		0xff, 0x93, 0x00, 0x00, 0x02, 0x46, 0xcf, 0xca, 0x00, 0x50, 0x05, 0x15, 0x21, 0x6f, 0xa4, 0x9c,
+		0xf4, 0x59, 0x80, 0x18, 0x08, 0x09, 0x8c, 0x86, 0x00, 0x00, 0x01, 0x01, 0x08, 0x0a, 0x2a, 0x85,
+		0xee, 0x9e, 0x64, 0x5c, 0x27, 0x28,
+	},
+	// 802.1Q IPv4 TCP
+	{
+		0x08, 0xec, 0xf5, 0x2a, 0x8f, 0xbe, 0x74, 0x83, 0xef, 0x30, 0x65, 0xb7, 0x81, 0x00, 0x00, 0x1e,
+		0x08, 0x00, 0x45, 0x00, 0x05, 0xd4, 0x3b, 0xba, 0x40, 0x00, 0x3f, 0x06, 0xbd, 0x99, 0xb9, 0x3b,
+		0xdc, 0x93, 0x58, 0xee, 0x4e, 0x13, 0x01, 0xbb, 0xcf, 0xd6, 0x45, 0xb7, 0x1b, 0xc0, 0xd5, 0xb8,
+		0xff, 0x24, 0x80, 0x10, 0x00, 0x04, 0x01, 0x55, 0x00, 0x00, 0x01, 0x01, 0x08, 0x0a, 0xc8, 0xc8,
+		0x56, 0x95, 0x00, 0x34, 0xf6, 0x0f, 0xe8, 0x1d, 0xbd, 0x41, 0x45, 0x92, 0x4c, 0xc2, 0x71, 0xe0,
+		0xeb, 0x2e, 0x35, 0x17, 0x7c, 0x2f, 0xb9, 0xa8, 0x05, 0x92, 0x0e, 0x03, 0x1b, 0x50, 0x53, 0x0c,
+		0xe5, 0x7d, 0x86, 0x75, 0x32, 0x8a, 0xcc, 0xe2, 0x26, 0xa8, 0x90, 0x21, 0x78, 0xbf, 0xce, 0x7a,
+		0xf8, 0xb5, 0x8d, 0x48, 0xe4, 0xaa, 0xfe, 0x26, 0x34, 0xe0, 0xad, 0xb9, 0xec, 0x79, 0x74, 0xd8,
+	},
+}
+
+func concatLayers(layers ...[]byte) []byte {
+	var header []byte
+	for _, layer := range layers {
+		header = append(header, layer...)
+	}
+	return header
+}
+
+func ethernetLayer(etherType uint16) []byte {
+	layer := []byte{0x02, 0, 0, 0, 0, 0x02, 0x02, 0, 0, 0, 0, 0x01}
+	return binary.BigEndian.AppendUint16(layer, etherType)
+}
+
+func vlanLayer(vlanId uint16, etherType uint16) []byte {
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, 0x2000|vlanId), etherType)
+}
+
+func ipv4Layer(proto uint8, src string, dst string) []byte {
+	layer := []byte{0x45, 0x10, 0, 0, 0x12, 0x34, 0x40, 0, 64, proto, 0, 0}
+	layer = append(layer, net.ParseIP(src).To4()...)
+	return append(layer, net.ParseIP(dst).To4()...)
+}
+
+func ipv6Layer(nextHeader uint8, src string, dst string) []byte {
+	layer := []byte{0x6a, 0x00, 0x00, 0x01, 0, 0, nextHeader, 64}
+	layer = append(layer, net.ParseIP(src)...)
+	return append(layer, net.ParseIP(dst)...)
+}
+
+func udpLayer(src uint16, dst uint16) []byte {
+	layer := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, src), dst)
+	return append(layer, 0, 0, 0, 0)
+}
+
+func tcpLayer(src uint16, dst uint16, flags uint8) []byte {
+	layer := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, src), dst)
+	layer = append(layer, make([]byte, 9)...)
+	return append(layer, flags, 0, 0, 0, 0, 0, 0)
+}
+
+func TestParseSampledHeader(t *testing.T) {
+	vxlan := concatLayers(ethernetLayer(0x0800), ipv4Layer(17, "192.0.2.1", "192.0.2.2"), udpLayer(50000, 4789),
+		[]byte{0x08, 0, 0, 0, 0, 0x10, 0, 0},
+		ethernetLayer(0x0800), ipv4Layer(6, "10.0.0.1", "10.0.0.2"), tcpLayer(12345, 443, 0x02))
+
+	testCases := []struct {
+		name     string
+		protocol uint32
+		header   []byte
+		config   *SFlowProducerConfig
+		check    func(t *testing.T, msg *flowmessage.FlowMessage)
+	}{
+		{
+			name:   "802.1Q capture",
+			header: sampledHeaderCaptures[1],
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, uint32(30), msg.VlanId)
+				assert.Equal(t, uint32(0x0800), msg.Etype)
+				assert.Equal(t, net.IP{185, 59, 220, 147}, net.IP(msg.SrcAddr))
+				assert.Equal(t, uint32(443), msg.SrcPort)
+				assert.Equal(t, uint32(0x10), msg.TCPFlags)
+			},
+		},
+		{
+			name:   "QinQ",
+			header: concatLayers(ethernetLayer(0x88a8), vlanLayer(100, 0x8100), vlanLayer(200, 0x0800), ipv4Layer(17, "192.0.2.1", "192.0.2.2"), udpLayer(53, 5353)),
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, uint32(100), msg.VlanId)
+				assert.Equal(t, uint32(17), msg.Proto)
+				assert.Equal(t, uint32(5353), msg.DstPort)
+			},
+		},
+		{
+			name:   "VXLAN",
+			header: vxlan,
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.True(t, msg.HasEncap)
+				assert.Equal(t, net.IP{192, 0, 2, 1}, net.IP(msg.SrcAddr))
+				assert.Equal(t, net.IP{10, 0, 0, 1}, net.IP(msg.SrcAddrEncap))
+				assert.Equal(t, uint32(17), msg.Proto)
+				assert.Equal(t, uint32(6), msg.ProtoEncap)
+				assert.Equal(t, uint32(443), msg.DstPort)
+				assert.Equal(t, uint32(0x02), msg.TCPFlags)
+			},
+		},
+		{
+			name:   "VXLAN inner header",
+			header: vxlan,
+			config: &SFlowProducerConfig{DecodeGRE: true},
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, net.IP{10, 0, 0, 1}, net.IP(msg.SrcAddr))
+				assert.Equal(t, net.IP{192, 0, 2, 1}, net.IP(msg.SrcAddrEncap))
+			},
+		},
+		{
+			name:   "VXLAN not decoded",
+			header: vxlan,
+			config: &SFlowProducerConfig{MaxEncapDepth: -1},
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.False(t, msg.HasEncap)
+				assert.Equal(t, uint32(4789), msg.DstPort)
+			},
+		},
+		{
+			name: "GENEVE",
+			header: concatLayers(ethernetLayer(0x0800), ipv4Layer(17, "192.0.2.1", "192.0.2.2"), udpLayer(50000, 6081),
+				[]byte{0x01, 0, 0x65, 0x58, 0, 0, 0x10, 0}, make([]byte, 4), // one word of options
+				ethernetLayer(0x86dd), ipv6Layer(58, "2001:db8::1", "2001:db8::2"), []byte{128, 0}),
+			config: &SFlowProducerConfig{DecodeGRE: true},
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, uint32(0x86dd), msg.Etype)
+				assert.Equal(t, uint32(0x0800), msg.EtypeEncap)
+				assert.Equal(t, uint32(58), msg.Proto)
+				assert.Equal(t, uint32(128), msg.IcmpType)
+			},
+		},
+		{
+			name: "GRE with key and sequence number",
+			header: concatLayers(ethernetLayer(0x0800), ipv4Layer(47, "192.0.2.1", "192.0.2.2"),
+				[]byte{0x30, 0, 0x08, 0, 0, 0, 0, 42, 0, 0, 0, 1},
+				ipv4Layer(6, "10.0.0.1", "10.0.0.2"), tcpLayer(12345, 22, 0x18)),
+			config: &SFlowProducerConfig{DecodeGRE: true},
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.True(t, msg.HasEncap)
+				assert.Equal(t, net.IP{10, 0, 0, 2}, net.IP(msg.DstAddr))
+				assert.Equal(t, uint32(47), msg.ProtoEncap)
+				assert.Equal(t, uint32(22), msg.DstPort)
+			},
+		},
+		{
+			name: "GRE nested beyond the depth",
+			header: concatLayers(ethernetLayer(0x0800), ipv4Layer(47, "192.0.2.1", "192.0.2.2"), []byte{0, 0, 0x08, 0},
+				ipv4Layer(47, "198.51.100.1", "198.51.100.2"), []byte{0, 0, 0x08, 0},
+				ipv4Layer(6, "10.0.0.1", "10.0.0.2"), tcpLayer(12345, 22, 0x18)),
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, net.IP{198, 51, 100, 1}, net.IP(msg.SrcAddrEncap))
+				assert.Zero(t, msg.DstPort)
+			},
+		},
+		{
+			name:   "IPv6 in IPv4",
+			header: concatLayers(ethernetLayer(0x0800), ipv4Layer(41, "192.0.2.1", "192.0.2.2"), ipv6Layer(17, "2001:db8::1", "2001:db8::2"), udpLayer(53, 53)),
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, uint32(0x86dd), msg.EtypeEncap)
+				assert.Equal(t, net.ParseIP("2001:db8::2"), net.IP(msg.DstAddrEncap))
+				assert.Equal(t, uint32(53), msg.SrcPort)
+			},
+		},
+		{
+			name: "IPv6 extension headers",
+			header: concatLayers(ethernetLayer(0x86dd), ipv6Layer(0, "2001:db8::1", "2001:db8::2"),
+				[]byte{44, 0, 1, 4, 0, 0, 0, 0},       // hop-by-hop options
+				[]byte{17, 0, 0, 1, 0, 0, 0x12, 0x34}, // first fragment
+				udpLayer(4500, 500)),
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, uint32(17), msg.Proto)
+				assert.Equal(t, uint32(0x1234), msg.FragmentId)
+				assert.Equal(t, uint32(500), msg.DstPort)
+				assert.Equal(t, uint32(1), msg.IPv6FlowLabel)
+			},
+		},
+		{
+			name:   "MPLS",
+			header: concatLayers(ethernetLayer(0x8847), []byte{0, 0x06, 0x40, 0x3f, 0, 0x0c, 0x81, 0x3e}, ipv4Layer(1, "192.0.2.1", "192.0.2.2"), []byte{8, 0}),
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.True(t, msg.HasMPLS)
+				assert.Equal(t, uint32(2), msg.MPLSCount)
+				assert.Equal(t, uint32(100), msg.MPLS1Label)
+				assert.Equal(t, uint32(200), msg.MPLSLastLabel)
+				assert.Equal(t, uint32(0x0800), msg.Etype)
+				assert.Equal(t, uint32(8), msg.IcmpType)
+			},
+		},
+		{
+			name:     "raw IPv4",
+			protocol: 11,
+			header:   concatLayers(ipv4Layer(6, "192.0.2.1", "192.0.2.2"), tcpLayer(80, 12345, 0x11)),
+			check: func(t *testing.T, msg *flowmessage.FlowMessage) {
+				assert.Equal(t, net.IP{192, 0, 2, 2}, net.IP(msg.DstAddr))
+				assert.Equal(t, uint32(80), msg.SrcPort)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			protocol := testCase.protocol
+			if protocol == 0 {
+				protocol = 1
+			}
+			var msg flowmessage.FlowMessage
+			err := ParseSampledHeaderConfig(&msg, &sflow.SampledHeader{Protocol: protocol, HeaderData: testCase.header}, testCase.config)
+			assert.Nil(t, err)
+			testCase.check(t, &msg)
+		})
+	}
+}
+
+func FuzzParseSampledHeader(f *testing.F) {
+	for _, header := range sampledHeaderCaptures {
+		f.Add(uint32(1), header, false, 1)
+	}
+	f.Add(uint32(1), concatLayers(ethernetLayer(0x0800), ipv4Layer(17, "192.0.2.1", "192.0.2.2"), udpLayer(50000, 4789),
+		[]byte{0x08, 0, 0, 0, 0, 0x10, 0, 0}, ethernetLayer(0x8100), vlanLayer(10, 0x86dd), ipv6Layer(0, "2001:db8::1", "2001:db8::2")), true, 2)
+	f.Add(uint32(12), ipv6Layer(44, "2001:db8::1", "2001:db8::2"), false, 1)
+	f.Fuzz(func(t *testing.T, protocol uint32, data []byte, decodeGRE bool, maxDepth int) {
+		var msg flowmessage.FlowMessage
+		ParseSampledHeaderConfig(&msg, &sflow.SampledHeader{Protocol: protocol, HeaderData: data}, &SFlowProducerConfig{
+			DecodeGRE:     decodeGRE,
+			MaxEncapDepth: maxDepth % 8,
+		})
+	})
+}
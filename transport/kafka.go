@@ -20,8 +20,10 @@ var (
 	KafkaTLS   *bool
 	KafkaSASL  *bool
 	KafkaTopic *string
-	KafkaSrv   *string
-	KafkaBrk   *string
+	// sFlow interface counters are not sent when empty
+	KafkaCountersTopic *string
+	KafkaSrv           *string
+	KafkaBrk           *string
 
 	KafkaLogErrors *bool
 
@@ -34,10 +36,12 @@ var (
 
 type KafkaState struct {
 	FixedLengthProto bool
+	CountersTopic    string // topic of the sFlow interface counters (disabled when empty)
 	producer         sarama.AsyncProducer
 	topic            string
 	hashing          bool
 	keying           []string
+	errorsDone       chan bool
 }
 
 // SetKafkaVersion sets the KafkaVersion that is used to set the log message format version
@@ -55,6 +59,7 @@ func RegisterFlags() {
 	KafkaTLS = flag.Bool("kafka.tls", false, "Use TLS to connect to Kafka")
 	KafkaSASL = flag.Bool("kafka.sasl", false, "Use SASL/PLAIN data to connect to Kafka (TLS is recommended and the environment variables KAFKA_SASL_USER and KAFKA_SASL_PASS need to be set)")
 	KafkaTopic = flag.String("kafka.topic", "flow-messages", "Kafka topic to produce to")
+	KafkaCountersTopic = flag.String("kafka.counters.topic", "", "Kafka topic to produce the sFlow interface counters to (disabled when empty)")
 	KafkaSrv = flag.String("kafka.srv", "", "SRV record containing a list of Kafka brokers (or use kafka.out.brokers)")
 	KafkaBrk = flag.String("kafka.brokers", "127.0.0.1:9092,[::1]:9092", "Kafka brokers list separated by commas")
 
@@ -77,7 +82,12 @@ func StartKafkaProducerFromArgs(log utils.Logger) (*KafkaState, error) {
 	} else {
 		addrs = strings.Split(*KafkaBrk, ",")
 	}
-	return StartKafkaProducer(addrs, *KafkaTopic, *KafkaHashing, *KafkaKeying, *KafkaTLS, *KafkaSASL, *KafkaLogErrors, log)
+	state, err := StartKafkaProducer(addrs, *KafkaTopic, *KafkaHashing, *KafkaKeying, *KafkaTLS, *KafkaSASL, *KafkaLogErrors, log)
+	if err != nil {
+		return nil, err
+	}
+	state.CountersTopic = *KafkaCountersTopic
+	return state, nil
 }
 
 func StartKafkaProducer(addrs []string, topic string, hashing bool, keying string, useTls bool, useSasl bool, logErrors bool, log utils.Logger) (*KafkaState, error) {
@@ -118,27 +128,40 @@ func StartKafkaProducer(addrs []string, topic string, hashing bool, keying strin
 	if err != nil {
 		return nil, err
 	}
+	return newKafkaState(kafkaProducer, topic, hashing, keyingSplit, logErrors, log), nil
+}
+
+func newKafkaState(kafkaProducer sarama.AsyncProducer, topic string, hashing bool, keying []string, logErrors bool, log utils.Logger) *KafkaState {
 	state := KafkaState{
-		producer: kafkaProducer,
-		topic:    topic,
-		hashing:  hashing,
-		keying:   keyingSplit,
+		producer:   kafkaProducer,
+		topic:      topic,
+		hashing:    hashing,
+		keying:     keying,
+		errorsDone: make(chan bool),
 	}
 
 	if logErrors {
 		go func() {
-			for {
-				select {
-				case msg := <-kafkaProducer.Errors():
-					if log != nil {
-						log.Error(msg)
-					}
+			for msg := range kafkaProducer.Errors() {
+				if log != nil {
+					log.Error(msg)
 				}
 			}
+			close(state.errorsDone)
 		}()
+	} else {
+		close(state.errorsDone)
 	}
 
-	return &state, nil
+	return &state
+}
+
+// Close flushes the messages buffered by the producer and closes it.
+// Publish must not be called anymore.
+func (s KafkaState) Close() error {
+	err := s.producer.Close()
+	<-s.errorsDone
+	return err
 }
 
 func HashProto(fields []string, flowMessage *flowmessage.FlowMessage) string {
@@ -185,3 +208,33 @@ func (s KafkaState) Publish(msgs []*flowmessage.FlowMessage) {
 		s.SendKafkaFlowMessage(msg)
 	}
 }
+
+func (s KafkaState) SendKafkaCountersMessage(countersMessage *flowmessage.CountersMessage) {
+	var key sarama.Encoder
+	if s.hashing {
+		// keeps the counters of an interface in order
+		key = sarama.StringEncoder(fmt.Sprintf("%v-%v-", countersMessage.SamplerAddress, countersMessage.IfIndex))
+	}
+	var b []byte
+	if !s.FixedLengthProto {
+		b, _ = proto.Marshal(countersMessage)
+	} else {
+		buf := proto.NewBuffer([]byte{})
+		buf.EncodeMessage(countersMessage)
+		b = buf.Bytes()
+	}
+	s.producer.Input() <- &sarama.ProducerMessage{
+		Topic: s.CountersTopic,
+		Key:   key,
+		Value: sarama.ByteEncoder(b),
+	}
+}
+
+func (s KafkaState) PublishCounters(msgs []*flowmessage.CountersMessage) {
+	if s.CountersTopic == "" {
+		return
+	}
+	for _, msg := range msgs {
+		s.SendKafkaCountersMessage(msg)
+	}
+}
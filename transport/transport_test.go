@@ -1,8 +1,13 @@
 package transport
 
 import (
+	"errors"
+	"runtime"
 	"testing"
+	"time"
 
+	sarama "github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
 	flowmessage "github.com/cloudflare/goflow/v3/pb"
 	"github.com/stretchr/testify/assert"
 )
@@ -14,3 +19,44 @@ func TestHash(t *testing.T) {
 	key := HashProto([]string{"SamplerAddress", "InvalidField"}, msg)
 	assert.Equal(t, "[10 0 0 1]-", key, "The two keys should be the same.")
 }
+
+func TestKafkaClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Errors = true
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(errors.New("failed"))
+	producer.ExpectInputAndSucceed()
+
+	state := newKafkaState(producer, "flows", false, nil, true, nil)
+	state.Publish([]*flowmessage.FlowMessage{{}, {}, {}})
+
+	// every message was handed to the producer
+	assert.Nil(t, state.Close())
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines, "goroutines leaked")
+}
+
+func TestKafkaPublishCounters(t *testing.T) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+
+	state := newKafkaState(producer, "flows", true, nil, false, nil)
+	// disabled without topic
+	state.PublishCounters([]*flowmessage.CountersMessage{{IfIndex: 1}})
+
+	state.CountersTopic = "counters"
+	state.PublishCounters([]*flowmessage.CountersMessage{{SamplerAddress: []byte{10, 0, 0, 1}, IfIndex: 2}})
+	msg := <-producer.Successes()
+	assert.Equal(t, "counters", msg.Topic)
+	assert.Equal(t, sarama.StringEncoder("[10 0 0 1]-2-"), msg.Key)
+
+	assert.Nil(t, state.Close())
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	decoder "github.com/cloudflare/goflow/v3/decoders"
+	"github.com/cloudflare/goflow/v3/decoders/ipfixfile"
+)
+
+// IPFIXFileRoutine replays the messages of an IPFIX file (RFC 5655) in order.
+// The messages are attributed to src and received at their export time.
+// Templates are kept in the session of the file path.
+func IPFIXFileRoutine(name string, decodeFunc decoder.DecoderFunc, path string, src net.IP, logger Logger) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ecb := DefaultErrorCallback{
+		Logger: logger,
+	}
+
+	reader := ipfixfile.NewReader(f)
+	for {
+		payload, err := reader.ReadMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		exportTime := binary.BigEndian.Uint32(payload[4:8])
+		baseMessage := BaseMessage{
+			Src:      src,
+			Payload:  payload,
+			Session:  path,
+			SetTime:  true,
+			RecvTime: time.Unix(int64(exportTime), 0),
+		}
+
+		timeTrackStart := time.Now()
+		err = decodeFunc(baseMessage)
+		timeTrackStop := time.Now()
+		if err != nil {
+			ecb.Callback(name, 0, timeTrackStart, timeTrackStop, err)
+		} else {
+			DefaultAccountCallback(name, 0, timeTrackStart, timeTrackStop)
+		}
+	}
+}
+
+// FlowRoutineFile replays an IPFIX file as if its messages were sent by src.
+func (s *StateNetFlow) FlowRoutineFile(path string, src net.IP) error {
+	s.initTemplates()
+	defer s.DeleteSession(path)
+	return IPFIXFileRoutine("NetFlowFile", s.DecodeFlow, path, src, s.Logger)
+}
@@ -4,6 +4,7 @@ import (
 	"strconv"
 	"time"
 
+	decoder "github.com/cloudflare/goflow/v3/decoders"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -30,6 +31,34 @@ var (
 		},
 		[]string{"remote_ip", "remote_port", "local_ip", "local_port", "type"},
 	)
+	MetricTCPConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flow_tcp_connections",
+			Help: "Open TCP connections.",
+		},
+		[]string{"local_ip", "local_port", "type"},
+	)
+	MetricTCPConnectionsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_tcp_connections_count",
+			Help: "TCP connections accepted.",
+		},
+		[]string{"local_ip", "local_port", "type"},
+	)
+	MetricTeeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_tee_errors_count",
+			Help: "Received datagrams which could not be written to the raw capture.",
+		},
+		[]string{"type"},
+	)
+	MetricTeeDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_tee_dropped_count",
+			Help: "Received datagrams dropped because the raw capture queue was full.",
+		},
+		[]string{"type"},
+	)
 	DecoderStats = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "flow_decoder_count",
@@ -44,6 +73,20 @@ var (
 		},
 		[]string{"worker", "name"},
 	)
+	DecoderQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flow_decoder_queue_depth",
+			Help: "Messages waiting for a decoder worker.",
+		},
+		[]string{"name"},
+	)
+	DecoderDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_decoder_dropped_count",
+			Help: "Messages dropped because the decoder queue was full.",
+		},
+		[]string{"name", "router"},
+	)
 	DecoderTime = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
 			Name:       "flow_summary_decoding_time_us",
@@ -103,6 +146,41 @@ var (
 		},
 		[]string{"router", "version", "obs_domain_id", "template_id", "type"}, // options/template
 	)
+	NetFlowTemplatesChanged = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_process_nf_templates_changed_count",
+			Help: "NetFlow/IPFIX templates redefined with different fields.",
+		},
+		[]string{"router", "version", "obs_domain_id", "template_id"},
+	)
+	NetFlowTemplatesWithdrawn = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_process_nf_templates_withdrawn_count",
+			Help: "IPFIX template withdrawals.",
+		},
+		[]string{"router", "obs_domain_id", "template_id"},
+	)
+	NetFlowBufferedSets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_process_nf_buffered_sets_count",
+			Help: "NetFlow/IPFIX data sets received before their template (buffered, recovered or expired).",
+		},
+		[]string{"router", "version", "status"},
+	)
+	NetFlowMappingErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_process_nf_mapping_errors_count",
+			Help: "NetFlow/IPFIX values which could not be mapped to their destination.",
+		},
+		[]string{"destination"},
+	)
+	NetFlowSamplingRateSource = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flow_process_nf_sampling_rate_source_count",
+			Help: "NetFlow/IPFIX flows by source of their sampling rate (learned, override, default or none).",
+		},
+		[]string{"router", "source"},
+	)
 	SFlowStats = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "flow_process_sf_count",
@@ -137,9 +215,15 @@ func init() {
 	prometheus.MustRegister(MetricTrafficBytes)
 	prometheus.MustRegister(MetricTrafficPackets)
 	prometheus.MustRegister(MetricPacketSizeSum)
+	prometheus.MustRegister(MetricTCPConnections)
+	prometheus.MustRegister(MetricTCPConnectionsCount)
+	prometheus.MustRegister(MetricTeeErrors)
+	prometheus.MustRegister(MetricTeeDropped)
 
 	prometheus.MustRegister(DecoderStats)
 	prometheus.MustRegister(DecoderErrors)
+	prometheus.MustRegister(DecoderQueueDepth)
+	prometheus.MustRegister(DecoderDropped)
 	prometheus.MustRegister(DecoderTime)
 	prometheus.MustRegister(DecoderProcessTime)
 
@@ -149,6 +233,11 @@ func init() {
 	prometheus.MustRegister(NetFlowSetStatsSum)
 	prometheus.MustRegister(NetFlowTimeStatsSum)
 	prometheus.MustRegister(NetFlowTemplatesStats)
+	prometheus.MustRegister(NetFlowTemplatesChanged)
+	prometheus.MustRegister(NetFlowTemplatesWithdrawn)
+	prometheus.MustRegister(NetFlowBufferedSets)
+	prometheus.MustRegister(NetFlowSamplingRateSource)
+	prometheus.MustRegister(NetFlowMappingErrors)
 
 	prometheus.MustRegister(SFlowStats)
 	prometheus.MustRegister(SFlowErrors)
@@ -169,3 +258,16 @@ func DefaultAccountCallback(name string, id int, start, end time.Time) {
 		}).
 		Inc()
 }
+
+func DefaultDropCallback(name string, msg decoder.Message) {
+	var router string
+	if pkt, ok := msg.(BaseMessage); ok {
+		router = pkt.Src.String()
+	}
+	DecoderDropped.With(
+		prometheus.Labels{
+			"name":   name,
+			"router": router,
+		}).
+		Inc()
+}
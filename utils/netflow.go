@@ -2,10 +2,14 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudflare/goflow/v3/decoders/netflow"
@@ -17,11 +21,12 @@ import (
 type TemplateSystem struct {
 	key       string
 	templates *netflow.BasicTemplateSystem
+	store     *TemplateStore
+	logger    Logger
+	buffer    *dataSetBuffer
 }
 
 func (s *TemplateSystem) AddTemplate(version uint16, obsDomainId uint32, template interface{}) {
-	s.templates.AddTemplate(version, obsDomainId, template)
-
 	typeStr := "options_template"
 	var templateId uint16
 	switch templateIdConv := template.(type) {
@@ -33,6 +38,29 @@ func (s *TemplateSystem) AddTemplate(version uint16, obsDomainId uint32, templat
 		templateId = templateIdConv.TemplateId
 		typeStr = "template"
 	}
+
+	previous, err := s.templates.GetTemplate(version, obsDomainId, templateId)
+	if err == nil && !sameTemplate(previous, template) {
+		// eg: the router was upgraded, data sent with the previous
+		// definition until now would be decoded wrongly
+		NetFlowTemplatesChanged.With(
+			prometheus.Labels{
+				"router":        s.key,
+				"version":       strconv.Itoa(int(version)),
+				"obs_domain_id": strconv.Itoa(int(obsDomainId)),
+				"template_id":   strconv.Itoa(int(templateId)),
+			}).
+			Inc()
+		if s.logger != nil {
+			s.logger.Warnf("Template %v of observation domain %v of %v (version %v) changed", templateId, obsDomainId, s.key, version)
+		}
+	}
+
+	s.templates.AddTemplate(version, obsDomainId, template)
+	if s.store != nil {
+		s.store.AddTemplate(s.key, version, obsDomainId, template)
+	}
+	s.recoverDataSets(version, obsDomainId, templateId)
 	NetFlowTemplatesStats.With(
 		prometheus.Labels{
 			"router":        s.key,
@@ -44,18 +72,108 @@ func (s *TemplateSystem) AddTemplate(version uint16, obsDomainId uint32, templat
 		Inc()
 }
 
+func (s *TemplateSystem) RemoveTemplate(version uint16, obsDomainId uint32, templateId uint16) {
+	s.templates.RemoveTemplate(version, obsDomainId, templateId)
+	if s.store != nil {
+		s.store.RemoveTemplate(s.key, version, obsDomainId, templateId)
+	}
+	s.countWithdrawal(obsDomainId, strconv.Itoa(int(templateId)))
+}
+
+func (s *TemplateSystem) RemoveTemplates(version uint16, obsDomainId uint32, options bool) {
+	s.templates.RemoveTemplates(version, obsDomainId, options)
+	if s.store != nil {
+		s.store.RemoveTemplates(s.key, version, obsDomainId, options)
+	}
+	templateId := "all"
+	if options {
+		templateId = "all_options"
+	}
+	s.countWithdrawal(obsDomainId, templateId)
+}
+
+// sameTemplate compares the fields of two templates.
+func sameTemplate(a interface{}, b interface{}) bool {
+	switch ac := a.(type) {
+	case netflow.TemplateRecord:
+		bc, ok := b.(netflow.TemplateRecord)
+		return ok && sameFields(ac.Fields, bc.Fields)
+	case netflow.NFv9OptionsTemplateRecord:
+		bc, ok := b.(netflow.NFv9OptionsTemplateRecord)
+		return ok && sameFields(ac.Scopes, bc.Scopes) && sameFields(ac.Options, bc.Options)
+	case netflow.IPFIXOptionsTemplateRecord:
+		bc, ok := b.(netflow.IPFIXOptionsTemplateRecord)
+		return ok && sameFields(ac.Scopes, bc.Scopes) && sameFields(ac.Options, bc.Options)
+	}
+	return false
+}
+
+func sameFields(a []netflow.Field, b []netflow.Field) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *TemplateSystem) countWithdrawal(obsDomainId uint32, templateId string) {
+	NetFlowTemplatesWithdrawn.With(
+		prometheus.Labels{
+			"router":        s.key,
+			"obs_domain_id": strconv.Itoa(int(obsDomainId)),
+			"template_id":   templateId,
+		}).
+		Inc()
+}
+
 func (s *TemplateSystem) GetTemplate(version uint16, obsDomainId uint32, templateId uint16) (interface{}, error) {
 	return s.templates.GetTemplate(version, obsDomainId, templateId)
 }
 
 type StateNetFlow struct {
-	Transport     Transport
-	Logger        Logger
+	Transport Transport
+	Logger    Logger
+	Config    *producer.NetFlowProducerConfig
+	Tee       DatagramTee
+	Store     *TemplateStore // templates and sampling rates kept across restarts
+	// NetFlow v9 templates not received again for this long are removed (0 keeps them)
+	TemplateTimeout time.Duration
+	// sampling rates of samplers, selectors and interfaces, and interface
+	// names, not received again for this long are removed (0 keeps them)
+	OptionsTTL time.Duration
+	// data sets kept per template when received before it (0 disables) and for how long
+	BufferSize  int
+	BufferAge   time.Duration
+	QueueSize   int    // datagrams waiting for a worker
+	QueuePolicy string // when the queue is full (decoder.QUEUE_POLICY_*)
+	Sockets     int    // SO_REUSEPORT sockets with a reader each
+	PinReaders  bool   // lock each reader to an OS thread
+	ReadBatch   int    // datagrams read per system call on Linux (recvmmsg)
+	// IPFIX over TCP connections receiving nothing for this long are closed (0 keeps them)
+	TCPIdleTimeout time.Duration
+	// publish the flow sets of a message which could be decoded when others
+	// cannot, these are only counted (by default the message fails)
+	PartialDecoding bool
+	// sampling rates of the routers not sending them or to override
+	Sampling *producer.SamplingRateConfig
+	// sampling rate of every flow, replacing the learned ones (0 learns them)
+	SamplingRate  uint32
+	initOnce      sync.Once
 	templateslock *sync.RWMutex
 	templates     map[string]*TemplateSystem
 
 	samplinglock *sync.RWMutex
 	sampling     map[string]producer.SamplingRateSystem
+
+	interfaceslock *sync.RWMutex
+	interfaces     map[string]*producer.InterfaceTable
+
+	// when the expired templates and option data were last removed (unix nanoseconds)
+	lastExpire int64
 }
 
 func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
@@ -67,28 +185,52 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 	if samplerAddress.To4() != nil {
 		samplerAddress = samplerAddress.To4()
 	}
+	sessionKey := key
+	if pkt.Session != "" {
+		sessionKey = pkt.Session
+	}
 
 	s.templateslock.RLock()
-	templates, ok := s.templates[key]
+	templates, ok := s.templates[sessionKey]
 	s.templateslock.RUnlock()
 	if !ok {
-		templates = &TemplateSystem{
-			templates: netflow.CreateTemplateSystem(),
-			key:       key,
-		}
+		// another reader or worker may have created it meanwhile
 		s.templateslock.Lock()
-		s.templates[key] = templates
+		templates, ok = s.templates[sessionKey]
+		if !ok {
+			// the templates of transport sessions are not kept across restarts
+			templates = s.newTemplateSystem(key, pkt.Session == "")
+			s.templates[sessionKey] = templates
+		}
 		s.templateslock.Unlock()
 	}
 	s.samplinglock.RLock()
-	sampling, ok := s.sampling[key]
+	sampling, ok := s.sampling[sessionKey]
 	s.samplinglock.RUnlock()
 	if !ok {
-		sampling = producer.CreateSamplingSystem()
 		s.samplinglock.Lock()
-		s.sampling[key] = sampling
+		sampling, ok = s.sampling[sessionKey]
+		if !ok {
+			sampling = s.newSamplingSystem(key, pkt.Session == "")
+			s.sampling[sessionKey] = sampling
+		}
 		s.samplinglock.Unlock()
 	}
+	// interface names are kept per router, across its transport sessions
+	s.interfaceslock.RLock()
+	interfaces, ok := s.interfaces[key]
+	s.interfaceslock.RUnlock()
+	if !ok {
+		s.interfaceslock.Lock()
+		interfaces, ok = s.interfaces[key]
+		if !ok {
+			interfaces = producer.CreateInterfaceTable()
+			s.interfaces[key] = interfaces
+		}
+		s.interfaceslock.Unlock()
+	}
+
+	s.removeExpired(time.Now())
 
 	ts := uint64(time.Now().UTC().Unix())
 	if pkt.SetTime {
@@ -96,42 +238,29 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 	}
 
 	timeTrackStart := time.Now()
-	msgDec, err := netflow.DecodeMessage(buf, templates)
-	if err != nil {
-		switch err.(type) {
-		case *netflow.ErrorVersion:
-			NetFlowErrors.With(
-				prometheus.Labels{
-					"router": key,
-					"error":  "error_version",
-				}).
-				Inc()
-		case *netflow.ErrorFlowId:
-			NetFlowErrors.With(
-				prometheus.Labels{
-					"router": key,
-					"error":  "error_flow_id",
-				}).
-				Inc()
-		case *netflow.ErrorTemplateNotFound:
-			NetFlowErrors.With(
-				prometheus.Labels{
-					"router": key,
-					"error":  "template_not_found",
-				}).
-				Inc()
-		default:
-			NetFlowErrors.With(
-				prometheus.Labels{
-					"router": key,
-					"error":  "error_decoding",
-				}).
-				Inc()
+	var msgDec interface{}
+	var err error
+	if s.PartialDecoding {
+		msgDec, err = netflow.DecodeMessagePartial(buf, templates)
+	} else {
+		msgDec, err = netflow.DecodeMessage(buf, templates)
+	}
+	if decodeErr, ok := err.(*netflow.ErrorFlowSets); ok {
+		// the flow sets decoded are published, the others are only counted
+		for _, setErr := range decodeErr.Unwrap() {
+			countNetFlowError(key, setErr)
+		}
+		if s.Logger != nil {
+			s.Logger.Debugf("NetFlow message from %v: %v", key, err)
 		}
+	} else if err != nil {
+		countNetFlowError(key, err)
 		return err
 	}
 
 	flowMessageSet := make([]*flowmessage.FlowMessage, 0)
+	// the option data sets may name the interfaces of the router
+	var optionDataFlowSet []netflow.OptionsDataFlowSet
 
 	switch msgDecConv := msgDec.(type) {
 	case netflow.NFv9Packet:
@@ -179,6 +308,7 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 					Add(float64(len(fsConv.Records)))
 
 			case netflow.OptionsDataFlowSet:
+				optionDataFlowSet = append(optionDataFlowSet, fsConv)
 				NetFlowSetStatsSum.With(
 					prometheus.Labels{
 						"router":  key,
@@ -212,7 +342,8 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 					Add(float64(len(fsConv.Records)))
 			}
 		}
-		flowMessageSet, err = producer.ProcessMessageNetFlow(msgDecConv, sampling)
+		flowMessageSet, err = producer.ProcessMessageNetFlowConfig(msgDecConv, sampling, s.Config)
+		producer.ProcessNetFlowInterfaces(9, optionDataFlowSet, interfaces, flowMessageSet)
 
 		for _, fmsg := range flowMessageSet {
 			fmsg.TimeReceived = ts
@@ -270,6 +401,7 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 					Add(float64(len(fsConv.Records)))
 
 			case netflow.OptionsDataFlowSet:
+				optionDataFlowSet = append(optionDataFlowSet, fsConv)
 
 				NetFlowSetStatsSum.With(
 					prometheus.Labels{
@@ -305,7 +437,8 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 					Add(float64(len(fsConv.Records)))
 			}
 		}
-		flowMessageSet, err = producer.ProcessMessageNetFlow(msgDecConv, sampling)
+		flowMessageSet, err = producer.ProcessMessageNetFlowConfig(msgDecConv, sampling, s.Config)
+		producer.ProcessNetFlowInterfaces(10, optionDataFlowSet, interfaces, flowMessageSet)
 
 		for _, fmsg := range flowMessageSet {
 			fmsg.TimeReceived = ts
@@ -320,6 +453,10 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 		}
 	}
 
+	recovered := s.decodeRecoveredDataSets(templates, sampling, samplerAddress)
+	interfaces.SetInterfaceNames(recovered)
+	flowMessageSet = append(flowMessageSet, recovered...)
+
 	timeTrackStop := time.Now()
 	DecoderTime.With(
 		prometheus.Labels{
@@ -330,15 +467,64 @@ func (s *StateNetFlow) DecodeFlow(msg interface{}) error {
 	if s.Transport != nil {
 		s.Transport.Publish(flowMessageSet)
 	}
+	producer.ReleaseFlowMessages(flowMessageSet)
 
 	return nil
 }
 
+func countNetFlowError(key string, err error) {
+	switch err.(type) {
+	case *netflow.ErrorVersion:
+		NetFlowErrors.With(
+			prometheus.Labels{
+				"router": key,
+				"error":  "error_version",
+			}).
+			Inc()
+	case *netflow.ErrorFlowId:
+		NetFlowErrors.With(
+			prometheus.Labels{
+				"router": key,
+				"error":  "error_flow_id",
+			}).
+			Inc()
+	case *netflow.ErrorTemplateNotFound:
+		NetFlowErrors.With(
+			prometheus.Labels{
+				"router": key,
+				"error":  "template_not_found",
+			}).
+			Inc()
+	default:
+		NetFlowErrors.With(
+			prometheus.Labels{
+				"router": key,
+				"error":  "error_decoding",
+			}).
+			Inc()
+	}
+}
+
+// ServeHTTPTemplates lists the templates of each router.
 func (s *StateNetFlow) ServeHTTPTemplates(w http.ResponseWriter, r *http.Request) {
+	s.initTemplates()
 	tmp := make(map[string]map[uint16]map[uint32]map[uint16]interface{})
+	now := time.Now()
 	s.templateslock.RLock()
 	for key, templatesrouterstr := range s.templates {
 		templatesrouter := templatesrouterstr.templates.GetTemplates()
+		for version, templatesVersion := range templatesrouter {
+			for obsDomainId, templatesObsDom := range templatesVersion {
+				for templateId, template := range templatesObsDom {
+					var age time.Duration
+					updated, ok := templatesrouterstr.templates.TemplateUpdated(version, obsDomainId, templateId)
+					if ok {
+						age = now.Sub(updated)
+					}
+					templatesObsDom[templateId] = withTemplateAge(template, age)
+				}
+			}
+		}
 		tmp[key] = templatesrouter
 	}
 	s.templateslock.RUnlock()
@@ -346,14 +532,324 @@ func (s *StateNetFlow) ServeHTTPTemplates(w http.ResponseWriter, r *http.Request
 	enc.Encode(tmp)
 }
 
+// ServeHTTPInterfaces lists the interface names of each router by ifIndex.
+func (s *StateNetFlow) ServeHTTPInterfaces(w http.ResponseWriter, r *http.Request) {
+	s.initTemplates()
+	tmp := make(map[string]map[uint32]producer.InterfaceName)
+	s.interfaceslock.RLock()
+	for key, interfaces := range s.interfaces {
+		if ifNames := interfaces.GetInterfaces(); len(ifNames) > 0 {
+			tmp[key] = ifNames
+		}
+	}
+	s.interfaceslock.RUnlock()
+	enc := json.NewEncoder(w)
+	enc.Encode(tmp)
+}
+
+// withTemplateAge adds the seconds since the template was last received to
+// its JSON representation.
+func withTemplateAge(template interface{}, age time.Duration) interface{} {
+	seconds := int64(age.Seconds())
+	switch templatec := template.(type) {
+	case netflow.TemplateRecord:
+		return struct {
+			netflow.TemplateRecord
+			Age int64
+		}{templatec, seconds}
+	case netflow.NFv9OptionsTemplateRecord:
+		return struct {
+			netflow.NFv9OptionsTemplateRecord
+			Age int64
+		}{templatec, seconds}
+	case netflow.IPFIXOptionsTemplateRecord:
+		return struct {
+			netflow.IPFIXOptionsTemplateRecord
+			Age int64
+		}{templatec, seconds}
+	}
+	return template
+}
+
+// LoadNetFlowProducerConfig reads a YAML or JSON fields mapping file.
+func LoadNetFlowProducerConfig(path string) (*producer.NetFlowProducerConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mapping, err := producer.LoadNetFlowMapping(f)
+	if err != nil {
+		return nil, err
+	}
+	config, err := producer.CreateNetFlowProducerConfig(mapping)
+	if err != nil {
+		return nil, err
+	}
+	config.MappingErrorCallback = countMappingError
+	return config, nil
+}
+
+func countMappingError(field producer.NetFlowMapField, err error) {
+	NetFlowMappingErrors.With(
+		prometheus.Labels{
+			"destination": strconv.Itoa(int(field.Destination)),
+		}).
+		Inc()
+}
+
+// LoadSamplingRateConfig reads a YAML or JSON sampling rates file.
+func LoadSamplingRateConfig(path string) (*producer.SamplingRateConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mapping, err := producer.LoadSamplingRateMapping(f)
+	if err != nil {
+		return nil, err
+	}
+	return producer.CreateSamplingRateConfig(mapping)
+}
+
+// InitTemplates creates the state of the routers. It does nothing once the
+// state exists, the routines call it as well.
 func (s *StateNetFlow) InitTemplates() {
+	s.initOnce.Do(s.newState)
+}
+
+func (s *StateNetFlow) newState() {
 	s.templates = make(map[string]*TemplateSystem)
 	s.templateslock = &sync.RWMutex{}
 	s.sampling = make(map[string]producer.SamplingRateSystem)
+	s.interfaces = make(map[string]*producer.InterfaceTable)
+	s.interfaceslock = &sync.RWMutex{}
 	s.samplinglock = &sync.RWMutex{}
+
+	if s.Store != nil {
+		// restored entries are not recorded again so that they expire
+		// unless the routers send them
+		s.Store.restore(
+			func(router string, version uint16, obsDomainId uint32, template interface{}) {
+				templates, ok := s.templates[router]
+				if !ok {
+					templates = s.newTemplateSystem(router, true)
+					s.templates[router] = templates
+				}
+				templates.templates.AddTemplate(version, obsDomainId, template)
+			},
+			func(router string, version uint16, obsDomainId uint32, scope producer.SamplingRateScope, samplingRate uint32) {
+				sampling, ok := s.sampling[router]
+				if !ok {
+					sampling = s.newSamplingSystem(router, true)
+					s.sampling[router] = sampling
+				}
+				if router, ok := sampling.(*routerSamplingRateSystem); ok {
+					sampling = router.SamplingRateSystem
+				}
+				if stored, ok := sampling.(*storedSamplingRateSystem); ok {
+					sampling = stored.SamplingRateSystem
+				}
+				if scope.Type != producer.SAMPLING_SCOPE_OBS_DOMAIN {
+					addScopedSamplingRate(sampling, version, obsDomainId, scope, samplingRate)
+					return
+				}
+				sampling.AddSamplingRate(version, obsDomainId, samplingRate)
+			})
+	}
 }
 
-func (s *StateNetFlow) FlowRoutine(workers int, addr string, port int, reuseport bool) error {
+func (s *StateNetFlow) newTemplateSystem(key string, persist bool) *TemplateSystem {
+	templates := &TemplateSystem{
+		templates: netflow.CreateTemplateSystem(),
+		key:       key,
+		logger:    s.Logger,
+	}
+	templates.templates.NFv9Timeout = s.TemplateTimeout
+	if s.BufferSize > 0 {
+		templates.buffer = newDataSetBuffer(s.BufferSize, s.BufferAge)
+	}
+	if persist {
+		templates.store = s.Store
+	}
+	return templates
+}
+
+// routerSamplingRateSystem applies the configured sampling rates of a router
+// and counts the sources of the rates of its flows.
+type routerSamplingRateSystem struct {
+	producer.SamplingRateSystem
+	rates  *producer.RouterSamplingRates
+	fixed  bool
+	router string
+
+	// NetFlowSamplingRateSource of the router per source, looked up once
+	sources map[string]prometheus.Counter
+}
+
+func newRouterSamplingRateSystem(sampling producer.SamplingRateSystem, rates *producer.RouterSamplingRates, fixed bool, router string) *routerSamplingRateSystem {
+	sources := make(map[string]prometheus.Counter)
+	for _, source := range []string{producer.SAMPLING_SOURCE_LEARNED, producer.SAMPLING_SOURCE_OVERRIDE, producer.SAMPLING_SOURCE_DEFAULT, producer.SAMPLING_SOURCE_NONE} {
+		sources[source] = NetFlowSamplingRateSource.With(
+			prometheus.Labels{
+				"router": router,
+				"source": source,
+			})
+	}
+	return &routerSamplingRateSystem{
+		SamplingRateSystem: sampling,
+		rates:              rates,
+		fixed:              fixed,
+		router:             router,
+		sources:            sources,
+	}
+}
+
+func (s *routerSamplingRateSystem) ResolveSamplingRate(version uint16, obsDomainId uint32, inIf uint32, samplingRate uint32) uint32 {
+	source := producer.SAMPLING_SOURCE_OVERRIDE
+	if !s.fixed {
+		samplingRate, source = s.rates.Resolve(obsDomainId, inIf, samplingRate)
+	}
+	s.sources[source].Inc()
+	return samplingRate
+}
+
+func (s *routerSamplingRateSystem) GetScopedSamplingRate(version uint16, obsDomainId uint32, scope producer.SamplingRateScope) (uint32, bool) {
+	return getScopedSamplingRate(s.SamplingRateSystem, version, obsDomainId, scope)
+}
+
+func (s *routerSamplingRateSystem) AddScopedSamplingRate(version uint16, obsDomainId uint32, scope producer.SamplingRateScope, samplingRate uint32) {
+	addScopedSamplingRate(s.SamplingRateSystem, version, obsDomainId, scope, samplingRate)
+}
+
+func (s *routerSamplingRateSystem) RemoveExpiredScopedSamplingRates(ttl time.Duration) {
+	removeExpiredScopedSamplingRates(s.SamplingRateSystem, ttl)
+}
+
+// getScopedSamplingRate, addScopedSamplingRate and
+// removeExpiredScopedSamplingRates forward to the sampling rate systems
+// keeping scoped rates.
+func getScopedSamplingRate(sampling producer.SamplingRateSystem, version uint16, obsDomainId uint32, scope producer.SamplingRateScope) (uint32, bool) {
+	scoped, ok := sampling.(producer.ScopedSamplingRateSystem)
+	if !ok {
+		return 0, false
+	}
+	return scoped.GetScopedSamplingRate(version, obsDomainId, scope)
+}
+
+func addScopedSamplingRate(sampling producer.SamplingRateSystem, version uint16, obsDomainId uint32, scope producer.SamplingRateScope, samplingRate uint32) {
+	if scoped, ok := sampling.(producer.ScopedSamplingRateSystem); ok {
+		scoped.AddScopedSamplingRate(version, obsDomainId, scope, samplingRate)
+	}
+}
+
+func removeExpiredScopedSamplingRates(sampling producer.SamplingRateSystem, ttl time.Duration) {
+	if scoped, ok := sampling.(producer.ScopedSamplingRateSystem); ok {
+		scoped.RemoveExpiredScopedSamplingRates(ttl)
+	}
+}
+
+// newSamplingSystem counts the sources of the sampling rates only when they
+// can be set by the configuration (-nf.sampling or -nf.sampling.rate).
+func (s *StateNetFlow) newSamplingSystem(key string, persist bool) producer.SamplingRateSystem {
+	if s.SamplingRate > 0 {
+		return newRouterSamplingRateSystem(&producer.SingleSamplingRateSystem{Sampling: s.SamplingRate}, nil, true, key)
+	}
+	sampling := producer.CreateSamplingSystem()
+	if persist && s.Store != nil {
+		sampling = &storedSamplingRateSystem{
+			SamplingRateSystem: sampling,
+			store:              s.Store,
+			router:             key,
+		}
+	}
+	if s.Sampling == nil {
+		return sampling
+	}
+	return newRouterSamplingRateSystem(sampling, s.Sampling.Router(net.ParseIP(key)), false, key)
+}
+
+// initTemplates allows several routines to share the same state.
+func (s *StateNetFlow) initTemplates() {
 	s.InitTemplates()
-	return UDPRoutine("NetFlow", s.DecodeFlow, workers, addr, port, reuseport, s.Logger)
+}
+
+// removeExpired deletes the NetFlow v9 templates not received for
+// TemplateTimeout and the option data not received for OptionsTTL. The
+// routers are checked at most once per the shorter of the two.
+func (s *StateNetFlow) removeExpired(now time.Time) {
+	interval := s.TemplateTimeout
+	if interval <= 0 || (s.OptionsTTL > 0 && s.OptionsTTL < interval) {
+		interval = s.OptionsTTL
+	}
+	if interval <= 0 {
+		return
+	}
+	last := atomic.LoadInt64(&s.lastExpire)
+	if now.UnixNano()-last < int64(interval) || !atomic.CompareAndSwapInt64(&s.lastExpire, last, now.UnixNano()) {
+		return
+	}
+	if s.TemplateTimeout > 0 {
+		s.templateslock.RLock()
+		for _, templates := range s.templates {
+			templates.templates.RemoveExpiredTemplates()
+		}
+		s.templateslock.RUnlock()
+	}
+	if s.OptionsTTL > 0 {
+		s.samplinglock.RLock()
+		for _, sampling := range s.sampling {
+			removeExpiredScopedSamplingRates(sampling, s.OptionsTTL)
+		}
+		s.samplinglock.RUnlock()
+		s.interfaceslock.RLock()
+		for _, interfaces := range s.interfaces {
+			interfaces.RemoveExpiredInterfaces(s.OptionsTTL)
+		}
+		s.interfaceslock.RUnlock()
+	}
+}
+
+// DeleteSession removes the templates and sampling rates of a transport session.
+func (s *StateNetFlow) DeleteSession(session string) {
+	s.templateslock.Lock()
+	delete(s.templates, session)
+	s.templateslock.Unlock()
+	s.samplinglock.Lock()
+	delete(s.sampling, session)
+	s.samplinglock.Unlock()
+}
+
+func (s *StateNetFlow) FlowRoutine(workers int, addr string, port int, reuseport bool) error {
+	return s.FlowRoutineContext(context.Background(), workers, addr, port, reuseport)
+}
+
+// FlowRoutineContext collects until ctx is done, then decodes the datagrams
+// already received before returning.
+func (s *StateNetFlow) FlowRoutineContext(ctx context.Context, workers int, addr string, port int, reuseport bool) error {
+	s.initTemplates()
+	return udpRoutine(ctx, "NetFlow", s.DecodeFlow, workers, addr, port, reuseport, s.udpOptions(), s.Logger)
+}
+
+// FlowRoutineTCP collects IPFIX over TCP. Templates are kept per connection.
+func (s *StateNetFlow) FlowRoutineTCP(addr string, port int) error {
+	return s.FlowRoutineTCPContext(context.Background(), addr, port)
+}
+
+func (s *StateNetFlow) FlowRoutineTCPContext(ctx context.Context, addr string, port int) error {
+	s.initTemplates()
+	return tcpRoutine(ctx, "NetFlowTCP", s.DecodeFlow, s.DeleteSession, addr, port, s.TCPIdleTimeout, s.Logger)
+}
+
+func (s *StateNetFlow) udpOptions() udpOptions {
+	return udpOptions{
+		tee:         s.Tee,
+		queueSize:   s.QueueSize,
+		queuePolicy: s.QueuePolicy,
+		sockets:     s.Sockets,
+		pinReaders:  s.PinReaders,
+		readBatch:   s.ReadBatch,
+
+		poolPayloads: true,
+	}
 }
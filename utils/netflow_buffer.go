@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+	"github.com/cloudflare/goflow/v3/producer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type dataSetKey struct {
+	version     uint16
+	obsDomainId uint32
+	templateId  uint16
+}
+
+type bufferedDataSet struct {
+	key      dataSetKey
+	packet   interface{} // NFv9Packet or IPFIXPacket header
+	fsheader netflow.FlowSetHeader
+	data     []byte
+	received time.Time
+}
+
+// dataSetBuffer keeps the data sets of a router received before their
+// template, up to size sets per template for at most age.
+type dataSetBuffer struct {
+	size int
+	age  time.Duration // 0 keeps the sets until the template is received
+
+	lock      sync.Mutex
+	sets      map[dataSetKey][]bufferedDataSet
+	recovered []bufferedDataSet
+}
+
+func newDataSetBuffer(size int, age time.Duration) *dataSetBuffer {
+	return &dataSetBuffer{
+		size: size,
+		age:  age,
+		sets: make(map[dataSetKey][]bufferedDataSet),
+	}
+}
+
+func (b *dataSetBuffer) expired(set bufferedDataSet, now time.Time) bool {
+	return b.age > 0 && now.Sub(set.received) > b.age
+}
+
+// prune removes the expired sets and calls expire for each of them.
+func (b *dataSetBuffer) prune(now time.Time, expire func(bufferedDataSet)) {
+	for key, sets := range b.sets {
+		kept := sets[:0]
+		for _, set := range sets {
+			if b.expired(set, now) {
+				expire(set)
+				continue
+			}
+			kept = append(kept, set)
+		}
+		if len(kept) == 0 {
+			delete(b.sets, key)
+		} else {
+			b.sets[key] = kept
+		}
+	}
+}
+
+func (s *TemplateSystem) countBufferedSet(version uint16, status string) {
+	NetFlowBufferedSets.With(
+		prometheus.Labels{
+			"router":  s.key,
+			"version": strconv.Itoa(int(version)),
+			"status":  status,
+		}).
+		Inc()
+}
+
+func (s *TemplateSystem) BufferDataSet(packet interface{}, fsheader netflow.FlowSetHeader, data []byte) bool {
+	if s.buffer == nil {
+		return false
+	}
+	var key dataSetKey
+	switch packetc := packet.(type) {
+	case netflow.NFv9Packet:
+		key = dataSetKey{packetc.Version, packetc.SourceId, fsheader.Id}
+	case netflow.IPFIXPacket:
+		key = dataSetKey{packetc.Version, packetc.ObservationDomainId, fsheader.Id}
+	default:
+		return false
+	}
+
+	now := time.Now()
+	b := s.buffer
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.prune(now, func(set bufferedDataSet) {
+		s.countBufferedSet(set.key.version, "expired")
+	})
+	// the template may have been received by another worker since the
+	// set was decoded, its sets were then already recovered
+	_, err := s.GetTemplate(key.version, key.obsDomainId, key.templateId)
+	if err != nil && len(b.sets[key]) >= b.size {
+		return false
+	}
+	// the payload is recycled once decoded
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	set := bufferedDataSet{
+		key:      key,
+		packet:   packet,
+		fsheader: fsheader,
+		data:     dataCopy,
+		received: now,
+	}
+	s.countBufferedSet(key.version, "buffered")
+	if err == nil {
+		b.recovered = append(b.recovered, set)
+	} else {
+		b.sets[key] = append(b.sets[key], set)
+	}
+	return true
+}
+
+// recoverDataSets marks the sets waiting for a template which was just
+// received to be decoded.
+func (s *TemplateSystem) recoverDataSets(version uint16, obsDomainId uint32, templateId uint16) {
+	if s.buffer == nil {
+		return
+	}
+	key := dataSetKey{version, obsDomainId, templateId}
+	now := time.Now()
+	b := s.buffer
+	b.lock.Lock()
+	for _, set := range b.sets[key] {
+		if b.expired(set, now) {
+			s.countBufferedSet(version, "expired")
+			continue
+		}
+		b.recovered = append(b.recovered, set)
+	}
+	delete(b.sets, key)
+	b.lock.Unlock()
+}
+
+// takeRecoveredDataSets returns the sets whose template was received.
+func (s *TemplateSystem) takeRecoveredDataSets() []bufferedDataSet {
+	if s.buffer == nil {
+		return nil
+	}
+	s.buffer.lock.Lock()
+	recovered := s.buffer.recovered
+	s.buffer.recovered = nil
+	s.buffer.lock.Unlock()
+	return recovered
+}
+
+// decodeRecoveredDataSets converts the buffered data sets of a router whose
+// template was received into flow messages. The sets and their errors are
+// counted like the ones of the packets.
+func (s *StateNetFlow) decodeRecoveredDataSets(templates *TemplateSystem, sampling producer.SamplingRateSystem, samplerAddress net.IP) []*flowmessage.FlowMessage {
+	var flowMessageSet []*flowmessage.FlowMessage
+	for _, set := range templates.takeRecoveredDataSets() {
+		template, err := templates.GetTemplate(set.key.version, set.key.obsDomainId, set.key.templateId)
+		if err != nil {
+			// withdrawn meanwhile
+			countNetFlowError(templates.key, err)
+			continue
+		}
+		flowSet, err := netflow.DecodeDataFlowSet(set.key.version, set.fsheader, bytes.NewBuffer(set.data), template)
+		if err != nil {
+			countNetFlowError(templates.key, err)
+			continue
+		}
+		version := strconv.Itoa(int(set.key.version))
+		var packet interface{}
+		switch packetc := set.packet.(type) {
+		case netflow.NFv9Packet:
+			packetc.FlowSets = []interface{}{flowSet}
+			packet = packetc
+		case netflow.IPFIXPacket:
+			packetc.FlowSets = []interface{}{flowSet}
+			packet = packetc
+		}
+		switch flowSetc := flowSet.(type) {
+		case netflow.DataFlowSet:
+			countRecoveredDataSet(templates.key, version, "DataFlowSet", len(flowSetc.Records))
+		case netflow.OptionsDataFlowSet:
+			countRecoveredDataSet(templates.key, version, "OptionsDataFlowSet", len(flowSetc.Records))
+		default:
+			continue
+		}
+		msgs, err := producer.ProcessMessageNetFlowConfig(packet, sampling, s.Config)
+		if err != nil {
+			countNetFlowError(templates.key, err)
+			continue
+		}
+		received := uint64(set.received.UTC().Unix())
+		for _, fmsg := range msgs {
+			fmsg.TimeReceived = received
+			fmsg.SamplerAddress = samplerAddress
+			NetFlowTimeStatsSum.With(
+				prometheus.Labels{
+					"router":  templates.key,
+					"version": version,
+				}).
+				Observe(float64(fmsg.TimeReceived - fmsg.TimeFlowEnd))
+		}
+		flowMessageSet = append(flowMessageSet, msgs...)
+		templates.countBufferedSet(set.key.version, "recovered")
+	}
+	return flowMessageSet
+}
+
+func countRecoveredDataSet(key string, version string, setType string, records int) {
+	NetFlowSetStatsSum.With(
+		prometheus.Labels{
+			"router":  key,
+			"version": version,
+			"type":    setType,
+		}).
+		Inc()
+	NetFlowSetRecordsStatsSum.With(
+		prometheus.Labels{
+			"router":  key,
+			"version": version,
+			"type":    setType,
+		}).
+		Add(float64(records))
+}
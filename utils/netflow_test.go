@@ -0,0 +1,599 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	"github.com/cloudflare/goflow/v3/decoders/rawcapture"
+	"github.com/cloudflare/goflow/v3/internal/testhelpers"
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+	"github.com/cloudflare/goflow/v3/producer"
+	proto "github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+type testTransport struct {
+	lock  sync.Mutex
+	msgs  []*flowmessage.FlowMessage
+	delay time.Duration
+}
+
+func (t *testTransport) Publish(msgs []*flowmessage.FlowMessage) {
+	time.Sleep(t.delay)
+	t.lock.Lock()
+	// the messages are recycled once published
+	for _, msg := range msgs {
+		t.msgs = append(t.msgs, proto.Clone(msg).(*flowmessage.FlowMessage))
+	}
+	t.lock.Unlock()
+}
+
+func (t *testTransport) Count() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.msgs)
+}
+
+// IPFIX message with template 256 (sourceIPv4Address, destinationIPv4Address, octetDeltaCount).
+func getIPFIXTemplate() []byte {
+	return []byte{
+		0x00, 0x0a, 0x00, 0x24, 0x5f, 0x5e, 0x10, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 0x00, 0x14, 0x01, 0x00, 0x00, 0x03, 0x00, 0x08, 0x00, 0x04, 0x00, 0x0c, 0x00, 0x04,
+		0x00, 0x01, 0x00, 0x08,
+	}
+}
+
+// IPFIX message with two data records of template 256.
+func getIPFIXData() []byte {
+	return []byte{
+		0x00, 0x0a, 0x00, 0x34, 0x5f, 0x5e, 0x10, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01,
+		0x01, 0x00, 0x00, 0x24,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0xdc,
+		0x0a, 0x00, 0x00, 0x03, 0x0a, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40,
+	}
+}
+
+func getFreePort(t *testing.T, network string) int {
+	if network == "udp" {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		assert.Nil(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.Nil(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// countingTee counts the datagrams read from the socket.
+type countingTee struct {
+	count     int64
+	dataCount int64
+}
+
+func (tee *countingTee) WriteDatagram(datagram rawcapture.Datagram) error {
+	atomic.AddInt64(&tee.count, 1)
+	if bytes.Equal(datagram.Payload, getIPFIXData()) {
+		atomic.AddInt64(&tee.dataCount, 1)
+	}
+	return nil
+}
+
+func TestFlowRoutineContext(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	// slow transport so that datagrams are still queued when cancelling
+	transport := &testTransport{delay: time.Millisecond}
+	tee := &countingTee{}
+	s := &StateNetFlow{
+		Transport: transport,
+		Tee:       tee,
+	}
+	port := getFreePort(t, "udp")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.FlowRoutineContext(ctx, 2, "127.0.0.1", port, false)
+	}()
+
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		conn.Write(getIPFIXTemplate())
+		return atomic.LoadInt64(&tee.count) > 0
+	}, time.Second, 10*time.Millisecond)
+	for i := 0; i < 20; i++ {
+		conn.Write(getIPFIXData())
+	}
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+
+	cancel()
+	assert.Nil(t, <-done)
+
+	// every data message read from the socket was decoded
+	assert.NotZero(t, atomic.LoadInt64(&tee.dataCount))
+	assert.Equal(t, 2*int(atomic.LoadInt64(&tee.dataCount)), transport.Count())
+	testhelpers.WaitGoroutines(t, goroutines)
+}
+
+func TestFlowRoutineTCP(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	transport := &testTransport{}
+	s := &StateNetFlow{
+		Transport: transport,
+	}
+	port := getFreePort(t, "tcp")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.FlowRoutineTCPContext(ctx, "127.0.0.1", port)
+	}()
+
+	var conn net.Conn
+	var err error
+	assert.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the data message is split across writes to exercise framing
+	data := getIPFIXData()
+	stream := append(getIPFIXTemplate(), data[:10]...)
+	_, err = conn.Write(stream)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = conn.Write(data[10:])
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool { return transport.Count() == 2 }, time.Second, 10*time.Millisecond)
+	transport.lock.Lock()
+	assert.Equal(t, uint64(1500), transport.msgs[0].Bytes)
+	assert.Equal(t, []byte{127, 0, 0, 1}, transport.msgs[0].SamplerAddress)
+	transport.lock.Unlock()
+
+	session := conn.LocalAddr().String()
+	s.templateslock.RLock()
+	_, ok := s.templates[session]
+	s.templateslock.RUnlock()
+	assert.True(t, ok)
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		s.templateslock.RLock()
+		defer s.templateslock.RUnlock()
+		_, ok := s.templates[session]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// open connections are closed when cancelling
+	conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(getIPFIXTemplate())
+	assert.Nil(t, err)
+	cancel()
+	assert.Nil(t, <-done)
+	testhelpers.WaitGoroutines(t, goroutines)
+}
+
+func TestInitTemplatesOnce(t *testing.T) {
+	s := &StateNetFlow{Transport: &testTransport{}}
+	s.InitTemplates()
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: net.ParseIP("192.0.2.36"), Payload: getIPFIXTemplate()}))
+	// a routine started afterwards keeps the state
+	s.initTemplates()
+	_, ok := s.templates["192.0.2.36"]
+	assert.True(t, ok)
+}
+
+func TestFlowRoutineTCPIdle(t *testing.T) {
+	s := &StateNetFlow{
+		Transport:      &testTransport{},
+		TCPIdleTimeout: 50 * time.Millisecond,
+	}
+	s.initTemplates()
+	port := getFreePort(t, "tcp")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.FlowRoutineTCPContext(ctx, "127.0.0.1", port)
+	}()
+	defer func() {
+		cancel()
+		assert.Nil(t, <-done)
+	}()
+
+	var conn net.Conn
+	var err error
+	assert.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer conn.Close()
+	_, err = conn.Write(getIPFIXTemplate())
+	assert.Nil(t, err)
+
+	// the collector closes the idle connection and forgets its templates
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	session := conn.LocalAddr().String()
+	assert.Eventually(t, func() bool {
+		s.templateslock.RLock()
+		defer s.templateslock.RUnlock()
+		_, ok := s.templates[session]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFlowRoutineSockets(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	transport := &testTransport{}
+	tee := &countingTee{}
+	s := &StateNetFlow{
+		Transport: transport,
+		Tee:       tee,
+		Sockets:   4,
+	}
+	s.initTemplates()
+	port := getFreePort(t, "udp")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.FlowRoutineContext(ctx, 2, "127.0.0.1", port, false)
+	}()
+
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	conn, err := net.Dial("udp", target)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		conn.Write(getIPFIXTemplate())
+		s.templateslock.RLock()
+		templates, ok := s.templates["127.0.0.1"]
+		s.templateslock.RUnlock()
+		if !ok {
+			return false
+		}
+		_, err := templates.GetTemplate(10, 1, 256)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	conn.Close()
+
+	// the template is shared by the sockets receiving the other source ports
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("udp", target)
+		assert.Nil(t, err)
+		for j := 0; j < 5; j++ {
+			conn.Write(getIPFIXData())
+		}
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool { return transport.Count() == 2*8*5 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(8*5), atomic.LoadInt64(&tee.dataCount))
+
+	cancel()
+	assert.Nil(t, <-done)
+	testhelpers.WaitGoroutines(t, goroutines)
+}
+
+func TestTemplateChanged(t *testing.T) {
+	s := &StateNetFlow{Transport: &testTransport{}}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.14")
+	changed := NetFlowTemplatesChanged.With(prometheus.Labels{
+		"router":        src.String(),
+		"version":       "10",
+		"obs_domain_id": "1",
+		"template_id":   "256",
+	})
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	assert.Equal(t, float64(0), testutil.ToFloat64(changed))
+
+	// octetDeltaCount on 4 bytes instead of 8
+	template := getIPFIXTemplate()
+	template[len(template)-1] = 4
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: template}))
+	assert.Equal(t, float64(1), testutil.ToFloat64(changed))
+}
+
+func TestRemoveExpired(t *testing.T) {
+	s := &StateNetFlow{Transport: &testTransport{}, TemplateTimeout: 10 * time.Millisecond, OptionsTTL: 10 * time.Millisecond}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.20")
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	templates := s.templates[src.String()].templates
+	templates.AddTemplate(9, 1, netflow.TemplateRecord{TemplateId: 256, FieldCount: 1, Fields: []netflow.Field{{Type: netflow.NFV9_FIELD_IPV4_SRC_ADDR, Length: 4}}})
+	scope := producer.SamplingRateScope{Type: producer.SAMPLING_SCOPE_SAMPLER, Id: 1}
+	sampling := s.sampling[src.String()].(producer.ScopedSamplingRateSystem)
+	sampling.AddScopedSamplingRate(10, 1, scope, 100)
+	s.interfaces[src.String()].AddInterface(1, producer.InterfaceName{Name: "xe-0/0/1"})
+
+	time.Sleep(20 * time.Millisecond)
+	s.removeExpired(time.Now())
+	_, ok := templates.TemplateUpdated(9, 1, 256)
+	assert.False(t, ok)
+	_, ok = templates.TemplateUpdated(10, 1, 256)
+	assert.True(t, ok)
+	_, ok = sampling.GetScopedSamplingRate(10, 1, scope)
+	assert.False(t, ok)
+	_, ok = s.interfaces[src.String()].GetInterface(1)
+	assert.False(t, ok)
+}
+
+func TestServeHTTPTemplatesAge(t *testing.T) {
+	s := &StateNetFlow{Transport: &testTransport{}}
+	s.initTemplates()
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: net.ParseIP("192.0.2.15"), Payload: getIPFIXTemplate()}))
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTPTemplates(recorder, httptest.NewRequest("GET", "/templates", nil))
+
+	var templates map[string]map[string]map[string]map[string]struct {
+		TemplateId uint16
+		Age        *int64
+	}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &templates))
+	template := templates["192.0.2.15"]["10"]["1"]["256"]
+	assert.Equal(t, uint16(256), template.TemplateId)
+	if assert.NotNil(t, template.Age) {
+		assert.Equal(t, int64(0), *template.Age)
+	}
+}
+
+func TestBufferDataSets(t *testing.T) {
+	transport := &testTransport{}
+	s := &StateNetFlow{
+		Transport:  transport,
+		BufferSize: 2,
+		BufferAge:  time.Minute,
+	}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.16")
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}))
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}))
+	// the buffer of the template is full
+	var errTemplate *netflow.ErrorTemplateNotFound
+	assert.ErrorAs(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}), &errTemplate)
+	assert.Equal(t, 0, transport.Count())
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	assert.Equal(t, 2*2, transport.Count())
+	assert.Equal(t, uint64(1500), transport.msgs[0].Bytes)
+	assert.Equal(t, float64(2), testutil.ToFloat64(NetFlowBufferedSets.With(prometheus.Labels{
+		"router":  src.String(),
+		"version": "10",
+		"status":  "recovered",
+	})))
+	// the recovered sets are counted with the ones of the packets
+	assert.Equal(t, float64(2), testutil.ToFloat64(NetFlowSetStatsSum.With(prometheus.Labels{
+		"router":  src.String(),
+		"version": "10",
+		"type":    "DataFlowSet",
+	})))
+}
+
+func TestBufferDataSetTemplateReceived(t *testing.T) {
+	s := &StateNetFlow{
+		Transport:  &testTransport{},
+		BufferSize: 1,
+	}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.19")
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+
+	// the template was received by another worker after the set was decoded
+	templates := s.templates[src.String()]
+	packet := netflow.IPFIXPacket{Version: 10, ObservationDomainId: 1}
+	assert.True(t, templates.BufferDataSet(packet, netflow.FlowSetHeader{Id: 256, Length: 4 + 20}, getIPFIXData()[20:40]))
+	assert.Empty(t, templates.buffer.sets)
+	assert.Len(t, templates.takeRecoveredDataSets(), 1)
+}
+
+func TestBufferDataSetsExpired(t *testing.T) {
+	transport := &testTransport{}
+	s := &StateNetFlow{
+		Transport:  transport,
+		BufferSize: 2,
+		BufferAge:  10 * time.Millisecond,
+	}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.17")
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	assert.Equal(t, 0, transport.Count())
+	assert.Equal(t, float64(1), testutil.ToFloat64(NetFlowBufferedSets.With(prometheus.Labels{
+		"router":  src.String(),
+		"version": "10",
+		"status":  "expired",
+	})))
+}
+
+func TestDecodeFlowPartial(t *testing.T) {
+	transport := &testTransport{}
+	s := &StateNetFlow{Transport: transport}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.18")
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+
+	// a data set of unknown template 257 followed by the data set of template 256
+	data := getIPFIXData()
+	unknown := []byte{0x01, 0x01, 0x00, 0x08, 0x0a, 0x00, 0x00, 0x01}
+	pkt := append(append(append([]byte{}, data[:16]...), unknown...), data[16:]...)
+	binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+
+	// by default the message fails
+	err := s.DecodeFlow(BaseMessage{Src: src, Payload: pkt})
+	assert.IsType(t, &netflow.ErrorTemplateNotFound{}, err)
+	assert.Equal(t, 0, transport.Count())
+
+	templateErrors := NetFlowErrors.With(prometheus.Labels{"router": src.String(), "error": "template_not_found"})
+	count := testutil.ToFloat64(templateErrors)
+	s.PartialDecoding = true
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: pkt}))
+	assert.Equal(t, 2, transport.Count())
+	assert.Equal(t, count+1, testutil.ToFloat64(templateErrors))
+}
+
+func TestDefaultErrorCallbackTemplateNotFound(t *testing.T) {
+	logger, hook := logrustest.NewNullLogger()
+	cb := DefaultErrorCallback{Logger: logger}
+	now := time.Now()
+
+	_, err := netflow.DecodeMessagePartial(bytes.NewBuffer(getIPFIXData()), netflow.CreateTemplateSystem())
+	assert.IsType(t, &netflow.ErrorFlowSets{}, err)
+	cb.Callback("NetFlow", 0, now, now, err)
+	assert.Empty(t, hook.AllEntries())
+
+	cb.Callback("NetFlow", 0, now, now, netflow.NewErrorVersion(8))
+	assert.Len(t, hook.AllEntries(), 1)
+}
+
+func TestSamplingRateConfig(t *testing.T) {
+	transport := &testTransport{}
+	config, err := producer.CreateSamplingRateConfig(&producer.SamplingRateMapping{
+		Sampling: []producer.SamplingRateRule{
+			{Network: "192.0.2.32/32", Rate: 100, Mode: producer.SAMPLING_SOURCE_OVERRIDE},
+			{Network: "192.0.2.0/24", Rate: 1000},
+		},
+	})
+	assert.Nil(t, err)
+	s := &StateNetFlow{
+		Transport: transport,
+		Sampling:  config,
+	}
+	s.initTemplates()
+
+	for _, router := range []string{"192.0.2.32", "192.0.2.33", "198.51.100.1"} {
+		src := net.ParseIP(router)
+		assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+		assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}))
+	}
+	assert.Equal(t, 3*2, transport.Count())
+	assert.Equal(t, uint64(100), transport.msgs[0].SamplingRate)
+	assert.Equal(t, uint64(1000), transport.msgs[2].SamplingRate)
+	assert.Equal(t, uint64(0), transport.msgs[4].SamplingRate)
+	for router, source := range map[string]string{
+		"192.0.2.32":   producer.SAMPLING_SOURCE_OVERRIDE,
+		"192.0.2.33":   producer.SAMPLING_SOURCE_DEFAULT,
+		"198.51.100.1": producer.SAMPLING_SOURCE_NONE,
+	} {
+		assert.Equal(t, float64(2), testutil.ToFloat64(NetFlowSamplingRateSource.With(prometheus.Labels{
+			"router": router,
+			"source": source,
+		})))
+	}
+
+	// the learned rate comes before the default one
+	s.sampling["192.0.2.33"].AddSamplingRate(10, 1, 10)
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: net.ParseIP("192.0.2.33"), Payload: getIPFIXData()}))
+	assert.Equal(t, uint64(10), transport.msgs[6].SamplingRate)
+}
+
+func TestSamplingRateFixed(t *testing.T) {
+	transport := &testTransport{}
+	s := &StateNetFlow{
+		Transport:    transport,
+		SamplingRate: 64,
+	}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.34")
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	s.sampling[src.String()].AddSamplingRate(10, 1, 10)
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}))
+	assert.Equal(t, 2, transport.Count())
+	assert.Equal(t, uint64(64), transport.msgs[0].SamplingRate)
+	assert.Equal(t, float64(2), testutil.ToFloat64(NetFlowSamplingRateSource.With(prometheus.Labels{
+		"router": src.String(),
+		"source": producer.SAMPLING_SOURCE_OVERRIDE,
+	})))
+}
+
+func TestSamplingRateSourceDisabled(t *testing.T) {
+	transport := &testTransport{}
+	s := &StateNetFlow{Transport: transport}
+	s.initTemplates()
+	src := net.ParseIP("192.0.2.35")
+	series := testutil.CollectAndCount(NetFlowSamplingRateSource)
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}))
+	assert.Equal(t, 2, transport.Count())
+	// without sampling rules, the sources are not counted
+	assert.Equal(t, series, testutil.CollectAndCount(NetFlowSamplingRateSource))
+}
+
+// IPFIX message with an options template naming the interfaces (scope ingressInterface,
+// interfaceName), a template (ingressInterface, egressInterface, octetDeltaCount) and
+// their data.
+func getIPFIXInterfaces() []byte {
+	u16 := binary.BigEndian.AppendUint16
+	u32 := binary.BigEndian.AppendUint32
+	var sets []byte
+	sets = u16(u16(sets, 3), 18)
+	sets = u16(u16(u16(sets, 257), 2), 1)
+	sets = u16(u16(sets, 10), 4)
+	sets = u16(u16(sets, 82), 8)
+	sets = u16(u16(sets, 2), 20)
+	sets = u16(u16(sets, 258), 3)
+	sets = u16(u16(sets, 10), 4)
+	sets = u16(u16(sets, 14), 4)
+	sets = u16(u16(sets, 1), 8)
+	sets = u16(u16(sets, 257), 28)
+	sets = append(u32(sets, 1), "xe-0/0/1"...)
+	sets = append(u32(sets, 2), "lo0\x00\x00\x00\x00\x00"...)
+	sets = u16(u16(sets, 258), 20)
+	sets = binary.BigEndian.AppendUint64(u32(u32(sets, 1), 2), 1500)
+
+	msg := u16(u16(nil, 10), uint16(16+len(sets)))
+	msg = u32(u32(u32(msg, 0x5f5e1000), 1), 1)
+	return append(msg, sets...)
+}
+
+func TestInterfaceNames(t *testing.T) {
+	transport := &testTransport{}
+	s := &StateNetFlow{Transport: transport}
+	s.initTemplates()
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: net.ParseIP("192.0.2.35"), Payload: getIPFIXInterfaces()}))
+	if assert.Equal(t, 1, transport.Count()) {
+		assert.Equal(t, uint32(1), transport.msgs[0].InIf)
+		assert.Equal(t, "xe-0/0/1", transport.msgs[0].InIfName)
+		assert.Equal(t, "lo0", transport.msgs[0].OutIfName)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTPInterfaces(recorder, httptest.NewRequest("GET", "/interfaces", nil))
+	var interfaces map[string]map[string]producer.InterfaceName
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &interfaces))
+	assert.Equal(t, map[string]map[string]producer.InterfaceName{
+		"192.0.2.35": {
+			"1": {Name: "xe-0/0/1"},
+			"2": {Name: "lo0"},
+		},
+	}, interfaces)
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"time"
 
 	"github.com/cloudflare/goflow/v3/decoders/netflowlegacy"
@@ -13,6 +14,13 @@ import (
 type StateNFLegacy struct {
 	Transport Transport
 	Logger    Logger
+	Tee       DatagramTee
+
+	QueueSize   int    // datagrams waiting for a worker
+	QueuePolicy string // when the queue is full (decoder.QUEUE_POLICY_*)
+	Sockets     int    // SO_REUSEPORT sockets with a reader each
+	PinReaders  bool   // lock each reader to an OS thread
+	ReadBatch   int    // datagrams read per system call on Linux (recvmmsg)
 }
 
 func (s *StateNFLegacy) DecodeFlow(msg interface{}) error {
@@ -80,10 +88,30 @@ func (s *StateNFLegacy) DecodeFlow(msg interface{}) error {
 	if s.Transport != nil {
 		s.Transport.Publish(flowMessageSet)
 	}
+	producer.ReleaseFlowMessages(flowMessageSet)
 
 	return nil
 }
 
 func (s *StateNFLegacy) FlowRoutine(workers int, addr string, port int, reuseport bool) error {
-	return UDPRoutine("NetFlowV5", s.DecodeFlow, workers, addr, port, reuseport, s.Logger)
+	return s.FlowRoutineContext(context.Background(), workers, addr, port, reuseport)
+}
+
+// FlowRoutineContext collects until ctx is done, then decodes the datagrams
+// already received before returning.
+func (s *StateNFLegacy) FlowRoutineContext(ctx context.Context, workers int, addr string, port int, reuseport bool) error {
+	return udpRoutine(ctx, "NetFlowV5", s.DecodeFlow, workers, addr, port, reuseport, s.udpOptions(), s.Logger)
+}
+
+func (s *StateNFLegacy) udpOptions() udpOptions {
+	return udpOptions{
+		tee:         s.Tee,
+		queueSize:   s.QueueSize,
+		queuePolicy: s.QueuePolicy,
+		sockets:     s.Sockets,
+		pinReaders:  s.PinReaders,
+		readBatch:   s.ReadBatch,
+
+		poolPayloads: true,
+	}
 }
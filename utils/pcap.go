@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	decoder "github.com/cloudflare/goflow/v3/decoders"
+	"github.com/cloudflare/goflow/v3/decoders/pcap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PcapRoute sends the datagrams captured towards a port to a collector.
+type PcapRoute struct {
+	Name       string
+	DecodeFunc decoder.DecoderFunc
+}
+
+// PcapRoutine replays the UDP datagrams of a capture file (pcap or pcapng) to
+// the route of their destination port, in order. The messages are received
+// at the time of their capture.
+func PcapRoutine(path string, routes map[int]PcapRoute, logger Logger) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := pcap.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	ecb := DefaultErrorCallback{
+		Logger: logger,
+	}
+
+	for {
+		packet, err := reader.ReadPacket()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		datagram, err := pcap.DecodeUDP(packet.LinkType, packet.Data)
+		if err != nil {
+			continue
+		}
+		route, ok := routes[datagram.DstPort]
+		if !ok {
+			continue
+		}
+
+		baseMessage := BaseMessage{
+			Src:      datagram.Src,
+			Port:     datagram.SrcPort,
+			Payload:  datagram.Payload,
+			SetTime:  true,
+			RecvTime: packet.Timestamp,
+		}
+
+		timeTrackStart := time.Now()
+		err = route.DecodeFunc(baseMessage)
+		timeTrackStop := time.Now()
+		if err != nil {
+			ecb.Callback(route.Name, 0, timeTrackStart, timeTrackStop, err)
+		} else {
+			DefaultAccountCallback(route.Name, 0, timeTrackStart, timeTrackStop)
+		}
+
+		labels := prometheus.Labels{
+			"remote_ip":   datagram.Src.String(),
+			"remote_port": strconv.Itoa(datagram.SrcPort),
+			"local_ip":    datagram.Dst.String(),
+			"local_port":  strconv.Itoa(datagram.DstPort),
+			"type":        route.Name,
+		}
+		MetricTrafficBytes.With(labels).Add(float64(len(datagram.Payload)))
+		MetricTrafficPackets.With(labels).Inc()
+		MetricPacketSizeSum.With(labels).Observe(float64(len(datagram.Payload)))
+	}
+}
+
+func (s *StateSFlow) PcapRoute() PcapRoute {
+	return PcapRoute{"sFlow", s.DecodeFlow}
+}
+
+func (s *StateNetFlow) PcapRoute() PcapRoute {
+	s.initTemplates()
+	return PcapRoute{"NetFlow", s.DecodeFlow}
+}
+
+func (s *StateNFLegacy) PcapRoute() PcapRoute {
+	return PcapRoute{"NetFlowV5", s.DecodeFlow}
+}
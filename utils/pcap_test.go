@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/goflow/v3/decoders/pcap"
+	"github.com/stretchr/testify/assert"
+)
+
+// getRawUDPPacket returns an IPv4 packet from 10.0.0.1:1234 to 10.0.0.2:port.
+func getRawUDPPacket(port uint16, payload []byte) []byte {
+	ip := []byte{
+		0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02,
+		0x04, 0xd2, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.BigEndian.PutUint16(ip[2:4], uint16(28+len(payload)))
+	binary.BigEndian.PutUint16(ip[22:24], port)
+	binary.BigEndian.PutUint16(ip[24:26], uint16(8+len(payload)))
+	return append(ip, payload...)
+}
+
+func TestPcapRoutine(t *testing.T) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []uint32{pcap.PCAP_MAGIC_MICROSECONDS})
+	binary.Write(buf, binary.LittleEndian, []uint16{2, 4})
+	binary.Write(buf, binary.LittleEndian, []uint32{0, 0, 65535, pcap.LINKTYPE_RAW})
+	packets := [][]byte{
+		getRawUDPPacket(2055, getIPFIXTemplate()),
+		getRawUDPPacket(9999, getIPFIXData()),
+		getRawUDPPacket(2055, getIPFIXData()),
+	}
+	for i, packet := range packets {
+		binary.Write(buf, binary.LittleEndian, []uint32{1600000000 + uint32(i), 0, uint32(len(packet)), uint32(len(packet))})
+		buf.Write(packet)
+	}
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	assert.Nil(t, os.WriteFile(path, buf.Bytes(), 0644))
+
+	transport := &testTransport{}
+	s := &StateNetFlow{
+		Transport: transport,
+	}
+	err := PcapRoutine(path, map[int]PcapRoute{2055: s.PcapRoute()}, nil)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, transport.Count())
+	assert.Equal(t, uint64(1600000002), transport.msgs[0].TimeReceived)
+	assert.Equal(t, []byte{10, 0, 0, 1}, transport.msgs[0].SamplerAddress)
+}
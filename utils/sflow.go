@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"net"
 	"time"
 
@@ -14,8 +15,16 @@ import (
 type StateSFlow struct {
 	Transport Transport
 	Logger    Logger
+	Tee       DatagramTee
 
-	Config *producer.SFlowProducerConfig
+	QueueSize   int    // datagrams waiting for a worker
+	QueuePolicy string // when the queue is full (decoder.QUEUE_POLICY_*)
+	Sockets     int    // SO_REUSEPORT sockets with a reader each
+	PinReaders  bool   // lock each reader to an OS thread
+	ReadBatch   int    // datagrams read per system call on Linux (recvmmsg)
+
+	Config   *producer.SFlowProducerConfig
+	Counters *CountersExporter // latest interface counters exported as metrics
 }
 
 func (s *StateSFlow) DecodeFlow(msg interface{}) error {
@@ -133,10 +142,43 @@ func (s *StateSFlow) DecodeFlow(msg interface{}) error {
 	if s.Transport != nil {
 		s.Transport.Publish(flowMessageSet)
 	}
+	producer.ReleaseFlowMessages(flowMessageSet)
+
+	if countersTransport, ok := s.Transport.(CountersTransport); ok {
+		countersMessageSet, _ := producer.ProcessMessageSFlowCounters(msgDec)
+		for _, cmsg := range countersMessageSet {
+			cmsg.TimeReceived = ts
+		}
+		if len(countersMessageSet) > 0 {
+			countersTransport.PublishCounters(countersMessageSet)
+		}
+	}
+	if packet, ok := msgDec.(sflow.Packet); ok && s.Counters != nil {
+		s.Counters.Update(packet)
+	}
 
 	return nil
 }
 
 func (s *StateSFlow) FlowRoutine(workers int, addr string, port int, reuseport bool) error {
-	return UDPRoutine("sFlow", s.DecodeFlow, workers, addr, port, reuseport, s.Logger)
+	return s.FlowRoutineContext(context.Background(), workers, addr, port, reuseport)
+}
+
+// FlowRoutineContext collects until ctx is done, then decodes the datagrams
+// already received before returning.
+func (s *StateSFlow) FlowRoutineContext(ctx context.Context, workers int, addr string, port int, reuseport bool) error {
+	return udpRoutine(ctx, "sFlow", s.DecodeFlow, workers, addr, port, reuseport, s.udpOptions(), s.Logger)
+}
+
+func (s *StateSFlow) udpOptions() udpOptions {
+	return udpOptions{
+		tee:         s.Tee,
+		queueSize:   s.QueueSize,
+		queuePolicy: s.QueuePolicy,
+		sockets:     s.Sockets,
+		pinReaders:  s.PinReaders,
+		readBatch:   s.ReadBatch,
+
+		poolPayloads: true,
+	}
 }
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/sflow"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type interfaceKey struct {
+	agent   string
+	ifIndex uint32
+}
+
+type interfaceCounters struct {
+	ifCounters *sflow.IfCounters
+	ethernet   *sflow.EthernetCounters
+	updated    time.Time
+}
+
+type ifCountersMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(*sflow.IfCounters) float64
+}
+
+type ethernetCountersMetric struct {
+	desc  *prometheus.Desc
+	value func(*sflow.EthernetCounters) float64
+}
+
+var interfaceLabels = []string{"agent", "if_index"}
+
+func newInterfaceDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, interfaceLabels, nil)
+}
+
+// CountersExporter is a Prometheus collector of the latest interface counters
+// received by an sFlow collector for each agent and ifIndex. The interfaces
+// not received for TTL are not exported anymore.
+type CountersExporter struct {
+	TTL time.Duration
+
+	lock       sync.Mutex
+	interfaces map[interfaceKey]*interfaceCounters
+	ifMetrics  []ifCountersMetric
+	ethMetrics []ethernetCountersMetric
+}
+
+func NewCountersExporter(ttl time.Duration) *CountersExporter {
+	return &CountersExporter{
+		TTL:        ttl,
+		interfaces: make(map[interfaceKey]*interfaceCounters),
+		ifMetrics: []ifCountersMetric{
+			{newInterfaceDesc("flow_sflow_if_speed", "Interface speed in bits per second."), prometheus.GaugeValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfSpeed) }},
+			{newInterfaceDesc("flow_sflow_if_status", "Interface status (bit 0: admin up, bit 1: operational up)."), prometheus.GaugeValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfStatus) }},
+			{newInterfaceDesc("flow_sflow_if_in_octets_count", "Octets received by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfInOctets) }},
+			{newInterfaceDesc("flow_sflow_if_in_ucast_packets_count", "Unicast packets received by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfInUcastPkts) }},
+			{newInterfaceDesc("flow_sflow_if_in_multicast_packets_count", "Multicast packets received by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfInMulticastPkts) }},
+			{newInterfaceDesc("flow_sflow_if_in_broadcast_packets_count", "Broadcast packets received by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfInBroadcastPkts) }},
+			{newInterfaceDesc("flow_sflow_if_in_discards_count", "Inbound packets discarded by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfInDiscards) }},
+			{newInterfaceDesc("flow_sflow_if_in_errors_count", "Inbound packets with errors."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfInErrors) }},
+			{newInterfaceDesc("flow_sflow_if_in_unknown_protos_count", "Inbound packets of unknown protocols."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfInUnknownProtos) }},
+			{newInterfaceDesc("flow_sflow_if_out_octets_count", "Octets sent by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfOutOctets) }},
+			{newInterfaceDesc("flow_sflow_if_out_ucast_packets_count", "Unicast packets sent by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfOutUcastPkts) }},
+			{newInterfaceDesc("flow_sflow_if_out_multicast_packets_count", "Multicast packets sent by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfOutMulticastPkts) }},
+			{newInterfaceDesc("flow_sflow_if_out_broadcast_packets_count", "Broadcast packets sent by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfOutBroadcastPkts) }},
+			{newInterfaceDesc("flow_sflow_if_out_discards_count", "Outbound packets discarded by the interface."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfOutDiscards) }},
+			{newInterfaceDesc("flow_sflow_if_out_errors_count", "Outbound packets with errors."), prometheus.CounterValue,
+				func(c *sflow.IfCounters) float64 { return float64(c.IfOutErrors) }},
+		},
+		ethMetrics: []ethernetCountersMetric{
+			{newInterfaceDesc("flow_sflow_if_dot3_alignment_errors_count", "Ethernet frames with alignment errors."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsAlignmentErrors) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_fcs_errors_count", "Ethernet frames with FCS errors."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsFCSErrors) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_single_collision_frames_count", "Ethernet frames sent after a single collision."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsSingleCollisionFrames) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_multiple_collision_frames_count", "Ethernet frames sent after multiple collisions."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsMultipleCollisionFrames) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_sqe_test_errors_count", "Ethernet SQE test errors."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsSQETestErrors) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_deferred_transmissions_count", "Ethernet frames deferred because the medium was busy."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsDeferredTransmissions) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_late_collisions_count", "Ethernet late collisions."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsLateCollisions) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_excessive_collisions_count", "Ethernet frames not sent because of excessive collisions."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsExcessiveCollisions) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_internal_mac_transmit_errors_count", "Ethernet frames not sent because of MAC errors."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsInternalMacTransmitErrors) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_carrier_sense_errors_count", "Ethernet carrier sense errors."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsCarrierSenseErrors) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_frame_too_longs_count", "Ethernet frames exceeding the maximum size."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsFrameTooLongs) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_internal_mac_receive_errors_count", "Ethernet frames not received because of MAC errors."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsInternalMacReceiveErrors) }},
+			{newInterfaceDesc("flow_sflow_if_dot3_symbol_errors_count", "Ethernet symbol errors."),
+				func(c *sflow.EthernetCounters) float64 { return float64(c.Dot3StatsSymbolErrors) }},
+		},
+	}
+}
+
+// Update keeps the interface counters of the counter samples of a packet.
+func (e *CountersExporter) Update(packet sflow.Packet) {
+	agent := net.IP(packet.AgentIP).String()
+	now := time.Now()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, sample := range packet.Samples {
+		counterSample, ok := sample.(sflow.CounterSample)
+		if !ok {
+			continue
+		}
+		var ifCounters *sflow.IfCounters
+		var ethernet *sflow.EthernetCounters
+		for _, record := range counterSample.Records {
+			switch recordData := record.Data.(type) {
+			case sflow.IfCounters:
+				ifCounters = &recordData
+			case sflow.EthernetCounters:
+				ethernet = &recordData
+			}
+		}
+		if ifCounters == nil && ethernet == nil {
+			continue
+		}
+
+		// the data source of interface counters is the ifIndex
+		key := interfaceKey{agent, counterSample.Header.SourceIdValue}
+		if ifCounters != nil {
+			key.ifIndex = ifCounters.IfIndex
+		}
+		counters, ok := e.interfaces[key]
+		if !ok {
+			counters = &interfaceCounters{}
+			e.interfaces[key] = counters
+		}
+		// agents may send the structures in different samples
+		if ifCounters != nil {
+			counters.ifCounters = ifCounters
+		}
+		if ethernet != nil {
+			counters.ethernet = ethernet
+		}
+		counters.updated = now
+	}
+}
+
+func (e *CountersExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range e.ifMetrics {
+		ch <- metric.desc
+	}
+	for _, metric := range e.ethMetrics {
+		ch <- metric.desc
+	}
+}
+
+// Collect exports the interfaces which have not expired and forgets the others.
+func (e *CountersExporter) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for key, counters := range e.interfaces {
+		if e.TTL > 0 && now.Sub(counters.updated) > e.TTL {
+			delete(e.interfaces, key)
+			continue
+		}
+		ifIndex := strconv.Itoa(int(key.ifIndex))
+		if counters.ifCounters != nil {
+			for _, metric := range e.ifMetrics {
+				ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(counters.ifCounters), key.agent, ifIndex)
+			}
+		}
+		if counters.ethernet != nil {
+			for _, metric := range e.ethMetrics {
+				ch <- prometheus.MustNewConstMetric(metric.desc, prometheus.CounterValue, metric.value(counters.ethernet), key.agent, ifIndex)
+			}
+		}
+	}
+}
@@ -1,8 +1,17 @@
 package utils
 
 import (
-	"github.com/stretchr/testify/assert"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	flowmessage "github.com/cloudflare/goflow/v3/pb"
+	proto "github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDecodeFlowExpandedSFlow(t *testing.T) {
@@ -19,6 +28,72 @@ func TestDecodeFlowExpandedSFlow(t *testing.T) {
 	assert.Nil(t, s.DecodeFlow(msg))
 }
 
+type testCountersTransport struct {
+	testTransport
+	countersLock sync.Mutex
+	counters     []*flowmessage.CountersMessage
+}
+
+func (t *testCountersTransport) PublishCounters(msgs []*flowmessage.CountersMessage) {
+	t.countersLock.Lock()
+	for _, msg := range msgs {
+		t.counters = append(t.counters, proto.Clone(msg).(*flowmessage.CountersMessage))
+	}
+	t.countersLock.Unlock()
+}
+
+// getSFlowCounters returns an sFlow datagram of agent 192.0.2.1 with a
+// counter sample of interface 3 (generic interface counters).
+func getSFlowCounters() []byte {
+	ifCounters := binary.BigEndian.AppendUint32(nil, 3)            // IfIndex
+	ifCounters = binary.BigEndian.AppendUint32(ifCounters, 6)      // IfType
+	ifCounters = binary.BigEndian.AppendUint64(ifCounters, 1e10)   // IfSpeed
+	ifCounters = binary.BigEndian.AppendUint32(ifCounters, 1)      // IfDirection
+	ifCounters = binary.BigEndian.AppendUint32(ifCounters, 3)      // IfStatus
+	ifCounters = binary.BigEndian.AppendUint64(ifCounters, 123456) // IfInOctets
+	ifCounters = append(ifCounters, make([]byte, 6*4)...)
+	ifCounters = binary.BigEndian.AppendUint64(ifCounters, 654321) // IfOutOctets
+	ifCounters = append(ifCounters, make([]byte, 6*4)...)
+
+	sample := binary.BigEndian.AppendUint32(nil, 1)   // sequence number
+	sample = binary.BigEndian.AppendUint32(sample, 3) // source id
+	sample = binary.BigEndian.AppendUint32(sample, 1) // records
+	sample = binary.BigEndian.AppendUint32(sample, 1) // generic interface counters
+	sample = binary.BigEndian.AppendUint32(sample, uint32(len(ifCounters)))
+	sample = append(sample, ifCounters...)
+
+	pkt := binary.BigEndian.AppendUint32(nil, 5)
+	pkt = binary.BigEndian.AppendUint32(pkt, 1)
+	pkt = append(pkt, 192, 0, 2, 1)
+	pkt = binary.BigEndian.AppendUint32(pkt, 0)  // sub agent
+	pkt = binary.BigEndian.AppendUint32(pkt, 42) // sequence number
+	pkt = binary.BigEndian.AppendUint32(pkt, 0)  // uptime
+	pkt = binary.BigEndian.AppendUint32(pkt, 1)  // samples
+	pkt = binary.BigEndian.AppendUint32(pkt, 2)  // counter sample
+	pkt = binary.BigEndian.AppendUint32(pkt, uint32(len(sample)))
+	return append(pkt, sample...)
+}
+
+func TestDecodeFlowSFlowCounters(t *testing.T) {
+	transport := &testCountersTransport{}
+	s := &StateSFlow{
+		Transport: transport,
+	}
+
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: net.ParseIP("192.0.2.1"), Payload: getSFlowCounters()}))
+	assert.Equal(t, 0, transport.Count())
+	if assert.Len(t, transport.counters, 1) {
+		cmsg := transport.counters[0]
+		assert.Equal(t, []byte{192, 0, 2, 1}, cmsg.SamplerAddress)
+		assert.Equal(t, uint32(42), cmsg.SequenceNum)
+		assert.Equal(t, uint32(3), cmsg.IfIndex)
+		assert.Equal(t, uint64(1e10), cmsg.IfSpeed)
+		assert.Equal(t, uint64(123456), cmsg.IfInOctets)
+		assert.Equal(t, uint64(654321), cmsg.IfOutOctets)
+		assert.NotZero(t, cmsg.TimeReceived)
+	}
+}
+
 func getExpandedSFlowDecode() []byte {
 	return []byte{
 		0, 0, 0, 5, 0, 0, 0, 1, 1, 2, 3, 4, 0, 0, 0, 0, 5, 167, 139, 219, 5, 118,
@@ -92,3 +167,29 @@ func getExpandedSFlowDecode() []byte {
 		0, 0, 0, 0,
 	}
 }
+
+func TestCountersExporter(t *testing.T) {
+	exporter := NewCountersExporter(time.Minute)
+	s := &StateSFlow{
+		Transport: &testTransport{},
+		Counters:  exporter,
+	}
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: net.ParseIP("192.0.2.1"), Payload: getSFlowCounters()}))
+
+	expected := `
+# HELP flow_sflow_if_in_octets_count Octets received by the interface.
+# TYPE flow_sflow_if_in_octets_count counter
+flow_sflow_if_in_octets_count{agent="192.0.2.1",if_index="3"} 123456
+# HELP flow_sflow_if_speed Interface speed in bits per second.
+# TYPE flow_sflow_if_speed gauge
+flow_sflow_if_speed{agent="192.0.2.1",if_index="3"} 1e+10
+`
+	assert.Nil(t, testutil.CollectAndCompare(exporter, strings.NewReader(expected), "flow_sflow_if_in_octets_count", "flow_sflow_if_speed"))
+	// no Ethernet counters received
+	assert.Equal(t, 0, testutil.CollectAndCount(exporter, "flow_sflow_if_dot3_fcs_errors_count"))
+
+	// the agent went silent
+	exporter.TTL = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, testutil.CollectAndCount(exporter))
+}
@@ -0,0 +1,390 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	"github.com/cloudflare/goflow/v3/producer"
+)
+
+const (
+	TEMPLATE_STORE_VERSION = 1
+
+	STORED_TEMPLATE      = "template"
+	STORED_NFV9_OPTIONS  = "nfv9_options_template"
+	STORED_IPFIX_OPTIONS = "ipfix_options_template"
+)
+
+type templateStoreKey struct {
+	router      string
+	version     uint16
+	obsDomainId uint32
+	templateId  uint16
+}
+
+type samplingStoreKey struct {
+	router      string
+	version     uint16
+	obsDomainId uint32
+	scope       producer.SamplingRateScope
+}
+
+type storedTemplate struct {
+	Router      string          `json:"router"`
+	Version     uint16          `json:"version"`
+	ObsDomainId uint32          `json:"obs_domain_id"`
+	TemplateId  uint16          `json:"template_id"`
+	Type        string          `json:"type"`
+	Updated     time.Time       `json:"updated"`
+	Template    json.RawMessage `json:"template"`
+
+	template interface{} // decoded Template
+}
+
+type storedSamplingRate struct {
+	Router       string    `json:"router"`
+	Version      uint16    `json:"version"`
+	ObsDomainId  uint32    `json:"obs_domain_id"`
+	ScopeType    uint16    `json:"scope_type,omitempty"`
+	ScopeId      uint64    `json:"scope_id,omitempty"`
+	SamplingRate uint32    `json:"sampling_rate"`
+	Updated      time.Time `json:"updated"`
+}
+
+type templateStoreFile struct {
+	Version   int                  `json:"version"`
+	Templates []storedTemplate     `json:"templates"`
+	Sampling  []storedSamplingRate `json:"sampling"`
+}
+
+// TemplateStore keeps the NetFlow/IPFIX templates and sampling rates of the
+// routers in a JSON file so that data received right after a restart can be
+// decoded. Entries not refreshed by the routers for TTL are dropped.
+type TemplateStore struct {
+	Path string
+	TTL  time.Duration // 0 keeps the entries forever
+
+	lock      sync.Mutex
+	templates map[templateStoreKey]storedTemplate
+	sampling  map[samplingStoreKey]storedSamplingRate
+	dirty     bool
+}
+
+func NewTemplateStore(path string, ttl time.Duration) *TemplateStore {
+	return &TemplateStore{
+		Path:      path,
+		TTL:       ttl,
+		templates: make(map[templateStoreKey]storedTemplate),
+		sampling:  make(map[samplingStoreKey]storedSamplingRate),
+	}
+}
+
+func (s *TemplateStore) expired(updated time.Time, now time.Time) bool {
+	return s.TTL > 0 && now.Sub(updated) > s.TTL
+}
+
+// unchanged tells if an entry received again with the same value can be left
+// as is. It is written again once half of the TTL passed so that it does not
+// expire while the router keeps sending it.
+func (s *TemplateStore) unchanged(updated time.Time, now time.Time) bool {
+	return s.TTL <= 0 || now.Sub(updated) < s.TTL/2
+}
+
+// Load replaces the entries of the store with the ones of the file which
+// have not expired. Invalid entries are skipped and reported in the returned
+// error, the others are still loaded. A missing file is not an error.
+func (s *TemplateStore) Load() error {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var file templateStoreFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+	if file.Version != TEMPLATE_STORE_VERSION {
+		return fmt.Errorf("unknown template store version %v", file.Version)
+	}
+
+	now := time.Now()
+	templates := make(map[templateStoreKey]storedTemplate)
+	sampling := make(map[samplingStoreKey]storedSamplingRate)
+	var skipped int
+	var skippedErr error
+	for _, entry := range file.Templates {
+		if s.expired(entry.Updated, now) {
+			continue
+		}
+		entry.template, err = decodeStoredTemplate(entry)
+		if err != nil {
+			skipped++
+			skippedErr = err
+			continue
+		}
+		templates[templateStoreKey{entry.Router, entry.Version, entry.ObsDomainId, entry.TemplateId}] = entry
+	}
+	for _, entry := range file.Sampling {
+		if s.expired(entry.Updated, now) {
+			continue
+		}
+		sampling[samplingStoreKey{entry.Router, entry.Version, entry.ObsDomainId, entry.scope()}] = entry
+	}
+
+	s.lock.Lock()
+	s.templates = templates
+	s.sampling = sampling
+	s.lock.Unlock()
+	if skipped > 0 {
+		return fmt.Errorf("skipped %v invalid stored templates (last: %v)", skipped, skippedErr)
+	}
+	return nil
+}
+
+func decodeStoredTemplate(entry storedTemplate) (interface{}, error) {
+	var err error
+	switch entry.Type {
+	case STORED_TEMPLATE:
+		var template netflow.TemplateRecord
+		err = json.Unmarshal(entry.Template, &template)
+		return template, err
+	case STORED_NFV9_OPTIONS:
+		var template netflow.NFv9OptionsTemplateRecord
+		err = json.Unmarshal(entry.Template, &template)
+		return template, err
+	case STORED_IPFIX_OPTIONS:
+		var template netflow.IPFIXOptionsTemplateRecord
+		err = json.Unmarshal(entry.Template, &template)
+		return template, err
+	}
+	return nil, fmt.Errorf("unknown stored template type %v", entry.Type)
+}
+
+// AddTemplate records a template received from router. A template received
+// again with the same fields is not written again.
+func (s *TemplateStore) AddTemplate(router string, version uint16, obsDomainId uint32, template interface{}) {
+	entry := storedTemplate{
+		Router:      router,
+		Version:     version,
+		ObsDomainId: obsDomainId,
+		Updated:     time.Now(),
+		template:    template,
+	}
+	switch templatec := template.(type) {
+	case netflow.TemplateRecord:
+		entry.Type = STORED_TEMPLATE
+		entry.TemplateId = templatec.TemplateId
+	case netflow.NFv9OptionsTemplateRecord:
+		entry.Type = STORED_NFV9_OPTIONS
+		entry.TemplateId = templatec.TemplateId
+	case netflow.IPFIXOptionsTemplateRecord:
+		entry.Type = STORED_IPFIX_OPTIONS
+		entry.TemplateId = templatec.TemplateId
+	default:
+		return
+	}
+	key := templateStoreKey{router, version, obsDomainId, entry.TemplateId}
+
+	s.lock.Lock()
+	previous, ok := s.templates[key]
+	if ok && s.unchanged(previous.Updated, entry.Updated) && sameTemplate(previous.template, template) {
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Unlock()
+
+	data, err := json.Marshal(template)
+	if err != nil {
+		return
+	}
+	entry.Template = data
+
+	s.lock.Lock()
+	s.templates[key] = entry
+	s.dirty = true
+	s.lock.Unlock()
+}
+
+// RemoveTemplate forgets a template withdrawn by router.
+func (s *TemplateStore) RemoveTemplate(router string, version uint16, obsDomainId uint32, templateId uint16) {
+	s.lock.Lock()
+	delete(s.templates, templateStoreKey{router, version, obsDomainId, templateId})
+	s.dirty = true
+	s.lock.Unlock()
+}
+
+// RemoveTemplates forgets the templates, or the options templates, of an
+// observation domain of router.
+func (s *TemplateStore) RemoveTemplates(router string, version uint16, obsDomainId uint32, options bool) {
+	s.lock.Lock()
+	for key, entry := range s.templates {
+		if key.router != router || key.version != version || key.obsDomainId != obsDomainId {
+			continue
+		}
+		if (entry.Type == STORED_TEMPLATE) != options {
+			delete(s.templates, key)
+		}
+	}
+	s.dirty = true
+	s.lock.Unlock()
+}
+
+func (entry storedSamplingRate) scope() producer.SamplingRateScope {
+	return producer.SamplingRateScope{Type: entry.ScopeType, Id: entry.ScopeId}
+}
+
+// AddSamplingRate records a sampling rate received from router.
+func (s *TemplateStore) AddSamplingRate(router string, version uint16, obsDomainId uint32, samplingRate uint32) {
+	s.AddScopedSamplingRate(router, version, obsDomainId, producer.SamplingRateScope{}, samplingRate)
+}
+
+// AddScopedSamplingRate records the sampling rate of a sampler, a selector
+// or an interface received from router.
+func (s *TemplateStore) AddScopedSamplingRate(router string, version uint16, obsDomainId uint32, scope producer.SamplingRateScope, samplingRate uint32) {
+	key := samplingStoreKey{router, version, obsDomainId, scope}
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	previous, ok := s.sampling[key]
+	if ok && s.unchanged(previous.Updated, now) && previous.SamplingRate == samplingRate {
+		return
+	}
+	s.sampling[key] = storedSamplingRate{
+		Router:       router,
+		Version:      version,
+		ObsDomainId:  obsDomainId,
+		ScopeType:    scope.Type,
+		ScopeId:      scope.Id,
+		SamplingRate: samplingRate,
+		Updated:      now,
+	}
+	s.dirty = true
+}
+
+// restore calls the functions with the stored entries.
+func (s *TemplateStore) restore(addTemplate func(router string, version uint16, obsDomainId uint32, template interface{}), addSamplingRate func(router string, version uint16, obsDomainId uint32, scope producer.SamplingRateScope, samplingRate uint32)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, entry := range s.templates {
+		addTemplate(entry.Router, entry.Version, entry.ObsDomainId, entry.template)
+	}
+	for _, entry := range s.sampling {
+		addSamplingRate(entry.Router, entry.Version, entry.ObsDomainId, entry.scope(), entry.SamplingRate)
+	}
+}
+
+// Save writes the entries which have not expired when they changed since the
+// last save. The file is replaced atomically.
+func (s *TemplateStore) Save() error {
+	s.lock.Lock()
+	if !s.dirty {
+		s.lock.Unlock()
+		return nil
+	}
+	file := templateStoreFile{
+		Version:   TEMPLATE_STORE_VERSION,
+		Templates: make([]storedTemplate, 0, len(s.templates)),
+		Sampling:  make([]storedSamplingRate, 0, len(s.sampling)),
+	}
+	now := time.Now()
+	for key, entry := range s.templates {
+		if s.expired(entry.Updated, now) {
+			delete(s.templates, key)
+			continue
+		}
+		file.Templates = append(file.Templates, entry)
+	}
+	for key, entry := range s.sampling {
+		if s.expired(entry.Updated, now) {
+			delete(s.sampling, key)
+			continue
+		}
+		file.Sampling = append(file.Sampling, entry)
+	}
+	s.dirty = false
+	s.lock.Unlock()
+
+	data, err := json.Marshal(file)
+	if err == nil {
+		err = writeFileAtomic(s.Path, data)
+	}
+	if err != nil {
+		s.lock.Lock()
+		s.dirty = true
+		s.lock.Unlock()
+	}
+	return err
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	errClose := tmp.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// SaveRoutine saves the store every interval until ctx is done. Call Save
+// once the collectors stopped to keep the last entries.
+func (s *TemplateStore) SaveRoutine(ctx context.Context, interval time.Duration, logger Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.Save()
+			if err != nil && logger != nil {
+				logger.Errorf("Error saving templates: %v", err)
+			}
+		}
+	}
+}
+
+// storedSamplingRateSystem records the sampling rates of a router in a store.
+type storedSamplingRateSystem struct {
+	producer.SamplingRateSystem
+	store  *TemplateStore
+	router string
+}
+
+func (s *storedSamplingRateSystem) AddSamplingRate(version uint16, obsDomainId uint32, samplingRate uint32) {
+	s.SamplingRateSystem.AddSamplingRate(version, obsDomainId, samplingRate)
+	s.store.AddSamplingRate(s.router, version, obsDomainId, samplingRate)
+}
+
+func (s *storedSamplingRateSystem) GetScopedSamplingRate(version uint16, obsDomainId uint32, scope producer.SamplingRateScope) (uint32, bool) {
+	return getScopedSamplingRate(s.SamplingRateSystem, version, obsDomainId, scope)
+}
+
+func (s *storedSamplingRateSystem) RemoveExpiredScopedSamplingRates(ttl time.Duration) {
+	removeExpiredScopedSamplingRates(s.SamplingRateSystem, ttl)
+}
+
+func (s *storedSamplingRateSystem) AddScopedSamplingRate(version uint16, obsDomainId uint32, scope producer.SamplingRateScope, samplingRate uint32) {
+	addScopedSamplingRate(s.SamplingRateSystem, version, obsDomainId, scope, samplingRate)
+	s.store.AddScopedSamplingRate(s.router, version, obsDomainId, scope, samplingRate)
+}
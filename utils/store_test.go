@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	"github.com/cloudflare/goflow/v3/producer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateStoreRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	src := net.ParseIP("127.0.0.1")
+
+	store := NewTemplateStore(path, time.Hour)
+	s := &StateNetFlow{
+		Store: store,
+	}
+	s.initTemplates()
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXTemplate()}))
+	// templates of transport sessions are not stored
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Session: "127.0.0.1:1234", Payload: getIPFIXTemplate()}))
+	s.sampling["127.0.0.1"].AddSamplingRate(10, 1, 100)
+	assert.Nil(t, store.Save())
+	assert.Len(t, store.templates, 1)
+
+	// after a restart, data is decoded before the router sends the template again
+	store = NewTemplateStore(path, time.Hour)
+	assert.Nil(t, store.Load())
+	transport := &testTransport{}
+	s = &StateNetFlow{
+		Transport: transport,
+		Store:     store,
+	}
+	s.initTemplates()
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: src, Payload: getIPFIXData()}))
+	assert.Equal(t, 2, transport.Count())
+	assert.Equal(t, uint64(100), transport.msgs[0].SamplingRate)
+
+	// restored entries are not refreshed
+	assert.False(t, store.dirty)
+}
+
+func TestTemplateStoreTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+
+	store := NewTemplateStore(path, time.Hour)
+	store.AddSamplingRate("10.0.0.1", 9, 1, 100)
+	store.AddSamplingRate("10.0.0.2", 9, 1, 1000)
+	entry := store.sampling[samplingStoreKey{"10.0.0.2", 9, 1, producer.SamplingRateScope{}}]
+	entry.Updated = time.Now().Add(-2 * time.Hour)
+	store.sampling[samplingStoreKey{"10.0.0.2", 9, 1, producer.SamplingRateScope{}}] = entry
+	assert.Nil(t, store.Save())
+	assert.Len(t, store.sampling, 1)
+
+	store = NewTemplateStore(path, time.Hour)
+	assert.Nil(t, store.Load())
+	assert.Len(t, store.sampling, 1)
+	assert.Equal(t, uint32(100), store.sampling[samplingStoreKey{"10.0.0.1", 9, 1, producer.SamplingRateScope{}}].SamplingRate)
+
+	// entries expire while the collector is stopped
+	store = NewTemplateStore(path, time.Nanosecond)
+	assert.Nil(t, store.Load())
+	assert.Len(t, store.sampling, 0)
+}
+
+func TestTemplateStoreScopedSamplingRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	scope := producer.SamplingRateScope{Type: producer.SAMPLING_SCOPE_SAMPLER, Id: 2}
+
+	store := NewTemplateStore(path, time.Hour)
+	s := &StateNetFlow{
+		Store: store,
+	}
+	s.initTemplates()
+	assert.Nil(t, s.DecodeFlow(BaseMessage{Src: net.ParseIP("127.0.0.1"), Payload: getIPFIXTemplate()}))
+	s.sampling["127.0.0.1"].(producer.ScopedSamplingRateSystem).AddScopedSamplingRate(10, 1, scope, 1000)
+	assert.Nil(t, store.Save())
+
+	store = NewTemplateStore(path, time.Hour)
+	assert.Nil(t, store.Load())
+	s = &StateNetFlow{
+		Store: store,
+	}
+	s.initTemplates()
+	samplingRate, ok := s.sampling["127.0.0.1"].(producer.ScopedSamplingRateSystem).GetScopedSamplingRate(10, 1, scope)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1000), samplingRate)
+	_, err := s.sampling["127.0.0.1"].GetSamplingRate(10, 1)
+	assert.NotNil(t, err)
+}
+
+func TestTemplateStoreUnchanged(t *testing.T) {
+	store := NewTemplateStore(filepath.Join(t.TempDir(), "templates.json"), time.Hour)
+	template := netflow.TemplateRecord{TemplateId: 256, FieldCount: 1, Fields: []netflow.Field{{Type: netflow.IPFIX_FIELD_sourceIPv4Address, Length: 4}}}
+	store.AddTemplate("10.0.0.1", 10, 1, template)
+	store.AddSamplingRate("10.0.0.1", 10, 1, 100)
+	assert.Nil(t, store.Save())
+
+	// refreshed entries are not written again
+	store.AddTemplate("10.0.0.1", 10, 1, template)
+	store.AddSamplingRate("10.0.0.1", 10, 1, 100)
+	assert.False(t, store.dirty)
+
+	template.Fields = []netflow.Field{{Type: netflow.IPFIX_FIELD_sourceIPv4Address, Length: 16}}
+	store.AddTemplate("10.0.0.1", 10, 1, template)
+	assert.True(t, store.dirty)
+}
+
+func TestTemplateStoreLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	now, _ := time.Now().MarshalJSON()
+	data := `{"version":1,"templates":[` +
+		`{"router":"10.0.0.1","version":10,"obs_domain_id":1,"template_id":256,"type":"template","updated":` + string(now) + `,"template":{"TemplateId":256}},` +
+		`{"router":"10.0.0.1","version":10,"obs_domain_id":1,"template_id":257,"type":"unknown","updated":` + string(now) + `,"template":{}}` +
+		`],"sampling":[]}`
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0644))
+
+	store := NewTemplateStore(path, time.Hour)
+	assert.NotNil(t, store.Load())
+	assert.Len(t, store.templates, 1)
+}
+
+func TestTemplateStoreMissingFile(t *testing.T) {
+	store := NewTemplateStore(filepath.Join(t.TempDir(), "templates.json"), time.Hour)
+	assert.Nil(t, store.Load())
+	// nothing to write
+	assert.Nil(t, store.Save())
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	decoder "github.com/cloudflare/goflow/v3/decoders"
+	"github.com/cloudflare/goflow/v3/decoders/netflow"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TCPRoutine accepts IPFIX exporters over TCP (RFC 7011 10.4). Each connection
+// is decoded in order by its own goroutine since templates must be processed
+// before the data sets following them. closeFunc is called with the session
+// of a connection once it is closed.
+func TCPRoutine(name string, decodeFunc decoder.DecoderFunc, closeFunc func(string), addr string, port int, logger Logger) error {
+	return TCPRoutineContext(context.Background(), name, decodeFunc, closeFunc, addr, port, logger)
+}
+
+// TCPRoutineContext is TCPRoutine stopping when ctx is done. The listener and
+// the connections are closed, it returns once the messages being decoded are.
+func TCPRoutineContext(ctx context.Context, name string, decodeFunc decoder.DecoderFunc, closeFunc func(string), addr string, port int, logger Logger) error {
+	return tcpRoutine(ctx, name, decodeFunc, closeFunc, addr, port, 0, logger)
+}
+
+// tcpRoutine closes the connections on which nothing was received for
+// idleTimeout (0 keeps them).
+func tcpRoutine(ctx context.Context, name string, decodeFunc decoder.DecoderFunc, closeFunc func(string), addr string, port int, idleTimeout time.Duration, logger Logger) error {
+	addrTCP := net.TCPAddr{
+		IP:   net.ParseIP(addr),
+		Port: port,
+	}
+
+	listener, err := net.ListenTCP("tcp", &addrTCP)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	localIP := addrTCP.IP.String()
+	if addrTCP.IP == nil {
+		localIP = ""
+	}
+
+	ecb := DefaultErrorCallback{
+		Logger: logger,
+	}
+
+	wg := &sync.WaitGroup{}
+	connslock := &sync.Mutex{}
+	conns := make(map[*net.TCPConn]bool)
+	defer func() {
+		connslock.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		connslock.Unlock()
+		wg.Wait()
+	}()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stopped:
+		}
+	}()
+
+	for {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		connslock.Lock()
+		conns[conn] = true
+		connslock.Unlock()
+
+		wg.Add(1)
+		go func() {
+			handleTCPConnection(name, decodeFunc, closeFunc, ecb.Callback, conn, localIP, port, idleTimeout, logger)
+			connslock.Lock()
+			delete(conns, conn)
+			connslock.Unlock()
+			wg.Done()
+		}()
+	}
+}
+
+func handleTCPConnection(name string, decodeFunc decoder.DecoderFunc, closeFunc func(string), errorCallback decoder.ErrorCallback,
+	conn *net.TCPConn, localIP string, localPort int, idleTimeout time.Duration, logger Logger) {
+	remote := conn.RemoteAddr().(*net.TCPAddr)
+	session := remote.String()
+
+	labels := prometheus.Labels{
+		"remote_ip":   remote.IP.String(),
+		"remote_port": strconv.Itoa(remote.Port),
+		"local_ip":    localIP,
+		"local_port":  strconv.Itoa(localPort),
+		"type":        name,
+	}
+	connLabels := prometheus.Labels{
+		"local_ip":   localIP,
+		"local_port": strconv.Itoa(localPort),
+		"type":       name,
+	}
+	MetricTCPConnections.With(connLabels).Inc()
+	MetricTCPConnectionsCount.With(connLabels).Inc()
+
+	defer func() {
+		conn.Close()
+		if closeFunc != nil {
+			closeFunc(session)
+		}
+		MetricTCPConnections.With(connLabels).Dec()
+		MetricTrafficBytes.Delete(labels)
+		MetricTrafficPackets.Delete(labels)
+		MetricPacketSizeSum.Delete(labels)
+		if logger != nil {
+			logger.Debugf("%v: connection from %v closed", name, session)
+		}
+	}()
+
+	if logger != nil {
+		logger.Debugf("%v: connection from %v", name, session)
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		if idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		payload, err := netflow.ReadIPFIXMessage(reader)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if logger != nil {
+				logger.Infof("%v: closing connection from %v: idle for %v", name, session, idleTimeout)
+			}
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) && logger != nil {
+				logger.Warnf("%v: closing connection from %v: %v", name, session, err)
+			}
+			return
+		}
+
+		baseMessage := BaseMessage{
+			Src:     remote.IP,
+			Port:    remote.Port,
+			Payload: payload,
+			Session: session,
+		}
+
+		timeTrackStart := time.Now()
+		err = decodeFunc(baseMessage)
+		timeTrackStop := time.Now()
+		if err != nil {
+			errorCallback(name, 0, timeTrackStart, timeTrackStop, err)
+		} else {
+			DefaultAccountCallback(name, 0, timeTrackStart, timeTrackStop)
+		}
+
+		MetricTrafficBytes.With(labels).Add(float64(len(payload)))
+		MetricTrafficPackets.With(labels).Inc()
+		MetricPacketSizeSum.With(labels).Observe(float64(len(payload)))
+	}
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/rawcapture"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DatagramTee records a copy of the datagrams received by the collectors
+// (eg: rawcapture.Writer).
+type DatagramTee interface {
+	WriteDatagram(datagram rawcapture.Datagram) error
+}
+
+// DatagramFlusher is implemented by the tees buffering their writes.
+type DatagramFlusher interface {
+	Flush() error
+}
+
+type teeItem struct {
+	name     string
+	datagram rawcapture.Datagram
+}
+
+// AsyncTee writes the datagrams to a DatagramTee from its own goroutine so
+// that the collectors do not wait for the disk. Datagrams received while
+// its queue is full are dropped.
+type AsyncTee struct {
+	tee    DatagramTee
+	logger Logger
+
+	queue  chan teeItem
+	done   chan struct{}
+	lock   sync.RWMutex
+	closed bool
+}
+
+func NewAsyncTee(tee DatagramTee, queueSize int, logger Logger) *AsyncTee {
+	t := &AsyncTee{
+		tee:    tee,
+		logger: logger,
+		queue:  make(chan teeItem, queueSize),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *AsyncTee) run() {
+	defer close(t.done)
+	flusher, _ := t.tee.(DatagramFlusher)
+	for item := range t.queue {
+		writeTee(item.name, t.tee, item.datagram, t.logger)
+		// flushing once the queue is drained batches the writes under load
+		if flusher != nil && len(t.queue) == 0 {
+			err := flusher.Flush()
+			if err != nil && t.logger != nil {
+				t.logger.Debugf("could not flush tee: %v", err)
+			}
+		}
+	}
+}
+
+// WriteDatagram queues a copy of the datagram.
+func (t *AsyncTee) WriteDatagram(datagram rawcapture.Datagram) error {
+	if !t.enqueue("", datagram) {
+		return errors.New("tee queue full")
+	}
+	return nil
+}
+
+func (t *AsyncTee) enqueue(name string, datagram rawcapture.Datagram) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.closed {
+		return false
+	}
+	// the payload buffer is reused once decoded
+	datagram.Payload = append([]byte(nil), datagram.Payload...)
+	select {
+	case t.queue <- teeItem{name: name, datagram: datagram}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close writes the queued datagrams and closes the underlying tee if it
+// is an io.Closer. The datagrams received afterwards are dropped.
+func (t *AsyncTee) Close() error {
+	t.lock.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.lock.Unlock()
+	<-t.done
+
+	if closer, ok := t.tee.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func teeDatagram(name string, tee DatagramTee, pktAddr *net.UDPAddr, localPort int, payload []byte, logger Logger) {
+	datagram := rawcapture.Datagram{
+		Time:      time.Now(),
+		Src:       pktAddr.IP,
+		SrcPort:   pktAddr.Port,
+		LocalPort: localPort,
+		Payload:   payload,
+	}
+	if async, ok := tee.(*AsyncTee); ok {
+		if !async.enqueue(name, datagram) {
+			MetricTeeDropped.With(
+				prometheus.Labels{
+					"type": name,
+				}).
+				Inc()
+		}
+		return
+	}
+	writeTee(name, tee, datagram, logger)
+}
+
+func writeTee(name string, tee DatagramTee, datagram rawcapture.Datagram, logger Logger) {
+	err := tee.WriteDatagram(datagram)
+	if err != nil {
+		MetricTeeErrors.With(
+			prometheus.Labels{
+				"type": name,
+			}).
+			Inc()
+		if logger != nil {
+			logger.Debugf("%v: could not tee datagram: %v", name, err)
+		}
+	}
+}
+
+// RawCaptureRoutine replays capture files written by rawcapture.Writer to the
+// route of their local port, in order. The messages are received at the time
+// they were captured.
+func RawCaptureRoutine(paths []string, routes map[int]PcapRoute, logger Logger) error {
+	ecb := DefaultErrorCallback{
+		Logger: logger,
+	}
+
+	for _, path := range paths {
+		err := replayRawCapture(path, routes, ecb.Callback)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayRawCapture(path string, routes map[int]PcapRoute, errorCallback func(string, int, time.Time, time.Time, error)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := rawcapture.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	for {
+		datagram, err := reader.ReadDatagram()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the last record may be truncated if the collector stopped while writing
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		route, ok := routes[datagram.LocalPort]
+		if !ok {
+			continue
+		}
+
+		baseMessage := BaseMessage{
+			Src:      datagram.Src,
+			Port:     datagram.SrcPort,
+			Payload:  datagram.Payload,
+			SetTime:  true,
+			RecvTime: datagram.Time,
+		}
+
+		timeTrackStart := time.Now()
+		err = route.DecodeFunc(baseMessage)
+		timeTrackStop := time.Now()
+		if err != nil {
+			errorCallback(route.Name, 0, timeTrackStart, timeTrackStop, err)
+		} else {
+			DefaultAccountCallback(route.Name, 0, timeTrackStart, timeTrackStop)
+		}
+	}
+}
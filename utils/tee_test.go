@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/goflow/v3/decoders/rawcapture"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeeReplay(t *testing.T) {
+	dir := t.TempDir()
+	tee := NewAsyncTee(rawcapture.NewWriter(dir, "test", 0, 0, 0), 100, nil)
+	transport := &testTransport{}
+	s := &StateNetFlow{
+		Transport: transport,
+		Tee:       tee,
+	}
+	port := getFreePort(t, "udp")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.FlowRoutineContext(ctx, 1, "127.0.0.1", port, false)
+	}()
+
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		conn.Write(getIPFIXTemplate())
+		conn.Write(getIPFIXData())
+		return transport.Count() > 0
+	}, time.Second, 20*time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	tee.Close()
+
+	files, err := rawcapture.ListFiles(dir, "test")
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+
+	replayTransport := &testTransport{}
+	sReplay := &StateNetFlow{
+		Transport: replayTransport,
+	}
+	err = RawCaptureRoutine(files, map[int]PcapRoute{port: sReplay.PcapRoute()}, nil)
+	assert.Nil(t, err)
+	assert.True(t, replayTransport.Count() >= 2)
+	assert.Equal(t, []byte{127, 0, 0, 1}, replayTransport.msgs[0].SamplerAddress)
+}
+
+// blockingTee waits for release before writing its first datagram.
+type blockingTee struct {
+	release chan struct{}
+	count   int
+}
+
+func (tee *blockingTee) WriteDatagram(datagram rawcapture.Datagram) error {
+	<-tee.release
+	tee.count++
+	return nil
+}
+
+func TestAsyncTeeDropped(t *testing.T) {
+	blocking := &blockingTee{release: make(chan struct{})}
+	tee := NewAsyncTee(blocking, 2, nil)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	dropped := testutil.ToFloat64(MetricTeeDropped.With(prometheus.Labels{"type": "TestAsyncTee"}))
+
+	payload := []byte{1}
+	teeDatagram("TestAsyncTee", tee, addr, 2055, payload, nil)
+	// the writer goroutine holds the first datagram
+	assert.Eventually(t, func() bool {
+		return len(tee.queue) == 0
+	}, time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		teeDatagram("TestAsyncTee", tee, addr, 2055, payload, nil)
+	}
+	// the payload was copied before being queued
+	payload[0] = 2
+
+	assert.Equal(t, dropped+1, testutil.ToFloat64(MetricTeeDropped.With(prometheus.Labels{"type": "TestAsyncTee"})))
+	close(blocking.release)
+	assert.Nil(t, tee.Close())
+	assert.Equal(t, 3, blocking.count)
+	assert.NotNil(t, tee.WriteDatagram(rawcapture.Datagram{}))
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package utils
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Datagrams are read with recvmmsg.
+const batchReadSupported = true
+
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+func newBatchConn(udpconn *net.UDPConn) batchConn {
+	if addr, ok := udpconn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(udpconn)
+	}
+	// IPv6 and dual-stack sockets
+	return ipv6.NewPacketConn(udpconn)
+}
+
+// readBatch reads up to batchSize datagrams per system call. When the
+// reader pools its buffers, the ones handed to the processor are replaced
+// by buffers of the pool, otherwise they are reused between calls.
+func (r *udpReader) readBatch(udpconn *net.UDPConn, batchSize int) error {
+	conn := newBatchConn(udpconn)
+	msgs := make([]ipv4.Message, batchSize)
+	buffers := make([]*[]byte, batchSize)
+	for i := range msgs {
+		buffers[i] = r.newBuffer()
+		msgs[i].Buffers = [][]byte{*buffers[i]}
+	}
+	if r.pool {
+		defer func() {
+			for _, buffer := range buffers {
+				payloadPool.Put(buffer)
+			}
+		}()
+	}
+
+	for {
+		n, err := conn.ReadBatch(msgs, 0)
+		if err != nil {
+			return err
+		}
+		for i, msg := range msgs[:n] {
+			pktAddr, ok := msg.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			r.handle(pktAddr, (*buffers[i])[:msg.N], buffers[i])
+			if r.pool {
+				buffers[i] = r.newBuffer()
+				msgs[i].Buffers[0] = *buffers[i]
+			}
+		}
+	}
+}
+
+func (r *udpReader) newBuffer() *[]byte {
+	if r.pool {
+		return payloadPool.Get().(*[]byte)
+	}
+	buffer := make([]byte, 9000)
+	return &buffer
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package utils
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	decoder "github.com/cloudflare/goflow/v3/decoders"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlowRoutineReadBatch(t *testing.T) {
+	transport := &testTransport{}
+	tee := &countingTee{}
+	s := &StateNetFlow{
+		Transport: transport,
+		Tee:       tee,
+		ReadBatch: 8,
+	}
+	port := getFreePort(t, "udp")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.FlowRoutineContext(ctx, 2, "127.0.0.1", port, false)
+	}()
+
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		conn.Write(getIPFIXTemplate())
+		return atomic.LoadInt64(&tee.count) > 0
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		conn.Write(getIPFIXData())
+	}
+	assert.Eventually(t, func() bool { return transport.Count() == 2*20 }, time.Second, 10*time.Millisecond)
+
+	transport.lock.Lock()
+	assert.Equal(t, uint64(1500), transport.msgs[0].Bytes)
+	assert.Equal(t, []byte{127, 0, 0, 1}, transport.msgs[0].SamplerAddress)
+	transport.lock.Unlock()
+
+	cancel()
+	assert.Nil(t, <-done)
+}
+
+const BENCHMARK_BURST = 64
+
+// benchmarkUDPRoutine receives b.N datagrams sent by a local sender.
+func benchmarkUDPRoutine(b *testing.B, readBatch int) {
+	payload := getIPFIXData()
+	var received int64
+	ctx, cancel := context.WithCancel(context.Background())
+	decodeFunc := func(msg interface{}) error {
+		if atomic.AddInt64(&received, 1) == int64(b.N) {
+			cancel()
+		}
+		return nil
+	}
+
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	port := listener.LocalAddr().(*net.UDPAddr).Port
+	listener.Close()
+
+	done := make(chan error)
+	go func() {
+		done <- udpRoutine(ctx, "bench", decodeFunc, 1, "127.0.0.1", port, false, udpOptions{
+			queueSize:    1000,
+			queuePolicy:  decoder.QUEUE_POLICY_DROP_NEWEST,
+			readBatch:    readBatch,
+			poolPayloads: true,
+		}, nil)
+	}()
+
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		// bursts fill the socket buffer so that the reader can batch, the next
+		// one is sent once they are received (or lost)
+		for ctx.Err() == nil {
+			target := atomic.LoadInt64(&received) + BENCHMARK_BURST
+			for i := 0; i < BENCHMARK_BURST; i++ {
+				conn.Write(payload)
+			}
+			start := time.Now()
+			for atomic.LoadInt64(&received) < target && ctx.Err() == nil && time.Since(start) < 10*time.Millisecond {
+				time.Sleep(10 * time.Microsecond)
+			}
+		}
+	}()
+
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	err = <-done
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkUDPReadFrom(b *testing.B) {
+	benchmarkUDPRoutine(b, 1)
+}
+
+func BenchmarkUDPReadBatch(b *testing.B) {
+	benchmarkUDPRoutine(b, 32)
+}
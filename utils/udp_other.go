@@ -0,0 +1,14 @@
+//go:build !linux
+
+package utils
+
+import (
+	"net"
+)
+
+// Datagrams are read one by one.
+const batchReadSupported = false
+
+func (r *udpReader) readBatch(udpconn *net.UDPConn, batchSize int) error {
+	return r.read(udpconn)
+}
@@ -1,12 +1,16 @@
 package utils
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	decoder "github.com/cloudflare/goflow/v3/decoders"
@@ -50,14 +54,45 @@ type BaseMessage struct {
 	Port    int
 	Payload []byte
 
+	// Transport session (eg: TCP connection) the message was received on.
+	// NetFlow/IPFIX templates are kept per session, or per source address when empty.
+	Session string
+
 	SetTime  bool
 	RecvTime time.Time
+
+	// buffer holding Payload, returned to payloadPool once decoded
+	buffer *[]byte
+}
+
+// payloadPool holds the buffers the collectors read datagrams into.
+var payloadPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, 9000)
+		return &buffer
+	},
 }
 
+// releasePayload returns the buffer of a decoded message to the pool. The
+// payload and what was decoded from it must not be used anymore.
+func releasePayload(msg interface{}) {
+	if pkt, ok := msg.(BaseMessage); ok && pkt.buffer != nil {
+		payloadPool.Put(pkt.buffer)
+	}
+}
+
+// Transport sends the flow messages. They are recycled once Publish returns
+// and must not be kept (eg: marshal them or use proto.Clone).
 type Transport interface {
 	Publish([]*flowmessage.FlowMessage)
 }
 
+// CountersTransport is implemented by the transports also sending the
+// interface counters of sFlow agents.
+type CountersTransport interface {
+	PublishCounters([]*flowmessage.CountersMessage)
+}
+
 type DefaultLogTransport struct {
 }
 
@@ -81,7 +116,8 @@ type DefaultErrorCallback struct {
 }
 
 func (cb *DefaultErrorCallback) Callback(name string, id int, start, end time.Time, err error) {
-	if _, ok := err.(*netflow.ErrorTemplateNotFound); ok {
+	var errTemplate *netflow.ErrorTemplateNotFound
+	if errors.As(err, &errTemplate) {
 		return
 	}
 	if cb.Logger != nil {
@@ -253,91 +289,263 @@ func FlowMessageToJSON(fmsg *flowmessage.FlowMessage) string {
 }
 
 func UDPRoutine(name string, decodeFunc decoder.DecoderFunc, workers int, addr string, port int, sockReuse bool, logger Logger) error {
+	return udpRoutine(context.Background(), name, decodeFunc, workers, addr, port, sockReuse, udpOptions{}, logger)
+}
+
+// UDPRoutineContext is UDPRoutine stopping when ctx is done. The socket is
+// closed then the datagrams already received are decoded before it returns.
+func UDPRoutineContext(ctx context.Context, name string, decodeFunc decoder.DecoderFunc, workers int, addr string, port int, sockReuse bool, logger Logger) error {
+	return udpRoutine(ctx, name, decodeFunc, workers, addr, port, sockReuse, udpOptions{}, logger)
+}
+
+// udpOptions are the optional settings of a UDP collector.
+type udpOptions struct {
+	tee         DatagramTee
+	queueSize   int
+	queuePolicy string
+	sockets     int
+	pinReaders  bool
+	readBatch   int
+
+	// the decoder does not use the payload once it returned
+	poolPayloads bool
+}
+
+func checkQueuePolicy(policy string) error {
+	switch policy {
+	case "", decoder.QUEUE_POLICY_BLOCK, decoder.QUEUE_POLICY_DROP_NEWEST, decoder.QUEUE_POLICY_DROP_OLDEST:
+		return nil
+	}
+	return fmt.Errorf("unknown queue policy %v", policy)
+}
+
+func udpRoutine(ctx context.Context, name string, decodeFunc decoder.DecoderFunc, workers int, addr string, port int, sockReuse bool, options udpOptions, logger Logger) error {
 	ecb := DefaultErrorCallback{
 		Logger: logger,
 	}
 
+	err := checkQueuePolicy(options.queuePolicy)
+	if err != nil {
+		return err
+	}
 	decoderParams := decoder.DecoderParams{
-		DecoderFunc:   decodeFunc,
-		DoneCallback:  DefaultAccountCallback,
-		ErrorCallback: ecb.Callback,
+		DecoderFunc:    decodeFunc,
+		DoneCallback:   DefaultAccountCallback,
+		ErrorCallback:  ecb.Callback,
+		QueueSize:      options.queueSize,
+		OverflowPolicy: options.queuePolicy,
+		DropCallback:   DefaultDropCallback,
+	}
+	if options.poolPayloads {
+		decoderParams.DecoderFunc = func(msg interface{}) error {
+			defer releasePayload(msg)
+			return decodeFunc(msg)
+		}
+		decoderParams.DropCallback = func(name string, msg decoder.Message) {
+			DefaultDropCallback(name, msg)
+			releasePayload(msg)
+		}
 	}
-
-	processor := decoder.CreateProcessor(workers, decoderParams, name)
-	processor.Start()
 
 	addrUDP := net.UDPAddr{
 		IP:   net.ParseIP(addr),
 		Port: port,
 	}
 
-	var udpconn *net.UDPConn
-	var err error
+	sockets := options.sockets
+	if sockets < 1 {
+		sockets = 1
+	}
+	if sockets > 1 {
+		// the kernel spreads the routers across the sockets
+		sockReuse = true
+	}
 
-	if sockReuse {
-		pconn, err := reuseport.ListenPacket("udp", addrUDP.String())
-		if err != nil {
-			return err
-		}
-		defer pconn.Close()
-		var ok bool
-		udpconn, ok = pconn.(*net.UDPConn)
-		if !ok {
-			return err
+	conns := make([]*net.UDPConn, 0, sockets)
+	defer func() {
+		for _, udpconn := range conns {
+			udpconn.Close()
 		}
-	} else {
-		udpconn, err = net.ListenUDP("udp", &addrUDP)
+	}()
+	for i := 0; i < sockets; i++ {
+		udpconn, err := listenUDP(&addrUDP, sockReuse)
 		if err != nil {
 			return err
 		}
-		defer udpconn.Close()
+		conns = append(conns, udpconn)
+		// the following sockets share the port picked by the kernel
+		addrUDP.Port = udpconn.LocalAddr().(*net.UDPAddr).Port
 	}
 
-	payload := make([]byte, 9000)
+	processor := decoder.CreateProcessor(workers, decoderParams, name)
+	processor.Start()
+	defer processor.Stop()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		for _, udpconn := range conns {
+			udpconn.Close()
+		}
+	}()
 
 	localIP := addrUDP.IP.String()
 	if addrUDP.IP == nil {
 		localIP = ""
 	}
 
+	errs := make(chan error, len(conns))
+	for _, udpconn := range conns {
+		go func(udpconn *net.UDPConn) {
+			if options.pinReaders {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+			reader := &udpReader{
+				name:      name,
+				localIP:   localIP,
+				localPort: addrUDP.Port,
+				processor: processor,
+				tee:       options.tee,
+				logger:    logger,
+				pool:      options.poolPayloads,
+			}
+			var err error
+			if options.readBatch > 1 && batchReadSupported {
+				err = reader.readBatch(udpconn, options.readBatch)
+			} else {
+				err = reader.read(udpconn)
+			}
+			if ctx.Err() != nil {
+				err = nil
+			}
+			errs <- err
+		}(udpconn)
+	}
+
+	// a failing socket stops the others
+	for range conns {
+		errRead := <-errs
+		if errRead != nil && err == nil {
+			err = errRead
+			for _, udpconn := range conns {
+				udpconn.Close()
+			}
+		}
+	}
+	return err
+}
+
+func listenUDP(addrUDP *net.UDPAddr, sockReuse bool) (*net.UDPConn, error) {
+	if !sockReuse {
+		return net.ListenUDP("udp", addrUDP)
+	}
+	pconn, err := reuseport.ListenPacket("udp", addrUDP.String())
+	if err != nil {
+		return nil, err
+	}
+	udpconn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		pconn.Close()
+		return nil, fmt.Errorf("not an UDP socket: %T", pconn)
+	}
+	return udpconn, nil
+}
+
+// udpReader sends the datagrams of a socket to the processor until it is closed.
+type udpReader struct {
+	name      string
+	localIP   string
+	localPort int
+	processor decoder.Processor
+	tee       DatagramTee
+	logger    Logger
+
+	// datagrams are read into buffers of payloadPool instead of being copied
+	pool bool
+}
+
+// read reads the datagrams one by one.
+func (r *udpReader) read(udpconn *net.UDPConn) error {
+	payload := make([]byte, 9000)
+
 	for {
-		size, pktAddr, _ := udpconn.ReadFromUDP(payload)
-		payloadCut := make([]byte, size)
-		copy(payloadCut, payload[0:size])
-
-		baseMessage := BaseMessage{
-			Src:     pktAddr.IP,
-			Port:    pktAddr.Port,
-			Payload: payloadCut,
+		buffer := &payload
+		if r.pool {
+			buffer = payloadPool.Get().(*[]byte)
+		}
+		size, pktAddr, err := udpconn.ReadFromUDP(*buffer)
+		if err != nil {
+			if r.pool {
+				payloadPool.Put(buffer)
+			}
+			return err
 		}
-		processor.ProcessMessage(baseMessage)
-
-		MetricTrafficBytes.With(
-			prometheus.Labels{
-				"remote_ip":   pktAddr.IP.String(),
-				"remote_port": strconv.Itoa(pktAddr.Port),
-				"local_ip":    localIP,
-				"local_port":  strconv.Itoa(addrUDP.Port),
-				"type":        name,
-			}).
-			Add(float64(size))
-		MetricTrafficPackets.With(
-			prometheus.Labels{
-				"remote_ip":   pktAddr.IP.String(),
-				"remote_port": strconv.Itoa(pktAddr.Port),
-				"local_ip":    localIP,
-				"local_port":  strconv.Itoa(addrUDP.Port),
-				"type":        name,
-			}).
-			Inc()
-		MetricPacketSizeSum.With(
-			prometheus.Labels{
-				"remote_ip":   pktAddr.IP.String(),
-				"remote_port": strconv.Itoa(pktAddr.Port),
-				"local_ip":    localIP,
-				"local_port":  strconv.Itoa(addrUDP.Port),
-				"type":        name,
-			}).
-			Observe(float64(size))
+		r.handle(pktAddr, (*buffer)[:size], buffer)
+	}
+}
+
+// handle sends a datagram to the processor. Unless the reader pools its
+// buffers, the payload is copied so that buffer can be read into again.
+func (r *udpReader) handle(pktAddr *net.UDPAddr, payload []byte, buffer *[]byte) {
+	if !r.pool {
+		payloadCut := make([]byte, len(payload))
+		copy(payloadCut, payload)
+		payload = payloadCut
+		buffer = nil
+	}
+
+	name := r.name
+	localIP := r.localIP
+	localPort := r.localPort
+	size := len(payload)
+
+	if r.tee != nil {
+		teeDatagram(name, r.tee, pktAddr, localPort, payload, r.logger)
+	}
+
+	baseMessage := BaseMessage{
+		Src:     pktAddr.IP,
+		Port:    pktAddr.Port,
+		Payload: payload,
+		buffer:  buffer,
 	}
+	r.processor.ProcessMessage(baseMessage)
+	DecoderQueueDepth.With(
+		prometheus.Labels{
+			"name": name,
+		}).
+		Set(float64(r.processor.QueueLength()))
+
+	MetricTrafficBytes.With(
+		prometheus.Labels{
+			"remote_ip":   pktAddr.IP.String(),
+			"remote_port": strconv.Itoa(pktAddr.Port),
+			"local_ip":    localIP,
+			"local_port":  strconv.Itoa(localPort),
+			"type":        name,
+		}).
+		Add(float64(size))
+	MetricTrafficPackets.With(
+		prometheus.Labels{
+			"remote_ip":   pktAddr.IP.String(),
+			"remote_port": strconv.Itoa(pktAddr.Port),
+			"local_ip":    localIP,
+			"local_port":  strconv.Itoa(localPort),
+			"type":        name,
+		}).
+		Inc()
+	MetricPacketSizeSum.With(
+		prometheus.Labels{
+			"remote_ip":   pktAddr.IP.String(),
+			"remote_port": strconv.Itoa(pktAddr.Port),
+			"local_ip":    localIP,
+			"local_port":  strconv.Itoa(localPort),
+			"type":        name,
+		}).
+		Observe(float64(size))
 }